@@ -3,19 +3,29 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/jsiebens/faas-nomad/pkg/logging"
+	"github.com/jsiebens/faas-nomad/pkg/maintenance"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
 	"github.com/jsiebens/faas-nomad/pkg/proxy"
-	"github.com/jsiebens/faas-nomad/pkg/resolver"
-	"log"
+	"github.com/jsiebens/faas-nomad/pkg/readiness"
+	"github.com/jsiebens/faas-nomad/pkg/reload"
+	resolvers "github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/accesslog"
+	"github.com/jsiebens/faas-nomad/pkg/admission"
+	"github.com/jsiebens/faas-nomad/pkg/autoscaler"
 	"github.com/jsiebens/faas-nomad/pkg/handlers"
 	"github.com/jsiebens/faas-nomad/pkg/services"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	"github.com/jsiebens/faas-nomad/version"
 	fbootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/auth"
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
@@ -28,73 +38,403 @@ func main() {
 
 	config, err := types.LoadConfig(*configFile)
 	if err != nil {
-		log.Fatal(err)
+		fatal(hclog.Default(), err)
 	}
 
-	logger := setupLogging(config.Log)
-
-	log.SetOutput(logger.StandardWriter(&hclog.StandardLoggerOptions{InferLevels: true}))
-	log.SetPrefix("")
-	log.SetFlags(0)
+	logger, logBuffer, err := logging.New(config.Log)
+	if err != nil {
+		fatal(hclog.Default(), err)
+	}
+	logging.RedirectStandardLog(logger)
 
-	secrets, err := services.NewVaultSecrets(config.Vault)
+	secrets, err := services.NewSecretStore(config)
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, err)
 	}
 
 	jobs, err := services.NewNomadJobs(config.Nomad)
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, err)
+	}
+
+	allocations, err := services.NewNomadAllocations(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	allocFS, err := services.NewNomadAllocFS(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	deployments, err := services.NewNomadDeployments(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	evaluations, err := services.NewNomadEvaluations(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	nodes, err := services.NewNomadNodes(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
 	}
 
 	factory := services.NewJobFactory(config)
 
-	resolver, err := resolver.NewConsulResolver(config, logger)
+	resolver, err := resolvers.NewConsulResolver(config, logger)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	nomadStatus, err := services.NewNomadStatus(config.Nomad)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	gates := readiness.NewGates(config.Readiness, logger)
+	gates.Add("nomad", nomadStatus)
+	gates.Add("consul", resolver.(readiness.Pinger))
+	if vault, ok := secrets.(*services.VaultSecrets); ok {
+		gates.Add("vault", vault)
+	}
+	gates.Start()
+
+	maintenanceMode := maintenance.NewMode(logger)
+	functionLabels := services.NewFunctionLabels(config, jobs)
+	warmer := handlers.NewFunctionWarmer(jobs, resolver, logger)
+	monitor := handlers.NewDeploymentMonitor(config, jobs, deployments, logger)
+	prepuller := handlers.NewImagePrepuller(jobs, logger)
+	scaleLimiter := handlers.NewScaleLimiter()
+	quota := handlers.NewQuotaChecker(config, jobs, allocations, nodes)
+
+	serviceMaintenance, err := services.NewConsulServiceMaintenance(config.Consul)
+	if err != nil {
+		fatal(logger, err)
+	}
+	warmPool := handlers.NewWarmPool(config, jobs, serviceMaintenance, logger)
+	warmPool.Start()
+
+	var providerCounts *handlers.ProviderCounts
+	if config.Info.IncludeCounts {
+		providerCounts = handlers.NewProviderCounts(config, jobs)
+	}
+
+	var deletes *softdelete.Tracker
+	if config.Scheduling.SoftDelete {
+		deletes = softdelete.NewTracker(jobs, config.Scheduling.SoftDeleteGrace, logger)
+	}
+
+	var intentions *services.Intentions
+	if config.Scheduling.ConsulConnect {
+		connect, err := services.NewConsulConnect(config.Consul)
+		if err != nil {
+			fatal(logger, err)
+		}
+		intentions = services.NewIntentions(connect)
+	}
+
+	var webhook *admission.Webhook
+	if len(config.Admission.WebhookURL) != 0 {
+		webhook = admission.NewWebhook(config.Admission, logger)
+	}
+
+	proxySettings := proxy.NewSettings(config)
+	errorPages, err := proxy.NewErrorPages(config.Proxy)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	registry, err := newEnvironmentRegistry(config, resolver, logger)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	var proxyResolver resolvers.ServiceResolver = resolver
+	if registry != nil {
+		proxyResolver = registry
+	}
+
+	coldStarts := proxy.NewColdStartTracker(proxyResolver, config.Proxy.RetryAfter)
+
+	responseCache, err := proxy.NewResponseCache(config.Proxy, functionLabels, proxySettings)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	sloTracker := proxy.NewSLOTracker(config.Scheduling.Namespace, functionLabels)
+	metrics.Register(sloTracker)
+
+	kv, err := services.NewConsulKV(config.Consul)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	var colours *services.Colours
+	var blueGreen *handlers.BlueGreenDeployments
+	if config.Scheduling.BlueGreen {
+		colours = services.NewColours(kv, config.Consul.BlueGreenKVPrefix, config.Scheduling.Namespace)
+		blueGreen = handlers.NewBlueGreenDeployments(config, jobs, colours, logger)
+	}
+
+	// the built-in middlewares of the proxy in their default order, outermost first
+	middlewares := proxy.NewMiddlewareRegistry()
+	middlewares.Register("errors", func() (proxy.Middleware, error) {
+		return errorPages.Middleware(), nil
+	})
+	middlewares.Register("variants", func() (proxy.Middleware, error) {
+		if !config.Proxy.Variants {
+			return nil, nil
+		}
+		return proxy.NewVariantMiddleware(functionLabels), nil
+	})
+	middlewares.Register("bluegreen", func() (proxy.Middleware, error) {
+		if colours == nil {
+			return nil, nil
+		}
+		return proxy.NewBlueGreenMiddleware(colours), nil
+	})
+	middlewares.Register("accesslog", func() (proxy.Middleware, error) {
+		if len(config.AccessLog.Sink) == 0 {
+			return nil, nil
+		}
+		sink, err := accesslog.NewSink(config.AccessLog)
+		if err != nil {
+			return nil, err
+		}
+		return accesslog.NewLogger(config, functionLabels, sink, logger).Wrap, nil
+	})
+	middlewares.Register("auth", func() (proxy.Middleware, error) {
+		return proxy.NewAuthMiddleware(config.Scheduling.Namespace, functionLabels, secrets), nil
+	})
+	middlewares.Register("autoscaler", func() (proxy.Middleware, error) {
+		if len(config.Autoscaler.WebhookURL) == 0 {
+			return nil, nil
+		}
+		recorder := autoscaler.NewRecorder(config.Scheduling.Namespace)
+		autoscaler.NewPublisher(config, jobs, resolver, recorder, logger).Start()
+		return recorder.Wrap, nil
+	})
+	middlewares.Register("environment", func() (proxy.Middleware, error) {
+		if registry == nil {
+			return nil, nil
+		}
+		return proxy.NewEnvironmentMiddleware(registry), nil
+	})
+	middlewares.Register("pinning", func() (proxy.Middleware, error) {
+		if !config.Proxy.InstancePinning {
+			return nil, nil
+		}
+		return proxy.NewInstancePinningMiddleware(proxyResolver), nil
+	})
+	middlewares.Register("slo", func() (proxy.Middleware, error) {
+		return sloTracker.Middleware(), nil
+	})
+	middlewares.Register("wait", func() (proxy.Middleware, error) {
+		return proxy.NewWaitMiddleware(config.Proxy, functionLabels, proxyResolver), nil
+	})
+	middlewares.Register("gzip", func() (proxy.Middleware, error) {
+		return proxy.NewGzipMiddleware(config.Proxy, functionLabels), nil
+	})
+	middlewares.Register("cache", func() (proxy.Middleware, error) {
+		return responseCache.Middleware(), nil
+	})
+	middlewares.Register("mirror", func() (proxy.Middleware, error) {
+		return proxy.NewMirrorMiddleware(functionLabels, logger), nil
+	})
+	middlewares.Register("timeout", func() (proxy.Middleware, error) {
+		return proxy.NewTimeoutMiddleware(functionLabels), nil
+	})
+	middlewares.Register("rewrite", func() (proxy.Middleware, error) {
+		return proxy.NewPathRewriteMiddleware(functionLabels), nil
+	})
+
+	proxyHandler, err := middlewares.Build(config.Proxy.Middlewares, proxy.NewReloadableHandlerFunc(proxySettings, coldStarts, logger))
 	if err != nil {
-		log.Fatal(err)
+		fatal(logger, err)
+	}
+
+	functionProxy := maintenanceMode.Wrap(proxyHandler)
+
+	functionCaches := []handlers.FunctionCache{
+		resolver.(handlers.FunctionCache),
+		functionLabels.(handlers.FunctionCache),
+		scaleLimiter,
+		coldStarts,
+		sloTracker,
+		responseCache,
 	}
 
+	deployHandler := handlers.NewDeployIdempotency(config, logger).Wrap(handlers.MakeDeployHandler(config, factory, jobs, secrets, intentions, webhook, warmer, deletes, monitor, prepuller, blueGreen, handlers.NewHealthProber(config, jobs, allocations, logger), handlers.NewEvaluationRetrier(config, jobs, evaluations, logger), quota, logger))
+
 	bootstrapHandlers := ftypes.FaaSHandlers{
-		FunctionProxy:        proxy.NewHandlerFunc(config.FaaS, resolver, logger),
-		FunctionReader:       handlers.MakeFunctionReader(config, jobs, logger),
-		DeployHandler:        handlers.MakeDeployHandler(config, factory, jobs, secrets, logger),
-		DeleteHandler:        handlers.MakeDeleteHandler(config, jobs, logger),
-		ReplicaReader:        handlers.MakeReplicaReader(config, jobs, resolver, logger),
-		ReplicaUpdater:       handlers.MakeReplicaUpdater(config, jobs, logger),
-		SecretHandler:        handlers.MakeSecretHandler(secrets, logger),
-		LogHandler:           unimplemented,
-		UpdateHandler:        handlers.MakeDeployHandler(config, factory, jobs, secrets, logger),
-		HealthHandler:        handlers.MakeHealthHandler(),
-		InfoHandler:          handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommit),
+		FunctionProxy:        functionProxy,
+		FunctionReader:       handlers.MakeFunctionReader(config, jobs, blueGreen, logger),
+		DeployHandler:        deployHandler,
+		DeleteHandler:        handlers.MakeDeleteHandler(config, jobs, intentions, deletes, blueGreen, functionCaches, logger),
+		ReplicaReader:        handlers.MakeReplicaReader(config, jobs, resolver, serviceMaintenance, blueGreen, logger),
+		ReplicaUpdater:       handlers.MakeReplicaUpdater(config, jobs, scaleLimiter, warmPool, blueGreen, quota, logger),
+		SecretHandler:        handlers.MakeSecretHandler(config, secrets, logger),
+		LogHandler:           handlers.MakeLogHandler(config, jobs, allocations, allocFS, blueGreen, logger),
+		UpdateHandler:        deployHandler,
+		HealthHandler:        handlers.MakeHealthHandler(maintenanceMode, gates, resolver.(handlers.HealthCheck)),
+		InfoHandler:          handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommit, providerCounts, logger),
 		ListNamespaceHandler: handlers.MakeListNamespaceHandler(config),
 	}
 
+	withAuth, err := basicAuthDecorator(config.FaaS)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	router := fbootstrap.Router()
+	if len(config.Proxy.Hosts) != 0 {
+		// registered first, so that the other routes are not reachable on the hostnames of functions
+		hosts := proxy.NewHostRouter(config.Proxy.Hosts)
+		router.MatcherFunc(hosts.Match).HandlerFunc(errorPages.Middleware()(hosts.Handler(functionProxy)))
+	}
+	if len(config.Proxy.Passthrough) != 0 {
+		passthrough := proxy.NewPassthroughRouter(config.Proxy.Passthrough, resolver.(resolvers.PassthroughResolver))
+		passthroughProxy := maintenanceMode.Wrap(errorPages.Middleware()(passthrough.Handler(proxy.NewReloadableHandlerFunc(proxySettings, passthrough, logger))))
+		router.HandleFunc(proxy.PassthroughPath+"{name:["+fbootstrap.NameExpression+"]+}", passthroughProxy)
+		router.HandleFunc(proxy.PassthroughPath+"{name:["+fbootstrap.NameExpression+"]+}/", passthroughProxy)
+		router.HandleFunc(proxy.PassthroughPath+"{name:["+fbootstrap.NameExpression+"]+}/{params:.*}", passthroughProxy)
+	}
+	router.Handle("/metrics", metrics.MakeHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/system/functions/summary", withAuth(handlers.MakeFunctionsSummaryHandler(config, jobs, resolver, logger))).Methods(http.MethodGet)
+	router.HandleFunc("/system/cost/usage", withAuth(handlers.MakeCostUsageHandler(config, jobs, logger))).Methods(http.MethodGet)
+	if deletes != nil {
+		router.HandleFunc("/system/functions/deleted", withAuth(handlers.MakeDeletedFunctionsHandler(deletes))).Methods(http.MethodGet)
+		router.HandleFunc("/system/functions/undelete", withAuth(handlers.MakeUndeleteHandler(deletes, logger))).Methods(http.MethodPost)
+	}
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/url", withAuth(handlers.MakeFunctionURLHandler(config, jobs, logger))).Methods(http.MethodGet)
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/manifest", withAuth(handlers.MakeFunctionManifestHandler(config, jobs, resolver, logger))).Methods(http.MethodGet)
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/rollback", withAuth(handlers.MakeRollbackHandler(config, jobs, deployments, logger))).Methods(http.MethodPost)
+	if blueGreen != nil {
+		router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/promote", withAuth(handlers.MakePromoteHandler(config, blueGreen, logger))).Methods(http.MethodPost)
+	}
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/restart", withAuth(handlers.MakeRestartHandler(config, jobs, logger))).Methods(http.MethodPost)
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/restart", withAuth(handlers.MakeRestartStatusHandler(config, jobs, logger))).Methods(http.MethodGet)
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/loglevel", withAuth(handlers.MakeLogLevelHandler(config, jobs, allocations, kv, logger))).Methods(http.MethodPost)
+	router.HandleFunc("/system/function/{name:["+fbootstrap.NameExpression+"]+}/slo", withAuth(handlers.MakeFunctionSLOHandler(config, sloTracker))).Methods(http.MethodGet)
+	router.HandleFunc("/system/functions/scrape-targets", withAuth(handlers.MakeScrapeTargetsHandler(config, jobs, resolver, logger))).Methods(http.MethodGet)
+	router.HandleFunc("/system/functions/batch", withAuth(handlers.MakeBatchDeployHandler(handlers.NewBatchDeployer(config, deployHandler, resolver, logger), logger))).Methods(http.MethodPost)
+	router.HandleFunc("/system/scale/batch", withAuth(handlers.MakeBatchScaleHandler(config, jobs, scaleLimiter, warmPool, blueGreen, quota, logger))).Methods(http.MethodPost)
+	if logBuffer != nil {
+		router.HandleFunc("/system/provider/logs", withAuth(handlers.MakeProviderLogsHandler(logBuffer))).Methods(http.MethodGet)
+	}
+	router.HandleFunc("/system/maintenance", withAuth(handlers.MakeMaintenanceHandler(maintenanceMode, logger))).Methods(http.MethodGet, http.MethodPost)
+
+	reloader := reload.NewReloader(config, func() (*types.ProviderConfig, error) { return types.LoadConfig(*configFile) }, logger)
+	reloader.Register(proxySettings)
+	reloader.Register(proxyResolver.(reload.Reloadable))
+	reloader.Register(reload.ReloadFunc(func(c *types.ProviderConfig) error {
+		level, err := logging.ParseLevel(c.Log.Level)
+		if err != nil {
+			return err
+		}
+		logger.SetLevel(level)
+		if logBuffer != nil {
+			logBuffer.SetLevel(level)
+		}
+		return nil
+	}))
+	reloader.WatchSignal()
+
+	if config.Info.ExposeConfig {
+		router.HandleFunc("/system/config", withAuth(handlers.MakeConfigHandler(reloader.Current, logger))).Methods(http.MethodGet)
+	}
+
+	shutdown := []func(){warmer.Stop}
+	if registry != nil {
+		shutdown = append(shutdown, registry.Stop)
+	} else if stoppable, ok := resolver.(interface{ Stop() }); ok {
+		shutdown = append(shutdown, stoppable.Stop)
+	}
+	if config.Consul.Register {
+		deregister, err := registerProvider(config, logger)
+		if err != nil {
+			fatal(logger, err)
+		}
+		shutdown = append(shutdown, deregister)
+	}
+	handleShutdown(logger, shutdown)
+
 	logger.Info(fmt.Sprintf("Listening on TCP port: %d", *config.FaaS.TCPPort))
 
 	fbootstrap.Serve(&bootstrapHandlers, &config.FaaS)
 }
 
-func unimplemented(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
-}
+// registerProvider registers the provider in Consul, and returns the func which deregisters it on shutdown.
+func registerProvider(config *types.ProviderConfig, logger hclog.Logger) (func(), error) {
+	registry, err := services.NewConsulServiceRegistry(config.Consul)
+	if err != nil {
+		return nil, err
+	}
 
-func setupLogging(config types.LogConfig) hclog.Logger {
-	appLogger := hclog.New(&hclog.LoggerOptions{
-		Name:       "faas-nomad",
-		Level:      hclog.LevelFromString(config.Level),
-		JSONFormat: strings.ToLower(config.Format) == "json",
-		Output:     createLogFile(config),
-	})
-	return appLogger
+	registration := services.NewSelfRegistration(config, registry)
+	if err := registration.Register(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Provider registered in Consul", "service", config.Consul.ServiceName)
+
+	return func() {
+		if err := registration.Deregister(); err != nil {
+			logger.Error("Error deregistering provider from Consul", "error", err.Error())
+		} else {
+			logger.Info("Provider deregistered from Consul", "service", config.Consul.ServiceName)
+		}
+	}, nil
 }
 
-func createLogFile(config types.LogConfig) *os.File {
-	if config.File != "" {
-		f, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-		if err == nil {
-			return f
+// handleShutdown stops the background work of the provider, in order, when it receives SIGINT or SIGTERM, and exits.
+func handleShutdown(logger hclog.Logger, stops []func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		logger.Info("Shutting down provider")
+		for _, stop := range stops {
+			stop()
 		}
-		log.Printf("Unable to open file for output, defaulting to std out: %s\n", err.Error())
+		os.Exit(0)
+	}()
+}
+
+func basicAuthDecorator(config ftypes.FaaSConfig) (func(http.HandlerFunc) http.HandlerFunc, error) {
+	if !config.EnableBasicAuth {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }, nil
 	}
-	return os.Stdout
+
+	reader := auth.ReadBasicAuthFromDisk{
+		SecretMountPath: config.SecretMountPath,
+	}
+
+	credentials, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return auth.DecorateWithBasicAuth(next, credentials)
+	}, nil
+}
+
+// newEnvironmentRegistry creates the resolvers of the configured environments, or nil when only the
+// default resolver is used.
+func newEnvironmentRegistry(config *types.ProviderConfig, fallback resolvers.ServiceResolver, logger hclog.Logger) (*resolvers.Registry, error) {
+	if len(config.Consul.Environments) == 0 {
+		return nil, nil
+	}
+	return resolvers.NewRegistryFromConfig(config, fallback, logger)
+}
+
+// fatal logs an error the provider can't recover from, and exits with a non-zero code.
+func fatal(logger hclog.Logger, err error) {
+	logger.Error("Fatal error, exiting", "error", err.Error())
+	os.Exit(1)
 }
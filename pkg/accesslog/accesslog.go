@@ -0,0 +1,230 @@
+// Package accesslog records an access log record per function invocation, e.g. for usage based billing or audits.
+//
+// Records are written as JSON lines, one record per invocation. The fields of a record are part of the
+// contract with the consumers of the log: fields may be added, but are never renamed or removed.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+
+	sampleRateLabel = "com.openfaas.access-log.sample-rate"
+)
+
+// Record is the access log record of a function invocation.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Function   string    `json:"function"`
+	Namespace  string    `json:"namespace"`
+	Caller     string    `json:"caller"`
+	CallID     string    `json:"call_id,omitempty"`
+	Method     string    `json:"method"`
+	Status     int       `json:"status"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Sink receives the access log records.
+type Sink interface {
+	Write(record Record) error
+}
+
+// LabelsReader provides the labels of a function, to read the sample rate of a function.
+type LabelsReader interface {
+	Labels(functionName string) (map[string]string, error)
+}
+
+// NewSink creates the sink of the config, which writes the records as JSON lines to stdout or to a file.
+func NewSink(config types.AccessLogConfig) (Sink, error) {
+	switch config.Sink {
+	case SinkStdout:
+		return NewWriterSink(os.Stdout), nil
+	case SinkFile:
+		if len(config.File) == 0 {
+			return nil, fmt.Errorf("access log sink 'file' requires an access log file")
+		}
+		f, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterSink(f), nil
+	default:
+		return nil, fmt.Errorf("invalid access log sink '%s'", config.Sink)
+	}
+}
+
+// WriterSink writes the records as JSON lines to a writer.
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *WriterSink) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// Logger records the invocations of functions to a sink. The invocations are sampled with the sample rate of
+// the config, which can be overridden per function with the `com.openfaas.access-log.sample-rate` label, e.g.
+// 0.1 to record one in ten invocations of a high volume function.
+type Logger struct {
+	namespace  string
+	sampleRate float64
+	labels     LabelsReader
+	sink       Sink
+	logger     hclog.Logger
+	now        func() time.Time
+	sample     func() float64
+}
+
+func NewLogger(config *types.ProviderConfig, labels LabelsReader, sink Sink, logger hclog.Logger) *Logger {
+	return &Logger{
+		namespace:  config.Scheduling.Namespace,
+		sampleRate: config.AccessLog.SampleRate,
+		labels:     labels,
+		sink:       sink,
+		logger:     logger.Named("access_log"),
+		now:        time.Now,
+		sample:     rand.Float64,
+	}
+}
+
+// Wrap decorates a function proxy handler so that its invocations are recorded.
+func (l *Logger) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if !l.sampled(functionName) {
+			next(w, r)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		start := l.now()
+		next(rw, r)
+		duration := l.now().Sub(start)
+
+		name, namespace := splitFunctionName(functionName, l.namespace)
+		record := Record{
+			Time:       start.UTC(),
+			Function:   name,
+			Namespace:  namespace,
+			Caller:     caller(r),
+			CallID:     r.Header.Get("X-Call-Id"),
+			Method:     r.Method,
+			Status:     rw.status,
+			BytesIn:    body.count,
+			BytesOut:   rw.count,
+			DurationMs: float64(duration.Microseconds()) / 1000,
+		}
+
+		if err := l.sink.Write(record); err != nil {
+			l.logger.Error("Error writing access log record", "function", functionName, "error", err.Error())
+		}
+	}
+}
+
+func (l *Logger) sampled(functionName string) bool {
+	rate := l.sampleRate
+	if l.labels != nil && functionName != "" {
+		if values, err := l.labels.Labels(functionName); err == nil {
+			if value, ok := values[sampleRateLabel]; ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					rate = parsed
+				}
+			}
+		}
+	}
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && l.sample() < rate
+}
+
+// splitFunctionName splits a function name in the form of name.namespace, using the default namespace when the
+// function name has no namespace.
+func splitFunctionName(functionName, namespace string) (string, string) {
+	if idx := strings.LastIndex(functionName, "."); idx > 0 {
+		return functionName[:idx], functionName[idx+1:]
+	}
+	return functionName, namespace
+}
+
+// caller returns the client of the invocation, which is the first address of the `X-Forwarded-For` header when
+// the invocation is forwarded by the gateway.
+func caller(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) != 0 {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	count       int64
+	wroteHeader bool
+}
+
+func (c *countingResponseWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status = status
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingResponseWriter) Write(b []byte) (int, error) {
+	c.wroteHeader = true
+	n, err := c.ResponseWriter.Write(b)
+	c.count += int64(n)
+	return n, err
+}
+
+func (c *countingResponseWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
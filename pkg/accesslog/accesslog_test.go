@@ -0,0 +1,162 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	records []Record
+}
+
+func (s *recordingSink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func setupLogger(sampleRate float64, labels map[string]string, sink Sink) *Logger {
+	config, _ := types.DefaultConfig()
+	config.AccessLog.SampleRate = sampleRate
+
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+
+	logger := NewLogger(config, reader, sink, hclog.NewNullLogger())
+
+	start := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	calls := 0
+	logger.now = func() time.Time {
+		calls++
+		if calls == 1 {
+			return start
+		}
+		return start.Add(1500 * time.Microsecond)
+	}
+	return logger
+}
+
+func invoke(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/function/echo", strings.NewReader(body))
+	request.Header.Set("X-Forwarded-For", "10.1.2.3, 10.0.0.1")
+	request.Header.Set("X-Call-Id", "call-1")
+	request = mux.SetURLVars(request, map[string]string{"name": "echo"})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body := make([]byte, 64)
+	n, _ := r.Body.Read(body)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("echo: "))
+	w.Write(body[:n])
+}
+
+func TestLoggerRecordsInvocation(t *testing.T) {
+	sink := &recordingSink{}
+	handler := setupLogger(1, map[string]string{}, sink).Wrap(echoHandler)
+
+	recorder := invoke(handler, "hello")
+
+	assert.Equal(t, "echo: hello", recorder.Body.String())
+	assert.Equal(t, []Record{{
+		Time:       time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC),
+		Function:   "echo",
+		Namespace:  "default",
+		Caller:     "10.1.2.3",
+		CallID:     "call-1",
+		Method:     http.MethodPost,
+		Status:     http.StatusCreated,
+		BytesIn:    5,
+		BytesOut:   11,
+		DurationMs: 1.5,
+	}}, sink.records)
+}
+
+func TestLoggerSamplesWithLabel(t *testing.T) {
+	sink := &recordingSink{}
+	logger := setupLogger(1, map[string]string{sampleRateLabel: "0.25"}, sink)
+	samples := []float64{0.1, 0.5, 0.3, 0.2}
+	logger.sample = func() float64 {
+		s := samples[0]
+		samples = samples[1:]
+		return s
+	}
+	handler := logger.Wrap(echoHandler)
+
+	for i := 0; i < 4; i++ {
+		invoke(handler, "hello")
+	}
+
+	assert.Equal(t, 2, len(sink.records))
+}
+
+func TestLoggerIsDisabledWithZeroSampleRate(t *testing.T) {
+	sink := &recordingSink{}
+	handler := setupLogger(0, map[string]string{}, sink).Wrap(echoHandler)
+
+	recorder := invoke(handler, "hello")
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Empty(t, sink.records)
+}
+
+func TestFileSinkAppendsJSONLines(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	sink, err := NewSink(types.AccessLogConfig{Sink: SinkFile, File: file})
+	assert.NoError(t, err)
+
+	handler := setupLogger(1, map[string]string{}, sink).Wrap(echoHandler)
+	invoke(handler, "hello")
+	invoke(handler, "world!")
+
+	f, err := os.Open(file)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, map[string]interface{}{
+		"time":        "2021-05-01T10:00:00Z",
+		"function":    "echo",
+		"namespace":   "default",
+		"caller":      "10.1.2.3",
+		"call_id":     "call-1",
+		"method":      "POST",
+		"status":      float64(201),
+		"bytes_in":    float64(5),
+		"bytes_out":   float64(11),
+		"duration_ms": 1.5,
+	}, lines[0])
+	assert.Equal(t, float64(6), lines[1]["bytes_in"])
+}
+
+func TestNewSinkRejectsInvalidSink(t *testing.T) {
+	_, err := NewSink(types.AccessLogConfig{Sink: "nats"})
+	assert.Error(t, err)
+
+	_, err = NewSink(types.AccessLogConfig{Sink: SinkFile})
+	assert.Error(t, err)
+}
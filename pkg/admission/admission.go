@@ -0,0 +1,106 @@
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const maxMessageSize = 4096
+
+// Review is the payload posted to the admission webhook for every deployment.
+type Review struct {
+	Namespace  string                    `json:"namespace"`
+	Deployment ftypes.FunctionDeployment `json:"deployment"`
+	Job        *api.Job                  `json:"job"`
+}
+
+// Rejection is returned when the admission webhook rejects a deployment.
+type Rejection struct {
+	StatusCode int
+	Message    string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("deployment rejected by admission webhook: %s", r.Message)
+}
+
+// Webhook runs deployments past an external policy engine before they are registered in Nomad.
+//
+// The rendered job and the original deployment are posted to the configured URL; any response other
+// than a 200 rejects the deployment, with the response body, or its `message` field when it is JSON,
+// as the reason. When the webhook can't be reached, the deployment is rejected as well, unless the
+// webhook is configured to fail open.
+type Webhook struct {
+	config types.AdmissionConfig
+	client *http.Client
+	logger hclog.Logger
+}
+
+func NewWebhook(config types.AdmissionConfig, logger hclog.Logger) *Webhook {
+	return &Webhook{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		logger: logger.Named("admission"),
+	}
+}
+
+// Admit returns a *Rejection when the webhook rejects the deployment, or another error when the webhook
+// failed and is configured to fail closed.
+func (w *Webhook) Admit(namespace string, fd ftypes.FunctionDeployment, job *api.Job) error {
+	err := w.review(Review{Namespace: namespace, Deployment: fd, Job: job})
+	if err == nil {
+		return nil
+	}
+
+	if _, rejected := err.(*Rejection); !rejected && w.config.FailOpen {
+		w.logger.Warn("Admission webhook failed, accepting deployment", "function", fd.Service, "error", err.Error())
+		return nil
+	}
+
+	return err
+}
+
+func (w *Webhook) review(review Review) error {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.client.Post(w.config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("admission webhook failed: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxMessageSize))
+	return &Rejection{StatusCode: res.StatusCode, Message: parseMessage(res.StatusCode, data)}
+}
+
+func parseMessage(statusCode int, data []byte) string {
+	var value struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &value); err == nil && len(value.Message) != 0 {
+		return value.Message
+	}
+
+	if message := strings.TrimSpace(string(data)); len(message) != 0 {
+		return message
+	}
+
+	return fmt.Sprintf("unexpected status code %d", statusCode)
+}
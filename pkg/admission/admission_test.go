@@ -0,0 +1,107 @@
+package admission
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func deployment() (ftypes.FunctionDeployment, *api.Job) {
+	fd := ftypes.FunctionDeployment{Service: "echo", Image: "docker.io/functions/echo:latest"}
+	name := "faas-fn-echo"
+	return fd, &api.Job{ID: &name, Name: &name}
+}
+
+func webhookServer(status int, body string) (*httptest.Server, *Review) {
+	review := &Review{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(review)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	return server, review
+}
+
+func TestWebhookAdmitsDeployment(t *testing.T) {
+	server, review := webhookServer(http.StatusOK, "")
+	defer server.Close()
+
+	fd, job := deployment()
+	webhook := NewWebhook(types.AdmissionConfig{WebhookURL: server.URL, Timeout: time.Second}, hclog.NewNullLogger())
+
+	assert.NoError(t, webhook.Admit("default", fd, job))
+	assert.Equal(t, "default", review.Namespace)
+	assert.Equal(t, "echo", review.Deployment.Service)
+	assert.Equal(t, "faas-fn-echo", *review.Job.ID)
+}
+
+func TestWebhookRejectsDeploymentWithMessage(t *testing.T) {
+	cases := map[string]string{
+		`{"message": "registry docker.io is not allowed"}`: "registry docker.io is not allowed",
+		"registry docker.io is not allowed\n":              "registry docker.io is not allowed",
+		"":                                                 "unexpected status code 403",
+	}
+
+	for body, message := range cases {
+		server, _ := webhookServer(http.StatusForbidden, body)
+
+		fd, job := deployment()
+		webhook := NewWebhook(types.AdmissionConfig{WebhookURL: server.URL, Timeout: time.Second, FailOpen: true}, hclog.NewNullLogger())
+
+		err := webhook.Admit("default", fd, job)
+		if assert.IsType(t, &Rejection{}, err) {
+			assert.Equal(t, http.StatusForbidden, err.(*Rejection).StatusCode)
+			assert.Equal(t, message, err.(*Rejection).Message)
+		}
+
+		server.Close()
+	}
+}
+
+func TestWebhookFailsClosed(t *testing.T) {
+	server, _ := webhookServer(http.StatusOK, "")
+	server.Close()
+
+	fd, job := deployment()
+	webhook := NewWebhook(types.AdmissionConfig{WebhookURL: server.URL, Timeout: time.Second}, hclog.NewNullLogger())
+
+	err := webhook.Admit("default", fd, job)
+	assert.Error(t, err)
+	_, rejected := err.(*Rejection)
+	assert.False(t, rejected)
+}
+
+func TestWebhookFailsOpen(t *testing.T) {
+	server, _ := webhookServer(http.StatusOK, "")
+	server.Close()
+
+	fd, job := deployment()
+	webhook := NewWebhook(types.AdmissionConfig{WebhookURL: server.URL, Timeout: time.Second, FailOpen: true}, hclog.NewNullLogger())
+
+	assert.NoError(t, webhook.Admit("default", fd, job))
+}
+
+func TestWebhookTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	fd, job := deployment()
+	webhook := NewWebhook(types.AdmissionConfig{WebhookURL: server.URL, Timeout: 50 * time.Millisecond}, hclog.NewNullLogger())
+
+	err := webhook.Admit("default", fd, job)
+	if assert.Error(t, err) {
+		_, rejected := err.(*Rejection)
+		assert.False(t, rejected)
+	}
+}
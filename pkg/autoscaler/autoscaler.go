@@ -0,0 +1,178 @@
+package autoscaler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// LoadEvent is the load of a function during the last interval, as pushed to the autoscaler.
+type LoadEvent struct {
+	Name              string    `json:"name"`
+	Namespace         string    `json:"namespace"`
+	Replicas          uint64    `json:"replicas"`
+	AvailableReplicas uint64    `json:"availableReplicas"`
+	Invocations       uint64    `json:"invocations"`
+	RPS               float64   `json:"rps"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// Recorder counts the invocations of every function in the current interval.
+type Recorder struct {
+	namespace   string
+	mu          sync.Mutex
+	invocations map[string]uint64
+}
+
+func NewRecorder(namespace string) *Recorder {
+	return &Recorder{namespace: namespace, invocations: map[string]uint64{}}
+}
+
+// Wrap decorates a function proxy handler so that its invocations are recorded.
+func (r *Recorder) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimSuffix(mux.Vars(req)["name"], "."+r.namespace)
+		r.mu.Lock()
+		r.invocations[name]++
+		r.mu.Unlock()
+
+		next(w, req)
+	}
+}
+
+// collect returns the invocations since the previous call, and resets the counters.
+func (r *Recorder) collect() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invocations := r.invocations
+	r.invocations = map[string]uint64{}
+	return invocations
+}
+
+// Publisher pushes the load of all functions to a webhook at a fixed interval, so that an external
+// autoscaler can react on it without polling the provider.
+type Publisher struct {
+	config   *types.ProviderConfig
+	jobs     services.Jobs
+	resolver resolver.ServiceResolver
+	recorder *Recorder
+	client   *http.Client
+	logger   hclog.Logger
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func NewPublisher(config *types.ProviderConfig, jobs services.Jobs, resolver resolver.ServiceResolver, recorder *Recorder, logger hclog.Logger) *Publisher {
+	return &Publisher{
+		config:   config,
+		jobs:     jobs,
+		resolver: resolver,
+		recorder: recorder,
+		client:   &http.Client{Timeout: config.Autoscaler.Interval},
+		logger:   logger.Named("autoscaler"),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (p *Publisher) Start() {
+	go func() {
+		ticker := time.NewTicker(p.config.Autoscaler.Interval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-ticker.C:
+				if err := p.publish(now.Sub(last)); err != nil {
+					p.logger.Warn("Error publishing load events", "url", p.config.Autoscaler.WebhookURL, "error", err.Error())
+				}
+				last = now
+			}
+		}
+	}()
+}
+
+func (p *Publisher) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *Publisher) publish(elapsed time.Duration) error {
+	events, err := p.events(p.recorder.collect(), elapsed)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.Post(p.config.Autoscaler.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	p.logger.Trace("Load events published", "functions", len(events))
+	return nil
+}
+
+func (p *Publisher) events(invocations map[string]uint64, elapsed time.Duration) ([]LoadEvent, error) {
+	namespace := p.config.Scheduling.Namespace
+
+	list, err := services.ListFunctionJobs(p.jobs, p.config.Scheduling.JobPrefix, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	events := []LoadEvent{}
+
+	for _, j := range list {
+		if j.Status == "dead" {
+			continue
+		}
+
+		name := services.FunctionName(j, p.config.Scheduling.JobPrefix)
+		available, err := p.resolver.ResolveAll(name)
+		if err != nil {
+			p.logger.Warn("Error resolving function", "function", name, "namespace", namespace, "error", err.Error())
+		}
+
+		event := LoadEvent{
+			Name:              name,
+			Namespace:         namespace,
+			Replicas:          services.ScheduledReplicas(j.JobSummary),
+			AvailableReplicas: uint64(len(available)),
+			Invocations:       invocations[name],
+			Timestamp:         now,
+		}
+		if elapsed > 0 {
+			event.RPS = float64(event.Invocations) / elapsed.Seconds()
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
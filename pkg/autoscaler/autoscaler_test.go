@@ -0,0 +1,108 @@
+package autoscaler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func invoke(handler http.HandlerFunc, name string) {
+	request := httptest.NewRequest("GET", "/function/"+name, nil)
+	handler(httptest.NewRecorder(), mux.SetURLVars(request, map[string]string{"name": name}))
+}
+
+func TestRecorderCountsInvocationsPerFunction(t *testing.T) {
+	recorder := NewRecorder("default")
+	handler := recorder.Wrap(func(w http.ResponseWriter, r *http.Request) {})
+
+	invoke(handler, "echo")
+	invoke(handler, "echo.default")
+	invoke(handler, "figlet")
+
+	assert.Equal(t, map[string]uint64{"echo": 2, "figlet": 1}, recorder.collect())
+	assert.Empty(t, recorder.collect())
+}
+
+func TestPublisherPushesLoadEvents(t *testing.T) {
+	received := make(chan []LoadEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var events []LoadEvent
+		_ = json.Unmarshal(body, &events)
+		received <- events
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	config, _ := types.DefaultConfig()
+	config.Autoscaler.WebhookURL = server.URL
+
+	jobs := &services.MockJobs{}
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{
+			ID:     "faas-fn-echo",
+			Name:   "faas-fn-echo",
+			Status: "running",
+			JobSummary: &api.JobSummary{Summary: map[string]api.TaskGroupSummary{
+				"echo": {Running: 2, Starting: 1},
+			}},
+		},
+		{ID: "faas-fn-figlet", Name: "faas-fn-figlet", Status: "running"},
+		{ID: "faas-fn-stopped", Name: "faas-fn-stopped", Status: "dead"},
+	}, nil, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}}, nil)
+	resolver.On("ResolveAll", "figlet").Return(nil, nil)
+
+	recorder := NewRecorder("default")
+	handler := recorder.Wrap(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 20; i++ {
+		invoke(handler, "echo")
+	}
+
+	publisher := NewPublisher(config, jobs, resolver, recorder, hclog.Default())
+	assert.NoError(t, publisher.publish(10*time.Second))
+
+	events := <-received
+	assert.Len(t, events, 2)
+
+	assert.Equal(t, "echo", events[0].Name)
+	assert.Equal(t, "default", events[0].Namespace)
+	assert.Equal(t, uint64(3), events[0].Replicas)
+	assert.Equal(t, uint64(2), events[0].AvailableReplicas)
+	assert.Equal(t, uint64(20), events[0].Invocations)
+	assert.Equal(t, 2.0, events[0].RPS)
+
+	assert.Equal(t, "figlet", events[1].Name)
+	assert.Equal(t, uint64(0), events[1].Invocations)
+	assert.Equal(t, 0.0, events[1].RPS)
+}
+
+func TestPublisherReportsErrorOnUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config, _ := types.DefaultConfig()
+	config.Autoscaler.WebhookURL = server.URL
+
+	jobs := &services.MockJobs{}
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{}, nil, nil)
+
+	publisher := NewPublisher(config, jobs, &services.MockResolver{}, NewRecorder("default"), hclog.Default())
+	assert.Error(t, publisher.publish(10*time.Second))
+}
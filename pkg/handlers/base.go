@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
 	"github.com/openfaas/faas-provider/types"
 )
 
@@ -13,26 +14,43 @@ const (
 	TypeApplicationJson = "application/json"
 
 	EnvProcessName = "fprocess"
+
+	// SoftDeletedAnnotation marks the status of a function which was soft-deleted, and is pending its purge.
+	SoftDeletedAnnotation = "com.openfaas.nomad.soft-deleted"
 )
 
 func createFunctionStatus(job *api.Job, jobPrefix string) types.FunctionStatus {
-	var labels = map[string]string{}
 	task := job.TaskGroups[0].Tasks[0]
+	labels := services.JobLabels(job)
 
-	if task.Config["labels"] != nil {
-		labels = parseLabels(task.Config["labels"].([]interface{}))
-	}
+	// only the docker driver has an image, the other drivers fetch an artifact
+	image, _ := task.Config["image"].(string)
 
 	var annotations = map[string]string{}
-	if job.Meta != nil {
-		annotations = job.Meta
+	for k, v := range job.Meta {
+		// the cost attribution meta is derived from the labels, and not an annotation of the function
+		if !strings.HasPrefix(k, services.CostMetaPrefix) {
+			annotations[k] = v
+		}
+	}
+
+	// the warm replicas are part of the count of the job, but not in rotation
+	replicas := *job.TaskGroups[0].Count - services.WarmReplicas(labels)
+	if replicas < 0 {
+		replicas = 0
+	}
+
+	// a soft-deleted function is stopped until it is restored or purged
+	if job.Stop != nil && *job.Stop {
+		annotations[SoftDeletedAnnotation] = "true"
+		replicas = 0
 	}
 
 	return types.FunctionStatus{
 		Name:            sanitiseJobName(job, jobPrefix),
 		Namespace:       *job.Namespace,
-		Image:           task.Config["image"].(string),
-		Replicas:        uint64(*job.TaskGroups[0].Count),
+		Image:           image,
+		Replicas:        uint64(replicas),
 		InvocationCount: 0,
 		Labels:          &labels,
 		Annotations:     &annotations,
@@ -52,18 +70,6 @@ func getEnvProcess(m map[string]string) string {
 	return ""
 }
 
-func parseLabels(labels []interface{}) map[string]string {
-	newLabels := map[string]string{}
-	if len(labels) > 0 {
-		for _, l := range labels {
-			for k, v := range l.(map[string]interface{}) {
-				newLabels[k] = v.(string)
-			}
-		}
-	}
-	return newLabels
-}
-
 func sanitiseJobName(job *api.Job, jobPrefix string) string {
 	return strings.Replace(*job.Name, jobPrefix, "", -1)
 }
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+type FunctionPromotion struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Active    string `json:"active"`
+	Previous  string `json:"previous"`
+}
+
+// BlueGreenDeployments deploys the functions with the `com.openfaas.bluegreen` label as two jobs, the function
+// name suffixed with blue and green. A deployment updates the job of the inactive colour, while the active colour
+// keeps serving the requests of the function, until the inactive colour is promoted. The previous colour is kept
+// for the grace period, so a promote can be reverted instantly, and is stopped after.
+type BlueGreenDeployments struct {
+	config    *types.ProviderConfig
+	jobs      services.Jobs
+	colours   *services.Colours
+	grace     time.Duration
+	logger    hclog.Logger
+	mu        sync.Mutex
+	teardowns map[string]*time.Timer
+}
+
+func NewBlueGreenDeployments(config *types.ProviderConfig, jobs services.Jobs, colours *services.Colours, logger hclog.Logger) *BlueGreenDeployments {
+	return &BlueGreenDeployments{
+		config:    config,
+		jobs:      jobs,
+		colours:   colours,
+		grace:     config.Scheduling.BlueGreenGrace,
+		logger:    logger.Named("blue_green"),
+		teardowns: map[string]*time.Timer{},
+	}
+}
+
+// Target returns the colour a deployment of a function updates, the inactive colour, or blue for the first
+// deployment of the function.
+func (b *BlueGreenDeployments) Target(functionName string) (string, error) {
+	active, err := b.colours.Active(functionName)
+	if err != nil {
+		return "", err
+	}
+	return services.OtherColour(active), nil
+}
+
+// Deployed keeps the colour of a function which was just deployed, which becomes active when the function has
+// no active colour yet.
+func (b *BlueGreenDeployments) Deployed(functionName, colour string) error {
+	b.cancelTeardown(functionName, colour)
+
+	active, err := b.colours.Active(functionName)
+	if err != nil {
+		return err
+	}
+	if len(active) == 0 {
+		return b.colours.SetActive(functionName, colour)
+	}
+	return nil
+}
+
+// Promote makes the inactive colour of a function active, and returns the previous colour.
+func (b *BlueGreenDeployments) Promote(functionName string) (string, string, error) {
+	previous, err := b.colours.Active(functionName)
+	if err != nil {
+		return "", "", err
+	}
+	if len(previous) == 0 {
+		return "", "", &notBlueGreen{functionName: functionName}
+	}
+
+	active := services.OtherColour(previous)
+	job, _, err := b.jobs.Info(b.jobID(functionName, active), &api.QueryOptions{Namespace: b.config.Scheduling.Namespace})
+	if err != nil {
+		if status, _ := classifyNomadError(err); status != http.StatusNotFound {
+			return "", "", err
+		}
+		job = nil
+	}
+	if job == nil || (job.Stop != nil && *job.Stop) {
+		return "", "", &notBlueGreen{functionName: functionName, colour: active}
+	}
+
+	if err := b.colours.SetActive(functionName, active); err != nil {
+		return "", "", err
+	}
+
+	b.cancelTeardown(functionName, active)
+	b.scheduleTeardown(functionName, previous)
+
+	return active, previous, nil
+}
+
+func (b *BlueGreenDeployments) scheduleTeardown(functionName, colour string) {
+	if b.grace <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := functionName + "-" + colour
+	if timer, ok := b.teardowns[key]; ok {
+		timer.Stop()
+	}
+	b.teardowns[key] = time.AfterFunc(b.grace, func() {
+		b.mu.Lock()
+		delete(b.teardowns, key)
+		b.mu.Unlock()
+
+		b.teardown(functionName, colour)
+	})
+}
+
+func (b *BlueGreenDeployments) cancelTeardown(functionName, colour string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := functionName + "-" + colour
+	if timer, ok := b.teardowns[key]; ok {
+		timer.Stop()
+		delete(b.teardowns, key)
+	}
+}
+
+// teardown stops the job of a colour, unless the colour was promoted again in the meantime.
+func (b *BlueGreenDeployments) teardown(functionName, colour string) {
+	namespace := b.config.Scheduling.Namespace
+
+	active, err := b.colours.Active(functionName)
+	if err != nil || active == colour {
+		return
+	}
+
+	jobID := b.jobID(functionName, colour)
+	if _, _, err := b.jobs.Deregister(jobID, false, &api.WriteOptions{Namespace: namespace}); err != nil {
+		b.logger.Warn("Error stopping previous colour of function", "function", functionName, "colour", colour, "namespace", namespace, "error", err.Error())
+		return
+	}
+	b.logger.Info("Previous colour of function stopped", "function", functionName, "colour", colour, "namespace", namespace)
+}
+
+// Resolve returns the function name of the active colour of a function deployed blue/green, e.g. echo-green for
+// echo, so the status, scale and logs of the function apply to the job serving its requests. Any other function
+// name is returned as is. As for the requests of the function, the last known colour is used when the active colour
+// can't be read.
+func (b *BlueGreenDeployments) Resolve(functionName string) string {
+	if b == nil {
+		return functionName
+	}
+	active, _ := b.colours.Active(functionName)
+	if len(active) == 0 {
+		return functionName
+	}
+	return functionName + "-" + active
+}
+
+// Listed returns the function name of a listed job of a function deployed blue/green, e.g. echo for echo-green,
+// and false for the job of the inactive colour, so the function is listed once, by the job of its active colour.
+func (b *BlueGreenDeployments) Listed(name string, labels map[string]string) (string, bool) {
+	if b == nil || !services.BlueGreen(&labels) {
+		return name, true
+	}
+	for _, colour := range []string{services.ColourBlue, services.ColourGreen} {
+		if functionName := strings.TrimSuffix(name, "-"+colour); functionName != name {
+			active, _ := b.colours.Active(functionName)
+			return functionName, active == colour
+		}
+	}
+	return name, true
+}
+
+// Delete deregisters the jobs of both colours of a function deployed blue/green, and removes its active colour.
+// It returns the function names of the colours, or false when the function isn't deployed blue/green.
+func (b *BlueGreenDeployments) Delete(functionName string) ([]string, bool, error) {
+	if b == nil {
+		return nil, false, nil
+	}
+	active, err := b.colours.Active(functionName)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(active) == 0 {
+		return nil, false, nil
+	}
+
+	namespace := b.config.Scheduling.Namespace
+	var names []string
+	for _, colour := range []string{services.ColourBlue, services.ColourGreen} {
+		b.cancelTeardown(functionName, colour)
+
+		if _, _, err := b.jobs.Deregister(b.jobID(functionName, colour), true, &api.WriteOptions{Namespace: namespace}); err != nil {
+			if status, _ := classifyNomadError(err); status != http.StatusNotFound {
+				return nil, true, err
+			}
+		}
+		names = append(names, functionName+"-"+colour)
+	}
+
+	if err := b.colours.Remove(functionName); err != nil {
+		return nil, true, err
+	}
+	return names, true, nil
+}
+
+func (b *BlueGreenDeployments) jobID(functionName, colour string) string {
+	return fmt.Sprintf("%s%s-%s", b.config.Scheduling.JobPrefix, functionName, colour)
+}
+
+type notBlueGreen struct {
+	functionName string
+	colour       string
+}
+
+func (e *notBlueGreen) Error() string {
+	if len(e.colour) == 0 {
+		return fmt.Sprintf("function %s is not deployed blue/green", e.functionName)
+	}
+	return fmt.Sprintf("function %s has no %s deployment to promote", e.functionName, e.colour)
+}
+
+// MakePromoteHandler flips the colour serving the requests of a function deployed blue/green.
+//
+// A 404 is returned when the function isn't deployed blue/green, and a 409 when the inactive colour isn't deployed.
+func MakePromoteHandler(config *types.ProviderConfig, blueGreen *BlueGreenDeployments, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("promote_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		active, previous, err := blueGreen.Promote(functionName)
+		if err != nil {
+			if e, ok := err.(*notBlueGreen); ok {
+				status := http.StatusNotFound
+				if len(e.colour) != 0 {
+					status = http.StatusConflict
+				}
+				httputil.Errorf(w, status, "%s", e.Error())
+				return
+			}
+			writeNomadError(w, err)
+			log.Error("Error promoting function", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		response, _ := json.Marshal(FunctionPromotion{
+			Name:      functionName,
+			Namespace: namespace,
+			Active:    active,
+			Previous:  previous,
+		})
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+
+		log.Info("Function promoted", "function", functionName, "namespace", namespace, "active", active, "previous", previous)
+	}
+}
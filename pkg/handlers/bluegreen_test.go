@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const colourKey = "faas-nomad/blue-green/default/echo"
+
+func setupBlueGreen(active string) (*types.ProviderConfig, *services.MockJobs, *services.MockConsulKV, *BlueGreenDeployments) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	kv := &services.MockConsulKV{}
+
+	if len(active) == 0 {
+		kv.On("Get", colourKey, mock.Anything).Return(nil, nil)
+	} else {
+		kv.On("Get", colourKey, mock.Anything).Return(&consulapi.KVPair{Key: colourKey, Value: []byte(active)}, nil)
+	}
+
+	colours := services.NewColours(kv, config.Consul.BlueGreenKVPrefix, config.Scheduling.Namespace)
+	return config, jobs, kv, NewBlueGreenDeployments(config, jobs, colours, hclog.Default())
+}
+
+func deployBlueGreen(config *types.ProviderConfig, jobs *services.MockJobs, blueGreen *BlueGreenDeployments) *httptest.ResponseRecorder {
+	labels := map[string]string{services.BlueGreenLabel: "true"}
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo", Labels: &labels})
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, nil, blueGreen, nil, nil, nil, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body)))
+	return recorder
+}
+
+func promote(config *types.ProviderConfig, blueGreen *BlueGreenDeployments) *httptest.ResponseRecorder {
+	request := mux.SetURLVars(httptest.NewRequest("POST", "/system/function/echo/promote", nil), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+	MakePromoteHandler(config, blueGreen, hclog.Default())(recorder, request)
+	return recorder
+}
+
+func TestDeployHandlerDeploysInactiveColour(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen(services.ColourBlue)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	recorder := deployBlueGreen(config, jobs, blueGreen)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, "faas-fn-echo-green", *job.ID)
+	kv.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerActivatesColourOfFirstDeployment(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen("")
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	kv.On("Put", &consulapi.KVPair{Key: colourKey, Value: []byte(services.ColourBlue)}, mock.Anything).Return(nil)
+
+	recorder := deployBlueGreen(config, jobs, blueGreen)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, "faas-fn-echo-blue", *job.ID)
+	kv.AssertExpectations(t)
+}
+
+func TestPromoteHandlerFlipsActiveColour(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen(services.ColourBlue)
+	blueGreen.grace = 0
+
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(createWarmJob(1, "0"), nil, nil)
+	kv.On("Put", &consulapi.KVPair{Key: colourKey, Value: []byte(services.ColourGreen)}, mock.Anything).Return(nil)
+
+	recorder := promote(config, blueGreen)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var promotion FunctionPromotion
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &promotion))
+	assert.Equal(t, FunctionPromotion{Name: "echo", Namespace: "default", Active: services.ColourGreen, Previous: services.ColourBlue}, promotion)
+
+	// the promote is reverted by promoting again, from the cached colour
+	jobs.On("Info", "faas-fn-echo-blue", mock.Anything).Return(createWarmJob(1, "0"), nil, nil)
+	kv.On("Put", &consulapi.KVPair{Key: colourKey, Value: []byte(services.ColourBlue)}, mock.Anything).Return(nil)
+
+	recorder = promote(config, blueGreen)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &promotion))
+	assert.Equal(t, services.ColourBlue, promotion.Active)
+	kv.AssertExpectations(t)
+}
+
+func TestPromoteHandlerStopsPreviousColourAfterGracePeriod(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen(services.ColourBlue)
+	blueGreen.grace = 10 * time.Millisecond
+
+	stopped := make(chan string, 1)
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(createWarmJob(1, "0"), nil, nil)
+	jobs.On("Deregister", "faas-fn-echo-blue", false, mock.Anything).Return(nil, nil, nil).Run(func(args mock.Arguments) {
+		stopped <- args.String(0)
+	})
+	kv.On("Put", mock.Anything, mock.Anything).Return(nil)
+
+	recorder := promote(config, blueGreen)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	select {
+	case jobID := <-stopped:
+		assert.Equal(t, "faas-fn-echo-blue", jobID)
+	case <-time.After(time.Second):
+		t.Fatal("previous colour was not stopped")
+	}
+}
+
+func TestPromoteHandlerReportsMissingInactiveColour(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen(services.ColourBlue)
+
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+
+	recorder := promote(config, blueGreen)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "no green deployment")
+	kv.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestPromoteHandlerReportsFunctionNotDeployedBlueGreen(t *testing.T) {
+	config, _, _, blueGreen := setupBlueGreen("")
+
+	recorder := promote(config, blueGreen)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func colourJob(colour string) *api.Job {
+	job := createMockJob("faas-fn-echo-"+colour, "running")
+	name := "faas-fn-echo-" + colour
+	job.ID = &name
+	job.Name = &name
+	job.TaskGroups[0].Tasks[0].Meta = map[string]string{services.BlueGreenLabel: "true"}
+	return job
+}
+
+func TestFunctionReaderListsActiveColourOnce(t *testing.T) {
+	config, jobs, _, blueGreen := setupBlueGreen(services.ColourGreen)
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-echo-blue", Name: "faas-fn-echo-blue", Status: "running"},
+		{ID: "faas-fn-echo-green", Name: "faas-fn-echo-green", Status: "running"},
+	}, nil, nil)
+	jobs.On("Info", "faas-fn-echo-blue", mock.Anything).Return(colourJob(services.ColourBlue), nil, nil)
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(colourJob(services.ColourGreen), nil, nil)
+
+	recorder := httptest.NewRecorder()
+	MakeFunctionReader(config, jobs, blueGreen, hclog.Default())(recorder, httptest.NewRequest("GET", "/system/functions", nil))
+
+	funcs := make([]ftypes.FunctionStatus, 0)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &funcs))
+	assert.Len(t, funcs, 1)
+	assert.Equal(t, "echo", funcs[0].Name)
+}
+
+func TestReplicaReaderReadsActiveColour(t *testing.T) {
+	config, jobs, _, blueGreen := setupBlueGreen(services.ColourGreen)
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(colourJob(services.ColourGreen), nil, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("ResolveAll", "echo-green").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/system/function/echo", nil), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+	MakeReplicaReader(config, jobs, resolver, &services.MockServiceMaintenance{}, blueGreen, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var status ftypes.FunctionStatus
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, "echo", status.Name)
+	assert.Equal(t, uint64(1), status.AvailableReplicas)
+}
+
+func TestReplicaUpdaterScalesActiveColour(t *testing.T) {
+	config, jobs, _, blueGreen := setupBlueGreen(services.ColourGreen)
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(colourJob(services.ColourGreen), nil, nil)
+
+	replicas := 3
+	jobs.On("Scale", "faas-fn-echo-green", "echo-green", &replicas, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: 3})
+	recorder := httptest.NewRecorder()
+	MakeReplicaUpdater(config, jobs, nil, nil, blueGreen, nil, hclog.Default())(recorder, httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertExpectations(t)
+}
+
+func TestLogHandlerReadsLogsOfActiveColour(t *testing.T) {
+	config, jobs, _, blueGreen := setupBlueGreen(services.ColourGreen)
+	jobs.On("Info", "faas-fn-echo-green", mock.Anything).Return(colourJob(services.ColourGreen), nil, nil)
+	jobs.On("Allocations", "faas-fn-echo-green", false, mock.Anything).Return([]*api.AllocationListStub{}, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	MakeLogHandler(config, jobs, &services.MockAllocations{}, &services.MockAllocFS{}, blueGreen, hclog.Default())(recorder, httptest.NewRequest("GET", "/system/logs?name=echo", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	jobs.AssertCalled(t, "Allocations", "faas-fn-echo-green", false, mock.Anything)
+}
+
+func TestDeleteHandlerDeregistersBothColours(t *testing.T) {
+	config, jobs, kv, blueGreen := setupBlueGreen(services.ColourGreen)
+	jobs.On("Deregister", "faas-fn-echo-blue", true, mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("Deregister", "faas-fn-echo-green", true, mock.Anything).Return(nil, nil, nil)
+	kv.On("Delete", colourKey, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(ftypes.DeleteFunctionRequest{FunctionName: "echo"})
+	recorder := httptest.NewRecorder()
+	MakeDeleteHandler(config, jobs, nil, nil, blueGreen, nil, hclog.Default())(recorder, httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertExpectations(t)
+	kv.AssertExpectations(t)
+}
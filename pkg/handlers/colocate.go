@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// colocateLabel places a function, best effort, on the nodes running the instances of the listed functions,
+	// e.g. com.openfaas.colocate-with=api,db for a chain of latency sensitive functions calling each other.
+	colocateLabel = "com.openfaas.colocate-with"
+
+	colocateAttribute = "${node.unique.id}"
+	colocateWeight    = 50
+)
+
+// parseColocation returns the functions of the com.openfaas.colocate-with label of a function.
+func parseColocation(config *types.ProviderConfig, functionName string, fd ftypes.FunctionDeployment) ([]string, error) {
+	if fd.Labels == nil {
+		return nil, nil
+	}
+	value, ok := (*fd.Labels)[colocateLabel]
+	if !ok {
+		return nil, nil
+	}
+
+	var functions []string
+	for _, entry := range strings.Split(value, ",") {
+		name := strings.TrimSuffix(strings.TrimSpace(entry), "."+config.Scheduling.Namespace)
+		if !types.ValidServiceName(name) {
+			return nil, fmt.Errorf("invalid %s '%s', '%s' is not a valid function name", colocateLabel, value, name)
+		}
+		if name == functionName {
+			return nil, fmt.Errorf("invalid %s '%s', a function can't be colocated with itself", colocateLabel, value)
+		}
+		functions = append(functions, name)
+	}
+	return functions, nil
+}
+
+// colocate adds an affinity to the job of a function for the nodes running the allocations of the given functions.
+// The affinity is a soft constraint, so the function is placed on other nodes when these can't fit it, and the nodes
+// are read at deploy time, so the affinity follows the functions when they move on a later deployment.
+//
+// A function which isn't deployed yet, or has no running allocations, is a forward reference and doesn't add any node.
+func colocate(config *types.ProviderConfig, jobs services.Jobs, job *api.Job, functions []string) error {
+	if len(functions) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var nodes []string
+	for _, functionName := range functions {
+		jobID, err := functionJobID(config, jobs, functionName)
+		if err != nil {
+			return err
+		}
+
+		allocations, _, err := jobs.Allocations(jobID, false, &api.QueryOptions{Namespace: config.Scheduling.Namespace})
+		if err != nil {
+			if status, _ := classifyNomadError(err); status == http.StatusNotFound {
+				continue
+			}
+			return err
+		}
+
+		for _, allocation := range allocations {
+			if allocation.ClientStatus != api.AllocClientStatusRunning || seen[allocation.NodeID] {
+				continue
+			}
+			seen[allocation.NodeID] = true
+			nodes = append(nodes, allocation.NodeID)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// sorted, so that the job doesn't change when the function is deployed again on the same nodes
+	sort.Strings(nodes)
+	job.Affinities = append(job.Affinities, api.NewAffinity(colocateAttribute, api.ConstraintSetContainsAny, strings.Join(nodes, ","), colocateWeight))
+	return nil
+}
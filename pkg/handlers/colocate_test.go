@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func colocatedDeployment(value string) []byte {
+	labels := map[string]string{colocateLabel: value}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "echo"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+	return body
+}
+
+func TestDeployHandlerAddsAffinityForNodesOfColocatedFunctions(t *testing.T) {
+	jobs, deployHandler, request, recorder := setupDeployHandler(colocatedDeployment("api,db.default"))
+
+	jobs.On("Info", "faas-fn-api", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Info", "faas-fn-db", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Allocations", "faas-fn-api", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "a1", NodeID: "node-2", ClientStatus: api.AllocClientStatusRunning},
+		{ID: "a2", NodeID: "node-3", ClientStatus: api.AllocClientStatusComplete},
+	}, nil, nil)
+	jobs.On("Allocations", "faas-fn-db", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "d1", NodeID: "node-1", ClientStatus: api.AllocClientStatusRunning},
+		{ID: "d2", NodeID: "node-2", ClientStatus: api.AllocClientStatusRunning},
+	}, nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var job *api.Job
+	for _, call := range jobs.Calls {
+		if call.Method == "RegisterOpts" {
+			job = call.Arguments.Get(0).(*api.Job)
+		}
+	}
+
+	assert.Equal(t, []*api.Affinity{
+		api.NewAffinity("${node.unique.id}", api.ConstraintSetContainsAny, "node-1,node-2", 50),
+	}, job.Affinities)
+}
+
+func TestDeployHandlerAllowsForwardReferenceToColocatedFunction(t *testing.T) {
+	jobs, deployHandler, request, recorder := setupDeployHandler(colocatedDeployment("api"))
+
+	jobs.On("Info", "faas-fn-api", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{}, nil, nil)
+	jobs.On("Allocations", "faas-fn-api", false, mock.Anything).Return([]*api.AllocationListStub{}, nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	jobs.AssertCalled(t, "RegisterOpts", mock.MatchedBy(func(job *api.Job) bool {
+		return len(job.Affinities) == 0
+	}), mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerReportsErrorWhenColocationIsInvalid(t *testing.T) {
+	for _, value := range []string{"echo", "api,", "api/v1"} {
+		jobs, deployHandler, request, recorder := setupDeployHandler(colocatedDeployment(value))
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, value)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerReportsErrorWhenColocatedFunctionCantBeRead(t *testing.T) {
+	jobs, deployHandler, request, recorder := setupDeployHandler(colocatedDeployment("api"))
+
+	jobs.On("Info", "faas-fn-api", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 403 (Permission denied)"))
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
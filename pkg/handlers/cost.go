@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+type CostUsage struct {
+	Label     string `json:"label"`
+	Value     string `json:"value"`
+	Functions int    `json:"functions"`
+	CPU       int    `json:"cpu"`
+	Memory    int    `json:"memory"`
+}
+
+// MakeCostUsageHandler reports the resources currently reserved by the functions, grouped by the value of a cost
+// attribution label, e.g. ?by=team. The label defaults to the first configured cost label. Functions without the
+// label are reported with an empty value, and stopped functions don't reserve any resources.
+func MakeCostUsageHandler(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("cost_usage_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(config.Scheduling.CostLabels) == 0 {
+			httputil.Errorf(w, http.StatusNotFound, "no cost labels configured")
+			return
+		}
+
+		label := r.URL.Query().Get("by")
+		if len(label) == 0 {
+			label = config.Scheduling.CostLabels[0]
+		}
+		if !isCostLabel(config, label) {
+			httputil.Errorf(w, http.StatusBadRequest, "label %s is not a cost label", label)
+			return
+		}
+
+		namespace := config.Scheduling.Namespace
+		options := &api.QueryOptions{Namespace: namespace}
+
+		list, err := services.ListFunctionJobs(jobs, config.Scheduling.JobPrefix, options)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error listing functions", "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		usages := map[string]*CostUsage{}
+		for _, stub := range list {
+			if stub.Stop {
+				continue
+			}
+
+			job, _, err := jobs.Info(stub.ID, options)
+			if err != nil {
+				writeNomadError(w, err)
+				log.Error("Error reading function", "function", stub.ID, "namespace", namespace, "error", err.Error())
+				return
+			}
+
+			value := job.Meta[services.CostMetaKey(label)]
+			usage, ok := usages[value]
+			if !ok {
+				usage = &CostUsage{Label: label, Value: value}
+				usages[value] = usage
+			}
+
+			reserved := resourceUsage{}
+			reserved.add(job, nil)
+
+			usage.Functions++
+			usage.CPU += reserved.CPU
+			usage.Memory += reserved.Memory
+		}
+
+		result := make([]CostUsage, 0, len(usages))
+		for _, usage := range usages {
+			result = append(result, *usage)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Value < result[j].Value })
+
+		response, _ := json.Marshal(result)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
+
+func isCostLabel(config *types.ProviderConfig, label string) bool {
+	for _, l := range config.Scheduling.CostLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
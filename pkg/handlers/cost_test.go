@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func costJob(id string, count, cpu, memory int, meta map[string]string) *api.Job {
+	job := createQuotaJob(id, count, cpu, memory)
+	job.Meta = meta
+	return job
+}
+
+func setupCostUsageHandler(query string) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-billing", Name: "faas-fn-billing"},
+		{ID: "faas-fn-invoices", Name: "faas-fn-invoices"},
+		{ID: "faas-fn-search", Name: "faas-fn-search"},
+		{ID: "faas-fn-index", Name: "faas-fn-index"},
+		{ID: "faas-fn-legacy", Name: "faas-fn-legacy"},
+		{ID: "faas-fn-deleted", Name: "faas-fn-deleted", Stop: true},
+	}, nil, nil)
+	jobs.On("Info", "faas-fn-billing", mock.Anything).Return(costJob("faas-fn-billing", 2, 100, 128, map[string]string{"faas_nomad_cost_team": "finance", "faas_nomad_cost_project": "billing"}), nil, nil)
+	jobs.On("Info", "faas-fn-invoices", mock.Anything).Return(costJob("faas-fn-invoices", 1, 200, 256, map[string]string{"faas_nomad_cost_team": "finance", "faas_nomad_cost_project": "invoicing"}), nil, nil)
+	jobs.On("Info", "faas-fn-search", mock.Anything).Return(costJob("faas-fn-search", 3, 500, 512, map[string]string{"faas_nomad_cost_team": "platform"}), nil, nil)
+	jobs.On("Info", "faas-fn-index", mock.Anything).Return(costJob("faas-fn-index", 1, 250, 1024, map[string]string{"faas_nomad_cost_team": "platform"}), nil, nil)
+	jobs.On("Info", "faas-fn-legacy", mock.Anything).Return(costJob("faas-fn-legacy", 1, 100, 64, nil), nil, nil)
+
+	request := httptest.NewRequest("GET", "/system/cost/usage"+query, nil)
+	return jobs, MakeCostUsageHandler(config, jobs, hclog.Default()), request, httptest.NewRecorder()
+}
+
+func TestCostUsageHandlerSumsReservedResourcesPerTeam(t *testing.T) {
+	jobs, handler, request, recorder := setupCostUsageHandler("")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var usage []CostUsage
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &usage))
+	assert.Equal(t, []CostUsage{
+		{Label: "team", Value: "", Functions: 1, CPU: 100, Memory: 64},
+		{Label: "team", Value: "finance", Functions: 2, CPU: 400, Memory: 512},
+		{Label: "team", Value: "platform", Functions: 2, CPU: 1750, Memory: 2560},
+	}, usage)
+	jobs.AssertNotCalled(t, "Info", "faas-fn-deleted", mock.Anything)
+}
+
+func TestCostUsageHandlerGroupsByRequestedLabel(t *testing.T) {
+	_, handler, request, recorder := setupCostUsageHandler("?by=project")
+
+	handler(recorder, request)
+
+	var usage []CostUsage
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &usage))
+	assert.Equal(t, []CostUsage{
+		{Label: "project", Value: "", Functions: 3, CPU: 1850, Memory: 2624},
+		{Label: "project", Value: "billing", Functions: 1, CPU: 200, Memory: 256},
+		{Label: "project", Value: "invoicing", Functions: 1, CPU: 200, Memory: 256},
+	}, usage)
+}
+
+func TestCostUsageHandlerRejectsUnknownLabel(t *testing.T) {
+	jobs, handler, request, recorder := setupCostUsageHandler("?by=owner")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "List", mock.Anything)
+}
@@ -2,20 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
 	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
-func MakeDeleteHandler(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
+// FunctionCache is state kept per function, e.g. the resolved instances of the function, which is removed
+// once the function is deleted.
+type FunctionCache interface {
+	RemoveCacheItem(functionName string)
+}
+
+func MakeDeleteHandler(config *types.ProviderConfig, jobs services.Jobs, intentions *services.Intentions, deletes *softdelete.Tracker, blueGreen *BlueGreenDeployments, caches []FunctionCache, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
 	log := logger.Named("delete_handler")
 
+	// the intentions of a soft-deleted function are kept until it is purged, so a restored function keeps them
+	if deletes != nil {
+		deletes.OnPurge(func(functionName string) {
+			deleteIntentions(config, intentions, functionName, log)
+			removeCaches(caches, functionName)
+		})
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		body, _ := ioutil.ReadAll(r.Body)
@@ -27,18 +41,89 @@ func MakeDeleteHandler(config *types.ProviderConfig, jobs services.Jobs, logger
 		}
 
 		namespace := config.Scheduling.Namespace
-		jobName := fmt.Sprintf("%s%s", config.Scheduling.JobPrefix, req.FunctionName)
+
+		// both colours of a function deployed blue/green are purged right away, as the stopped jobs couldn't be
+		// restored without the active colour
+		colours, ok, err := blueGreen.Delete(req.FunctionName)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error deregistering function", "function", req.FunctionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+		if ok {
+			for _, colour := range colours {
+				deleteIntentions(config, intentions, colour, log)
+				if deletes != nil {
+					deletes.Forget(colour)
+				}
+				removeCaches(caches, colour)
+			}
+			removeCaches(caches, req.FunctionName)
+
+			log.Debug("Function deregistered successfully", "function", req.FunctionName, "namespace", namespace)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		jobName, err := functionJobID(config, jobs, req.FunctionName)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function", "function", req.FunctionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		if deletes != nil && r.URL.Query().Get("purge") != "true" {
+			if err := deletes.Delete(namespace, jobName, req.FunctionName); err != nil {
+				writeNomadError(w, err)
+				log.Error("Error stopping function", "function", jobName, "namespace", namespace, "error", err.Error())
+				return
+			}
+
+			removeCaches(caches, req.FunctionName)
+
+			log.Debug("Function stopped successfully, pending purge", "function", jobName, "namespace", namespace)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 
 		_, _, err = jobs.Deregister(jobName, true, &api.WriteOptions{Namespace: namespace})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			writeNomadError(w, err)
 			log.Error("Error deregistering function", "function", jobName, "namespace", namespace, "error", err.Error())
 			return
 		}
 
+		deleteIntentions(config, intentions, req.FunctionName, log)
+
+		if deletes != nil {
+			deletes.Forget(req.FunctionName)
+		}
+
+		removeCaches(caches, req.FunctionName)
+
 		log.Debug("Function deregistered successfully", "function", jobName, "namespace", namespace)
 		w.WriteHeader(http.StatusOK)
 	}
 
 }
+
+// deleteIntentions removes the intentions of the service of a deleted function.
+func deleteIntentions(config *types.ProviderConfig, intentions *services.Intentions, functionName string, log hclog.Logger) {
+	if intentions == nil {
+		return
+	}
+	namespace := config.Scheduling.Namespace
+	serviceName, err := config.Scheduling.ServiceName.Render(config.Scheduling.JobPrefix, functionName, namespace)
+	if err == nil {
+		err = intentions.Delete(serviceName)
+	}
+	if err != nil {
+		log.Warn("Error removing intentions of function", "function", functionName, "namespace", namespace, "error", err.Error())
+	}
+}
+
+func removeCaches(caches []FunctionCache, functionName string) {
+	for _, cache := range caches {
+		cache.RemoveCacheItem(functionName)
+	}
+}
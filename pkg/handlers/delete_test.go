@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/proxy"
 	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +31,7 @@ func setupDeleteHandler(body []byte) (*services.MockJobs, http.HandlerFunc, *htt
 		JobPrefix: "faas-fn-",
 	}}
 
-	handler := MakeDeleteHandler(config, jobs, hclog.Default())
+	handler := MakeDeleteHandler(config, jobs, nil, nil, nil, nil, hclog.Default())
 
 	return jobs, handler, request, response
 }
@@ -45,6 +50,7 @@ func TestDeleteHandlerReportsErrorWhenDeregisterFails(t *testing.T) {
 	data, _ := json.Marshal(req)
 
 	jobs, deleteHandler, request, recorder := setupDeleteHandler(data)
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
 	jobs.On("Deregister", "faas-fn-func123", mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
 
 	deleteHandler(recorder, request)
@@ -59,6 +65,7 @@ func TestDeleteHandlerDeregisterJob(t *testing.T) {
 	data, _ := json.Marshal(req)
 
 	jobs, deleteHandler, request, recorder := setupDeleteHandler(data)
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
 	jobs.On("Deregister", "faas-fn-func123", mock.Anything, mock.Anything).Return(nil, nil, nil)
 
 	deleteHandler(recorder, request)
@@ -66,3 +73,190 @@ func TestDeleteHandlerDeregisterJob(t *testing.T) {
 	assert.Equal(t, http.StatusOK, recorder.Code)
 	jobs.AssertCalled(t, "Deregister", "faas-fn-func123", mock.Anything, mock.Anything)
 }
+
+func setupSoftDeleteHandler(body []byte, target string) (*services.MockJobs, *softdelete.Tracker, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	jobs := &services.MockJobs{}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("DELETE", target, bytes.NewReader(body))
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+	}}
+
+	deletes := softdelete.NewTracker(jobs, time.Hour, hclog.Default())
+	handler := MakeDeleteHandler(config, jobs, nil, deletes, nil, nil, hclog.Default())
+
+	return jobs, deletes, handler, request, response
+}
+
+func TestDeleteHandlerSoftDeletesJob(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	jobs, deletes, deleteHandler, request, recorder := setupSoftDeleteHandler(data, "/system/functions")
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", false, mock.Anything).Return(nil, nil, nil)
+
+	deleteHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, deletes.IsPending("func123"))
+	jobs.AssertNotCalled(t, "Deregister", "faas-fn-func123", true, mock.Anything)
+}
+
+func TestDeleteHandlerPurgesJobWhenRequested(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	jobs, deletes, deleteHandler, request, recorder := setupSoftDeleteHandler(data, "/system/functions?purge=true")
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", true, mock.Anything).Return(nil, nil, nil)
+
+	deleteHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, deletes.IsPending("func123"))
+	jobs.AssertCalled(t, "Deregister", "faas-fn-func123", true, mock.Anything)
+}
+
+func TestDeleteHandlerRemovesFunctionCaches(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+	}}
+
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("RemoveCacheItem", "func123").Return()
+
+	limiter := NewScaleLimiter()
+	limiter.limit("func123", nil, 1, 2)
+
+	handler := MakeDeleteHandler(config, jobs, nil, nil, nil, []FunctionCache{resolver, limiter}, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader(data)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	resolver.AssertCalled(t, "RemoveCacheItem", "func123")
+	assert.NotContains(t, limiter.last, "func123")
+}
+
+func TestDeleteHandlerDrainsCachedResponsesOfSoftDeletedFunction(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+	}}
+
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", false, mock.Anything).Return(nil, nil, nil)
+
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "func123").Return(map[string]string{"com.openfaas.cache-ttl": "1m"}, nil)
+
+	cache, err := proxy.NewResponseCache(types.ProxyConfig{CacheSize: 10}, labels, proxy.NewSettings(config))
+	assert.NoError(t, err)
+
+	invoke := cache.Middleware()(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	invocation := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		invoke(recorder, mux.SetURLVars(httptest.NewRequest("GET", "/function/func123", nil), map[string]string{"name": "func123"}))
+		return recorder
+	}
+	invocation()
+	assert.Equal(t, "HIT", invocation().Header().Get(proxy.CacheHeader))
+
+	deletes := softdelete.NewTracker(jobs, time.Hour, hclog.Default())
+	handler := MakeDeleteHandler(config, jobs, nil, deletes, nil, []FunctionCache{cache}, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader(data)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, deletes.IsPending("func123"))
+	assert.Equal(t, "MISS", invocation().Header().Get(proxy.CacheHeader))
+}
+
+func TestDeleteHandlerKeepsFunctionCachesWhenDeregisterFails(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+	}}
+
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
+
+	resolver := &services.MockResolver{}
+
+	handler := MakeDeleteHandler(config, jobs, nil, nil, nil, []FunctionCache{resolver}, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader(data)))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	resolver.AssertNotCalled(t, "RemoveCacheItem", mock.Anything)
+}
+
+func TestDeleteHandlerDeletesIntentionsOnPurge(t *testing.T) {
+	req := ftypes.DeleteFunctionRequest{}
+	req.FunctionName = "func123"
+	data, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+
+	stop := true
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-func123", mock.Anything).Return(&api.Job{Stop: &stop}, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", false, mock.Anything).Return(nil, nil, nil)
+	jobs.On("Deregister", "faas-fn-func123", true, mock.Anything).Return(nil, nil, nil)
+
+	purged := make(chan struct{})
+	connect := &services.MockConsulConnect{}
+	connect.On("IntentionMatch", mock.Anything, mock.Anything).Return(nil, nil).Run(func(args mock.Arguments) { close(purged) })
+
+	deletes := softdelete.NewTracker(jobs, 10*time.Millisecond, hclog.Default())
+	handler := MakeDeleteHandler(config, jobs, services.NewIntentions(connect), deletes, nil, nil, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("DELETE", "/system/functions", bytes.NewReader(data)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	select {
+	case <-purged:
+	case <-time.After(time.Second):
+		t.Fatal("intentions of function not deleted after the purge")
+	}
+	jobs.AssertCalled(t, "Deregister", "faas-fn-func123", true, mock.Anything)
+}
+
+func TestFunctionStatusReportsSoftDelete(t *testing.T) {
+	job := createMockJob("1", "dead")
+	stop := true
+	job.Stop = &stop
+
+	status := createFunctionStatus(job, "faas-fn-")
+
+	assert.Equal(t, uint64(0), status.Replicas)
+	assert.Equal(t, "true", (*status.Annotations)[SoftDeletedAnnotation])
+	assert.NotContains(t, *createFunctionStatus(createMockJob("1", "running"), "faas-fn-").Annotations, SoftDeletedAnnotation)
+}
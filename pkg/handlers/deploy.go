@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/admission"
 	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 	"io/ioutil"
 	"net/http"
 )
 
-func MakeDeployHandler(config *types.ProviderConfig, jobFactory services.JobFactory, jobs services.Jobs, secrets services.Secrets, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
+func MakeDeployHandler(config *types.ProviderConfig, jobFactory services.JobFactory, jobs services.Jobs, secrets services.SecretStore, intentions *services.Intentions, webhook *admission.Webhook, warmer *FunctionWarmer, deletes *softdelete.Tracker, monitor *DeploymentMonitor, prepuller *ImagePrepuller, blueGreen *BlueGreenDeployments, prober *HealthProber, evaluations *EvaluationRetrier, quota *QuotaChecker, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
 	log := logger.Named("deploy_handler")
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -27,29 +29,197 @@ func MakeDeployHandler(config *types.ProviderConfig, jobFactory services.JobFact
 
 		namespace := config.Scheduling.Namespace
 
+		req.Service, err = sanitiseFunctionName(config, namespace, req.Service)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// a function deployed blue/green is deployed as the job of its inactive colour
+		functionName, colour := req.Service, ""
+		if blueGreen != nil && services.BlueGreen(req.Labels) {
+			colour, err = blueGreen.Target(functionName)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error reading active colour of function", "function", functionName, "namespace", namespace, "error", err.Error())
+				return
+			}
+			req.Service, err = sanitiseFunctionName(config, namespace, functionName+"-"+colour)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
 		// validate secrets
 		for _, s := range req.Secrets {
-			if !secrets.Exists(s) {
+			if !secrets.Exists(namespace, s) {
 				writeError(w, http.StatusBadRequest, fmt.Errorf("secret with key '%s' is not available", s))
 				return
 			}
 		}
 
-		job := jobFactory.CreateJob(namespace, req)
+		job, err := jobFactory.CreateJob(namespace, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		colocated, err := parseColocation(config, functionName, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := colocate(config, jobs, job, colocated); err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading the nodes of colocated functions", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+			return
+		}
+
+		if err := quota.Check(namespace, job, nil); err != nil {
+			if exceeded, ok := err.(*QuotaExceeded); ok {
+				writeError(w, http.StatusForbidden, exceeded)
+				log.Debug("Function exceeds the quota of the namespace", "function", *job.Name, "namespace", *job.Namespace)
+				return
+			}
+			writeNomadError(w, err)
+			log.Error("Error checking the quota of the namespace", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+			return
+		}
+
+		if webhook != nil {
+			if err := webhook.Admit(namespace, req, job); err != nil {
+				if rejection, ok := err.(*admission.Rejection); ok {
+					writeError(w, http.StatusForbidden, rejection)
+					log.Debug("Function rejected by admission webhook", "function", *job.Name, "namespace", *job.Namespace, "status", rejection.StatusCode)
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error reviewing function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+				return
+			}
+		}
 
 		// Use the Nomad API client to register the job
 		writeOptions := &api.WriteOptions{Namespace: namespace}
 		registerOptions := &api.RegisterOptions{
 			PreserveCounts: true,
 		}
-		_, _, err = jobs.RegisterOpts(job, registerOptions, writeOptions)
+		registration, _, err := jobs.RegisterOpts(job, registerOptions, writeOptions)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
+			writeNomadError(w, err)
 			log.Error("Error registering function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
 			return
 		}
 
+		if intentions != nil {
+			if err := syncIntentions(config, intentions, namespace, req); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error updating intentions of function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+				return
+			}
+		}
+
+		if deletes != nil {
+			deletes.Forget(req.Service)
+		}
+
+		if evaluations != nil && registration != nil {
+			if err := evaluations.Await(r.Context(), namespace, *job.ID, registration.EvalID); err != nil {
+				if failed, ok := err.(*PlacementFailure); ok && failed.Transient {
+					// the function stays registered, Nomad places it once the cluster has the capacity
+					response, _ := json.Marshal(FunctionPlacementPending{
+						Name:      req.Service,
+						Namespace: namespace,
+						Status:    evalStatusPending,
+						EvalID:    failed.EvalID,
+						Attempts:  failed.Attempts,
+						Reason:    failed.Reason,
+					})
+					w.Header().Set(HeaderContentType, TypeApplicationJson)
+					w.WriteHeader(http.StatusAccepted)
+					w.Write(response)
+					log.Warn("Function placement pending", "function", *job.Name, "namespace", *job.Namespace, "error", failed.Error())
+					return
+				}
+				if failed, ok := err.(*PlacementFailure); ok {
+					if err := evaluations.Rollback(namespace, *job.ID); err != nil {
+						log.Error("Error rolling back function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+					}
+					writeError(w, http.StatusBadRequest, failed)
+					log.Warn("Function placement failed, rolled back", "function", *job.Name, "namespace", *job.Namespace, "error", failed.Error())
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error evaluating function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+				return
+			}
+		}
+
+		if monitor != nil {
+			if err := monitor.Await(r.Context(), namespace, *job.ID, req); err != nil {
+				if timeout, ok := err.(*DeploymentTimeout); ok {
+					writeError(w, http.StatusGatewayTimeout, timeout)
+					log.Warn("Function deployment timed out", "function", *job.Name, "namespace", *job.Namespace, "error", timeout.Error())
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error deploying function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+				return
+			}
+		}
+
+		if prober != nil {
+			if err := prober.Probe(r.Context(), namespace, *job.ID, req.Service, req.Labels); err != nil {
+				if failed, ok := err.(*HealthProbeFailed); ok {
+					writeError(w, http.StatusBadGateway, failed)
+					log.Warn("Function failed health probes", "function", *job.Name, "namespace", *job.Namespace, "error", failed.Error())
+					return
+				}
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error probing function", "function", *job.Name, "namespace", *job.Namespace, "error", err.Error())
+				return
+			}
+		}
+
+		if len(colour) != 0 {
+			if err := blueGreen.Deployed(functionName, colour); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error activating colour of function", "function", functionName, "colour", colour, "namespace", namespace, "error", err.Error())
+				return
+			}
+		}
+
+		if prepuller != nil {
+			prepuller.Prepull(namespace, job, req.Labels)
+		}
+
+		if warmer != nil {
+			go warmer.Warmup(namespace, *job.ID, req.Service, req.Labels)
+		}
+
 		log.Debug("Function registered successfully", "function", *job.Name, "namespace", *job.Namespace)
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// syncIntentions allows the function to reach the upstreams of its egress label through Consul Connect,
+// the egress to any other service is denied by Connect.
+func syncIntentions(config *types.ProviderConfig, intentions *services.Intentions, namespace string, fd ftypes.FunctionDeployment) error {
+	serviceName, err := config.Scheduling.ServiceName.Render(config.Scheduling.JobPrefix, fd.Service, namespace)
+	if err != nil {
+		return err
+	}
+
+	upstreams, err := services.ParseEgress(fd)
+	if err != nil {
+		return err
+	}
+
+	var destinations []string
+	for _, upstream := range upstreams {
+		destinations = append(destinations, upstream.DestinationName)
+	}
+
+	return intentions.Sync(serviceName, destinations)
+}
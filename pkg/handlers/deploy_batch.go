@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+// BatchDeployRequest is a function of a batch deployment, with the functions it needs to be available.
+type BatchDeployRequest struct {
+	ftypes.FunctionDeployment
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+type BatchDeployResult struct {
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchDeployer deploys a batch of functions in the order of their dependencies. A function is deployed once the
+// functions it depends on are healthy, either deployed by the same batch or already running. The functions depending
+// on a function which failed to deploy, or didn't become healthy in time, are not deployed.
+type BatchDeployer struct {
+	config       *types.ProviderConfig
+	deploy       http.HandlerFunc
+	resolver     resolver.ServiceResolver
+	pollInterval time.Duration
+	logger       hclog.Logger
+}
+
+func NewBatchDeployer(config *types.ProviderConfig, deploy http.HandlerFunc, resolver resolver.ServiceResolver, logger hclog.Logger) *BatchDeployer {
+	return &BatchDeployer{
+		config:       config,
+		deploy:       deploy,
+		resolver:     resolver,
+		pollInterval: 2 * time.Second,
+		logger:       logger.Named("batch_deployer"),
+	}
+}
+
+// Deploy deploys the functions of a batch, and returns their results in the order of the batch. An error is
+// returned, without deploying any function, when the dependencies are cyclic.
+func (d *BatchDeployer) Deploy(ctx context.Context, batch []BatchDeployRequest) ([]BatchDeployResult, error) {
+	order, err := dependencyOrder(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchDeployResult, len(batch))
+	deployed := map[string]*BatchDeployResult{}
+	healthy := map[string]error{}
+
+	for _, i := range order {
+		item := batch[i]
+		result := &results[i]
+		result.Name = item.Service
+		deployed[item.Service] = result
+
+		if err := d.awaitDependencies(ctx, item, deployed, healthy); err != nil {
+			result.Status = http.StatusFailedDependency
+			result.Error = err.Error()
+			d.logger.Warn("Function not deployed", "function", item.Service, "namespace", d.config.Scheduling.Namespace, "error", err.Error())
+			continue
+		}
+
+		result.Status, result.Error = d.deployFunction(ctx, item.FunctionDeployment)
+	}
+
+	return results, nil
+}
+
+func (d *BatchDeployer) awaitDependencies(ctx context.Context, item BatchDeployRequest, deployed map[string]*BatchDeployResult, healthy map[string]error) error {
+	for _, dependency := range item.DependsOn {
+		if result, ok := deployed[dependency]; ok && result.Status != http.StatusOK {
+			return fmt.Errorf("dependency %s failed to deploy", dependency)
+		}
+
+		err, ok := healthy[dependency]
+		if !ok {
+			err = d.awaitHealthy(ctx, dependency)
+			healthy[dependency] = err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitHealthy waits for a function to have at least one healthy instance, within the dependency timeout.
+func (d *BatchDeployer) awaitHealthy(ctx context.Context, functionName string) error {
+	timeout := d.config.Scheduling.DependencyTimeout
+	deadline := time.Now().Add(timeout)
+
+	for {
+		instances, err := d.resolver.ResolveAll(functionName)
+		if err == nil && len(instances) != 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("dependency %s not healthy after %s", functionName, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dependency %s not healthy: %s", functionName, ctx.Err())
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+func (d *BatchDeployer) deployFunction(ctx context.Context, fd ftypes.FunctionDeployment) (int, string) {
+	body, _ := json.Marshal(fd)
+	request, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/system/functions", bytes.NewReader(body))
+
+	response := &deployResponse{header: http.Header{}}
+	d.deploy(response, request)
+
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	if response.status != http.StatusOK {
+		message := strings.TrimSpace(response.body.String())
+		if len(message) == 0 {
+			message = http.StatusText(response.status)
+		}
+		return response.status, message
+	}
+	return response.status, ""
+}
+
+// deployResponse captures the response of the deploy handler for a function of a batch.
+type deployResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *deployResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *deployResponse) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *deployResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// dependencyOrder returns the indexes of the functions of a batch in the order they can be deployed, keeping the
+// order of the batch among independent functions.
+func dependencyOrder(batch []BatchDeployRequest) ([]int, error) {
+	indexes := make(map[string]int, len(batch))
+	for i, item := range batch {
+		if _, ok := indexes[item.Service]; ok {
+			return nil, fmt.Errorf("function %s is listed more than once", item.Service)
+		}
+		indexes[item.Service] = i
+	}
+
+	// the number of dependencies of each function within the batch which aren't ordered yet
+	pending := make([]int, len(batch))
+	dependents := make([][]int, len(batch))
+	for i, item := range batch {
+		for _, dependency := range item.DependsOn {
+			if j, ok := indexes[dependency]; ok {
+				pending[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+
+	order := make([]int, 0, len(batch))
+	ordered := make([]bool, len(batch))
+	for len(order) < len(batch) {
+		next := -1
+		for i := range batch {
+			if !ordered[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next == -1 {
+			var cyclic []string
+			for i, item := range batch {
+				if !ordered[i] {
+					cyclic = append(cyclic, item.Service)
+				}
+			}
+			return nil, fmt.Errorf("cyclic dependencies between functions %s", strings.Join(cyclic, ", "))
+		}
+
+		ordered[next] = true
+		order = append(order, next)
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+		}
+	}
+
+	return order, nil
+}
+
+// MakeBatchDeployHandler deploys a batch of functions in the order of their dependencies. The outcome of every
+// function is reported individually, with a 207 Multi-Status when some of them failed.
+//
+// A 400 is returned, without deploying any function, when the dependencies are cyclic.
+func MakeBatchDeployHandler(deployer *BatchDeployer, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("batch_deploy_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var req []BatchDeployRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		results, err := deployer.Deploy(r.Context(), req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		status := http.StatusOK
+		for _, result := range results {
+			if result.Status != http.StatusOK {
+				status = http.StatusMultiStatus
+			}
+		}
+
+		resultsBytes, _ := json.Marshal(results)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(status)
+		w.Write(resultsBytes)
+
+		log.Debug("Functions deployed", "functions", len(results))
+	}
+}
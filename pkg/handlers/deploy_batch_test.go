@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDeployHandler records the order of the deployed functions, failing the functions in failures.
+func recordingDeployHandler(deployed *[]string, failures map[string]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var fd ftypes.FunctionDeployment
+		_ = json.Unmarshal(body, &fd)
+		*deployed = append(*deployed, fd.Service)
+
+		if status, ok := failures[fd.Service]; ok {
+			w.WriteHeader(status)
+			w.Write([]byte("registration failed"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func setupBatchDeployHandler(deployed *[]string, failures map[string]int, body []byte) (*services.MockResolver, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.DependencyTimeout = 50 * time.Millisecond
+
+	resolver := &services.MockResolver{}
+	deployer := NewBatchDeployer(config, recordingDeployHandler(deployed, failures), resolver, hclog.Default())
+	deployer.pollInterval = 10 * time.Millisecond
+
+	request := httptest.NewRequest("POST", "/system/functions/batch", bytes.NewReader(body))
+	return resolver, MakeBatchDeployHandler(deployer, hclog.Default()), request, httptest.NewRecorder()
+}
+
+func batchDeployRequest(name string, dependsOn ...string) BatchDeployRequest {
+	return BatchDeployRequest{FunctionDeployment: ftypes.FunctionDeployment{Service: name, Image: "functions/" + name}, DependsOn: dependsOn}
+}
+
+func readBatchDeployResults(t *testing.T, recorder *httptest.ResponseRecorder) []BatchDeployResult {
+	var results []BatchDeployResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestBatchDeployHandlerDeploysDependencyChainInOrder(t *testing.T) {
+	body, _ := json.Marshal([]BatchDeployRequest{
+		batchDeployRequest("frontend", "api"),
+		batchDeployRequest("api", "db"),
+		batchDeployRequest("db"),
+	})
+
+	var deployed []string
+	resolver, handler, request, recorder := setupBatchDeployHandler(&deployed, nil, body)
+	resolver.On("ResolveAll", "db").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+	resolver.On("ResolveAll", "api").Return([]url.URL{{Host: "10.0.0.2:8080"}}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"db", "api", "frontend"}, deployed)
+	assert.Equal(t, []BatchDeployResult{
+		{Name: "frontend", Status: http.StatusOK},
+		{Name: "api", Status: http.StatusOK},
+		{Name: "db", Status: http.StatusOK},
+	}, readBatchDeployResults(t, recorder))
+	resolver.AssertNotCalled(t, "ResolveAll", "frontend")
+}
+
+func TestBatchDeployHandlerRejectsCyclicDependencies(t *testing.T) {
+	body, _ := json.Marshal([]BatchDeployRequest{
+		batchDeployRequest("figlet"),
+		batchDeployRequest("a", "c"),
+		batchDeployRequest("b", "a"),
+		batchDeployRequest("c", "b"),
+	})
+
+	var deployed []string
+	_, handler, request, recorder := setupBatchDeployHandler(&deployed, nil, body)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "cyclic dependencies between functions a, b, c", recorder.Body.String())
+	assert.Empty(t, deployed)
+}
+
+func TestBatchDeployHandlerRejectsSelfDependency(t *testing.T) {
+	body, _ := json.Marshal([]BatchDeployRequest{batchDeployRequest("echo", "echo")})
+
+	var deployed []string
+	_, handler, request, recorder := setupBatchDeployHandler(&deployed, nil, body)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Empty(t, deployed)
+}
+
+func TestBatchDeployHandlerSkipsDependentsOfFailedFunction(t *testing.T) {
+	body, _ := json.Marshal([]BatchDeployRequest{
+		batchDeployRequest("db"),
+		batchDeployRequest("api", "db"),
+		batchDeployRequest("echo"),
+	})
+
+	var deployed []string
+	_, handler, request, recorder := setupBatchDeployHandler(&deployed, map[string]int{"db": http.StatusBadRequest}, body)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusMultiStatus, recorder.Code)
+	assert.Equal(t, []string{"db", "echo"}, deployed)
+	assert.Equal(t, []BatchDeployResult{
+		{Name: "db", Status: http.StatusBadRequest, Error: "registration failed"},
+		{Name: "api", Status: http.StatusFailedDependency, Error: "dependency db failed to deploy"},
+		{Name: "echo", Status: http.StatusOK},
+	}, readBatchDeployResults(t, recorder))
+}
+
+func TestBatchDeployHandlerSkipsFunctionWhenDependencyIsNotHealthy(t *testing.T) {
+	body, _ := json.Marshal([]BatchDeployRequest{batchDeployRequest("api", "db")})
+
+	var deployed []string
+	resolver, handler, request, recorder := setupBatchDeployHandler(&deployed, nil, body)
+	resolver.On("ResolveAll", "db").Return([]url.URL{}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusMultiStatus, recorder.Code)
+	assert.Empty(t, deployed)
+	assert.Equal(t, []BatchDeployResult{
+		{Name: "api", Status: http.StatusFailedDependency, Error: "dependency db not healthy after 50ms"},
+	}, readBatchDeployResults(t, recorder))
+}
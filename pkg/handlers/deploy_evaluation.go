@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	evalStatusPending  = "pending"
+	evalStatusBlocked  = "blocked"
+	evalStatusComplete = "complete"
+	evalStatusFailed   = "failed"
+)
+
+// PlacementFailure is returned when the evaluation of a deployed function failed to place its instances, and
+// either failed permanently or still failed for a transient reason after the retries.
+type PlacementFailure struct {
+	EvalID    string
+	Transient bool
+	Attempts  int
+	Reason    string
+}
+
+func (e *PlacementFailure) Error() string {
+	if e.Transient {
+		return fmt.Sprintf("evaluation %s failed to place the function after %d attempts: %s", e.EvalID, e.Attempts, e.Reason)
+	}
+	return fmt.Sprintf("evaluation %s failed to place the function: %s", e.EvalID, e.Reason)
+}
+
+// FunctionPlacementPending is the response of a deployment of which the placement is still pending after the
+// retries, as the function stays registered and Nomad places it once the cluster has the capacity.
+type FunctionPlacementPending struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	EvalID    string `json:"evalID"`
+	Attempts  int    `json:"attempts"`
+	Reason    string `json:"reason"`
+}
+
+// EvaluationRetrier awaits the evaluation of a registered function. When it failed to place the instances of the
+// function for a transient reason, i.e. the nodes were exhausted, e.g. of cpu, memory or ports, or no node was
+// available at all, e.g. as a node was briefly down, Nomad creates a blocked evaluation, which it re-evaluates
+// once the capacity of the cluster changes. The blocked evaluation is awaited with a backoff, as a retry of the
+// placement.
+//
+// Permanent failures, e.g. a constraint no node satisfies, are reported right away. Failures of the instances after
+// they are placed, e.g. a bad image, are not evaluation failures, and are reported by the deployment monitor.
+type EvaluationRetrier struct {
+	jobs         services.Jobs
+	evaluations  services.Evaluations
+	retries      int
+	backoff      time.Duration
+	pollInterval time.Duration
+	waitTimeout  time.Duration
+	logger       hclog.Logger
+}
+
+func NewEvaluationRetrier(config *types.ProviderConfig, jobs services.Jobs, evaluations services.Evaluations, logger hclog.Logger) *EvaluationRetrier {
+	return &EvaluationRetrier{
+		jobs:         jobs,
+		evaluations:  evaluations,
+		retries:      config.Scheduling.EvalRetries,
+		backoff:      config.Scheduling.EvalRetryBackoff,
+		pollInterval: 500 * time.Millisecond,
+		waitTimeout:  30 * time.Second,
+		logger:       logger.Named("evaluation_retrier"),
+	}
+}
+
+// Await returns once the evaluation of the function placed its instances, or with a PlacementFailure when the
+// placement failed permanently or kept failing after the retries. Without retries, the evaluation isn't awaited.
+// Awaiting stops when the context is done, e.g. when the client of the deployment went away.
+func (e *EvaluationRetrier) Await(ctx context.Context, namespace, jobID, evalID string) error {
+	if e.retries <= 0 || len(evalID) == 0 {
+		return nil
+	}
+
+	eval, err := e.awaitEvaluation(ctx, namespace, evalID, e.waitTimeout)
+	if err != nil {
+		return err
+	}
+	if eval.Status == evalStatusPending {
+		return fmt.Errorf("evaluation %s still pending after %s", evalID, e.waitTimeout)
+	}
+
+	backoff := e.backoff
+	reason := ""
+	for attempt := 1; ; attempt++ {
+		if eval.Status != evalStatusBlocked {
+			var transient, failed bool
+			reason, transient, failed = placementFailure(eval)
+			if !failed {
+				return nil
+			}
+			if !transient {
+				return &PlacementFailure{EvalID: eval.ID, Attempts: attempt, Reason: reason}
+			}
+			if len(eval.BlockedEval) == 0 {
+				return &PlacementFailure{EvalID: eval.ID, Transient: true, Attempts: attempt, Reason: reason}
+			}
+		}
+		if attempt > e.retries {
+			return &PlacementFailure{EvalID: eval.ID, Transient: true, Attempts: attempt, Reason: reason}
+		}
+
+		// the blocked evaluation is the retry of the placement, or is still blocked when the capacity didn't change
+		if len(eval.BlockedEval) != 0 {
+			evalID = eval.BlockedEval
+		}
+		e.logger.Warn("Evaluation failed to place function, awaiting the blocked evaluation", "job", jobID, "namespace", namespace, "eval", evalID, "reason", reason, "attempt", attempt, "backoff", backoff)
+
+		eval, err = e.awaitEvaluation(ctx, namespace, evalID, backoff)
+		if err != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// Rollback undoes the registration of a function of which the placement failed permanently, so the failed version
+// doesn't stay registered: the previous version of the function is restored, or the job is purged when the
+// function is new.
+func (e *EvaluationRetrier) Rollback(namespace, jobID string) error {
+	writeOptions := &api.WriteOptions{Namespace: namespace}
+
+	job, _, err := e.jobs.Info(jobID, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return err
+	}
+	if job.Version == nil || *job.Version == 0 {
+		_, _, err = e.jobs.Deregister(jobID, true, writeOptions)
+		return err
+	}
+
+	version := *job.Version
+	_, _, err = e.jobs.Revert(jobID, version-1, &version, writeOptions, "", "")
+	return err
+}
+
+// awaitEvaluation polls an evaluation until the scheduler processed it, or until the timeout passed, after which
+// the evaluation is returned pending or blocked.
+func (e *EvaluationRetrier) awaitEvaluation(ctx context.Context, namespace, evalID string, timeout time.Duration) (*api.Evaluation, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		eval, _, err := e.evaluations.Info(evalID, (&api.QueryOptions{Namespace: namespace}).WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if (eval.Status != evalStatusPending && eval.Status != evalStatusBlocked) || !time.Now().Before(deadline) {
+			return eval, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(e.pollInterval):
+		}
+	}
+}
+
+// placementFailure reports whether an evaluation failed to place instances, and whether the failure is transient.
+// An evaluation failed by the scheduler itself, e.g. after conflicting plans, is transient, as is a task group for
+// which the nodes were exhausted or no node was available. A task group for which all nodes were filtered, e.g. by
+// its constraints, is permanent, as is an exhausted quota, which takes an operator to resolve.
+func placementFailure(eval *api.Evaluation) (string, bool, bool) {
+	if eval.Status == evalStatusFailed {
+		return fmt.Sprintf("evaluation failed: %s", eval.StatusDescription), true, true
+	}
+	if eval.Status != evalStatusComplete || len(eval.FailedTGAllocs) == 0 {
+		return "", false, false
+	}
+
+	groups := make([]string, 0, len(eval.FailedTGAllocs))
+	for group := range eval.FailedTGAllocs {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	transient := true
+	var reasons []string
+	for _, group := range groups {
+		metric := eval.FailedTGAllocs[group]
+		switch {
+		case len(metric.QuotaExhausted) != 0:
+			transient = false
+			reasons = append(reasons, fmt.Sprintf("%s: quota exhausted (%s)", group, strings.Join(metric.QuotaExhausted, ", ")))
+		case metric.NodesEvaluated == 0:
+			reasons = append(reasons, fmt.Sprintf("%s: no nodes available", group))
+		case metric.NodesExhausted > 0:
+			reasons = append(reasons, fmt.Sprintf("%s: %d nodes exhausted (%s)", group, metric.NodesExhausted, formatMetricCounts(metric.DimensionExhausted)))
+		default:
+			transient = false
+			reasons = append(reasons, fmt.Sprintf("%s: %d nodes filtered (%s)", group, metric.NodesFiltered, formatMetricCounts(metric.ConstraintFiltered, metric.ClassFiltered)))
+		}
+	}
+	return strings.Join(reasons, "; "), transient, true
+}
+
+// formatMetricCounts formats the counts of allocation metrics, e.g. memory=2, cpu=1, most frequent first.
+func formatMetricCounts(counts ...map[string]int) string {
+	merged := map[string]int{}
+	var keys []string
+	for _, c := range counts {
+		for key, count := range c {
+			if _, ok := merged[key]; !ok {
+				keys = append(keys, key)
+			}
+			merged[key] += count
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if merged[keys[i]] != merged[keys[j]] {
+			return merged[keys[i]] > merged[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", key, merged[key])
+	}
+	return strings.Join(parts, ", ")
+}
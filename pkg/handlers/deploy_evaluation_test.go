@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func exhaustedEval(id, blocked string) *api.Evaluation {
+	return &api.Evaluation{ID: id, Status: evalStatusComplete, BlockedEval: blocked, FailedTGAllocs: map[string]*api.AllocationMetric{
+		"echo": {NodesEvaluated: 3, NodesExhausted: 3, DimensionExhausted: map[string]int{"memory": 2, "cpu": 1}},
+	}}
+}
+
+func filteredEval(id string) *api.Evaluation {
+	return &api.Evaluation{ID: id, Status: evalStatusComplete, FailedTGAllocs: map[string]*api.AllocationMetric{
+		"echo": {NodesEvaluated: 3, NodesFiltered: 3, ConstraintFiltered: map[string]int{"${attr.kernel.name} = windows": 3}},
+	}}
+}
+
+func setupEvaluationRetrier(retries int) (*services.MockJobs, *services.MockEvaluations, *EvaluationRetrier) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.EvalRetries = retries
+	config.Scheduling.EvalRetryBackoff = 20 * time.Millisecond
+	jobs := &services.MockJobs{}
+	evaluations := &services.MockEvaluations{}
+
+	retrier := NewEvaluationRetrier(config, jobs, evaluations, hclog.Default())
+	retrier.pollInterval = 5 * time.Millisecond
+	return jobs, evaluations, retrier
+}
+
+func setupEvaluatedDeployHandler(retries int) (*services.MockJobs, *services.MockEvaluations, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs, evaluations, retrier := setupEvaluationRetrier(retries)
+
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo"})
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, nil, nil, nil, retrier, nil, hclog.Default())
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(&api.JobRegisterResponse{EvalID: "eval-1"}, nil, nil)
+
+	return jobs, evaluations, handler, request, response
+}
+
+func TestDeployHandlerAwaitsBlockedEvaluation(t *testing.T) {
+	_, evaluations, handler, request, recorder := setupEvaluatedDeployHandler(3)
+	evaluations.On("Info", "eval-1", mock.Anything).Return(&api.Evaluation{ID: "eval-1", Status: evalStatusPending}, nil).Once()
+	evaluations.On("Info", "eval-1", mock.Anything).Return(exhaustedEval("eval-1", "eval-2"), nil).Once()
+	evaluations.On("Info", "eval-2", mock.Anything).Return(&api.Evaluation{ID: "eval-2", Status: evalStatusBlocked}, nil).Once()
+	evaluations.On("Info", "eval-2", mock.Anything).Return(&api.Evaluation{ID: "eval-2", Status: evalStatusComplete}, nil).Once()
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	evaluations.AssertExpectations(t)
+}
+
+func TestDeployHandlerAcceptsTransientPlacementFailureAfterRetries(t *testing.T) {
+	jobs, evaluations, handler, request, recorder := setupEvaluatedDeployHandler(2)
+	evaluations.On("Info", "eval-1", mock.Anything).Return(exhaustedEval("eval-1", "eval-2"), nil)
+	evaluations.On("Info", "eval-2", mock.Anything).Return(&api.Evaluation{ID: "eval-2", Status: evalStatusBlocked}, nil)
+
+	handler(recorder, request)
+
+	// the function stays registered with the blocked evaluation of Nomad
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, TypeApplicationJson, recorder.Header().Get(HeaderContentType))
+
+	pending := FunctionPlacementPending{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &pending))
+	assert.Equal(t, FunctionPlacementPending{
+		Name:      "echo",
+		Namespace: "default",
+		Status:    evalStatusPending,
+		EvalID:    "eval-2",
+		Attempts:  3,
+		Reason:    "echo: 3 nodes exhausted (memory=2, cpu=1)",
+	}, pending)
+	jobs.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+	jobs.AssertNotCalled(t, "Revert", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerPurgesNewFunctionAfterPermanentPlacementFailure(t *testing.T) {
+	jobs, evaluations, handler, request, recorder := setupEvaluatedDeployHandler(3)
+	evaluations.On("Info", "eval-1", mock.Anything).Return(filteredEval("eval-1"), nil)
+
+	jobID := "faas-fn-echo"
+	version := uint64(0)
+	jobs.On("Info", jobID, mock.Anything).Return(&api.Job{ID: &jobID, Version: &version}, nil, nil)
+	jobs.On("Deregister", jobID, true, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "echo: 3 nodes filtered (${attr.kernel.name} = windows=3)")
+	jobs.AssertCalled(t, "Deregister", jobID, true, mock.Anything)
+	evaluations.AssertNumberOfCalls(t, "Info", 1)
+}
+
+func TestDeployHandlerRevertsUpdatedFunctionAfterPermanentPlacementFailure(t *testing.T) {
+	jobs, evaluations, handler, request, recorder := setupEvaluatedDeployHandler(3)
+	evaluations.On("Info", "eval-1", mock.Anything).Return(filteredEval("eval-1"), nil)
+
+	jobID := "faas-fn-echo"
+	version := uint64(4)
+	jobs.On("Info", jobID, mock.Anything).Return(&api.Job{ID: &jobID, Version: &version}, nil, nil)
+	jobs.On("Revert", jobID, uint64(3), &version, mock.Anything).Return(nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertCalled(t, "Revert", jobID, uint64(3), &version, mock.Anything)
+	jobs.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerDoesNotAwaitEvaluationWithoutRetries(t *testing.T) {
+	_, evaluations, handler, request, recorder := setupEvaluatedDeployHandler(0)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	evaluations.AssertNotCalled(t, "Info", mock.Anything, mock.Anything)
+}
+
+func TestEvaluationRetrierStopsWhenContextIsCancelled(t *testing.T) {
+	_, evaluations, retrier := setupEvaluationRetrier(3)
+	evaluations.On("Info", "eval-1", mock.Anything).Return(&api.Evaluation{ID: "eval-1", Status: evalStatusPending}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := retrier.Await(ctx, "default", "faas-fn-echo", "eval-1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPlacementFailureDistinguishesTransientFromPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		eval      *api.Evaluation
+		failed    bool
+		transient bool
+	}{
+		{"placed", &api.Evaluation{Status: evalStatusComplete}, false, false},
+		{"blocked on exhausted nodes", exhaustedEval("eval-1", "eval-2"), true, true},
+		{"no nodes available", &api.Evaluation{Status: evalStatusComplete, FailedTGAllocs: map[string]*api.AllocationMetric{
+			"echo": {NodesAvailable: map[string]int{"dc1": 0}},
+		}}, true, true},
+		{"failed by the scheduler", &api.Evaluation{Status: evalStatusFailed}, true, true},
+		{"filtered by constraints", filteredEval("eval-1"), true, false},
+		{"quota exhausted", &api.Evaluation{Status: evalStatusComplete, FailedTGAllocs: map[string]*api.AllocationMetric{
+			"echo": {NodesEvaluated: 3, NodesExhausted: 3, QuotaExhausted: []string{"memory exhausted (2048 > 1024)"}},
+		}}, true, false},
+		{"one of the groups filtered", &api.Evaluation{Status: evalStatusComplete, FailedTGAllocs: map[string]*api.AllocationMetric{
+			"echo":   {NodesEvaluated: 3, NodesExhausted: 3},
+			"canary": {NodesEvaluated: 3, NodesFiltered: 3, ClassFiltered: map[string]int{"gpu": 3}},
+		}}, true, false},
+	}
+
+	for _, test := range tests {
+		_, transient, failed := placementFailure(test.eval)
+		assert.Equal(t, test.failed, failed, test.name)
+		assert.Equal(t, test.transient, transient, test.name)
+	}
+}
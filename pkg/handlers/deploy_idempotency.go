@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// IdempotencyKeyHeader makes a deployment safely retryable: a deployment of a function repeating the key of a
+	// recent deployment of the function returns the result of that deployment, rather than deploying it again.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on the responses returning the result of an earlier deployment.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+type idempotentResult struct {
+	digest  [sha256.Size]byte
+	done    bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// DeployIdempotency keeps the results of the deployments with an idempotency key during the idempotency window, per
+// function, so a client retrying a deployment, e.g. after a network failure, doesn't deploy the function twice.
+//
+// Only completed deployments are kept: a deployment failing with a server error, e.g. because Nomad couldn't be
+// reached, or accepted with its placement pending, is deployed again on a retry. A retry while the deployment is
+// still in progress is rejected, as is the reuse of a key for a different deployment of the function.
+type DeployIdempotency struct {
+	config *types.ProviderConfig
+	window time.Duration
+	now    func() time.Time
+	logger hclog.Logger
+
+	mu      sync.Mutex
+	results map[string]*idempotentResult
+}
+
+func NewDeployIdempotency(config *types.ProviderConfig, logger hclog.Logger) *DeployIdempotency {
+	return &DeployIdempotency{
+		config:  config,
+		window:  config.Scheduling.IdempotencyWindow,
+		now:     time.Now,
+		logger:  logger.Named("deploy_idempotency"),
+		results: map[string]*idempotentResult{},
+	}
+}
+
+// Wrap returns the results of earlier deployments for the requests of the deploy handler with an idempotency key.
+// Requests without a key are always deployed, as are all requests when the idempotency window is zero.
+func (d *DeployIdempotency) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if len(key) == 0 || d.window <= 0 {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s, must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		req := ftypes.FunctionDeployment{}
+		if err := json.Unmarshal(body, &req); err != nil || len(req.Service) == 0 {
+			// an invalid deployment is rejected by the deploy handler, so there is nothing to keep
+			next(w, r)
+			return
+		}
+
+		namespace := req.Namespace
+		if len(namespace) == 0 {
+			namespace = d.config.Scheduling.Namespace
+		}
+		id := strings.Join([]string{r.Method, namespace, req.Service, key}, "/")
+		digest := sha256.Sum256(body)
+
+		result, err := d.start(id, digest)
+		if err != nil {
+			writeError(w, http.StatusConflict, fmt.Errorf("%s for function %s: %s", IdempotencyKeyHeader, req.Service, err))
+			return
+		}
+		if result != nil {
+			if result.digest != digest {
+				writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("%s '%s' was used for a different deployment of function %s", IdempotencyKeyHeader, key, req.Service))
+				return
+			}
+			d.logger.Debug("Returning the result of an earlier deployment", "function", req.Service, "namespace", namespace, "key", key)
+			for name, values := range result.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(result.status)
+			w.Write(result.body)
+			return
+		}
+
+		// the deployment is finished even when the deploy handler panics, so its key isn't in progress for good
+		recorder := &recordingResponseWriter{ResponseWriter: w}
+		completed := false
+		defer func() {
+			d.finish(id, recorder, completed && (recorder.status != 0 || r.Context().Err() == nil))
+		}()
+		next(recorder, r)
+		completed = true
+	}
+}
+
+// start returns the completed result of a deployment, or marks the deployment as in progress when there is no
+// result yet. A deployment which is still in progress is reported as an error, until the idempotency window passed,
+// after which the deployment is assumed to be lost.
+func (d *DeployIdempotency) start(id string, digest [sha256.Size]byte) (*idempotentResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for k, result := range d.results {
+		if now.After(result.expires) {
+			delete(d.results, k)
+		}
+	}
+
+	result, ok := d.results[id]
+	if !ok {
+		d.results[id] = &idempotentResult{digest: digest, expires: now.Add(d.window)}
+		return nil, nil
+	}
+	if !result.done && result.digest == digest {
+		return nil, fmt.Errorf("a deployment with the same key is in progress")
+	}
+	return result, nil
+}
+
+// finish keeps the result of a completed deployment. The deployments which didn't complete, as the deploy handler
+// panicked or the client went away before a response was written, are forgotten like the failed ones, so they are
+// deployed again on a retry. So are the accepted deployments of which the placement is still pending, so a retry
+// reports the outcome of the placement rather than replaying the pending result.
+func (d *DeployIdempotency) finish(id string, recorder *recordingResponseWriter, completed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	result, ok := d.results[id]
+	if !ok || !completed || status == http.StatusAccepted || status >= http.StatusInternalServerError {
+		delete(d.results, id)
+		return
+	}
+
+	result.done = true
+	result.status = status
+	result.header = recorder.header
+	result.body = recorder.body
+	result.expires = d.now().Add(d.window)
+}
+
+// recordingResponseWriter records the response of the deploy handler while it is written to the client.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	rw.header = rw.Header().Clone()
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body = append(rw.body, b...)
+	return rw.ResponseWriter.Write(b)
+}
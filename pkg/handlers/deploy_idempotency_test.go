@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupIdempotentDeployHandler() (*services.MockJobs, *DeployIdempotency, http.HandlerFunc) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	secrets := &services.MockSecrets{}
+
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+	handler := idempotency.Wrap(MakeDeployHandler(config, services.NewJobFactory(config), jobs, secrets, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default()))
+	return jobs, idempotency, handler
+}
+
+func deployWithKey(handler http.HandlerFunc, service, image, key string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: service, Image: image})
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	if len(key) != 0 {
+		request.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func TestDeployIdempotencyRegistersFunctionOnce(t *testing.T) {
+	jobs, _, handler := setupIdempotentDeployHandler()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	first := deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42")
+	second := deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42")
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 1)
+}
+
+func TestDeployIdempotencyKeysArePerFunction(t *testing.T) {
+	jobs, _, handler := setupIdempotentDeployHandler()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "figlet", "functions/figlet:1.0", "ci-build-42").Code)
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "").Code)
+
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 3)
+}
+
+func TestDeployIdempotencyRejectsKeyReusedForDifferentDeployment(t *testing.T) {
+	jobs, _, handler := setupIdempotentDeployHandler()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, deployWithKey(handler, "echo", "functions/echo:2.0", "ci-build-42").Code)
+
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 1)
+}
+
+func TestDeployIdempotencyRetriesServerErrors(t *testing.T) {
+	jobs, _, handler := setupIdempotentDeployHandler()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("failure")).Once()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil).Once()
+
+	assert.Equal(t, http.StatusInternalServerError, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 2)
+}
+
+func TestDeployIdempotencyExpiresResults(t *testing.T) {
+	jobs, idempotency, handler := setupIdempotentDeployHandler()
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	now := time.Now()
+	idempotency.now = func() time.Time { return now }
+
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+
+	now = now.Add(idempotency.window + time.Second)
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 2)
+	assert.Len(t, idempotency.results, 1)
+}
+
+func TestDeployIdempotencyRejectsRetryInProgress(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+
+	var retry *httptest.ResponseRecorder
+	handler := idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if retry == nil {
+			// a retry arriving while the first deployment is still in progress
+			retry = deployWithKey(idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("unexpected deployment")
+			}), "echo", "functions/echo:1.0", "ci-build-42")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, http.StatusConflict, retry.Code)
+}
+
+func TestDeployIdempotencyRetriesDeploymentsWhichPanicked(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+
+	deployments := 0
+	handler := idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		deployments++
+		if deployments == 1 {
+			panic("failure")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	assert.Panics(t, func() { deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42") })
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, 2, deployments)
+}
+
+func TestDeployIdempotencyRetriesDeploymentsOfClientsWhichWentAway(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+
+	deployments := 0
+	handler := idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		deployments++
+		if r.Context().Err() == nil {
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo:1.0"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body)).WithContext(ctx)
+	request.Header.Set(IdempotencyKeyHeader, "ci-build-42")
+	handler(httptest.NewRecorder(), request)
+
+	assert.Equal(t, http.StatusOK, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+	assert.Equal(t, 2, deployments)
+}
+
+func TestDeployIdempotencyExpiresDeploymentsInProgress(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+
+	now := time.Now()
+	idempotency.now = func() time.Time { return now }
+
+	_, err := idempotency.start("POST/default/echo/ci-build-42", [32]byte{})
+	assert.NoError(t, err)
+	_, err = idempotency.start("POST/default/echo/ci-build-42", [32]byte{})
+	assert.Error(t, err)
+
+	now = now.Add(idempotency.window + time.Second)
+	result, err := idempotency.start("POST/default/echo/ci-build-42", [32]byte{})
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestDeployIdempotencyRetriesDeploymentsWithPlacementPending(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	idempotency := NewDeployIdempotency(config, hclog.Default())
+
+	deployments := 0
+	handler := idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		deployments++
+		if deployments == 1 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	assert.Equal(t, http.StatusAccepted, deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42").Code)
+
+	retry := deployWithKey(handler, "echo", "functions/echo:1.0", "ci-build-42")
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Empty(t, retry.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 2, deployments)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const deployTimeoutLabel = "com.openfaas.deploy-timeout"
+
+// DeploymentTimeout is returned when the deployment of a function didn't become healthy in time.
+type DeploymentTimeout struct {
+	DeploymentID string
+	Timeout      time.Duration
+	Reverted     bool
+}
+
+func (e *DeploymentTimeout) Error() string {
+	if e.Reverted {
+		return fmt.Sprintf("deployment %s not healthy after %s, reverted to the last stable version", e.DeploymentID, e.Timeout)
+	}
+	return fmt.Sprintf("deployment %s not healthy after %s, deployment failed", e.DeploymentID, e.Timeout)
+}
+
+// DeploymentMonitor waits for the deployment of a registered function to become healthy, within the timeout
+// of the `com.openfaas.deploy-timeout` label or the configured default.
+//
+// When the deadline passes, the deployment is failed, which makes Nomad revert the function to its last stable
+// version when auto revert is enabled in the update strategy of the function.
+type DeploymentMonitor struct {
+	config       *types.ProviderConfig
+	jobs         services.Jobs
+	deployments  services.Deployments
+	pollInterval time.Duration
+	logger       hclog.Logger
+}
+
+func NewDeploymentMonitor(config *types.ProviderConfig, jobs services.Jobs, deployments services.Deployments, logger hclog.Logger) *DeploymentMonitor {
+	return &DeploymentMonitor{
+		config:       config,
+		jobs:         jobs,
+		deployments:  deployments,
+		pollInterval: 2 * time.Second,
+		logger:       logger.Named("deployment_monitor"),
+	}
+}
+
+// Await returns once the deployment of the current version of the function is successful, or with an error when
+// the deployment failed or timed out. Functions without a timeout, and system jobs, are not awaited.
+// Awaiting stops when the context is done, e.g. when the client of the deployment went away.
+func (m *DeploymentMonitor) Await(ctx context.Context, namespace, jobID string, fd ftypes.FunctionDeployment) error {
+	timeout := types.ParseIntOrDurationValueFromMap(fd.Labels, deployTimeoutLabel, m.config.Scheduling.DeployTimeout)
+	if timeout <= 0 {
+		return nil
+	}
+
+	queryOptions := (&api.QueryOptions{Namespace: namespace}).WithContext(ctx)
+
+	job, _, err := m.jobs.Info(jobID, queryOptions)
+	if err != nil {
+		return err
+	}
+	if job.Version == nil || (job.Type != nil && *job.Type == api.JobTypeSystem) {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		deployment, _, err := m.jobs.LatestDeployment(jobID, queryOptions)
+		if err != nil {
+			return err
+		}
+
+		// until the deployment of the registered version is created, the previous deployment is returned
+		current := deployment != nil && registeredVersion(job, deployment.JobVersion)
+
+		if current {
+			switch deployment.Status {
+			case "successful":
+				return nil
+			case "failed", "cancelled":
+				return fmt.Errorf("deployment %s %s: %s", deployment.ID, deployment.Status, deployment.StatusDescription)
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if !current {
+				return fmt.Errorf("no deployment of version %d created after %s", *job.Version, timeout)
+			}
+
+			if _, _, err := m.deployments.Fail(deployment.ID, &api.WriteOptions{Namespace: namespace}); err != nil {
+				return err
+			}
+
+			reverted := job.Update != nil && job.Update.AutoRevert != nil && *job.Update.AutoRevert
+			m.logger.Warn("Function deployment not healthy in time, deployment failed", "function", fd.Service, "deployment", deployment.ID, "timeout", timeout, "reverted", reverted)
+			return &DeploymentTimeout{DeploymentID: deployment.ID, Timeout: timeout, Reverted: reverted}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.pollInterval):
+		}
+	}
+}
+
+// registeredVersion reports whether a deployment or an allocation of a function is of the version of the function
+// which was just registered, as Nomad keeps returning the ones of the previous version until the new version is
+// scheduled.
+func registeredVersion(job *api.Job, version uint64) bool {
+	return job.Version != nil && *job.Version == version
+}
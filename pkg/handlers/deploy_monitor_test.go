@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func monitoredJob(version uint64, autoRevert bool) *api.Job {
+	return &api.Job{
+		Version: &version,
+		Update:  &api.UpdateStrategy{AutoRevert: &autoRevert},
+	}
+}
+
+func setupMonitoredDeployHandler(labels map[string]string) (*services.MockJobs, *services.MockDeployments, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	deployments := &services.MockDeployments{}
+
+	monitor := NewDeploymentMonitor(config, jobs, deployments, hclog.Default())
+	monitor.pollInterval = 10 * time.Millisecond
+
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo", Labels: &labels})
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, monitor, nil, nil, nil, nil, nil, hclog.Default())
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	return jobs, deployments, handler, request, response
+}
+
+func TestDeployHandlerFailsDeploymentNotHealthyInTime(t *testing.T) {
+	jobs, deployments, handler, request, recorder := setupMonitoredDeployHandler(map[string]string{deployTimeoutLabel: "200ms"})
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(monitoredJob(2, true), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "running"}, nil, nil)
+	deployments.On("Fail", "d-2", mock.Anything).Return(nil)
+
+	start := time.Now()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "reverted to the last stable version")
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	deployments.AssertExpectations(t)
+}
+
+func TestDeployHandlerWaitsForDeploymentOfRegisteredVersion(t *testing.T) {
+	jobs, deployments, handler, request, recorder := setupMonitoredDeployHandler(map[string]string{deployTimeoutLabel: "2s"})
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(monitoredJob(2, true), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-1", JobVersion: 1, Status: "successful"}, nil, nil).Twice()
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "running"}, nil, nil).Twice()
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "successful"}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertNumberOfCalls(t, "LatestDeployment", 5)
+	deployments.AssertNotCalled(t, "Fail", mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerReportsFailedDeployment(t *testing.T) {
+	jobs, deployments, handler, request, recorder := setupMonitoredDeployHandler(map[string]string{deployTimeoutLabel: "2s"})
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(monitoredJob(2, true), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "failed", StatusDescription: "Failed due to unhealthy allocations"}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "unhealthy allocations")
+	deployments.AssertNotCalled(t, "Fail", mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerDoesNotWaitWithoutTimeout(t *testing.T) {
+	jobs, _, handler, request, recorder := setupMonitoredDeployHandler(map[string]string{})
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertNotCalled(t, "Info", mock.Anything, mock.Anything)
+}
+
+func TestDeploymentMonitorStopsWhenContextIsCancelled(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	deployments := &services.MockDeployments{}
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(monitoredJob(2, true), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "running"}, nil, nil)
+
+	monitor := NewDeploymentMonitor(config, jobs, deployments, hclog.Default())
+	monitor.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	labels := map[string]string{deployTimeoutLabel: "1m"}
+	err := monitor.Await(ctx, "default", "faas-fn-echo", ftypes.FunctionDeployment{Service: "echo", Labels: &labels})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	deployments.AssertNotCalled(t, "Fail", mock.Anything, mock.Anything)
+}
+
+func TestCreateJobUsesConfiguredAutoRevert(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.AutoRevert = false
+
+	job, err := services.NewJobFactory(config).CreateJob("default", ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo"})
+
+	assert.NoError(t, err)
+	assert.False(t, *job.Update.AutoRevert)
+}
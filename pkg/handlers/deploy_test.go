@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/admission"
 	"github.com/jsiebens/faas-nomad/pkg/services"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
@@ -18,15 +22,19 @@ import (
 )
 
 func setupDeployHandler(body []byte) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	return setupDeployHandlerWithConfig(config, body)
+}
+
+func setupDeployHandlerWithConfig(config *types.ProviderConfig, body []byte) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
 	jobs := &services.MockJobs{}
 	secrets := &services.MockSecrets{}
 
 	response := httptest.NewRecorder()
 	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
 
-	config, _ := types.DefaultConfig()
 	factory := services.NewJobFactory(config)
-	handler := MakeDeployHandler(config, factory, jobs, secrets, hclog.Default())
+	handler := MakeDeployHandler(config, factory, jobs, secrets, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
 
 	return jobs, handler, request, response
 }
@@ -99,6 +107,52 @@ func TestDeployHandlerWithInitialScaleCount(t *testing.T) {
 	assert.Equal(t, 3, *count)
 }
 
+func TestDeployHandlerWithDefaultReplicas(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.DefaultReplicas = 2
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	count := job.TaskGroups[0].Count
+
+	assert.Equal(t, 2, *count)
+}
+
+func TestDeployHandlerClampsDefaultReplicas(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.DefaultReplicas = 0
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	count := job.TaskGroups[0].Count
+
+	assert.Equal(t, 1, *count)
+}
+
 func TestDeployHandlerWithMultipleDatacenters(t *testing.T) {
 	req := ftypes.FunctionDeployment{}
 	req.Service = "Func123"
@@ -151,3 +205,1933 @@ func TestDeployHandlerWithConstraints(t *testing.T) {
 	assert.Equal(t, expectedConstraint1, *constraints[0])
 	assert.Equal(t, expectedConstraint2, *constraints[1])
 }
+
+func TestDeployHandlerWithDefaultSpread(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, 1, len(job.Spreads))
+	assert.Equal(t, "${node.unique.id}", job.Spreads[0].Attribute)
+	assert.Equal(t, int8(50), *job.Spreads[0].Weight)
+	assert.Equal(t, 0, len(job.Spreads[0].SpreadTarget))
+}
+
+func TestDeployHandlerWithSpreadTargets(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.spread":         "node.datacenter",
+		"com.openfaas.spread.weight":  "80",
+		"com.openfaas.spread.targets": "dc1=70, dc2=30",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	spread := job.Spreads[0]
+
+	assert.Equal(t, "${node.datacenter}", spread.Attribute)
+	assert.Equal(t, int8(80), *spread.Weight)
+	assert.Equal(t, api.SpreadTarget{Value: "dc1", Percent: 70}, *spread.SpreadTarget[0])
+	assert.Equal(t, api.SpreadTarget{Value: "dc2", Percent: 30}, *spread.SpreadTarget[1])
+}
+
+func TestDeployHandlerWithoutSpread(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.spread": "none",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, 0, len(job.Spreads))
+}
+
+func TestDeployHandlerReportsErrorWhenSpreadIsInvalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"com.openfaas.spread": "${unknown.attribute}"},
+		{"com.openfaas.spread.weight": "101"},
+		{"com.openfaas.spread.weight": "abc"},
+		{"com.openfaas.spread.targets": "dc1"},
+		{"com.openfaas.spread.targets": "dc1=70,dc2=40"},
+	}
+
+	for _, labels := range invalid {
+		l := labels
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &l
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "labels: %v", labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithMemoryMax(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.memory.max": "512",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.Limits = &ftypes.FunctionResources{Memory: "256"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	resources := job.TaskGroups[0].Tasks[0].Resources
+
+	assert.Equal(t, 256, *resources.MemoryMB)
+	assert.Equal(t, 512, *resources.MemoryMaxMB)
+}
+
+func TestDeployHandlerWithoutMemoryMax(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Limits = &ftypes.FunctionResources{Memory: "256"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	resources := job.TaskGroups[0].Tasks[0].Resources
+
+	assert.Equal(t, 256, *resources.MemoryMB)
+	assert.Nil(t, resources.MemoryMaxMB)
+}
+
+func TestDeployHandlerReportsErrorWhenMemoryMaxIsInvalid(t *testing.T) {
+	invalid := []string{"128", "abc"}
+
+	for _, value := range invalid {
+		labels := map[string]string{
+			"com.openfaas.memory.max": value,
+		}
+
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		req.Limits = &ftypes.FunctionResources{Memory: "256"}
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "memory max: %s", value)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithSystemJobType(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.job-type":  "system",
+		"com.openfaas.scale.min": "3",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, api.JobTypeSystem, *job.Type)
+	assert.Nil(t, job.TaskGroups[0].Count)
+	assert.Nil(t, job.Update.Canary)
+	assert.Equal(t, 0, len(job.Spreads))
+}
+
+func TestDeployHandlerReportsErrorWhenJobTypeIsInvalid(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.job-type": "batch",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerWithNomadVariableSecrets(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Secrets.Backend = services.SecretsBackendNomad
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Secrets = []string{"secret-a"}
+	body, _ := json.Marshal(req)
+
+	jobs := &services.MockJobs{}
+	secrets := &services.MockSecrets{}
+	secrets.On("Exists", "default", "secret-a").Return(true)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, secrets, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Nil(t, task.Vault)
+	assert.Equal(t, `{{with nomadVar "openfaas-fn/secret-a"}}{{base64Decode .value}}{{end}}`, *task.Templates[0].EmbeddedTmpl)
+}
+
+func TestDeployHandlerWithPriority(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.priority": "80",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, 80, *job.Priority)
+}
+
+func TestDeployHandlerWithDefaultPriority(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.DefaultPriority = 150
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, 100, *job.Priority)
+}
+
+func TestDeployHandlerReportsErrorWhenPriorityIsInvalid(t *testing.T) {
+	invalid := []string{"0", "101", "high"}
+
+	for _, value := range invalid {
+		labels := map[string]string{
+			"com.openfaas.priority": value,
+		}
+
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "priority: %s", value)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithConsulEnv(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.consul.change-mode": "signal",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.EnvVars = map[string]string{
+		"DB_HOST": "consul:config/db/host",
+		"DB_PORT": "consul:config/db/port",
+		"MODE":    "production",
+	}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, map[string]string{"MODE": "production"}, task.Env)
+	assert.Len(t, task.Templates, 1)
+
+	template := task.Templates[0]
+	assert.Equal(t, "DB_HOST={{ key \"config/db/host\" | toJSON }}\nDB_PORT={{ key \"config/db/port\" | toJSON }}\n", *template.EmbeddedTmpl)
+	assert.Equal(t, "local/consul.env", *template.DestPath)
+	assert.True(t, *template.Envvars)
+	assert.Equal(t, "signal", *template.ChangeMode)
+	assert.Equal(t, "SIGHUP", *template.ChangeSignal)
+}
+
+func TestDeployHandlerWithoutConsulEnv(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.EnvVars = map[string]string{"MODE": "production"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, map[string]string{"MODE": "production"}, task.Env)
+	assert.Empty(t, task.Templates)
+}
+
+func TestDeployHandlerReportsErrorWhenConsulEnvIsInvalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"DB_HOST": "consul:"},
+		{"DB_HOST": "consul:/config/db/host"},
+		{"DB_HOST": "consul:config//host"},
+		{"DB_HOST": "consul:config/db/host\"}}"},
+	}
+
+	for _, env := range invalid {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.EnvVars = env
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "env: %v", env)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerReportsErrorWhenConsulChangeModeIsInvalid(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.consul.change-mode": "reload",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.EnvVars = map[string]string{"DB_HOST": "consul:config/db/host"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerReportsErrorWhenConsulChangeSignalIsInvalid(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.consul.change-mode":   "signal",
+		"com.openfaas.consul.change-signal": "SIGHUB",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.EnvVars = map[string]string{"DB_HOST": "consul:config/db/host"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "invalid Consul change signal 'SIGHUB'")
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerUppercasesConsulChangeSignal(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.consul.change-mode":   "signal",
+		"com.openfaas.consul.change-signal": "sigusr1",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.EnvVars = map[string]string{"DB_HOST": "consul:config/db/host"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, "SIGUSR1", *job.TaskGroups[0].Tasks[0].Templates[0].ChangeSignal)
+}
+
+func TestDeployHandlerWithExecDriver(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.driver":   "exec",
+		"com.openfaas.artifact": "https://example.com/echo.tar.gz",
+		"com.openfaas.command":  "local/echo",
+		"com.openfaas.args":     "--verbose --mode=http",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.Secrets = []string{"secret-a"}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	secrets := &services.MockSecrets{}
+	secrets.On("Exists", "default", "secret-a").Return(true)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, secrets, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, "exec", task.Driver)
+	assert.Equal(t, map[string]interface{}{
+		"command": "local/echo",
+		"args":    []string{"--verbose", "--mode=http"},
+	}, task.Config)
+	assert.Equal(t, "https://example.com/echo.tar.gz", *task.Artifacts[0].GetterSource)
+	assert.Equal(t, "local/", *task.Artifacts[0].RelativeDest)
+	assert.Equal(t, "${NOMAD_PORT_http}", task.Env["port"])
+	assert.Len(t, task.Templates, 1)
+	// without docker labels, the labels of the function are kept in the task meta
+	assert.Equal(t, labels, task.Meta)
+	assert.Equal(t, labels, services.JobLabels(job))
+}
+
+func TestDeployHandlerWithJavaDriver(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.driver":      "java",
+		"com.openfaas.jar-path":    "local/echo.jar",
+		"com.openfaas.jvm-options": "-Xmx256m",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Image = "https://example.com/echo.jar"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, "java", task.Driver)
+	assert.Equal(t, map[string]interface{}{
+		"jar_path":    "local/echo.jar",
+		"jvm_options": []string{"-Xmx256m"},
+	}, task.Config)
+	assert.Equal(t, "https://example.com/echo.jar", *task.Artifacts[0].GetterSource)
+}
+
+func TestDeployHandlerWithDefaultDockerDriver(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Image = "functions/echo:latest"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	task := job.TaskGroups[0].Tasks[0]
+
+	assert.Equal(t, "docker", task.Driver)
+	assert.Equal(t, "functions/echo:latest", task.Config["image"])
+	assert.Empty(t, task.Artifacts)
+	assert.NotContains(t, task.Env, "port")
+}
+
+func TestDeployHandlerReportsErrorWhenDriverConfigIsInvalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"com.openfaas.driver": "podman"},
+		{"com.openfaas.driver": "exec"},
+		{"com.openfaas.driver": "raw_exec", "com.openfaas.command": ""},
+		{"com.openfaas.driver": "java", "com.openfaas.command": "java"},
+	}
+
+	for _, labels := range invalid {
+		l := labels
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &l
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "labels: %v", labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithMultipleArtifacts(t *testing.T) {
+	checksum := "sha256:" + strings.Repeat("a", 64)
+	labels := map[string]string{
+		"com.openfaas.driver":                      "raw_exec",
+		"com.openfaas.command":                     "local/echo",
+		"com.openfaas.artifact":                    "https://example.com/echo",
+		"com.openfaas.artifact.config.source":      "git::https://github.com/example/config",
+		"com.openfaas.artifact.config.destination": "local/config",
+		"com.openfaas.artifact.data.source":        "https://example.com/data.tar.gz",
+		"com.openfaas.artifact.data.checksum":      checksum,
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	artifacts := job.TaskGroups[0].Tasks[0].Artifacts
+
+	assert.Len(t, artifacts, 3)
+	assert.Equal(t, "https://example.com/echo", *artifacts[0].GetterSource)
+	assert.Equal(t, "local/", *artifacts[0].RelativeDest)
+	assert.Equal(t, "git::https://github.com/example/config", *artifacts[1].GetterSource)
+	assert.Equal(t, "local/config", *artifacts[1].RelativeDest)
+	assert.Nil(t, artifacts[1].GetterOptions)
+	assert.Equal(t, "https://example.com/data.tar.gz", *artifacts[2].GetterSource)
+	assert.Equal(t, map[string]string{"checksum": checksum}, artifacts[2].GetterOptions)
+}
+
+func TestDeployHandlerReportsErrorWhenArtifactIsInvalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"com.openfaas.artifact.data.source": "example.com/data.tar.gz"},
+		{"com.openfaas.artifact.data.source": "https://example.com/data", "com.openfaas.artifact.data.destination": "/etc"},
+		{"com.openfaas.artifact.data.source": "https://example.com/data", "com.openfaas.artifact.data.destination": "../data"},
+		{"com.openfaas.artifact.data.source": "https://example.com/data", "com.openfaas.artifact.data.checksum": "sha256:abc"},
+		{"com.openfaas.artifact.data.source": "https://example.com/data", "com.openfaas.artifact.data.checksum": "crc32:" + strings.Repeat("a", 8)},
+		{"com.openfaas.artifact.data.source": "https://example.com/data", "com.openfaas.artifact.data.checksum": "md5:" + strings.Repeat("z", 32)},
+	}
+
+	for _, labels := range invalid {
+		l := map[string]string{
+			"com.openfaas.driver":   "exec",
+			"com.openfaas.command":  "local/data",
+			"com.openfaas.artifact": "https://example.com/echo",
+		}
+		for k, v := range labels {
+			l[k] = v
+		}
+
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &l
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "labels: %v", labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithBridgeNetworkAndPorts(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.network-mode": "bridge",
+		"com.openfaas.ports":        "metrics:9100, grpc:9000",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	group := job.TaskGroups[0]
+	network := group.Networks[0]
+
+	assert.Equal(t, "bridge", network.Mode)
+	assert.Equal(t, []api.Port{{Label: "http", To: 8080}, {Label: "metrics", To: 9100}, {Label: "grpc", To: 9000}}, network.DynamicPorts)
+	assert.Empty(t, network.ReservedPorts)
+	assert.Empty(t, group.Constraints)
+	assert.Equal(t, []string{"http", "metrics", "grpc"}, group.Tasks[0].Config["ports"])
+
+	assert.Len(t, group.Services, 3)
+	assert.Equal(t, "faas-fn-Func123", group.Services[0].Name)
+	assert.Equal(t, "http", group.Services[0].PortLabel)
+	assert.Equal(t, "faas-fn-Func123-metrics", group.Services[1].Name)
+	assert.Equal(t, "metrics", group.Services[1].PortLabel)
+	assert.Equal(t, []string{"faas", "aux"}, group.Services[1].Tags)
+	assert.Equal(t, map[string]string{
+		"port_metrics": "${NOMAD_HOST_PORT_metrics}",
+		"port_grpc":    "${NOMAD_HOST_PORT_grpc}",
+	}, group.Services[0].Meta)
+	assert.Equal(t, "faas-fn-Func123-grpc", group.Services[2].Name)
+}
+
+func TestDeployHandlerWithHostNetworkAndStaticPorts(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.network-mode": "host",
+		"com.openfaas.ports":        "metrics:9100:9100",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.NetworkingMode = "bridge"
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	group := job.TaskGroups[0]
+	network := group.Networks[0]
+
+	assert.Equal(t, "host", network.Mode)
+	assert.Equal(t, []api.Port{{Label: "http", To: 8080}}, network.DynamicPorts)
+	assert.Equal(t, []api.Port{{Label: "metrics", Value: 9100, To: 9100}}, network.ReservedPorts)
+	assert.Equal(t, []*api.Constraint{api.NewConstraint("", api.ConstraintDistinctHosts, "true")}, group.Constraints)
+	assert.Equal(t, "faas-fn-Func123-metrics", group.Services[1].Name)
+}
+
+func TestDeployHandlerWithDefaultNetworkMode(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	group := job.TaskGroups[0]
+
+	assert.Equal(t, "host", group.Networks[0].Mode)
+	assert.Len(t, group.Services, 1)
+}
+
+func TestDeployHandlerReportsErrorWhenNetworkIsInvalid(t *testing.T) {
+	invalid := []map[string]string{
+		{"com.openfaas.network-mode": "overlay"},
+		{"com.openfaas.ports": "metrics"},
+		{"com.openfaas.ports": "metrics:abc"},
+		{"com.openfaas.ports": "metrics:70000"},
+		{"com.openfaas.ports": "http:9000"},
+		{"com.openfaas.ports": "metrics:9100,metrics:9101"},
+		{"com.openfaas.ports": "metrics:9100:9100,grpc:9000:9100"},
+		{"com.openfaas.ports": "my.port:9100"},
+	}
+
+	for _, labels := range invalid {
+		l := labels
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &l
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "labels: %v", labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithPrestartTemplate(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.prestart": "migrate",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Image = "functions/echo:latest"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Prestart = map[string]types.PrestartTemplate{
+		"migrate": {Image: "migrate/migrate:v4", Command: "migrate", Args: []string{"up"}},
+	}
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+	tasks := job.TaskGroups[0].Tasks
+
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, "Func123", tasks[0].Name)
+	assert.Nil(t, tasks[0].Lifecycle)
+
+	prestart := tasks[1]
+	assert.Equal(t, "Func123-prestart", prestart.Name)
+	assert.Equal(t, "docker", prestart.Driver)
+	assert.Equal(t, map[string]interface{}{
+		"image":   "migrate/migrate:v4",
+		"command": "migrate",
+		"args":    []string{"up"},
+	}, prestart.Config)
+	assert.Equal(t, &api.TaskLifecycle{Hook: api.TaskLifecycleHookPrestart, Sidecar: false}, prestart.Lifecycle)
+}
+
+func TestDeployHandlerReportsErrorWhenPrestartTemplateIsInvalid(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Prestart = map[string]types.PrestartTemplate{
+		"download": {Command: "wget"},
+	}
+
+	for _, name := range []string{"migrate", "download"} {
+		labels := map[string]string{
+			"com.openfaas.prestart": name,
+		}
+
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "prestart: %s", name)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithServiceNameTemplate(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.ServiceName, _ = types.ParseServiceNameTemplate("{{.Namespace}}-fn-{{.Name}}")
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, "faas-fn-Func123", *job.ID)
+	assert.Equal(t, "default-fn-Func123", job.TaskGroups[0].Services[0].Name)
+}
+
+func TestDeployHandlerReportsErrorWhenServiceNameIsInvalid(t *testing.T) {
+	templates := []string{"{{.Name}}.{{.Namespace}}", "{{.Name}}-", "{{.Unknown}}"}
+
+	for _, text := range templates {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		body, _ := json.Marshal(req)
+
+		config, _ := types.DefaultConfig()
+		config.Scheduling.ServiceName, _ = types.ParseServiceNameTemplate(text)
+		jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, "template: %s", text)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithConsulConnectEgress(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.network-mode": "bridge",
+		"com.openfaas.egress":       "payments:9001, users:9002",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.ConsulConnect = true
+	jobs := &services.MockJobs{}
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	connect := &services.MockConsulConnect{}
+	connect.On("IntentionMatch", mock.Anything, mock.Anything).Return(nil, nil)
+	connect.On("IntentionCreate", mock.Anything, mock.Anything).Return("1", nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, services.NewIntentions(connect), nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	connectStanza := job.TaskGroups[0].Services[0].Connect
+	assert.Equal(t, []*api.ConsulUpstream{
+		{DestinationName: "payments", LocalBindPort: 9001},
+		{DestinationName: "users", LocalBindPort: 9002},
+	}, connectStanza.SidecarService.Proxy.Upstreams)
+
+	connect.AssertNumberOfCalls(t, "IntentionCreate", 2)
+	created := connect.Calls[1].Arguments.Get(0).(*consulapi.Intention)
+	assert.Equal(t, "faas-fn-Func123", created.SourceName)
+	assert.Equal(t, "payments", created.DestinationName)
+}
+
+func TestDeployHandlerReportsErrorWhenEgressIsInvalid(t *testing.T) {
+	connectConfig, _ := types.DefaultConfig()
+	connectConfig.Scheduling.ConsulConnect = true
+
+	invalid := []struct {
+		config *types.ProviderConfig
+		labels map[string]string
+	}{
+		{connectConfig, map[string]string{"com.openfaas.egress": "payments:9001"}},
+		{connectConfig, map[string]string{"com.openfaas.network-mode": "bridge", "com.openfaas.egress": "payments"}},
+		{connectConfig, map[string]string{"com.openfaas.network-mode": "bridge", "com.openfaas.egress": "payments:9001,users:9001"}},
+		{connectConfig, map[string]string{"com.openfaas.network-mode": "bridge", "com.openfaas.egress": "payments:8080"}},
+		{connectConfig, map[string]string{"com.openfaas.network-mode": "bridge", "com.openfaas.egress": "-payments:9001"}},
+		{nil, map[string]string{"com.openfaas.network-mode": "bridge", "com.openfaas.egress": "payments:9001"}},
+	}
+
+	for _, tc := range invalid {
+		labels := tc.labels
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		config := tc.config
+		if config == nil {
+			config, _ = types.DefaultConfig()
+		}
+
+		jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels["com.openfaas.egress"])
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerReportsErrorWhenAdmissionWebhookRejects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "label team is required"}`))
+	}))
+	defer server.Close()
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Admission.WebhookURL = server.URL
+	jobs := &services.MockJobs{}
+	webhook := admission.NewWebhook(config.Admission, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, webhook, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "label team is required")
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func createQuotaJob(id string, count, cpu, memory int) *api.Job {
+	return &api.Job{
+		ID: &id,
+		TaskGroups: []*api.TaskGroup{{
+			Count: &count,
+			Tasks: []*api.Task{{
+				Resources: &api.Resources{CPU: &cpu, MemoryMB: &memory},
+			}},
+		}},
+	}
+}
+
+func quotaAllocation(jobID, desired, client string, cpu, memory int64) *api.AllocationListStub {
+	return &api.AllocationListStub{
+		JobID:         jobID,
+		DesiredStatus: desired,
+		ClientStatus:  client,
+		AllocatedResources: &api.AllocatedResources{Tasks: map[string]*api.AllocatedTaskResources{
+			"task": {Cpu: api.AllocatedCpuResources{CpuShares: cpu}, Memory: api.AllocatedMemoryResources{MemoryMB: memory}},
+		}},
+	}
+}
+
+func setupQuotaChecker(config *types.ProviderConfig, jobs *services.MockJobs) (*services.MockAllocations, *services.MockNodes, *QuotaChecker) {
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-existing", Name: "faas-fn-existing"},
+		{ID: "faas-fn-stopped", Name: "faas-fn-stopped", Stop: true},
+		{ID: "faas-fn-Func123", Name: "faas-fn-Func123"},
+		{ID: "other", Name: "other"},
+	}, nil, nil)
+
+	allocations := &services.MockAllocations{}
+	allocations.On("List", mock.Anything).Return([]*api.AllocationListStub{
+		quotaAllocation("faas-fn-existing", "run", "running", 200, 256),
+		quotaAllocation("faas-fn-existing", "run", "pending", 200, 256),
+		quotaAllocation("faas-fn-existing", "stop", "complete", 200, 256),
+		quotaAllocation("faas-fn-stopped", "run", "running", 500, 512),
+		quotaAllocation("faas-fn-Func123", "run", "running", 100, 128),
+		quotaAllocation("faas-fn-Func123", "run", "running", 100, 128),
+		quotaAllocation("faas-fn-Func123", "run", "running", 100, 128),
+		quotaAllocation("other", "run", "running", 1000, 1024),
+	}, nil)
+
+	nodes := &services.MockNodes{}
+	return allocations, nodes, NewQuotaChecker(config, jobs, allocations, nodes)
+}
+
+func setupQuotaDeployHandler(config *types.ProviderConfig, body []byte) (*services.MockJobs, *services.MockAllocations, *services.MockNodes, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	jobs := &services.MockJobs{}
+	allocations, nodes, quota := setupQuotaChecker(config, jobs)
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, quota, hclog.Default())
+
+	return jobs, allocations, nodes, handler, httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body)), httptest.NewRecorder()
+}
+
+func TestDeployHandlerWithinNamespaceQuota(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Limits = &ftypes.FunctionResources{CPU: "200", Memory: "256"}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {CPU: 1000, Memory: 1280}}
+
+	jobs, _, _, deployHandler, request, recorder := setupQuotaDeployHandler(config, body)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertNotCalled(t, "Info", mock.Anything, mock.Anything)
+	jobs.AssertCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerReportsErrorWhenNamespaceQuotaIsExceeded(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Limits = &ftypes.FunctionResources{CPU: "300", Memory: "256"}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {CPU: 1000}}
+
+	jobs, _, _, deployHandler, request, recorder := setupQuotaDeployHandler(config, body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "cpu 400 MHz in use, 900 MHz requested, limit 1000")
+	assert.Contains(t, recorder.Body.String(), "memory 512 MB in use, 768 MB requested, unlimited")
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerSkipsQuotaOfOtherNamespaces(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"team-a": {CPU: 1}}
+
+	jobs, allocations, _, deployHandler, request, recorder := setupQuotaDeployHandler(config, body)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertNotCalled(t, "List", mock.Anything)
+	allocations.AssertNotCalled(t, "List", mock.Anything)
+}
+
+func TestDeployHandlerCountsSystemJobOnEligibleNodes(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Limits = &ftypes.FunctionResources{CPU: "200", Memory: "256"}
+	req.Labels = &map[string]string{services.JobTypeLabel: api.JobTypeSystem}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {CPU: 900}}
+
+	jobs, _, nodes, deployHandler, request, recorder := setupQuotaDeployHandler(config, body)
+	nodes.On("List", mock.Anything).Return([]*api.NodeListStub{
+		{Datacenter: "dc1", Status: "ready", SchedulingEligibility: "eligible"},
+		{Datacenter: "dc1", Status: "ready", SchedulingEligibility: "eligible"},
+		{Datacenter: "dc1", Status: "ready", SchedulingEligibility: "eligible"},
+		{Datacenter: "dc1", Status: "ready", SchedulingEligibility: "ineligible"},
+		{Datacenter: "dc1", Status: "down", SchedulingEligibility: "eligible"},
+		{Datacenter: "dc2", Status: "ready", SchedulingEligibility: "eligible"},
+	}, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "cpu 400 MHz in use, 600 MHz requested, limit 900")
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerWithImagePerArchitecture(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{
+		services.ImagesLabel: "arm64=registry/fn:1.0-arm64, amd64=registry/fn:1.0-amd64",
+	}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+
+	assert.Equal(t, []*api.Constraint{{LTarget: "${attr.cpu.arch}", Operand: "set_contains_any", RTarget: "amd64,arm64"}}, job.Constraints)
+	assert.Equal(t, "registry/fn:1.0-${attr.cpu.arch}", job.TaskGroups[0].Tasks[0].Config["image"])
+}
+
+func TestDeployHandlerWithMultiArchImage(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Image = "registry/fn:1.0"
+	req.Labels = &map[string]string{services.ArchsLabel: "arm64"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+
+	assert.Equal(t, []*api.Constraint{{LTarget: "${attr.cpu.arch}", Operand: "=", RTarget: "arm64"}}, job.Constraints)
+	assert.Equal(t, "registry/fn:1.0", job.TaskGroups[0].Tasks[0].Config["image"])
+}
+
+func TestDeployHandlerReportsErrorWhenImagesAreInvalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.ImagesLabel: ","},
+		{services.ImagesLabel: "amd64"},
+		{services.ImagesLabel: "amd64=registry/fn:1.0-amd64,amd64=registry/fn:1.1-amd64"},
+		{services.ImagesLabel: "amd64=registry/fn:1.0"},
+		{services.ImagesLabel: "amd64=registry/fn:1.0-amd64,arm64=registry/fn-arm:1.0-arm64"},
+		{services.ImagesLabel: "amd64=registry/fn:1.0-amd64", services.ArchsLabel: "amd64"},
+		{services.ArchsLabel: "amd 64"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithConsulServiceTagsAndMeta(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{
+		"team":                    "payments",
+		"version":                 "1.4.2",
+		"com.example.cost-center": "cc-042",
+		"unrelated":               "ignored",
+	}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.ConsulTagLabels = []string{"team", "version", "owner"}
+	config.Scheduling.ConsulMetaLabels = []string{"team", "com.example.cost-center"}
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	service := job.TaskGroups[0].Services[0]
+
+	assert.Equal(t, []string{"http", "faas", "team=payments", "version=1.4.2"}, service.Tags)
+	assert.Equal(t, map[string]string{"team": "payments", "com_example_cost-center": "cc-042"}, service.Meta)
+}
+
+func TestDeployHandlerReportsErrorWhenConsulServiceLabelsAreInvalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{"team": "payments team"},
+		{"team": "payments,billing"},
+		{"port_http": "8080"},
+		{"consul-version": "1"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		config, _ := types.DefaultConfig()
+		config.Scheduling.ConsulTagLabels = []string{"team"}
+		config.Scheduling.ConsulMetaLabels = []string{"port_http", "consul-version"}
+
+		jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithUlimitsAndSysctls(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{
+		"com.openfaas.ulimit.nofile":                       "65536",
+		"com.openfaas.ulimit.nproc":                        "1024:4096",
+		"com.openfaas.sysctl.net.core.somaxconn":           "4096",
+		"com.openfaas.sysctl.net.ipv4.ip_local_port_range": "1024 65000",
+	}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	config := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0].Config
+
+	assert.Equal(t, map[string]string{"nofile": "65536", "nproc": "1024:4096"}, config["ulimit"])
+	assert.Equal(t, map[string]string{"net.core.somaxconn": "4096", "net.ipv4.ip_local_port_range": "1024 65000"}, config["sysctl"])
+}
+
+func TestDeployHandlerWithoutUlimitsKeepsDefaults(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	config := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0].Config
+
+	assert.NotContains(t, config, "ulimit")
+	assert.NotContains(t, config, "sysctl")
+}
+
+func TestDeployHandlerReportsErrorWhenUlimitsAreInvalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{"com.openfaas.ulimit.nofile": "many"},
+		{"com.openfaas.ulimit.nofile": "4096:1024"},
+		{"com.openfaas.ulimit.nofile": "-1"},
+		{"com.openfaas.ulimit.nofile": "2097152"},
+		{"com.openfaas.ulimit.unknown": "1024"},
+		{"com.openfaas.sysctl.vm.swappiness": "10"},
+		{"com.openfaas.sysctl.net.core.somaxconn": "lots"},
+		{"com.openfaas.ulimit.nofile": "1024", services.DriverLabel: "exec", "com.openfaas.command": "fn"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithDNSServersAndExtraHosts(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{
+		services.DNSServersLabel: "10.0.0.2, 2001:db8::53",
+		services.ExtraHostsLabel: "legacy.example.com:10.0.0.5,ledger:2001:db8::5",
+	}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	config := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0].Config
+
+	assert.Equal(t, []string{"10.0.0.2", "2001:db8::53"}, config["dns_servers"])
+	assert.Equal(t, []string{"legacy.example.com:10.0.0.5", "ledger:2001:db8::5"}, config["extra_hosts"])
+}
+
+func TestDeployHandlerWithoutDNSServersUsesNodeDefaults(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	config := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0].Config
+
+	assert.NotContains(t, config, "dns_servers")
+	assert.NotContains(t, config, "extra_hosts")
+}
+
+func TestDeployHandlerReportsErrorWhenDNSServersOrExtraHostsAreInvalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.DNSServersLabel: "dns.example.com"},
+		{services.DNSServersLabel: "10.0.0.2,,10.0.0.3"},
+		{services.ExtraHostsLabel: "legacy.example.com"},
+		{services.ExtraHostsLabel: "legacy.example.com:legacy"},
+		{services.ExtraHostsLabel: "legacy_host:10.0.0.5"},
+		{services.ExtraHostsLabel: ":10.0.0.5"},
+		{services.DNSServersLabel: "10.0.0.2", services.DriverLabel: "exec", "com.openfaas.command": "fn"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerStampsCostAttributionMeta(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{"team": "finance", "cost-center": "cc-42", "owner": "alice"}
+	req.Annotations = &map[string]string{"topic": "invoices"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+
+	assert.Equal(t, map[string]string{
+		"topic":                       "invoices",
+		"faas_nomad_cost_team":        "finance",
+		"faas_nomad_cost_cost-center": "cc-42",
+	}, job.Meta)
+}
+
+func TestDeployHandlerWithKillTimeoutAndShutdownDelay(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{
+		services.KillTimeoutLabel:   "25s",
+		services.ShutdownDelayLabel: "5",
+	}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	group := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0]
+
+	assert.Equal(t, 25*time.Second, *group.Tasks[0].KillTimeout)
+	assert.Equal(t, 5*time.Second, *group.ShutdownDelay)
+}
+
+func TestDeployHandlerWithoutKillTimeoutKeepsNomadDefaults(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	group := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0]
+
+	assert.Nil(t, group.Tasks[0].KillTimeout)
+	assert.Nil(t, group.ShutdownDelay)
+}
+
+func TestDeployHandlerReportsErrorWhenKillTimeoutIsInvalid(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.KillTimeoutLabel: "forever"},
+		{services.KillTimeoutLabel: "0s"},
+		{services.KillTimeoutLabel: "2m"},
+		{services.ShutdownDelayLabel: "-5s"},
+		{services.ShutdownDelayLabel: "soon"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerAllowsKillTimeoutUpToConfiguredMax(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.MaxKillTimeout = 5 * time.Minute
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{services.KillTimeoutLabel: "2m"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 2*time.Minute, *jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0].KillTimeout)
+}
+
+func TestDeployHandlerAddsWarmReplicasToCount(t *testing.T) {
+	labels := map[string]string{
+		"com.openfaas.scale.min": "2",
+		services.WarmLabel:       "1",
+	}
+
+	req := ftypes.FunctionDeployment{Service: "Func123", Labels: &labels}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, 3, *job.TaskGroups[0].Count)
+}
+
+func TestDeployHandlerRejectsInvalidWarmReplicas(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.WarmLabel: "-1"},
+		{services.WarmLabel: "many"},
+		{services.WarmLabel: "1", "com.openfaas.job-type": "system"},
+	} {
+		labels := labels
+
+		req := ftypes.FunctionDeployment{Service: "Func123", Labels: &labels}
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerWithJobIDAnnotation(t *testing.T) {
+	annotations := map[string]string{
+		services.JobIDAnnotation: "legacy.echo-v1",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "echo"
+	req.Annotations = &annotations
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	args := jobs.Calls[0].Arguments
+	job := args.Get(0).(*api.Job)
+
+	assert.Equal(t, "legacy.echo-v1", *job.ID)
+	assert.Equal(t, "faas-fn-echo", *job.Name)
+	assert.Equal(t, "faas-fn-echo", job.TaskGroups[0].Services[0].Name)
+}
+
+func TestDeployHandlerReportsErrorWhenJobIDAnnotationIsInvalid(t *testing.T) {
+	for _, id := range []string{"", "-echo", "echo/v1", "echo v1", strings.Repeat("a", 129)} {
+		annotations := map[string]string{
+			services.JobIDAnnotation: id,
+		}
+
+		req := ftypes.FunctionDeployment{}
+		req.Service = "echo"
+		req.Annotations = &annotations
+		body, _ := json.Marshal(req)
+
+		jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+		deployHandler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, id)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
+
+func TestDeployHandlerReportsErrorWhenJobIDAnnotationIsBlueGreen(t *testing.T) {
+	labels := map[string]string{
+		services.BlueGreenLabel: "true",
+	}
+	annotations := map[string]string{
+		services.JobIDAnnotation: "legacy-echo",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "echo"
+	req.Labels = &labels
+	req.Annotations = &annotations
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func deploySecretTemplates(labels map[string]string) (int, []*api.Template) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	req.Secrets = []string{"secret-a", "secret-b"}
+	body, _ := json.Marshal(req)
+
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	secrets := &services.MockSecrets{}
+	secrets.On("Exists", "default", mock.Anything).Return(true)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, secrets, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	if len(jobs.Calls) == 0 {
+		return recorder.Code, nil
+	}
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	return recorder.Code, job.TaskGroups[0].Tasks[0].Templates
+}
+
+func TestDeployHandlerRestartsOnSecretChangeByDefault(t *testing.T) {
+	status, templates := deploySecretTemplates(map[string]string{})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, templates, 2)
+	for _, template := range templates {
+		assert.Equal(t, "restart", *template.ChangeMode)
+		assert.Nil(t, template.ChangeSignal)
+	}
+}
+
+func TestDeployHandlerSignalsOnSecretChange(t *testing.T) {
+	status, templates := deploySecretTemplates(map[string]string{
+		services.SecretsChangeModeLabel:   "signal",
+		services.SecretsChangeSignalLabel: "sigusr1",
+	})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, templates, 2)
+	for _, template := range templates {
+		assert.Equal(t, "signal", *template.ChangeMode)
+		assert.Equal(t, "SIGUSR1", *template.ChangeSignal)
+	}
+
+	status, templates = deploySecretTemplates(map[string]string{
+		services.SecretsChangeModeLabel: "signal",
+	})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "SIGHUP", *templates[0].ChangeSignal)
+}
+
+func TestDeployHandlerIgnoresSecretChangeWithNoop(t *testing.T) {
+	status, templates := deploySecretTemplates(map[string]string{
+		services.SecretsChangeModeLabel:   "noop",
+		services.SecretsChangeSignalLabel: "SIGUSR1",
+	})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, templates, 2)
+	for _, template := range templates {
+		assert.Equal(t, "noop", *template.ChangeMode)
+		assert.Nil(t, template.ChangeSignal)
+	}
+}
+
+func TestDeployHandlerReportsErrorWhenSecretChangeModeIsInvalid(t *testing.T) {
+	status, templates := deploySecretTemplates(map[string]string{
+		services.SecretsChangeModeLabel: "reload",
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Nil(t, templates)
+
+	status, templates = deploySecretTemplates(map[string]string{
+		services.SecretsChangeModeLabel:   "signal",
+		services.SecretsChangeSignalLabel: "SIGFOO",
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Nil(t, templates)
+}
+
+func TestDeployHandlerWithOutboundProxy(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HTTPProxy = "http://proxy.example.com:3128"
+	config.Scheduling.HTTPSProxy = "http://proxy.example.com:3129"
+	config.Scheduling.NoProxy = []string{"internal.example.com", "10.0.0.0/8"}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.EnvVars = map[string]string{"https_proxy": "http://other.example.com:8080"}
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	env := job.TaskGroups[0].Tasks[0].Env
+
+	noProxy := "localhost,127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,.consul,internal.example.com"
+	assert.Equal(t, "http://proxy.example.com:3128", env["HTTP_PROXY"])
+	assert.Equal(t, "http://proxy.example.com:3128", env["http_proxy"])
+	assert.Equal(t, "http://proxy.example.com:3129", env["HTTPS_PROXY"])
+	assert.Equal(t, "http://other.example.com:8080", env["https_proxy"])
+	assert.Equal(t, noProxy, env["NO_PROXY"])
+	assert.Equal(t, noProxy, env["no_proxy"])
+}
+
+func TestDeployHandlerWithOutboundProxyLabels(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HTTPProxy = "http://proxy.example.com:3128"
+	config.Scheduling.HTTPSProxy = "http://proxy.example.com:3129"
+
+	labels := map[string]string{
+		services.HTTPProxyLabel:  "socks5://egress.example.com:1080",
+		services.HTTPSProxyLabel: "none",
+		services.NoProxyLabel:    "api.example.com",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	env := job.TaskGroups[0].Tasks[0].Env
+
+	assert.Equal(t, "socks5://egress.example.com:1080", env["HTTP_PROXY"])
+	assert.NotContains(t, env, "HTTPS_PROXY")
+	assert.NotContains(t, env, "https_proxy")
+	assert.True(t, strings.HasSuffix(env["NO_PROXY"], ",.consul,api.example.com"))
+}
+
+func TestDeployHandlerWithoutOutboundProxy(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.NotContains(t, job.TaskGroups[0].Tasks[0].Env, "NO_PROXY")
+}
+
+func TestDeployHandlerReportsErrorWhenOutboundProxyIsInvalid(t *testing.T) {
+	labels := map[string]string{
+		services.HTTPProxyLabel: "proxy.example.com:3128",
+	}
+
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, deployHandler, request, recorder := setupDeployHandler(body)
+
+	deployHandler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func deployServiceCheck(labels map[string]string) (int, *api.ServiceCheck) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &labels
+	body, _ := json.Marshal(req)
+
+	jobs, handler, request, recorder := setupDeployHandler(body)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	if len(jobs.Calls) == 0 {
+		return recorder.Code, nil
+	}
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	return recorder.Code, &job.TaskGroups[0].Services[0].Checks[0]
+}
+
+func TestDeployHandlerCreatesHTTPCheckByDefault(t *testing.T) {
+	status, check := deployServiceCheck(map[string]string{})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "http", check.Type)
+	assert.Equal(t, "http", check.PortLabel)
+	assert.Equal(t, "/_/health", check.Path)
+	assert.Equal(t, "critical", check.InitialStatus)
+	assert.NotNil(t, check.CheckRestart)
+}
+
+func TestDeployHandlerCreatesTCPCheck(t *testing.T) {
+	status, check := deployServiceCheck(map[string]string{services.HealthTypeLabel: "tcp"})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "tcp", check.Type)
+	assert.Equal(t, "http", check.PortLabel)
+	assert.Empty(t, check.Path)
+	assert.Equal(t, 5*time.Second, check.Interval)
+}
+
+func TestDeployHandlerCreatesGRPCCheck(t *testing.T) {
+	status, check := deployServiceCheck(map[string]string{
+		services.HealthTypeLabel:        "grpc",
+		services.HealthGRPCServiceLabel: "echo.v1.Echo",
+		services.HealthGRPCTLSLabel:     "true",
+	})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "grpc", check.Type)
+	assert.Equal(t, "http", check.PortLabel)
+	assert.Equal(t, "echo.v1.Echo", check.GRPCService)
+	assert.True(t, check.GRPCUseTLS)
+	assert.Empty(t, check.Path)
+
+	status, check = deployServiceCheck(map[string]string{services.HealthTypeLabel: "grpc"})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, check.GRPCService)
+	assert.False(t, check.GRPCUseTLS)
+}
+
+func TestDeployHandlerCreatesScriptCheck(t *testing.T) {
+	status, check := deployServiceCheck(map[string]string{
+		services.HealthTypeLabel:    "script",
+		services.HealthCommandLabel: "/bin/health --quiet",
+	})
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "script", check.Type)
+	assert.Equal(t, "Func123", check.TaskName)
+	assert.Equal(t, "/bin/health", check.Command)
+	assert.Equal(t, []string{"--quiet"}, check.Args)
+	assert.Empty(t, check.PortLabel)
+	assert.Empty(t, check.Path)
+}
+
+func TestDeployHandlerRejectsInvalidHealthCheck(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.HealthTypeLabel: "udp"},
+		{services.HealthTypeLabel: "script"},
+		{services.HealthTypeLabel: "script", services.HealthCommandLabel: "   "},
+		{services.HealthTypeLabel: "tcp", services.HealthCommandLabel: "/bin/health"},
+		{services.HealthTypeLabel: "http", services.HealthGRPCServiceLabel: "echo.v1.Echo"},
+		{services.HealthTypeLabel: "grpc", services.HealthGRPCServiceLabel: "echo/v1"},
+		{services.HealthTypeLabel: "grpc", services.HealthGRPCTLSLabel: "maybe"},
+	} {
+		status, check := deployServiceCheck(labels)
+
+		assert.Equal(t, http.StatusBadRequest, status, labels)
+		assert.Nil(t, check, labels)
+	}
+}
+
+func TestDeployHandlerRendersLogLevelTemplate(t *testing.T) {
+	req := ftypes.FunctionDeployment{}
+	req.Service = "Func123"
+	req.Labels = &map[string]string{services.LogLevelLabel: "template", services.LogLevelEnvLabel: "VERBOSITY"}
+	req.EnvVars = map[string]string{"VERBOSITY": "warn"}
+	body, _ := json.Marshal(req)
+
+	jobs, handler, request, recorder := setupDeployHandler(body)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	task := jobs.Calls[0].Arguments.Get(0).(*api.Job).TaskGroups[0].Tasks[0]
+	assert.NotContains(t, task.Env, "VERBOSITY")
+	assert.Len(t, task.Templates, 1)
+	assert.Equal(t, "local/log-level.env", *task.Templates[0].DestPath)
+	assert.Equal(t, "VERBOSITY={{ keyOrDefault \"faas-nomad/log-level/default/Func123\" \"warn\" | toJSON }}\n", *task.Templates[0].EmbeddedTmpl)
+	assert.True(t, *task.Templates[0].Envvars)
+	assert.Equal(t, "restart", *task.Templates[0].ChangeMode)
+}
+
+func TestDeployHandlerRejectsInvalidLogLevelLabels(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{services.LogLevelLabel: "file"},
+		{services.LogLevelLabel: "signal", services.LogLevelSignalsLabel: "verbose=SIGUSR1"},
+		{services.LogLevelLabel: "signal", services.LogLevelSignalsLabel: "debug=SIGKILL"},
+		{services.LogLevelLabel: "signal", services.LogLevelSignalsLabel: "debug"},
+		{services.LogLevelLabel: "template", services.LogLevelEnvLabel: "LOG-LEVEL"},
+	} {
+		req := ftypes.FunctionDeployment{}
+		req.Service = "Func123"
+		req.Labels = &labels
+		body, _ := json.Marshal(req)
+
+		jobs, handler, request, recorder := setupDeployHandler(body)
+
+		handler(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, labels)
+		jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+	}
+}
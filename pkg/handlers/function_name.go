@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+var invalidFunctionNameCharsRe = regexp.MustCompile(`[^a-z0-9_\-]+`)
+
+// sanitiseFunctionName applies the configured policy to the name of a deployed function, so a name producing an
+// invalid job ID or Consul service name is rejected up front instead of failing in Nomad or Consul. The function
+// is deployed with the returned name.
+func sanitiseFunctionName(config *types.ProviderConfig, namespace, name string) (string, error) {
+	original := name
+
+	switch config.Scheduling.FunctionNames {
+	case types.FunctionNamesLowercase:
+		name = strings.ToLower(name)
+	case types.FunctionNamesReplace:
+		name = invalidFunctionNameCharsRe.ReplaceAllString(strings.ToLower(name), "-")
+		name = strings.Trim(name, "-_")
+	}
+
+	if len(name) == 0 {
+		return "", fmt.Errorf("invalid function name '%s', must not be empty", original)
+	}
+	if !types.ValidServiceName(name) {
+		return "", fmt.Errorf("invalid function name '%s', must only contain alphanumeric characters, dashes or underscores, and start and end with an alphanumeric character", original)
+	}
+
+	maxLength := config.Scheduling.FunctionNameMaxLength
+	if maxLength <= 0 {
+		return name, nil
+	}
+
+	jobID := config.Scheduling.JobPrefix + name
+	if len(jobID) > maxLength {
+		return "", fmt.Errorf("invalid function name '%s', job ID '%s' is longer than %d characters", original, jobID, maxLength)
+	}
+
+	serviceName, err := config.Scheduling.ServiceName.Render(config.Scheduling.JobPrefix, name, namespace)
+	if err != nil {
+		return "", err
+	}
+	if len(serviceName) > maxLength {
+		return "", fmt.Errorf("invalid function name '%s', service name '%s' is longer than %d characters", original, serviceName, maxLength)
+	}
+
+	return name, nil
+}
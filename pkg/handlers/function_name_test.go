@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSanitiseFunctionName(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		name     string
+		expected string
+		valid    bool
+	}{
+		{types.FunctionNamesStrict, "Func123", "Func123", true},
+		{types.FunctionNamesStrict, "my_func-1", "my_func-1", true},
+		{types.FunctionNamesStrict, "my.func", "", false},
+		{types.FunctionNamesStrict, "-echo", "", false},
+		{types.FunctionNamesStrict, "", "", false},
+		{types.FunctionNamesLowercase, "Func123", "func123", true},
+		{types.FunctionNamesLowercase, "my func", "", false},
+		{types.FunctionNamesReplace, "My Func.v2", "my-func-v2", true},
+		{types.FunctionNamesReplace, "..echo..", "echo", true},
+		{types.FunctionNamesReplace, "...", "", false},
+	} {
+		t.Run(tc.policy+"/"+tc.name, func(t *testing.T) {
+			config, _ := types.DefaultConfig()
+			config.Scheduling.FunctionNames = tc.policy
+
+			name, err := sanitiseFunctionName(config, "default", tc.name)
+
+			if tc.valid {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, name)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSanitiseFunctionNameLength(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	prefix := config.Scheduling.JobPrefix
+
+	// the job ID, the prefix followed by the name, is exactly the max length
+	longest := strings.Repeat("a", config.Scheduling.FunctionNameMaxLength-len(prefix))
+	name, err := sanitiseFunctionName(config, "default", longest)
+	assert.NoError(t, err)
+	assert.Equal(t, longest, name)
+
+	_, err = sanitiseFunctionName(config, "default", longest+"a")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "longer than 63 characters")
+}
+
+func TestSanitiseFunctionNameLengthOfServiceName(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.ServiceName, _ = types.ParseServiceNameTemplate("{{.Namespace}}-{{.Prefix}}{{.Name}}")
+
+	name := strings.Repeat("a", config.Scheduling.FunctionNameMaxLength-len(config.Scheduling.JobPrefix))
+
+	_, err := sanitiseFunctionName(config, "default", name)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "service name")
+}
+
+func TestDeployHandlerRejectsInvalidFunctionName(t *testing.T) {
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "my.func", Image: "functions/echo"})
+	jobs, handler, request, recorder := setupDeployHandler(body)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "invalid function name 'my.func'")
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerDeploysSanitisedFunctionName(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.FunctionNames = types.FunctionNamesReplace
+
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "My.Func", Image: "functions/echo"})
+	jobs, handler, request, recorder := setupDeployHandlerWithConfig(config, body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	job := jobs.Calls[0].Arguments.Get(0).(*api.Job)
+	assert.Equal(t, "faas-fn-my-func", *job.ID)
+}
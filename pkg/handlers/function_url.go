@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+type FunctionURL struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	URL       string `json:"url"`
+}
+
+// MakeFunctionURLHandler returns the gateway-facing invocation URL of a function, based on the configured public URL.
+//
+// When the function is addressed with a namespace, either as a query parameter or as a name.namespace suffix,
+// the URL includes the namespace so that it is routed by the gateway to the same namespace.
+func MakeFunctionURLHandler(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("function_url_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		namespace := config.Scheduling.Namespace
+
+		requestedNamespace := r.URL.Query().Get("namespace")
+		if idx := strings.LastIndex(functionName, "."); idx > 0 {
+			requestedNamespace = functionName[idx+1:]
+			functionName = functionName[:idx]
+		}
+
+		if len(requestedNamespace) != 0 && requestedNamespace != namespace {
+			httputil.Errorf(w, http.StatusNotFound, "function %s not found in namespace %s", functionName, requestedNamespace)
+			return
+		}
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+
+		path := functionName
+		if len(requestedNamespace) != 0 {
+			path = fmt.Sprintf("%s.%s", functionName, namespace)
+		}
+
+		urlBytes, _ := json.Marshal(FunctionURL{
+			Name:      functionName,
+			Namespace: namespace,
+			URL:       publicFunctionURL(config, path),
+		})
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(urlBytes)
+
+		log.Trace("Function url read successfully", "function", functionName, "namespace", namespace)
+	}
+}
+
+// publicFunctionURL returns the invocation URL of a function on the gateway.
+func publicFunctionURL(config *types.ProviderConfig, path string) string {
+	return fmt.Sprintf("%s/function/%s", strings.TrimSuffix(config.Gateway.PublicURL, "/"), path)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupFunctionURLHandler(name string, target string) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	config.Gateway.PublicURL = "https://gateway.example.com/"
+	jobs := &services.MockJobs{}
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", target, nil), map[string]string{"name": name})
+	response := httptest.NewRecorder()
+
+	return jobs, MakeFunctionURLHandler(config, jobs, hclog.Default()), request, response
+}
+
+func readFunctionURL(t *testing.T, recorder *httptest.ResponseRecorder) FunctionURL {
+	var result FunctionURL
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestFunctionURLHandlerReturnsInvocationURL(t *testing.T) {
+	jobs, handler, request, recorder := setupFunctionURLHandler("echo", "/system/function/echo/url")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(&api.Job{}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	result := readFunctionURL(t, recorder)
+	assert.Equal(t, "echo", result.Name)
+	assert.Equal(t, "default", result.Namespace)
+	assert.Equal(t, "https://gateway.example.com/function/echo", result.URL)
+}
+
+func TestFunctionURLHandlerIncludesRequestedNamespace(t *testing.T) {
+	jobs, handler, request, recorder := setupFunctionURLHandler("echo.default", "/system/function/echo.default/url")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(&api.Job{}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "https://gateway.example.com/function/echo.default", readFunctionURL(t, recorder).URL)
+}
+
+func TestFunctionURLHandlerReportsNotFoundForUnknownFunction(t *testing.T) {
+	jobs, handler, request, recorder := setupFunctionURLHandler("echo", "/system/function/echo/url")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestFunctionURLHandlerReportsNotFoundForUnknownNamespace(t *testing.T) {
+	jobs, handler, request, recorder := setupFunctionURLHandler("echo", "/system/function/echo/url?namespace=other")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	jobs.AssertNotCalled(t, "Info", mock.Anything, mock.Anything)
+}
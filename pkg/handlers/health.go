@@ -1,9 +1,28 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
 
-func MakeHealthHandler() http.HandlerFunc {
+	"github.com/jsiebens/faas-nomad/pkg/maintenance"
+)
+
+// HealthCheck is a component of the provider that can be degraded, e.g. the resolver when Consul can't be reached.
+type HealthCheck interface {
+	Degraded() bool
+}
+
+func MakeHealthHandler(mode *maintenance.Mode, checks ...HealthCheck) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if mode.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, check := range checks {
+			if check.Degraded() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
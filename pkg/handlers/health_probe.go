@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	healthProbeCountLabel    = "com.openfaas.health-probe.count"
+	healthProbePathLabel     = "com.openfaas.health-probe.path"
+	healthProbeIntervalLabel = "com.openfaas.health-probe.interval"
+	healthProbeTimeoutLabel  = "com.openfaas.health-probe.timeout"
+
+	healthProbeRequestTimeout = 5 * time.Second
+	defaultHealthProbeTimeout = time.Minute
+)
+
+// HealthProbeFailed is returned when a deployed function didn't pass its health probes in time.
+type HealthProbeFailed struct {
+	Function string
+	Timeout  time.Duration
+	Err      error
+}
+
+func (e *HealthProbeFailed) Error() string {
+	return fmt.Sprintf("function %s not serving after %s: %s", e.Function, e.Timeout, e.Err)
+}
+
+// HealthProber probes the health endpoint of a deployed function, until the function passed the number of
+// consecutive probes of the `com.openfaas.health-probe.count` label or the configured default, so a deployment only
+// succeeds once the function actually serves requests, rather than once Nomad placed it.
+//
+// Only the instances of the registered version of the function are probed, at the address of their allocation, as
+// the instances of the previous version keep serving until the new version replaced them. The path, interval and
+// timeout of the probes are set with the `com.openfaas.health-probe.path`, `.interval` and `.timeout` labels, or
+// the configured defaults.
+type HealthProber struct {
+	config      *types.ProviderConfig
+	jobs        services.Jobs
+	allocations services.Allocations
+	client      *http.Client
+	logger      hclog.Logger
+}
+
+func NewHealthProber(config *types.ProviderConfig, jobs services.Jobs, allocations services.Allocations, logger hclog.Logger) *HealthProber {
+	return &HealthProber{
+		config:      config,
+		jobs:        jobs,
+		allocations: allocations,
+		client:      &http.Client{Timeout: healthProbeRequestTimeout},
+		logger:      logger.Named("health_probe"),
+	}
+}
+
+// Probe returns once the registered version of the function passed its health probes, or with an error when it
+// didn't within the timeout. Functions without a probe count are not probed.
+func (p *HealthProber) Probe(ctx context.Context, namespace, jobID, functionName string, labels *map[string]string) error {
+	scheduling := p.config.Scheduling
+	count := types.ParseIntValueFromMap(labels, healthProbeCountLabel, scheduling.HealthProbeCount)
+	if count <= 0 {
+		return nil
+	}
+
+	path := types.ParseStringValueFromMap(labels, healthProbePathLabel, scheduling.HealthProbePath)
+	interval := types.ParseIntOrDurationValueFromMap(labels, healthProbeIntervalLabel, scheduling.HealthProbeInterval)
+	timeout := types.ParseIntOrDurationValueFromMap(labels, healthProbeTimeoutLabel, scheduling.HealthProbeTimeout)
+	if timeout <= 0 {
+		timeout = defaultHealthProbeTimeout
+	}
+
+	job, _, err := p.jobs.Info(jobID, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	addresses := map[string]url.URL{}
+	passed := 0
+
+	for {
+		err := p.probe(ctx, namespace, job, path, addresses, passed)
+		if err == nil {
+			passed++
+			if passed >= count {
+				p.logger.Debug("Function passed health probes", "function", functionName, "probes", passed)
+				return nil
+			}
+		} else {
+			passed = 0
+			p.logger.Debug("Function health probe failed", "function", functionName, "error", err.Error())
+		}
+
+		if err != nil && time.Now().Add(interval).After(deadline) {
+			return &HealthProbeFailed{Function: functionName, Timeout: timeout, Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probe probes one of the running instances of the registered version of the function, a next one on every
+// consecutive probe.
+func (p *HealthProber) probe(ctx context.Context, namespace string, job *api.Job, path string, addresses map[string]url.URL, passed int) error {
+	instances, err := p.instances(namespace, job, addresses)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no running instances of the registered version")
+	}
+
+	target := instances[passed%len(instances)]
+	target.Path = path
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d from %s", response.StatusCode, target.String())
+	}
+	return nil
+}
+
+// instances returns the addresses of the running allocations of the registered version of a function, ordered by
+// allocation. The addresses are kept by allocation, as they don't change.
+func (p *HealthProber) instances(namespace string, job *api.Job, addresses map[string]url.URL) ([]url.URL, error) {
+	options := &api.QueryOptions{Namespace: namespace}
+
+	stubs, _, err := p.jobs.Allocations(*job.ID, false, options)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].ID < stubs[j].ID })
+
+	var result []url.URL
+	for _, stub := range stubs {
+		if stub.ClientStatus != api.AllocClientStatusRunning || !registeredVersion(job, stub.JobVersion) {
+			continue
+		}
+
+		address, ok := addresses[stub.ID]
+		if !ok {
+			allocation, _, err := p.allocations.Info(stub.ID, options)
+			if err != nil {
+				return nil, err
+			}
+			if address, ok = allocationAddress(allocation); !ok {
+				continue
+			}
+			addresses[stub.ID] = address
+		}
+		result = append(result, address)
+	}
+	return result, nil
+}
+
+// allocationAddress returns the address of the http port of an allocation, which is the address the instance of
+// the function is registered with in Consul.
+func allocationAddress(allocation *api.Allocation) (url.URL, bool) {
+	if allocation == nil || allocation.AllocatedResources == nil {
+		return url.URL{}, false
+	}
+
+	shared := allocation.AllocatedResources.Shared
+	for _, port := range shared.Ports {
+		if port.Label == "http" && len(port.HostIP) != 0 {
+			return url.URL{Scheme: "http", Host: net.JoinHostPort(port.HostIP, strconv.Itoa(port.Value))}, true
+		}
+	}
+	for _, network := range shared.Networks {
+		for _, port := range append(network.DynamicPorts, network.ReservedPorts...) {
+			if port.Label == "http" && len(network.IP) != 0 {
+				return url.URL{Scheme: "http", Host: net.JoinHostPort(network.IP, strconv.Itoa(port.Value))}, true
+			}
+		}
+	}
+	return url.URL{}, false
+}
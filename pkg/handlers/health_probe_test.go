@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// healthUpstream responds to the health probes with the status returned by status, counting the probes.
+func healthUpstream(t *testing.T, status func(probe int32) int) (url.URL, *int32) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe := atomic.AddInt32(&probes, 1)
+		if r.URL.Path != "/_/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status(probe))
+	}))
+	t.Cleanup(server.Close)
+
+	instance, _ := url.Parse(server.URL)
+	return *instance, &probes
+}
+
+// mockProbedInstances runs an allocation of the registered version of echo at the address of the instance, and an
+// allocation of the previous version at the address of the previous instance.
+func mockProbedInstances(jobs *services.MockJobs, instance, previous url.URL) *services.MockAllocations {
+	jobID := "faas-fn-echo"
+	version := uint64(2)
+	jobs.On("Info", jobID, mock.Anything).Return(&api.Job{ID: &jobID, Version: &version}, nil, nil)
+	jobs.On("Allocations", jobID, false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "a1", JobVersion: 1, ClientStatus: api.AllocClientStatusRunning},
+		{ID: "b2", JobVersion: 2, ClientStatus: api.AllocClientStatusRunning},
+		{ID: "c3", JobVersion: 2, ClientStatus: api.AllocClientStatusPending},
+	}, nil, nil)
+
+	allocations := &services.MockAllocations{}
+	allocations.On("Info", "a1", mock.Anything).Return(allocationAt(previous), nil, nil)
+	allocations.On("Info", "b2", mock.Anything).Return(allocationAt(instance), nil, nil)
+	return allocations
+}
+
+func allocationAt(instance url.URL) *api.Allocation {
+	port, _ := strconv.Atoi(instance.Port())
+	return &api.Allocation{AllocatedResources: &api.AllocatedResources{Shared: api.AllocatedSharedResources{
+		Ports: []api.PortMapping{{Label: "http", Value: port, HostIP: instance.Hostname()}},
+	}}}
+}
+
+func setupProbingDeployHandler(instance url.URL, labels map[string]string) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HealthProbeInterval = 5 * time.Millisecond
+	config.Scheduling.HealthProbeTimeout = 100 * time.Millisecond
+
+	req := ftypes.FunctionDeployment{Service: "echo", Labels: &labels}
+	body, _ := json.Marshal(req)
+
+	jobs := &services.MockJobs{}
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	allocations := mockProbedInstances(jobs, instance, url.URL{Scheme: "http", Host: "127.0.0.1:1"})
+
+	prober := NewHealthProber(config, jobs, allocations, hclog.NewNullLogger())
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, nil, nil, prober, nil, nil, hclog.NewNullLogger())
+
+	return jobs, handler, httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body)), httptest.NewRecorder()
+}
+
+func TestDeployHandlerReportsErrorWhenHealthProbeFails(t *testing.T) {
+	instance, probes := healthUpstream(t, func(int32) int { return http.StatusServiceUnavailable })
+	jobs, handler, request, recorder := setupProbingDeployHandler(instance, map[string]string{healthProbeCountLabel: "2"})
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "function echo not serving after 100ms: unexpected status code 503")
+	assert.Greater(t, atomic.LoadInt32(probes), int32(1))
+	jobs.AssertCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeployHandlerSucceedsAfterConsecutiveHealthProbes(t *testing.T) {
+	// the second probe fails, so the consecutive probes only pass with the fifth probe
+	instance, probes := healthUpstream(t, func(probe int32) int {
+		if probe == 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	_, handler, request, recorder := setupProbingDeployHandler(instance, map[string]string{healthProbeCountLabel: "3"})
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int32(5), atomic.LoadInt32(probes))
+}
+
+func TestDeployHandlerProbesPathOfLabel(t *testing.T) {
+	instance, probes := healthUpstream(t, func(int32) int { return http.StatusOK })
+	_, handler, request, recorder := setupProbingDeployHandler(instance, map[string]string{healthProbeCountLabel: "1", healthProbePathLabel: "/ready"})
+
+	handler(recorder, request)
+
+	// the upstream only serves its health on /_/health
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Greater(t, atomic.LoadInt32(probes), int32(0))
+}
+
+func TestDeployHandlerDoesNotProbeWithoutCount(t *testing.T) {
+	instance, probes := healthUpstream(t, func(int32) int { return http.StatusServiceUnavailable })
+	_, handler, request, recorder := setupProbingDeployHandler(instance, map[string]string{})
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(probes))
+}
+
+func TestHealthProberStopsWhenContextIsCancelled(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HealthProbeCount = 1
+	config.Scheduling.HealthProbeInterval = time.Second
+	config.Scheduling.HealthProbeTimeout = time.Hour
+
+	jobs := &services.MockJobs{}
+	allocations := mockProbedInstances(jobs, url.URL{Scheme: "http", Host: "127.0.0.1:1"}, url.URL{Scheme: "http", Host: "127.0.0.1:1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := NewHealthProber(config, jobs, allocations, hclog.NewNullLogger()).Probe(ctx, "default", "faas-fn-echo", "echo", nil)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestHealthProberOnlyProbesRegisteredVersion(t *testing.T) {
+	instance, probes := healthUpstream(t, func(int32) int { return http.StatusServiceUnavailable })
+	previous, previousProbes := healthUpstream(t, func(int32) int { return http.StatusOK })
+
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HealthProbeInterval = 5 * time.Millisecond
+	config.Scheduling.HealthProbeTimeout = 50 * time.Millisecond
+
+	jobs := &services.MockJobs{}
+	allocations := mockProbedInstances(jobs, instance, previous)
+
+	labels := map[string]string{healthProbeCountLabel: "1"}
+	err := NewHealthProber(config, jobs, allocations, hclog.NewNullLogger()).Probe(context.Background(), "default", "faas-fn-echo", "echo", &labels)
+
+	assert.IsType(t, &HealthProbeFailed{}, err)
+	assert.Greater(t, atomic.LoadInt32(probes), int32(0))
+	assert.Equal(t, int32(0), atomic.LoadInt32(previousProbes))
+	allocations.AssertNotCalled(t, "Info", "a1", mock.Anything)
+}
+
+func TestHealthProberDefaultsTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.HealthProbeCount = 1
+	config.Scheduling.HealthProbeInterval = time.Second
+	config.Scheduling.HealthProbeTimeout = 0
+
+	jobs := &services.MockJobs{}
+	allocations := mockProbedInstances(jobs, url.URL{Scheme: "http", Host: "127.0.0.1:1"}, url.URL{Scheme: "http", Host: "127.0.0.1:1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// without a default timeout the failed probe would be reported right away, rather than retried until cancelled
+	err := NewHealthProber(config, jobs, allocations, hclog.NewNullLogger()).Probe(ctx, "default", "faas-fn-echo", "echo", nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -2,10 +2,18 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/maintenance"
+	"github.com/jsiebens/faas-nomad/pkg/readiness"
+	"github.com/jsiebens/faas-nomad/pkg/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,8 +21,69 @@ func TestHealthHandlerReportsOK(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/healthz", bytes.NewReader([]byte("")))
 
-	handler := MakeHealthHandler()
+	handler := MakeHealthHandler(maintenance.NewMode(hclog.Default()))
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
 }
+
+func TestHealthHandlerReportsNotReadyWhenDraining(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/healthz", bytes.NewReader([]byte("")))
+
+	mode := maintenance.NewMode(hclog.Default())
+	mode.SetDraining(true)
+
+	handler := MakeHealthHandler(mode)
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+type degradedCheck bool
+
+func (d degradedCheck) Degraded() bool {
+	return bool(d)
+}
+
+func TestHealthHandlerReportsNotReadyWhenDegraded(t *testing.T) {
+	mode := maintenance.NewMode(hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	MakeHealthHandler(mode, degradedCheck(false), degradedCheck(true))(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	MakeHealthHandler(mode, degradedCheck(false))(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestHealthHandlerReportsOKOnlyAfterReadinessGatesPass(t *testing.T) {
+	mode := maintenance.NewMode(hclog.Default())
+
+	var ready int32
+	gates := readiness.NewGates(types.ReadinessConfig{CheckTimeout: time.Second, CheckInterval: time.Millisecond}, hclog.NewNullLogger())
+	gates.Add("nomad", readiness.PingFunc(func(ctx context.Context) error {
+		if atomic.LoadInt32(&ready) == 0 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}))
+
+	handler := MakeHealthHandler(mode, gates)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gates.Run(ctx)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	atomic.StoreInt32(&ready, 1)
+	assert.Eventually(t, gates.Ready, time.Second, time.Millisecond)
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
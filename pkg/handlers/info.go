@@ -3,26 +3,104 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
-	"github.com/openfaas/faas-provider/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
 )
 
 const (
 	OrchestrationIdentifier = "nomad"
 	ProviderName            = "faas-nomad"
+
+	providerCountsTTL = 5 * time.Second
 )
 
-func MakeInfoHandler(version, sha string) http.HandlerFunc {
+// ProviderInfo is the info of the provider, with the number of functions and replicas it manages, when enabled.
+type ProviderInfo struct {
+	ftypes.ProviderInfo
+	Functions *int    `json:"functions,omitempty"`
+	Replicas  *uint64 `json:"replicas,omitempty"`
+}
+
+// ProviderCounts counts the live functions and scheduled replicas managed by the provider, with a single Nomad list query
+// of which the result is cached for a few seconds, so scraping the info endpoint doesn't load Nomad.
+type ProviderCounts struct {
+	config *types.ProviderConfig
+	jobs   services.Jobs
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	expires   time.Time
+	functions int
+	replicas  uint64
+}
+
+func NewProviderCounts(config *types.ProviderConfig, jobs services.Jobs) *ProviderCounts {
+	return &ProviderCounts{config: config, jobs: jobs, ttl: providerCountsTTL, now: time.Now}
+}
+
+func (c *ProviderCounts) Get() (int, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.now().Before(c.expires) {
+		return c.functions, c.replicas, nil
+	}
+
+	options := &api.QueryOptions{Namespace: c.config.Scheduling.Namespace}
+	list, err := services.ListFunctionJobs(c.jobs, c.config.Scheduling.JobPrefix, options)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	functions := 0
+	var replicas uint64
+	for _, j := range list {
+		// the jobs of stopped functions are kept by Nomad until they are garbage collected
+		if j.Status == "dead" {
+			continue
+		}
+		functions++
+		replicas += services.ScheduledReplicas(j.JobSummary)
+	}
+
+	c.functions, c.replicas = functions, replicas
+	c.expires = c.now().Add(c.ttl)
+	return functions, replicas, nil
+}
+
+// MakeInfoHandler reports the provider info. When counts are given, the info includes the number of functions and
+// replicas, which are left out when they can't be read, so the info endpoint keeps working while Nomad is unavailable.
+func MakeInfoHandler(version, sha string, counts *ProviderCounts, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("info_handler")
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		providerInfo := types.ProviderInfo{
-			Orchestration: OrchestrationIdentifier,
-			Name:          ProviderName,
-			Version: &types.VersionInfo{
-				Release: version,
-				SHA:     sha,
+		providerInfo := ProviderInfo{
+			ProviderInfo: ftypes.ProviderInfo{
+				Orchestration: OrchestrationIdentifier,
+				Name:          ProviderName,
+				Version: &ftypes.VersionInfo{
+					Release: version,
+					SHA:     sha,
+				},
 			},
 		}
 
+		if counts != nil {
+			if functions, replicas, err := counts.Get(); err != nil {
+				log.Warn("Error counting functions", "error", err.Error())
+			} else {
+				providerInfo.Functions = &functions
+				providerInfo.Replicas = &replicas
+			}
+		}
+
 		jsonOut, marshalErr := json.Marshal(providerInfo)
 		if marshalErr != nil {
 			w.WriteHeader(http.StatusInternalServerError)
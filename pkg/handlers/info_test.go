@@ -3,20 +3,27 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestInfoHandlerReportsProviderInfo(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	request := httptest.NewRequest("GET", "/system/info", bytes.NewReader([]byte("")))
 
-	handler := MakeInfoHandler("1.2.3", "fa097935ca9d551d91fa78ed81ec05c6a1df249f")
+	handler := MakeInfoHandler("1.2.3", "fa097935ca9d551d91fa78ed81ec05c6a1df249f", nil, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
@@ -37,4 +44,43 @@ func TestInfoHandlerReportsProviderInfo(t *testing.T) {
 	assert.Equal(t, "1.2.3", info.Version.Release)
 	assert.Equal(t, "fa097935ca9d551d91fa78ed81ec05c6a1df249f", info.Version.SHA)
 	assert.Equal(t, "", info.Version.CommitMessage)
+	assert.NotContains(t, string(body), "replicas")
+}
+
+func TestInfoHandlerReportsCachedCounts(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-echo", Name: "faas-fn-echo", Status: "running", JobSummary: &api.JobSummary{Summary: map[string]api.TaskGroupSummary{"echo": {Running: 2, Starting: 1}}}},
+		{ID: "faas-fn-figlet", Name: "faas-fn-figlet", Status: "running", JobSummary: &api.JobSummary{Summary: map[string]api.TaskGroupSummary{"figlet": {Running: 1}}}},
+		{ID: "faas-fn-old", Name: "faas-fn-old", Status: "dead", JobSummary: &api.JobSummary{Summary: map[string]api.TaskGroupSummary{"old": {Running: 1}}}},
+	}, nil, nil).Once()
+
+	now := time.Now()
+	counts := NewProviderCounts(config, jobs)
+	counts.now = func() time.Time { return now }
+
+	handler := MakeInfoHandler("1.2.3", "fa097935ca9d551d91fa78ed81ec05c6a1df249f", counts, hclog.Default())
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", "/system/info", nil))
+
+		info := ProviderInfo{}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &info))
+		assert.Equal(t, ProviderName, info.Name)
+		assert.Equal(t, 2, *info.Functions)
+		assert.Equal(t, uint64(4), *info.Replicas)
+	}
+
+	jobs.AssertNumberOfCalls(t, "List", 1)
+
+	jobs.On("List", mock.Anything).Return(nil, nil, fmt.Errorf("nomad unavailable"))
+	now = now.Add(providerCountsTTL)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/system/info", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "functions")
 }
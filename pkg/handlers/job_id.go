@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// readFunctionJob reads the job of a function, which is the job prefix followed by the function name, unless the
+// function was deployed with the com.openfaas.nomad-job-id annotation.
+func readFunctionJob(config *types.ProviderConfig, jobs services.Jobs, functionName string) (*api.Job, error) {
+	options := &api.QueryOptions{Namespace: config.Scheduling.Namespace}
+	return services.ReadFunctionJob(jobs, config.Scheduling.JobPrefix, options, functionName)
+}
+
+// functionJobID returns the ID of the job of a function, as read by readFunctionJob. When the function doesn't
+// exist, the ID of the prefix convention is returned.
+func functionJobID(config *types.ProviderConfig, jobs services.Jobs, functionName string) (string, error) {
+	jobID := config.Scheduling.JobPrefix + functionName
+	options := &api.QueryOptions{Namespace: config.Scheduling.Namespace}
+
+	_, _, err := jobs.Info(jobID, options)
+	if id, ok := services.OverriddenJobID(jobs, config.Scheduling.JobPrefix, options, functionName, err); ok {
+		return id, nil
+	}
+	if err != nil {
+		if status, _ := classifyNomadError(err); status != http.StatusNotFound {
+			return "", err
+		}
+	}
+	return jobID, nil
+}
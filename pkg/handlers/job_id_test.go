@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockOverriddenJob(jobs *services.MockJobs, jobType string) {
+	jobID := "legacy-echo"
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-figlet", Name: "faas-fn-figlet"},
+		{ID: "legacy-echo", Name: "faas-fn-echo"},
+	}, nil, nil)
+	jobs.On("Info", "legacy-echo", mock.Anything).Return(&api.Job{ID: &jobID, Type: &jobType}, nil, nil)
+}
+
+func TestReplicaUpdaterScalesOverriddenJobID(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	mockOverriddenJob(jobs, api.JobTypeService)
+
+	replicas := 3
+	jobs.On("Scale", "legacy-echo", "echo", &replicas, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: 3})
+	request := httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaUpdater(config, jobs, NewScaleLimiter(), nil, nil, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertExpectations(t)
+}
+
+func TestDeleteHandlerDeregistersOverriddenJobID(t *testing.T) {
+	data, _ := json.Marshal(ftypes.DeleteFunctionRequest{FunctionName: "echo"})
+
+	jobs, deleteHandler, request, recorder := setupDeleteHandler(data)
+	mockOverriddenJob(jobs, api.JobTypeService)
+	jobs.On("Deregister", "legacy-echo", mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deleteHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertCalled(t, "Deregister", "legacy-echo", mock.Anything, mock.Anything)
+}
+
+func TestFunctionJobIDFallsBackToPrefixConvention(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{{ID: "faas-fn-figlet", Name: "faas-fn-figlet"}}, nil, nil)
+
+	jobID, err := functionJobID(config, jobs, "echo")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "faas-fn-echo", jobID)
+}
+
+func TestFunctionJobIDReportsNomadErrors(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 403 (Permission denied)"))
+
+	_, err := functionJobID(config, jobs, "echo")
+
+	assert.Error(t, err)
+	jobs.AssertNotCalled(t, "List", mock.Anything)
+}
+
+func TestFunctionReaderListsOverriddenJobID(t *testing.T) {
+	jobs, functionReader, request, recorder := setupFunctionReader()
+
+	job := createMockJob("legacy-echo", "running")
+	name := "faas-fn-echo"
+	job.Name = &name
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{{ID: "legacy-echo", Name: "faas-fn-echo", Status: "running"}}, nil, nil)
+	jobs.On("Info", "legacy-echo", mock.Anything).Return(job, nil, nil)
+
+	functionReader(recorder, request)
+
+	funcs := make([]ftypes.FunctionStatus, 0)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &funcs))
+	assert.Len(t, funcs, 1)
+	assert.Equal(t, "echo", funcs[0].Name)
+}
+
+func TestLogHandlerReadsAllocationsOfOverriddenJobID(t *testing.T) {
+	jobs := &services.MockJobs{}
+	mockOverriddenJob(jobs, api.JobTypeService)
+	jobs.On("Allocations", "legacy-echo", false, mock.Anything).Return([]*api.AllocationListStub{}, nil, nil)
+
+	config, _ := types.DefaultConfig()
+	request := httptest.NewRequest("GET", "/system/logs?name=echo", nil)
+	recorder := httptest.NewRecorder()
+
+	MakeLogHandler(config, jobs, &services.MockAllocations{}, &services.MockAllocFS{}, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	jobs.AssertCalled(t, "Allocations", "legacy-echo", false, mock.Anything)
+}
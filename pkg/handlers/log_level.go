@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type FunctionLogLevel struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Level     string `json:"level"`
+	Mode      string `json:"mode"`
+	// Instances is the number of instances signalled, when the log level is changed with a signal.
+	Instances int `json:"instances,omitempty"`
+}
+
+// MakeLogLevelHandler changes the log level of a running function without a redeploy, e.g. to turn on debug logging
+// during an incident, by the mechanism of the com.openfaas.log-level label of the function: a signal sent to all
+// running instances of the function, or the Consul KV key rendered by a template into the env of the function.
+//
+// A 400 is returned for an unknown level, or when the function doesn't support changing its log level, and a 409
+// when a function changing its log level with a signal has no running instances.
+func MakeLogLevelHandler(config *types.ProviderConfig, jobs services.Jobs, allocations services.Allocations, kv services.ConsulKV, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("log_level_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		req := LogLevelRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		level := strings.ToLower(strings.TrimSpace(req.Level))
+		if !containsStatus(services.LogLevels, level) {
+			httputil.Errorf(w, http.StatusBadRequest, "invalid log level '%s', must be one of %s", req.Level, strings.Join(services.LogLevels, ", "))
+			return
+		}
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil || len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+
+		labels := services.JobLabels(job)
+		mode, err := services.LogLevelMode(labels)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		result := FunctionLogLevel{Name: functionName, Namespace: namespace, Level: level, Mode: mode}
+
+		switch mode {
+		case services.LogLevelModeSignal:
+			signals, err := services.LogLevelSignals(labels)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			signal, ok := signals[level]
+			if !ok {
+				httputil.Errorf(w, http.StatusBadRequest, "function %s has no signal for log level %s in its %s label", functionName, level, services.LogLevelSignalsLabel)
+				return
+			}
+
+			signalled, err := signalInstances(jobs, allocations, namespace, job, signal)
+			if err != nil {
+				writeNomadError(w, err)
+				log.Error("Error signalling function", "function", functionName, "namespace", namespace, "signal", signal, "error", err.Error())
+				return
+			}
+			if signalled == 0 {
+				httputil.Errorf(w, http.StatusConflict, "function %s has no running instances", functionName)
+				return
+			}
+			result.Instances = signalled
+		case services.LogLevelModeTemplate:
+			key := services.LogLevelKey(config.Consul.LogLevelKVPrefix, namespace, functionName)
+			if _, err := kv.Put(&consulapi.KVPair{Key: key, Value: []byte(level)}, nil); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error writing function log level", "function", functionName, "namespace", namespace, "key", key, "error", err.Error())
+				return
+			}
+		default:
+			httputil.Errorf(w, http.StatusBadRequest, "function %s doesn't support changing its log level, it has no %s label", functionName, services.LogLevelLabel)
+			return
+		}
+
+		response, _ := json.Marshal(result)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(response)
+
+		log.Info("Function log level changed", "function", functionName, "namespace", namespace, "level", level, "mode", mode)
+	}
+}
+
+// signalInstances sends a signal to the task of the function in all its running allocations, and returns the
+// number of allocations signalled.
+func signalInstances(jobs services.Jobs, allocations services.Allocations, namespace string, job *api.Job, signal string) (int, error) {
+	options := &api.QueryOptions{Namespace: namespace}
+	stubs, _, err := jobs.Allocations(*job.ID, false, options)
+	if err != nil {
+		return 0, err
+	}
+
+	task := job.TaskGroups[0].Tasks[0].Name
+	signalled := 0
+	for _, stub := range stubs {
+		if stub.ClientStatus != api.AllocClientStatusRunning {
+			continue
+		}
+		if err := allocations.Signal(&api.Allocation{ID: stub.ID, Namespace: stub.Namespace}, options, task, signal); err != nil {
+			return signalled, fmt.Errorf("allocation %s: %w", stub.ID, err)
+		}
+		signalled++
+	}
+	return signalled, nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createLogLevelJob(labels map[string]interface{}) *api.Job {
+	job := createWarmJob(2, "")
+	job.TaskGroups[0].Tasks[0].Config["labels"] = []interface{}{labels}
+	return job
+}
+
+func setupLogLevelHandler(level string) (*services.MockJobs, *services.MockAllocations, *services.MockConsulKV, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	allocations := &services.MockAllocations{}
+	kv := &services.MockConsulKV{}
+
+	body, _ := json.Marshal(LogLevelRequest{Level: level})
+	request := mux.SetURLVars(httptest.NewRequest("POST", "/system/function/echo/loglevel", bytes.NewReader(body)), map[string]string{"name": "echo"})
+	return jobs, allocations, kv, MakeLogLevelHandler(config, jobs, allocations, kv, hclog.Default()), request, httptest.NewRecorder()
+}
+
+func TestLogLevelHandlerSignalsRunningInstances(t *testing.T) {
+	jobs, allocations, _, handler, request, recorder := setupLogLevelHandler("DEBUG")
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{services.LogLevelLabel: "signal"}), nil, nil)
+	jobs.On("Allocations", "faas-fn-echo", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "alloc-1", ClientStatus: api.AllocClientStatusRunning},
+		{ID: "alloc-2", ClientStatus: api.AllocClientStatusComplete},
+		{ID: "alloc-3", ClientStatus: api.AllocClientStatusRunning},
+	}, nil, nil)
+	allocations.On("Signal", mock.Anything, mock.Anything, "echo", "SIGUSR1").Return(nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var result FunctionLogLevel
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, FunctionLogLevel{Name: "echo", Namespace: "default", Level: "debug", Mode: services.LogLevelModeSignal, Instances: 2}, result)
+
+	allocations.AssertNumberOfCalls(t, "Signal", 2)
+	assert.Equal(t, "alloc-1", allocations.Calls[0].Arguments.Get(0).(*api.Allocation).ID)
+	assert.Equal(t, "alloc-3", allocations.Calls[1].Arguments.Get(0).(*api.Allocation).ID)
+}
+
+func TestLogLevelHandlerSignalsConfiguredSignal(t *testing.T) {
+	jobs, allocations, _, handler, request, recorder := setupLogLevelHandler("trace")
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{
+		services.LogLevelLabel:        "signal",
+		services.LogLevelSignalsLabel: "trace=sigwinch,error=SIGUSR2",
+	}), nil, nil)
+	jobs.On("Allocations", "faas-fn-echo", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "alloc-1", ClientStatus: api.AllocClientStatusRunning},
+	}, nil, nil)
+	allocations.On("Signal", mock.Anything, mock.Anything, "echo", "SIGWINCH").Return(nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	allocations.AssertNumberOfCalls(t, "Signal", 1)
+}
+
+func TestLogLevelHandlerRejectsLevelWithoutSignal(t *testing.T) {
+	jobs, allocations, _, handler, request, recorder := setupLogLevelHandler("warn")
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{services.LogLevelLabel: "signal"}), nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	allocations.AssertNotCalled(t, "Signal", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogLevelHandlerReportsConflictWithoutRunningInstances(t *testing.T) {
+	jobs, allocations, _, handler, request, recorder := setupLogLevelHandler("debug")
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{services.LogLevelLabel: "signal"}), nil, nil)
+	jobs.On("Allocations", "faas-fn-echo", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "alloc-1", ClientStatus: api.AllocClientStatusFailed},
+	}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	allocations.AssertNotCalled(t, "Signal", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogLevelHandlerWritesTemplateKey(t *testing.T) {
+	jobs, _, kv, handler, request, recorder := setupLogLevelHandler("debug")
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{services.LogLevelLabel: "template"}), nil, nil)
+	kv.On("Put", &consulapi.KVPair{Key: "faas-nomad/log-level/default/echo", Value: []byte("debug")}, mock.Anything).Return(nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	kv.AssertNumberOfCalls(t, "Put", 1)
+}
+
+func TestLogLevelHandlerRejectsInvalidRequests(t *testing.T) {
+	jobs, _, kv, handler, request, recorder := setupLogLevelHandler("verbose")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "Info", mock.Anything, mock.Anything)
+
+	jobs, _, kv, handler, request, recorder = setupLogLevelHandler("debug")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createLogLevelJob(map[string]interface{}{}), nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	kv.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
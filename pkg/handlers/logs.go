@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/faas-provider/logs"
+)
+
+var logTypes = []string{"stdout", "stderr"}
+
+type logRequest struct {
+	logs.Request
+	Task string
+}
+
+type logTarget struct {
+	alloc *api.Allocation
+	task  string
+}
+
+func MakeLogHandler(config *types.ProviderConfig, jobs services.Jobs, allocations services.Allocations, fs services.AllocFS, blueGreen *BlueGreenDeployments, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("log_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			httputil.Errorf(w, http.StatusInternalServerError, "streaming response is not supported")
+			return
+		}
+
+		req, err := parseLogRequest(r)
+		if err != nil {
+			httputil.Errorf(w, http.StatusUnprocessableEntity, "could not parse the log request")
+			return
+		}
+
+		// Nomad logs carry no timestamps, so the logs can't be filtered by the since of the request
+		if req.Since != nil {
+			httputil.Errorf(w, http.StatusBadRequest, "since is not supported, as the logs of Nomad carry no timestamps, use tail instead")
+			return
+		}
+
+		namespace := config.Scheduling.Namespace
+		options := &api.QueryOptions{Namespace: namespace}
+
+		// the logs of a function deployed blue/green are the logs of its active colour
+		jobID, err := functionJobID(config, jobs, blueGreen.Resolve(req.Name))
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function", "function", req.Name, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		stubs, _, err := jobs.Allocations(jobID, false, options)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error listing allocations", "function", req.Name, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		stubs = selectAllocations(stubs, req.Instance)
+		if len(stubs) == 0 {
+			if len(req.Instance) != 0 {
+				httputil.Errorf(w, http.StatusNotFound, "instance %s not found for function %s", req.Instance, req.Name)
+			} else {
+				httputil.Errorf(w, http.StatusNotFound, "no instances found for function %s", req.Name)
+			}
+			return
+		}
+
+		var targets []logTarget
+		for _, stub := range stubs {
+			tasks := selectTasks(stub, req.Task)
+			if len(tasks) == 0 {
+				continue
+			}
+
+			alloc, _, err := allocations.Info(stub.ID, options)
+			if err != nil {
+				writeNomadError(w, err)
+				log.Error("Error reading allocation", "function", req.Name, "allocation", stub.ID, "error", err.Error())
+				return
+			}
+
+			for _, task := range tasks {
+				targets = append(targets, logTarget{alloc: alloc, task: task})
+			}
+		}
+
+		if len(targets) == 0 {
+			httputil.Errorf(w, http.StatusNotFound, "task %s not found for function %s", req.Task, req.Name)
+			return
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if config.FaaS.WriteTimeout > 0 {
+			ctx, cancel = context.WithTimeout(r.Context(), config.FaaS.WriteTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(r.Context())
+		}
+		defer cancel()
+
+		messages := make(chan logs.Message)
+
+		var wg sync.WaitGroup
+		for _, target := range targets {
+			for _, logType := range logTypes {
+				wg.Add(1)
+				go func(target logTarget, logType string) {
+					defer wg.Done()
+					streamLogs(ctx, fs, target, logType, req, messages, options, log)
+				}(target, logType)
+			}
+		}
+
+		go func() {
+			wg.Wait()
+			close(messages)
+		}()
+
+		w.Header().Set("Connection", "Keep-Alive")
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.Header().Set(HeaderContentType, "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		encoder := json.NewEncoder(w)
+		for msg := range messages {
+			if err := encoder.Encode(msg); err != nil {
+				log.Error("Error serializing log message", "function", req.Name, "error", err.Error())
+				cancel()
+				break
+			}
+			flusher.Flush()
+		}
+
+		for range messages {
+		}
+
+		log.Trace("Function logs streamed successfully", "function", req.Name, "namespace", namespace)
+	}
+}
+
+// streamLogs streams the logs of a task to the messages. With a tail, the logs are first read up to the current
+// end to select the last lines, and then followed from the byte offset where this backfill ended, so the lines
+// at the boundary between the backfill and the live tail are emitted exactly once. Without a tail, the logs are
+// followed from the start.
+func streamLogs(ctx context.Context, fs services.AllocFS, target logTarget, logType string, req logRequest, messages chan<- logs.Message, options *api.QueryOptions, log hclog.Logger) {
+	emit := func(line string) bool {
+		select {
+		case messages <- logs.Message{
+			Name:      req.Name,
+			Namespace: options.Namespace,
+			Instance:  target.alloc.ID,
+			Timestamp: time.Now(),
+			Text:      line,
+		}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var offset int64
+	var partial []byte
+
+	if !req.Follow || req.Tail > 0 {
+		var lines []string
+		collect := func(line string) bool {
+			lines = append(lines, line)
+			return true
+		}
+
+		var ok bool
+		offset, partial, ok = readLogLines(ctx, fs, target, logType, req, false, 0, nil, collect, options, log)
+		if !ok {
+			return
+		}
+
+		if !req.Follow && len(partial) != 0 {
+			lines = append(lines, string(partial))
+		}
+		for _, line := range tailLines(lines, req.Tail) {
+			if !emit(line) {
+				return
+			}
+		}
+		if !req.Follow {
+			return
+		}
+	}
+
+	// a partial line at the end of the backfill is completed by the live tail
+	_, partial, ok := readLogLines(ctx, fs, target, logType, req, true, offset, partial, emit, options, log)
+	if ok && len(partial) != 0 {
+		emit(string(partial))
+	}
+}
+
+// readLogLines reads the logs of a task from a byte offset, passing the complete lines to onLine. It returns the
+// offset and the partial line where the logs ended, and false when the logs couldn't be read to the end.
+func readLogLines(ctx context.Context, fs services.AllocFS, target logTarget, logType string, req logRequest, follow bool, offset int64, partial []byte, onLine func(string) bool, options *api.QueryOptions, log hclog.Logger) (int64, []byte, bool) {
+	frames, errs := fs.Logs(target.alloc, follow, target.task, logType, "start", offset, ctx.Done(), options)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return offset, partial, false
+		case err := <-errs:
+			if err != nil {
+				log.Error("Error streaming logs", "function", req.Name, "allocation", target.alloc.ID, "task", target.task, "error", err.Error())
+			}
+			return offset, partial, false
+		case frame, ok := <-frames:
+			if !ok {
+				return offset, partial, true
+			}
+
+			offset += int64(len(frame.Data))
+
+			data := append(partial, frame.Data...)
+			idx := bytes.LastIndexByte(data, '\n')
+			if idx < 0 {
+				partial = data
+				continue
+			}
+			partial = append([]byte{}, data[idx+1:]...)
+
+			for _, line := range strings.Split(string(data[:idx]), "\n") {
+				if !onLine(line) {
+					return offset, partial, false
+				}
+			}
+		}
+	}
+}
+
+func tailLines(lines []string, tail int) []string {
+	if tail > 0 && len(lines) > tail {
+		return lines[len(lines)-tail:]
+	}
+	return lines
+}
+
+func selectAllocations(stubs []*api.AllocationListStub, instance string) []*api.AllocationListStub {
+	var selected []*api.AllocationListStub
+	for _, stub := range stubs {
+		if len(instance) != 0 {
+			if strings.HasPrefix(stub.ID, instance) {
+				selected = append(selected, stub)
+			}
+			continue
+		}
+		if stub.ClientStatus == "running" {
+			selected = append(selected, stub)
+		}
+	}
+	return selected
+}
+
+func selectTasks(stub *api.AllocationListStub, task string) []string {
+	var tasks []string
+	for name := range stub.TaskStates {
+		if len(task) == 0 || name == task {
+			tasks = append(tasks, name)
+		}
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+func parseLogRequest(r *http.Request) (logRequest, error) {
+	query := r.URL.Query()
+
+	req := logRequest{}
+	req.Name = query.Get("name")
+	req.Namespace = query.Get("namespace")
+	req.Instance = query.Get("instance")
+	req.Task = query.Get("task")
+
+	if len(req.Name) == 0 {
+		return req, fmt.Errorf("function name is required")
+	}
+
+	if tail := query.Get("tail"); tail != "" {
+		value, err := strconv.Atoi(tail)
+		if err != nil {
+			return req, err
+		}
+		req.Tail = value
+	}
+
+	req.Follow, _ = strconv.ParseBool(query.Get("follow"))
+
+	if since := query.Get("since"); since != "" {
+		value, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return req, err
+		}
+		req.Since = &value
+	}
+
+	return req, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/logs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupLogHandler(query string) (*services.MockJobs, *services.MockAllocations, *services.MockAllocFS, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	jobs := &services.MockJobs{}
+	allocations := &services.MockAllocations{}
+	fs := &services.MockAllocFS{}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/system/logs?"+query, bytes.NewReader([]byte("")))
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+		Namespace: "default",
+	}}
+
+	jobID := "faas-fn-figlet"
+	jobs.On("Info", jobID, mock.Anything).Return(&api.Job{ID: &jobID}, nil, nil)
+
+	handler := MakeLogHandler(config, jobs, allocations, fs, nil, hclog.Default())
+
+	return jobs, allocations, fs, handler, request, response
+}
+
+func createMockAllocations() []*api.AllocationListStub {
+	tasks := map[string]*api.TaskState{"figlet": {}, "sidecar": {}}
+	return []*api.AllocationListStub{
+		{ID: "aaaaaaaa-1111", ClientStatus: "running", TaskStates: tasks},
+		{ID: "bbbbbbbb-2222", ClientStatus: "running", TaskStates: tasks},
+	}
+}
+
+func logFrames(data string) chan *api.StreamFrame {
+	frames := make(chan *api.StreamFrame, 1)
+	if len(data) != 0 {
+		frames <- &api.StreamFrame{Data: []byte(data)}
+	}
+	close(frames)
+	return frames
+}
+
+func readLogMessages(t *testing.T, recorder *httptest.ResponseRecorder) []logs.Message {
+	var messages []logs.Message
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		msg := logs.Message{}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestLogHandlerReportsErrorWhenNameIsMissing(t *testing.T) {
+	_, _, _, handler, request, recorder := setupLogHandler("")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+}
+
+func TestLogHandlerRejectsSince(t *testing.T) {
+	jobs, _, _, handler, request, recorder := setupLogHandler("name=figlet&since=2021-04-16T10:00:00Z")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "Allocations", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogHandlerReportsNotFoundForUnknownInstance(t *testing.T) {
+	jobs, _, _, handler, request, recorder := setupLogHandler("name=figlet&instance=cccccccc")
+	jobs.On("Allocations", "faas-fn-figlet", false, mock.Anything).Return(createMockAllocations(), nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestLogHandlerReportsNotFoundForUnknownTask(t *testing.T) {
+	jobs, _, _, handler, request, recorder := setupLogHandler("name=figlet&task=unknown")
+	jobs.On("Allocations", "faas-fn-figlet", false, mock.Anything).Return(createMockAllocations(), nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestLogHandlerStreamsLogsOfSelectedInstanceAndTask(t *testing.T) {
+	jobs, allocations, fs, handler, request, recorder := setupLogHandler("name=figlet&instance=bbbbbbbb&task=figlet")
+	alloc := &api.Allocation{ID: "bbbbbbbb-2222"}
+
+	jobs.On("Allocations", "faas-fn-figlet", false, mock.Anything).Return(createMockAllocations(), nil, nil)
+	allocations.On("Info", "bbbbbbbb-2222", mock.Anything).Return(alloc, nil, nil)
+	fs.On("Logs", alloc, false, "figlet", "stdout", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames("line 1\nline 2\n"), make(chan error))
+	fs.On("Logs", alloc, false, "figlet", "stderr", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames(""), make(chan error))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	allocations.AssertNotCalled(t, "Info", "aaaaaaaa-1111", mock.Anything)
+
+	messages := readLogMessages(t, recorder)
+	assert.Equal(t, 2, len(messages))
+	for _, msg := range messages {
+		assert.Equal(t, "figlet", msg.Name)
+		assert.Equal(t, "bbbbbbbb-2222", msg.Instance)
+	}
+	assert.Equal(t, "line 1", messages[0].Text)
+	assert.Equal(t, "line 2", messages[1].Text)
+}
+
+func TestLogHandlerAppliesTail(t *testing.T) {
+	jobs, allocations, fs, handler, request, recorder := setupLogHandler("name=figlet&instance=aaaaaaaa&task=figlet&tail=1")
+	alloc := &api.Allocation{ID: "aaaaaaaa-1111"}
+
+	jobs.On("Allocations", "faas-fn-figlet", false, mock.Anything).Return(createMockAllocations(), nil, nil)
+	allocations.On("Info", "aaaaaaaa-1111", mock.Anything).Return(alloc, nil, nil)
+	fs.On("Logs", alloc, false, "figlet", "stdout", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames("line 1\nline 2\nline 3"), make(chan error))
+	fs.On("Logs", alloc, false, "figlet", "stderr", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames(""), make(chan error))
+
+	handler(recorder, request)
+
+	messages := readLogMessages(t, recorder)
+	assert.Equal(t, 1, len(messages))
+	assert.Equal(t, "line 3", messages[0].Text)
+}
+
+func TestLogHandlerFollowsFromEndOfBackfill(t *testing.T) {
+	jobs, allocations, fs, handler, request, recorder := setupLogHandler("name=figlet&instance=aaaaaaaa&task=figlet&tail=2&follow=true")
+	alloc := &api.Allocation{ID: "aaaaaaaa-1111"}
+	backfill := "line 1\nline 2\nline 3\npart"
+
+	jobs.On("Allocations", "faas-fn-figlet", false, mock.Anything).Return(createMockAllocations(), nil, nil)
+	allocations.On("Info", "aaaaaaaa-1111", mock.Anything).Return(alloc, nil, nil)
+	fs.On("Logs", alloc, false, "figlet", "stdout", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames(backfill), make(chan error))
+	fs.On("Logs", alloc, true, "figlet", "stdout", "start", int64(len(backfill)), mock.Anything, mock.Anything).Return(logFrames("ial 4\nline 5\n"), make(chan error))
+	fs.On("Logs", alloc, false, "figlet", "stderr", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames(""), make(chan error))
+	fs.On("Logs", alloc, true, "figlet", "stderr", "start", int64(0), mock.Anything, mock.Anything).Return(logFrames(""), make(chan error))
+
+	handler(recorder, request)
+
+	var texts []string
+	for _, msg := range readLogMessages(t, recorder) {
+		texts = append(texts, msg.Text)
+	}
+	assert.Equal(t, []string{"line 2", "line 3", "partial 4", "line 5"}, texts)
+	fs.AssertExpectations(t)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/maintenance"
+)
+
+type MaintenanceStatus struct {
+	Draining bool  `json:"draining"`
+	InFlight int64 `json:"inflight"`
+}
+
+type MaintenanceRequest struct {
+	Draining *bool `json:"draining"`
+}
+
+func MakeMaintenanceHandler(mode *maintenance.Mode, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("maintenance_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+
+			draining := !mode.Draining()
+			if len(body) != 0 {
+				req := MaintenanceRequest{}
+				if err := json.Unmarshal(body, &req); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				if req.Draining != nil {
+					draining = *req.Draining
+				}
+			}
+
+			mode.SetDraining(draining)
+			log.Debug("Maintenance mode updated", "draining", draining)
+		}
+
+		statusBytes, _ := json.Marshal(MaintenanceStatus{
+			Draining: mode.Draining(),
+			InFlight: mode.InFlight(),
+		})
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(statusBytes)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/maintenance"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceHandlerTogglesDraining(t *testing.T) {
+	mode := maintenance.NewMode(hclog.Default())
+	handler := MakeMaintenanceHandler(mode, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/maintenance", bytes.NewReader([]byte("")))
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, mode.Draining())
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/system/maintenance", bytes.NewReader([]byte("")))
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, mode.Draining())
+}
+
+func TestMaintenanceHandlerSetsDraining(t *testing.T) {
+	mode := maintenance.NewMode(hclog.Default())
+	handler := MakeMaintenanceHandler(mode, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/system/maintenance", bytes.NewReader([]byte(`{"draining": true}`)))
+	handler(recorder, request)
+
+	status := MaintenanceStatus{}
+	json.Unmarshal(recorder.Body.Bytes(), &status)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, status.Draining)
+	assert.True(t, mode.Draining())
+}
+
+func TestMaintenanceModeRejectsNewInvocations(t *testing.T) {
+	mode := maintenance.NewMode(hclog.Default())
+	mode.SetDraining(true)
+
+	called := false
+	proxy := mode.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/function/figlet", bytes.NewReader([]byte("")))
+	proxy(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+	assert.False(t, called)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const openAPIURLLabel = "com.openfaas.openapi-url"
+
+// FunctionManifest describes a function for API catalogs: its metadata, invocation URL and replica status.
+type FunctionManifest struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	Image             string            `json:"image"`
+	URL               string            `json:"url"`
+	OpenAPIURL        string            `json:"openapiUrl,omitempty"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+	Replicas          uint64            `json:"replicas"`
+	AvailableReplicas uint64            `json:"availableReplicas"`
+	CreatedAt         time.Time         `json:"createdAt"`
+}
+
+// MakeFunctionManifestHandler returns the manifest of a function, composed from its job in Nomad and its instances.
+//
+// The OpenAPI reference is read from the `com.openfaas.openapi-url` label, or annotation, where a path is relative
+// to the invocation URL of the function.
+func MakeFunctionManifestHandler(config *types.ProviderConfig, jobs services.Jobs, resolver resolver.ServiceResolver, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("function_manifest_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+
+		status := createFunctionStatus(job, config.Scheduling.JobPrefix)
+
+		if job.Status != nil && *job.Status == "dead" {
+			status.Replicas = 0
+		} else {
+			instances, err := resolver.ResolveAll(functionName)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				log.Error("Error reading function status", "function", functionName, "namespace", namespace, "error", err.Error())
+				return
+			}
+			status.AvailableReplicas = uint64(len(instances))
+
+			if job.Type != nil && *job.Type == api.JobTypeSystem {
+				status.Replicas = status.AvailableReplicas
+			}
+		}
+
+		manifest := FunctionManifest{
+			Name:              status.Name,
+			Namespace:         namespace,
+			Image:             status.Image,
+			URL:               publicFunctionURL(config, status.Name),
+			Labels:            *status.Labels,
+			Annotations:       *status.Annotations,
+			Replicas:          status.Replicas,
+			AvailableReplicas: status.AvailableReplicas,
+			CreatedAt:         status.CreatedAt,
+		}
+		manifest.OpenAPIURL = openAPIURL(manifest)
+
+		manifestBytes, _ := json.Marshal(manifest)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(manifestBytes)
+
+		log.Trace("Function manifest read successfully", "function", functionName, "namespace", namespace)
+	}
+}
+
+func openAPIURL(manifest FunctionManifest) string {
+	reference, ok := manifest.Labels[openAPIURLLabel]
+	if !ok {
+		reference = manifest.Annotations[openAPIURLLabel]
+	}
+	if strings.HasPrefix(reference, "/") {
+		return manifest.URL + reference
+	}
+	return reference
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createManifestJob(labels map[string]interface{}, meta map[string]string) *api.Job {
+	job := createWarmJob(2, "")
+	job.Meta = meta
+	job.TaskGroups[0].Tasks[0].Config["labels"] = []interface{}{labels}
+	return job
+}
+
+func setupFunctionManifestHandler(name string) (*services.MockJobs, *services.MockResolver, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	config.Gateway.PublicURL = "https://gateway.example.com/"
+	jobs := &services.MockJobs{}
+	resolver := &services.MockResolver{}
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/system/function/"+name+"/manifest", nil), map[string]string{"name": name})
+	return jobs, resolver, MakeFunctionManifestHandler(config, jobs, resolver, hclog.Default()), request, httptest.NewRecorder()
+}
+
+func TestFunctionManifestHandlerComposesManifest(t *testing.T) {
+	jobs, resolver, handler, request, recorder := setupFunctionManifestHandler("echo")
+
+	job := createManifestJob(map[string]interface{}{"team": "payments", openAPIURLLabel: "/openapi.json"}, map[string]string{"owner": "alice"})
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(job, nil, nil)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var manifest FunctionManifest
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &manifest))
+	assert.Equal(t, FunctionManifest{
+		Name:              "echo",
+		Namespace:         "default",
+		Image:             "functions/echo",
+		URL:               "https://gateway.example.com/function/echo",
+		OpenAPIURL:        "https://gateway.example.com/function/echo/openapi.json",
+		Labels:            map[string]string{"team": "payments", openAPIURLLabel: "/openapi.json"},
+		Annotations:       map[string]string{"owner": "alice"},
+		Replicas:          2,
+		AvailableReplicas: 1,
+		CreatedAt:         manifest.CreatedAt,
+	}, manifest)
+	assert.True(t, time.Unix(0, 0).Equal(manifest.CreatedAt))
+}
+
+func TestFunctionManifestHandlerReadsOpenAPIURLFromAnnotations(t *testing.T) {
+	jobs, resolver, handler, request, recorder := setupFunctionManifestHandler("echo.default")
+
+	job := createManifestJob(map[string]interface{}{}, map[string]string{openAPIURLLabel: "https://docs.example.com/echo.yaml"})
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(job, nil, nil)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var manifest FunctionManifest
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &manifest))
+	assert.Equal(t, "https://docs.example.com/echo.yaml", manifest.OpenAPIURL)
+	assert.Equal(t, uint64(0), manifest.AvailableReplicas)
+}
+
+func TestFunctionManifestHandlerReportsNotFoundForUnknownFunction(t *testing.T) {
+	jobs, _, handler, request, recorder := setupFunctionManifestHandler("echo")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// unexpectedResponseRe matches the errors of the Nomad API client for a response with an unexpected status code.
+var unexpectedResponseRe = regexp.MustCompile(`Unexpected response code: (\d{3})(?: \(((?s).*)\))?`)
+
+// classifyNomadError maps an error of the Nomad API client to the status and message reported to the client, so
+// that an unreachable Nomad or a denied request can be told apart from a failure of the function.
+func classifyNomadError(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable, fmt.Sprintf("nomad did not respond in time: %s", err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusServiceUnavailable, fmt.Sprintf("nomad did not respond in time: %s", err)
+	}
+
+	// the transport errors of the client, e.g. a refused connection or a failed dns lookup
+	var urlErr *url.Error
+	var opErr *net.OpError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) {
+		return http.StatusServiceUnavailable, fmt.Sprintf("nomad is unreachable: %s", err)
+	}
+
+	if match := unexpectedResponseRe.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		reason := strings.TrimSpace(match[2])
+		if len(reason) == 0 {
+			reason = http.StatusText(status)
+		}
+
+		switch {
+		case status == http.StatusForbidden:
+			return http.StatusForbidden, fmt.Sprintf("permission denied by nomad: %s", reason)
+		case status == http.StatusNotFound:
+			return http.StatusNotFound, fmt.Sprintf("not found in nomad: %s", reason)
+		case status == http.StatusBadRequest:
+			return http.StatusBadRequest, fmt.Sprintf("rejected by nomad: %s", reason)
+		case strings.Contains(reason, "No cluster leader") || status == http.StatusServiceUnavailable:
+			return http.StatusServiceUnavailable, fmt.Sprintf("nomad is unavailable: %s", reason)
+		}
+	}
+
+	return http.StatusInternalServerError, err.Error()
+}
+
+// writeNomadError writes the error of a Nomad API call with the status of its classification.
+func writeNomadError(w http.ResponseWriter, err error) {
+	status, message := classifyNomadError(err)
+	httputil.Errorf(w, status, "%s", message)
+}
+
+// writeFunctionNotFound reports a function which couldn't be read from Nomad as not found, unless Nomad was
+// unreachable or denied the request, in which case the function may very well exist.
+func writeFunctionNotFound(w http.ResponseWriter, err error, functionName string) {
+	if status, message, ok := lookupFailure(err); ok {
+		httputil.Errorf(w, status, "%s", message)
+		return
+	}
+	httputil.Errorf(w, http.StatusNotFound, "function %s not found", functionName)
+}
+
+// lookupFailure returns the classification of an error reading a function, when Nomad was unreachable or denied
+// the request rather than not finding the function.
+func lookupFailure(err error) (int, string, bool) {
+	if err == nil {
+		return 0, "", false
+	}
+	status, message := classifyNomadError(err)
+	return status, message, status == http.StatusServiceUnavailable || status == http.StatusForbidden
+}
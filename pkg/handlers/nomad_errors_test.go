@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// unreachableNomadError returns the error of the Nomad API client for a Nomad which isn't listening.
+func unreachableNomadError(t *testing.T) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	config := api.DefaultConfig()
+	config.Address = "http://" + address
+	client, err := api.NewClient(config)
+	assert.NoError(t, err)
+
+	_, _, err = client.Jobs().Info("faas-fn-echo", nil)
+	assert.Error(t, err)
+	return err
+}
+
+func TestClassifyNomadError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"connection refused", unreachableNomadError(t), http.StatusServiceUnavailable, "nomad is unreachable: "},
+		{"timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, http.StatusServiceUnavailable, "nomad did not respond in time: "},
+		{"acl denied", fmt.Errorf("Unexpected response code: 403 (Permission denied)"), http.StatusForbidden, "permission denied by nomad: Permission denied"},
+		{"not found", fmt.Errorf("Unexpected response code: 404 (job not found)"), http.StatusNotFound, "not found in nomad: job not found"},
+		{"invalid job", fmt.Errorf("Unexpected response code: 400 (1 error occurred:\n\t* Missing job datacenters\n\n)"), http.StatusBadRequest, "rejected by nomad: 1 error occurred:"},
+		{"no leader", fmt.Errorf("Unexpected response code: 500 (No cluster leader)"), http.StatusServiceUnavailable, "nomad is unavailable: No cluster leader"},
+		{"other", fmt.Errorf("something went wrong"), http.StatusInternalServerError, "something went wrong"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, message := classifyNomadError(tc.err)
+
+			assert.Equal(t, tc.status, status)
+			assert.Contains(t, message, tc.message)
+		})
+	}
+}
+
+func TestDeployHandlerReportsUnreachableNomad(t *testing.T) {
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo"})
+	jobs, handler, request, recorder := setupDeployHandler(body)
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, unreachableNomadError(t))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "nomad is unreachable")
+}
+
+func TestDeleteHandlerReportsDeniedRequest(t *testing.T) {
+	body, _ := json.Marshal(ftypes.DeleteFunctionRequest{FunctionName: "echo"})
+	jobs, handler, request, recorder := setupDeleteHandler(body)
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(&api.Job{}, nil, nil)
+	jobs.On("Deregister", "faas-fn-echo", true, mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 403 (Permission denied)"))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "permission denied by nomad")
+}
+
+func TestReplicaUpdaterReportsUnreachableNomad(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	unreachable := unreachableNomadError(t)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, unreachable)
+	jobs.On("Scale", "faas-fn-echo", "echo", mock.Anything, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, unreachable)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: 3})
+	request := httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaUpdater(config, jobs, nil, nil, nil, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "nomad is unreachable")
+}
+
+func TestReplicaReaderReportsUnreachableNomadInsteadOfNotFound(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, unreachableNomadError(t))
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/system/function/echo", nil), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaReader(config, jobs, &services.MockResolver{}, &services.MockServiceMaintenance{}, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestReplicaReaderReportsMissingFunction(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{}, nil, nil)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/system/function/echo", nil), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaReader(config, jobs, &services.MockResolver{}, &services.MockServiceMaintenance{}, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	prepullLabel = "com.openfaas.prepull"
+
+	// prepullJobPrefix is put in front of the job of the function, so the pre-pull jobs are never listed as functions
+	prepullJobPrefix = "prepull-"
+
+	jobTypeSysBatch = "sysbatch"
+
+	defaultPrepullTimeout = 15 * time.Minute
+)
+
+var (
+	prepullEntrypoint = []string{"/bin/true"}
+
+	prepullLogFiles  = 1
+	prepullLogSizeMB = 1
+)
+
+// ImagePrepuller pulls the image of a function onto all eligible nodes with a system batch job, so the instances
+// added when the function scales up don't have to wait for the image to be pulled.
+//
+// The pre-pull job runs a no-op command in the image of the function and is purged once it has completed on all
+// nodes. Images without /bin/true fail to start the command, but only after the image has been pulled.
+//
+// Note that the docker driver removes unused images after its gc.image_delay, which should therefore be longer
+// than the expected time between the deployment and the scale-ups of a function.
+type ImagePrepuller struct {
+	jobs         services.Jobs
+	timeout      time.Duration
+	pollInterval time.Duration
+	logger       hclog.Logger
+}
+
+func NewImagePrepuller(jobs services.Jobs, logger hclog.Logger) *ImagePrepuller {
+	return &ImagePrepuller{
+		jobs:         jobs,
+		timeout:      defaultPrepullTimeout,
+		pollInterval: 5 * time.Second,
+		logger:       logger.Named("prepull"),
+	}
+}
+
+// Prepull registers the pre-pull job of a function deployed with the `com.openfaas.prepull=true` label, and
+// purges it in the background once it is complete. Failures are logged, but never fail the deployment.
+func (p *ImagePrepuller) Prepull(namespace string, job *api.Job, labels *map[string]string) {
+	if !types.ParseBoolValueFromMap(labels, prepullLabel, false) {
+		return
+	}
+
+	prepull := createPrepullJob(job)
+	if prepull == nil {
+		p.logger.Warn("Skipping image pre-pull, the function is not running with the docker driver", "function", *job.Name, "namespace", namespace)
+		return
+	}
+
+	writeOptions := &api.WriteOptions{Namespace: namespace}
+	response, _, err := p.jobs.RegisterOpts(prepull, &api.RegisterOptions{}, writeOptions)
+	if err != nil {
+		p.logger.Warn("Error registering image pre-pull", "function", *job.Name, "namespace", namespace, "error", err.Error())
+		return
+	}
+
+	var modifyIndex uint64
+	if response != nil {
+		modifyIndex = response.JobModifyIndex
+	}
+
+	go p.cleanup(namespace, *prepull.ID, modifyIndex)
+}
+
+// cleanup purges the pre-pull job once it is complete, or when it didn't complete in time. A pre-pull job which
+// was registered again in the meantime, by a later deployment of the function, is left to that deployment.
+func (p *ImagePrepuller) cleanup(namespace, jobID string, modifyIndex uint64) {
+	deadline := time.Now().Add(p.timeout)
+	options := &api.QueryOptions{Namespace: namespace}
+
+	for {
+		job, _, err := p.jobs.Info(jobID, options)
+		if err != nil {
+			p.logger.Warn("Error reading image pre-pull", "job", jobID, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		if modifyIndex != 0 && job.JobModifyIndex != nil && *job.JobModifyIndex != modifyIndex {
+			return
+		}
+
+		if job.Status != nil && *job.Status == "dead" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			p.logger.Warn("Image pre-pull not complete in time", "job", jobID, "namespace", namespace, "timeout", p.timeout)
+			break
+		}
+
+		time.Sleep(p.pollInterval)
+	}
+
+	if _, _, err := p.jobs.Deregister(jobID, true, &api.WriteOptions{Namespace: namespace}); err != nil {
+		p.logger.Warn("Error purging image pre-pull", "job", jobID, "namespace", namespace, "error", err.Error())
+		return
+	}
+
+	p.logger.Debug("Image pre-pull complete", "job", jobID, "namespace", namespace)
+}
+
+// createPrepullJob creates a system batch job running the image of the function on every node the function can be
+// placed on, or nil when the function isn't running with the docker driver.
+func createPrepullJob(job *api.Job) *api.Job {
+	var image interface{}
+	for _, group := range job.TaskGroups {
+		for _, task := range group.Tasks {
+			if task.Driver == "docker" && task.Lifecycle == nil {
+				image = task.Config["image"]
+			}
+		}
+	}
+	if image == nil {
+		return nil
+	}
+
+	id := fmt.Sprintf("%s%s", prepullJobPrefix, *job.ID)
+	jobType := jobTypeSysBatch
+	attempts, mode := 0, "fail"
+	cpu, memory := 20, 16
+
+	return &api.Job{
+		ID:          &id,
+		Name:        &id,
+		Type:        &jobType,
+		Region:      job.Region,
+		Namespace:   job.Namespace,
+		Priority:    job.Priority,
+		Datacenters: job.Datacenters,
+		Constraints: job.Constraints,
+		TaskGroups: []*api.TaskGroup{
+			{
+				Name: &id,
+				RestartPolicy: &api.RestartPolicy{
+					Attempts: &attempts,
+					Mode:     &mode,
+				},
+				Tasks: []*api.Task{
+					{
+						Name:   "prepull",
+						Driver: "docker",
+						Config: map[string]interface{}{
+							"image":      image,
+							"entrypoint": prepullEntrypoint,
+						},
+						Resources: &api.Resources{
+							CPU:      &cpu,
+							MemoryMB: &memory,
+						},
+						LogConfig: &api.LogConfig{
+							MaxFiles:      &prepullLogFiles,
+							MaxFileSizeMB: &prepullLogSizeMB,
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func isPrepullJob(job *api.Job) bool {
+	return *job.ID == "prepull-faas-fn-echo"
+}
+
+func TestDeployHandlerRegistersPrepullJobWithImageOfFunction(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Datacenters = []string{"dc1", "dc2"}
+	jobs := &services.MockJobs{}
+
+	prepuller := NewImagePrepuller(jobs, hclog.Default())
+	prepuller.pollInterval = time.Millisecond
+
+	var prepull *api.Job
+	jobs.On("RegisterOpts", mock.MatchedBy(isPrepullJob), mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		prepull = args.Get(0).(*api.Job)
+	}).Return(&api.JobRegisterResponse{JobModifyIndex: 7}, nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	labels := map[string]string{prepullLabel: "true", services.ArchsLabel: "amd64"}
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo:1.0", Labels: &labels})
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	dead := "dead"
+	jobs.On("Info", "prepull-faas-fn-echo", mock.Anything).Return(&api.Job{Status: &dead}, nil, nil).Maybe()
+	jobs.On("Deregister", "prepull-faas-fn-echo", true, mock.Anything).Return("", nil, nil).Maybe()
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, prepuller, nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	if assert.NotNil(t, prepull) {
+		assert.Equal(t, jobTypeSysBatch, *prepull.Type)
+		assert.Equal(t, []string{"dc1", "dc2"}, prepull.Datacenters)
+		assert.Contains(t, prepull.Constraints, api.NewConstraint("${attr.cpu.arch}", "=", "amd64"))
+		assert.Len(t, prepull.TaskGroups, 1)
+		assert.Len(t, prepull.TaskGroups[0].Tasks, 1)
+
+		task := prepull.TaskGroups[0].Tasks[0]
+		assert.Equal(t, "docker", task.Driver)
+		assert.Equal(t, "functions/echo:1.0", task.Config["image"])
+		assert.Equal(t, prepullEntrypoint, task.Config["entrypoint"])
+	}
+}
+
+func TestDeployHandlerDoesNotPrepullWithoutLabel(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	body, _ := json.Marshal(ftypes.FunctionDeployment{Service: "echo", Image: "functions/echo"})
+	request := httptest.NewRequest("POST", "/system/functions", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	handler := MakeDeployHandler(config, services.NewJobFactory(config), jobs, &services.MockSecrets{}, nil, nil, nil, nil, nil, NewImagePrepuller(jobs, hclog.Default()), nil, nil, nil, nil, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertNumberOfCalls(t, "RegisterOpts", 1)
+}
+
+func TestCreatePrepullJobSkipsFunctionsWithoutDockerDriver(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	labels := map[string]string{services.DriverLabel: "raw_exec", "com.openfaas.command": "echo"}
+
+	job, err := services.NewJobFactory(config).CreateJob("default", ftypes.FunctionDeployment{Service: "echo", Image: "https://example.com/echo.tar.gz", Labels: &labels})
+
+	assert.NoError(t, err)
+	assert.Nil(t, createPrepullJob(job))
+}
+
+func TestImagePrepullerPurgesCompletedPrepullJob(t *testing.T) {
+	jobs := &services.MockJobs{}
+	prepuller := NewImagePrepuller(jobs, hclog.Default())
+	prepuller.pollInterval = time.Millisecond
+
+	running, dead := "running", "dead"
+	index := uint64(7)
+	jobs.On("Info", "prepull-faas-fn-echo", mock.Anything).Return(&api.Job{Status: &running, JobModifyIndex: &index}, nil, nil).Twice()
+	jobs.On("Info", "prepull-faas-fn-echo", mock.Anything).Return(&api.Job{Status: &dead, JobModifyIndex: &index}, nil, nil)
+	jobs.On("Deregister", "prepull-faas-fn-echo", true, mock.Anything).Return("", nil, nil)
+
+	prepuller.cleanup("default", "prepull-faas-fn-echo", index)
+
+	jobs.AssertNumberOfCalls(t, "Info", 3)
+	jobs.AssertCalled(t, "Deregister", "prepull-faas-fn-echo", true, mock.Anything)
+}
+
+func TestImagePrepullerLeavesPrepullJobOfLaterDeployment(t *testing.T) {
+	jobs := &services.MockJobs{}
+	prepuller := NewImagePrepuller(jobs, hclog.Default())
+	prepuller.pollInterval = time.Millisecond
+
+	running := "running"
+	later := uint64(9)
+	jobs.On("Info", "prepull-faas-fn-echo", mock.Anything).Return(&api.Job{Status: &running, JobModifyIndex: &later}, nil, nil)
+
+	prepuller.cleanup("default", "prepull-faas-fn-echo", 7)
+
+	jobs.AssertNotCalled(t, "Deregister", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/logbuffer"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// MakeProviderLogsHandler returns the recent log lines of the provider itself, optionally filtered by
+// a minimum `level` and limited to the last `tail` lines.
+func MakeProviderLogsHandler(buffer *logbuffer.Buffer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		level := hclog.Trace
+		if value := query.Get("level"); value != "" {
+			level = hclog.LevelFromString(value)
+			if level == hclog.NoLevel {
+				httputil.Errorf(w, http.StatusBadRequest, "invalid log level %s", value)
+				return
+			}
+		}
+
+		entries := buffer.Entries(level)
+
+		if value := query.Get("tail"); value != "" {
+			tail, err := strconv.Atoi(value)
+			if err != nil || tail < 0 {
+				httputil.Errorf(w, http.StatusBadRequest, "invalid tail %s", value)
+				return
+			}
+			if len(entries) > tail {
+				entries = entries[len(entries)-tail:]
+			}
+		}
+
+		entriesBytes, _ := json.Marshal(entries)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(entriesBytes)
+	}
+}
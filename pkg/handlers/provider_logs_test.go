@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/logbuffer"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupProviderLogs(t *testing.T) *logbuffer.Buffer {
+	buffer := logbuffer.NewBuffer(10, hclog.Trace)
+	buffer.Accept("faas-nomad", hclog.Debug, "resolving")
+	buffer.Accept("faas-nomad", hclog.Info, "deployed", "function", "echo")
+	buffer.Accept("faas-nomad", hclog.Error, "failed")
+
+	assert.Eventually(t, func() bool {
+		return len(buffer.Entries(hclog.Trace)) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	return buffer
+}
+
+func TestProviderLogsHandlerFiltersOnLevel(t *testing.T) {
+	handler := MakeProviderLogsHandler(setupProviderLogs(t))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/system/provider/logs?level=info", nil))
+
+	var entries []logbuffer.Entry
+	_ = json.Unmarshal(recorder.Body.Bytes(), &entries)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, TypeApplicationJson, recorder.Header().Get(HeaderContentType))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "deployed", entries[0].Message)
+	assert.Equal(t, map[string]string{"function": "echo"}, entries[0].Args)
+}
+
+func TestProviderLogsHandlerWithTail(t *testing.T) {
+	handler := MakeProviderLogsHandler(setupProviderLogs(t))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/system/provider/logs?tail=1", nil))
+
+	var entries []logbuffer.Entry
+	_ = json.Unmarshal(recorder.Body.Bytes(), &entries)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "failed", entries[0].Message)
+}
+
+func TestProviderLogsHandlerReportsErrorWhenQueryIsInvalid(t *testing.T) {
+	handler := MakeProviderLogsHandler(setupProviderLogs(t))
+
+	for _, query := range []string{"level=verbose", "tail=abc", "tail=-1"} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", "/system/provider/logs?"+query, nil))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// QuotaExceeded is returned when a deployment would exceed the quota of its namespace.
+type QuotaExceeded struct {
+	Namespace string
+	Quota     types.QuotaConfig
+	Used      resourceUsage
+	Requested resourceUsage
+}
+
+func (e *QuotaExceeded) Error() string {
+	return fmt.Sprintf("deployment exceeds the quota of namespace %s: cpu %d MHz in use, %d MHz requested, %s; memory %d MB in use, %d MB requested, %s",
+		e.Namespace,
+		e.Used.CPU, e.Requested.CPU, formatLimit(e.Quota.CPU),
+		e.Used.Memory, e.Requested.Memory, formatLimit(e.Quota.Memory))
+}
+
+type resourceUsage struct {
+	CPU    int
+	Memory int
+}
+
+func (u *resourceUsage) add(job *api.Job, count *int) {
+	for _, group := range job.TaskGroups {
+		// the count of the group is unknown for system jobs, which are counted as a single instance
+		replicas := 1
+		if count != nil {
+			replicas = *count
+		} else if group.Count != nil {
+			replicas = *group.Count
+		}
+
+		for _, task := range group.Tasks {
+			if task.Resources == nil {
+				continue
+			}
+			if task.Resources.CPU != nil {
+				u.CPU += replicas * *task.Resources.CPU
+			}
+			if task.Resources.MemoryMB != nil {
+				u.Memory += replicas * *task.Resources.MemoryMB
+			}
+		}
+	}
+}
+
+// addAllocation adds the resources allocated to the tasks of an allocation.
+func (u *resourceUsage) addAllocation(alloc *api.AllocationListStub) {
+	if alloc.AllocatedResources == nil {
+		return
+	}
+	for _, task := range alloc.AllocatedResources.Tasks {
+		u.CPU += int(task.Cpu.CpuShares)
+		u.Memory += int(task.Memory.MemoryMB)
+	}
+}
+
+// QuotaChecker verifies the resources reserved by the functions of a namespace stay within the quota of the
+// namespace, when a function is deployed or scaled up.
+//
+// The resources in use are the resources allocated to the running and pending instances of the functions, read
+// from a single list of the allocations of the namespace, so a system job counts an instance on every node it
+// runs on. Stopped functions, e.g. soft deleted functions, don't count towards the quota.
+type QuotaChecker struct {
+	config      *types.ProviderConfig
+	jobs        services.Jobs
+	allocations services.Allocations
+	nodes       services.Nodes
+}
+
+func NewQuotaChecker(config *types.ProviderConfig, jobs services.Jobs, allocations services.Allocations, nodes services.Nodes) *QuotaChecker {
+	return &QuotaChecker{config: config, jobs: jobs, allocations: allocations, nodes: nodes}
+}
+
+// Check verifies the resources of the functions of the namespace, with the job replacing the current version of
+// the function, stay within the quota of the namespace. Without a count, the requested resources of an existing
+// function are based on its current instances, as counts are preserved when a function is updated, and those of
+// a system job on the nodes eligible to run it.
+func (q *QuotaChecker) Check(namespace string, job *api.Job, count *int) error {
+	if q == nil {
+		return nil
+	}
+	quota, ok := q.config.Scheduling.Quotas[namespace]
+	if !ok || (quota.CPU <= 0 && quota.Memory <= 0) {
+		return nil
+	}
+
+	options := &api.QueryOptions{Namespace: namespace}
+
+	list, err := services.ListFunctionJobs(q.jobs, q.config.Scheduling.JobPrefix, options)
+	if err != nil {
+		return err
+	}
+
+	functions := map[string]bool{}
+	exists := false
+	for _, stub := range list {
+		if stub.ID == *job.ID {
+			exists = true
+			continue
+		}
+		if !stub.Stop {
+			functions[stub.ID] = true
+		}
+	}
+
+	allocs, _, err := q.allocations.List(&api.QueryOptions{Namespace: namespace, Params: map[string]string{"resources": "true"}})
+	if err != nil {
+		return err
+	}
+
+	used := resourceUsage{}
+	instances := 0
+	for _, alloc := range allocs {
+		if alloc.DesiredStatus != api.AllocDesiredStatusRun || (alloc.ClientStatus != api.AllocClientStatusPending && alloc.ClientStatus != api.AllocClientStatusRunning) {
+			continue
+		}
+		if alloc.JobID == *job.ID {
+			instances++
+			continue
+		}
+		if functions[alloc.JobID] {
+			used.addAllocation(alloc)
+		}
+	}
+
+	replicas, err := q.replicas(namespace, job, count, exists, instances)
+	if err != nil {
+		return err
+	}
+
+	requested := resourceUsage{}
+	requested.add(job, &replicas)
+
+	if exceeds(quota.CPU, used.CPU+requested.CPU) || exceeds(quota.Memory, used.Memory+requested.Memory) {
+		return &QuotaExceeded{Namespace: namespace, Quota: quota, Used: used, Requested: requested}
+	}
+	return nil
+}
+
+// replicas returns the instances of the job for which resources are requested.
+func (q *QuotaChecker) replicas(namespace string, job *api.Job, count *int, exists bool, instances int) (int, error) {
+	if job.Type != nil && *job.Type == api.JobTypeSystem {
+		return q.eligibleNodes(namespace, job)
+	}
+	if count != nil {
+		return *count, nil
+	}
+	if exists {
+		return instances, nil
+	}
+	if len(job.TaskGroups) != 0 && job.TaskGroups[0].Count != nil {
+		return *job.TaskGroups[0].Count, nil
+	}
+	return 1, nil
+}
+
+// eligibleNodes counts the ready nodes eligible for scheduling in the datacenters of a job, on which a system job
+// runs an instance each.
+func (q *QuotaChecker) eligibleNodes(namespace string, job *api.Job) (int, error) {
+	nodes, _, err := q.nodes.List(&api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return 0, err
+	}
+
+	eligible := 0
+	for _, node := range nodes {
+		if node.Status != "ready" || node.SchedulingEligibility != api.NodeSchedulingEligible {
+			continue
+		}
+		if inDatacenters(job, node.Datacenter) {
+			eligible++
+		}
+	}
+	return eligible, nil
+}
+
+func inDatacenters(job *api.Job, datacenter string) bool {
+	if len(job.Datacenters) == 0 {
+		return true
+	}
+	for _, dc := range job.Datacenters {
+		if dc == datacenter {
+			return true
+		}
+	}
+	return false
+}
+
+func exceeds(limit, value int) bool {
+	return limit > 0 && value > limit
+}
+
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("limit %d", limit)
+}
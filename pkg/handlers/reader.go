@@ -11,27 +11,24 @@ import (
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
-func MakeFunctionReader(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
+func MakeFunctionReader(config *types.ProviderConfig, jobs services.Jobs, blueGreen *BlueGreenDeployments, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
 	log := logger.Named("function_reader")
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		namespace := config.Scheduling.Namespace
 
-		options := &api.QueryOptions{
-			Namespace: namespace,
-			Prefix:    config.Scheduling.JobPrefix,
-		}
+		options := &api.QueryOptions{Namespace: namespace}
 
-		list, _, err := jobs.List(options)
+		list, err := services.ListFunctionJobs(jobs, config.Scheduling.JobPrefix, options)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
+			writeNomadError(w, err)
 			log.Error("Error listing functions", "namespace", namespace, "error", err.Error())
 			return
 		}
 
-		functions, err := getFunctions(config, jobs, list, options)
+		functions, err := getFunctions(config, jobs, blueGreen, list, options)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
+			writeNomadError(w, err)
 			log.Error("Error listing functions", "namespace", namespace, "error", err.Error())
 			return
 		}
@@ -45,7 +42,7 @@ func MakeFunctionReader(config *types.ProviderConfig, jobs services.Jobs, logger
 	}
 }
 
-func getFunctions(config *types.ProviderConfig, client services.Jobs, jobs []*api.JobListStub, options *api.QueryOptions) ([]ftypes.FunctionStatus, error) {
+func getFunctions(config *types.ProviderConfig, client services.Jobs, blueGreen *BlueGreenDeployments, jobs []*api.JobListStub, options *api.QueryOptions) ([]ftypes.FunctionStatus, error) {
 	functions := make([]ftypes.FunctionStatus, 0)
 	for _, j := range jobs {
 		job, _, err := client.Info(j.ID, options)
@@ -53,7 +50,16 @@ func getFunctions(config *types.ProviderConfig, client services.Jobs, jobs []*ap
 			return functions, err
 		}
 
-		functions = append(functions, createFunctionStatus(job, config.Scheduling.JobPrefix))
+		status := createFunctionStatus(job, config.Scheduling.JobPrefix)
+
+		// a function deployed blue/green is listed once, by the job of its active colour
+		name, active := blueGreen.Listed(status.Name, services.JobLabels(job))
+		if !active {
+			continue
+		}
+		status.Name = name
+
+		functions = append(functions, status)
 	}
 	return functions, nil
 }
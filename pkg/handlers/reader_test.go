@@ -55,7 +55,7 @@ func setupFunctionReader() (*services.MockJobs, http.HandlerFunc, *http.Request,
 		JobPrefix: "faas-fn-",
 	}}
 
-	handler := MakeFunctionReader(config, jobs, hclog.Default())
+	handler := MakeFunctionReader(config, jobs, nil, hclog.Default())
 
 	return jobs, handler, request, response
 }
@@ -73,7 +73,7 @@ func TestFunctionReaderReportsErrorWhenListingJobsFails(t *testing.T) {
 func TestFunctionReaderReportsErrorWhenGettingJobInfoFails(t *testing.T) {
 	jobs, functionReader, request, recorder := setupFunctionReader()
 
-	jobList := []*api.JobListStub{{ID: "123", Status: "running"}}
+	jobList := []*api.JobListStub{{ID: "123", Name: "faas-fn-123", Status: "running"}}
 
 	jobs.On("List", mock.Anything).Return(jobList, nil, nil)
 	jobs.On("Info", "123", mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
@@ -92,9 +92,9 @@ func TestFunctionReaderReportsRunningFunctions(t *testing.T) {
 	job3 := createMockJob("8929", "pending")
 
 	d := make([]*api.JobListStub, 0)
-	d = append(d, &api.JobListStub{ID: *job1.ID, Status: *job1.Status})
-	d = append(d, &api.JobListStub{ID: *job2.ID, Status: *job2.Status})
-	d = append(d, &api.JobListStub{ID: *job3.ID, Status: *job3.Status})
+	d = append(d, &api.JobListStub{ID: *job1.ID, Name: *job1.Name, Status: *job1.Status})
+	d = append(d, &api.JobListStub{ID: *job2.ID, Name: *job2.Name, Status: *job2.Status})
+	d = append(d, &api.JobListStub{ID: *job3.ID, Name: *job3.Name, Status: *job3.Status})
 
 	jobs.On("List", mock.Anything).Return(d, nil, nil)
 	jobs.On("Info", *job1.ID, mock.Anything).Return(job1, nil, nil)
@@ -112,3 +112,41 @@ func TestFunctionReaderReportsRunningFunctions(t *testing.T) {
 
 	assert.Equal(t, 3, len(funcs))
 }
+
+func TestFunctionReaderReportsFunctionsWithoutDockerDriver(t *testing.T) {
+	jobs, functionReader, request, recorder := setupFunctionReader()
+
+	job := createMockJob("1234", "running")
+	job.TaskGroups[0].Tasks[0].Driver = "exec"
+	job.TaskGroups[0].Tasks[0].Config = map[string]interface{}{"command": "local/echo"}
+	job.TaskGroups[0].Tasks[0].Meta = map[string]string{"com.openfaas.driver": "exec", "label": "test"}
+
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{{ID: *job.ID, Name: *job.Name, Status: *job.Status}}, nil, nil)
+	jobs.On("Info", *job.ID, mock.Anything).Return(job, nil, nil)
+
+	functionReader(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	funcs := make([]ftypes.FunctionStatus, 0)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &funcs))
+	assert.Equal(t, 1, len(funcs))
+	assert.Empty(t, funcs[0].Image)
+	assert.Equal(t, map[string]string{"com.openfaas.driver": "exec", "label": "test"}, *funcs[0].Labels)
+}
+
+func TestFunctionReaderOmitsCostAttributionMeta(t *testing.T) {
+	jobs, functionReader, request, recorder := setupFunctionReader()
+
+	job := createMockJob("1234", "running")
+	job.Meta[services.CostMetaKey("team")] = "finance"
+
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{{ID: *job.ID, Name: *job.Name, Status: *job.Status}}, nil, nil)
+	jobs.On("Info", *job.ID, mock.Anything).Return(job, nil, nil)
+
+	functionReader(recorder, request)
+
+	funcs := make([]ftypes.FunctionStatus, 0)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &funcs))
+	assert.Equal(t, 1, len(funcs))
+	assert.Equal(t, map[string]string{"topic": "test"}, *funcs[0].Annotations)
+}
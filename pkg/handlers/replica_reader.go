@@ -2,37 +2,74 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/jsiebens/faas-nomad/pkg/resolver"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/nomad/api"
 	"github.com/jsiebens/faas-nomad/pkg/services"
 	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
 )
 
-func MakeReplicaReader(config *types.ProviderConfig, client services.Jobs, resolver resolver.ServiceResolver, logger hclog.Logger) http.HandlerFunc {
+// imagePullMessage is the message of the event of the docker driver when it starts to pull the image of a task,
+// which isn't emitted when the image is already present on the node.
+const imagePullMessage = "Downloading image"
+
+var imageDigestRe = regexp.MustCompile(`sha256:[a-f0-9]{64}`)
+
+// FunctionInstance is the placement and health of an instance of a function, reported by the replica reader
+// with the verbose query parameter.
+//
+// The image is only reported for the instances of the current version of the function. Its digest is known when the
+// image is pinned by digest, or reported by the events of the task, and its pull time when the image was pulled by
+// the node rather than already present.
+type FunctionInstance struct {
+	AllocationID  string     `json:"allocationId"`
+	NodeID        string     `json:"nodeId"`
+	NodeName      string     `json:"nodeName"`
+	Datacenter    string     `json:"datacenter,omitempty"`
+	Status        string     `json:"status"`
+	Healthy       bool       `json:"healthy"`
+	Drained       bool       `json:"drained,omitempty"`
+	Image         string     `json:"image,omitempty"`
+	ImageDigest   string     `json:"imageDigest,omitempty"`
+	ImagePulledAt *time.Time `json:"imagePulledAt,omitempty"`
+}
+
+type FunctionStatusVerbose struct {
+	ftypes.FunctionStatus
+	Instances []FunctionInstance `json:"instances"`
+}
+
+// MakeReplicaReader reads the status of a function. With `?verbose=true`, the status includes the placement and
+// image of every instance, from the allocations of the function in Nomad, and its health in Consul.
+func MakeReplicaReader(config *types.ProviderConfig, client services.Jobs, resolver resolver.ServiceResolver, instances services.ServiceMaintenance, blueGreen *BlueGreenDeployments, logger hclog.Logger) http.HandlerFunc {
 	log := logger.Named("replica_reader")
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		functionName := vars["name"]
+		name := vars["name"]
 		namespace := config.Scheduling.Namespace
 
-		options := &api.QueryOptions{
-			Namespace: namespace,
-		}
+		// the status of a function deployed blue/green is the status of its active colour
+		functionName := blueGreen.Resolve(name)
 
-		job, _, err := client.Info(fmt.Sprintf("%s%s", config.Scheduling.JobPrefix, functionName), options)
+		job, err := readFunctionJob(config, client, functionName)
 
 		if job == nil || err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			writeFunctionNotFound(w, err, functionName)
 			return
 		}
 
 		status := createFunctionStatus(job, config.Scheduling.JobPrefix)
+		status.Name = name
 
 		if job.Status != nil && *job.Status == "dead" {
 			status.Replicas = 0
@@ -45,9 +82,26 @@ func MakeReplicaReader(config *types.ProviderConfig, client services.Jobs, resol
 				return
 			}
 			status.AvailableReplicas = uint64(len(availableReplicas))
+
+			// system jobs run an instance on every eligible node instead of a fixed count
+			if job.Type != nil && *job.Type == api.JobTypeSystem {
+				status.Replicas = status.AvailableReplicas
+			}
+		}
+
+		var statusBytes []byte
+		if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose {
+			functionInstances, err := readFunctionInstances(config, client, instances, job, functionName)
+			if err != nil {
+				writeNomadError(w, err)
+				log.Error("Error reading function instances", "function", functionName, "namespace", namespace, "error", err.Error())
+				return
+			}
+			statusBytes, _ = json.Marshal(FunctionStatusVerbose{FunctionStatus: status, Instances: functionInstances})
+		} else {
+			statusBytes, _ = json.Marshal(status)
 		}
 
-		statusBytes, _ := json.Marshal(status)
 		w.Header().Set(HeaderContentType, TypeApplicationJson)
 		w.WriteHeader(http.StatusOK)
 		w.Write(statusBytes)
@@ -56,3 +110,108 @@ func MakeReplicaReader(config *types.ProviderConfig, client services.Jobs, resol
 	}
 
 }
+
+// readFunctionInstances joins the running allocations of a function with the instances of its service in Consul,
+// where the id of the service registered by Nomad contains the id of the allocation.
+func readFunctionInstances(config *types.ProviderConfig, client services.Jobs, instances services.ServiceMaintenance, job *api.Job, functionName string) ([]FunctionInstance, error) {
+	namespace := config.Scheduling.Namespace
+
+	allocations, _, err := client.Allocations(*job.ID, false, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := config.Scheduling.ServiceName.Render(config.Scheduling.JobPrefix, functionName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceInstances, err := instances.Instances(service)
+	if err != nil {
+		return nil, err
+	}
+
+	// the function task is the first task of the group, the image of which is the image of the current version
+	task := job.TaskGroups[0].Tasks[0]
+	image, _ := task.Config["image"].(string)
+
+	result := []FunctionInstance{}
+	for _, allocation := range allocations {
+		if allocation.ClientStatus != api.AllocClientStatusRunning && allocation.ClientStatus != api.AllocClientStatusPending {
+			continue
+		}
+
+		instance := FunctionInstance{
+			AllocationID: allocation.ID,
+			NodeID:       allocation.NodeID,
+			NodeName:     allocation.NodeName,
+			Status:       allocation.ClientStatus,
+		}
+		for _, serviceInstance := range serviceInstances {
+			if strings.Contains(serviceInstance.ID, allocation.ID) {
+				instance.Datacenter = serviceInstance.Datacenter
+				instance.Healthy = serviceInstance.Healthy && !serviceInstance.Drained
+				instance.Drained = serviceInstance.Drained
+				break
+			}
+		}
+		if job.Version == nil || allocation.JobVersion == *job.Version {
+			instance.Image = image
+		}
+		instance.ImageDigest, instance.ImagePulledAt = readImagePull(instance.Image, allocation.TaskStates[task.Name])
+		result = append(result, instance)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].NodeName != result[j].NodeName {
+			return result[i].NodeName < result[j].NodeName
+		}
+		return result[i].AllocationID < result[j].AllocationID
+	})
+
+	return result, nil
+}
+
+// readImagePull returns the digest of the image of a task, from the image itself when pinned by digest, or
+// otherwise from the latest event of the task mentioning a digest, and the time the image was last pulled.
+func readImagePull(image string, state *api.TaskState) (string, *time.Time) {
+	digest := ""
+	if i := strings.Index(image, "@"); i != -1 && imageDigestRe.MatchString(image[i+1:]) {
+		digest = image[i+1:]
+	}
+	if state == nil {
+		return digest, nil
+	}
+
+	var pulledAt *time.Time
+	for i := len(state.Events) - 1; i >= 0; i-- {
+		event := state.Events[i]
+		if len(digest) == 0 {
+			digest = eventDigest(event)
+		}
+		if pulledAt == nil && event.Type == api.TaskDriverMessage && strings.HasPrefix(eventMessage(event), imagePullMessage) {
+			t := time.Unix(0, event.Time)
+			pulledAt = &t
+		}
+	}
+	return digest, pulledAt
+}
+
+func eventDigest(event *api.TaskEvent) string {
+	if digest := imageDigestRe.FindString(eventMessage(event)); len(digest) != 0 {
+		return digest
+	}
+	for _, value := range event.Details {
+		if digest := imageDigestRe.FindString(value); len(digest) != 0 {
+			return digest
+		}
+	}
+	return ""
+}
+
+func eventMessage(event *api.TaskEvent) string {
+	if len(event.DisplayMessage) != 0 {
+		return event.DisplayMessage
+	}
+	return event.DriverMessage
+}
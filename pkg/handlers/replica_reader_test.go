@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupReplicaReader(target string) (*services.MockJobs, *services.MockServiceMaintenance, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	instances := &services.MockServiceMaintenance{}
+
+	resolver := &services.MockResolver{}
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}}, nil)
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createWarmJob(2, ""), nil, nil)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", target, nil), map[string]string{"name": "echo"})
+	return jobs, instances, MakeReplicaReader(config, jobs, resolver, instances, nil, hclog.Default()), request, httptest.NewRecorder()
+}
+
+func TestReplicaReaderReportsInstancesWhenVerbose(t *testing.T) {
+	jobs, instances, handler, request, recorder := setupReplicaReader("/system/function/echo?verbose=true")
+
+	jobs.On("Allocations", "faas-fn-echo", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "b2c3", NodeID: "node-2", NodeName: "worker-2", ClientStatus: api.AllocClientStatusRunning},
+		{ID: "a1b2", NodeID: "node-1", NodeName: "worker-1", ClientStatus: api.AllocClientStatusRunning},
+		{ID: "c3d4", NodeID: "node-1", NodeName: "worker-1", ClientStatus: api.AllocClientStatusComplete},
+	}, nil, nil)
+	instances.On("Instances", mock.Anything).Return([]services.ServiceInstance{
+		{ID: "_nomad-task-a1b2-echo-faas-fn-echo-http", Node: "worker-1", Datacenter: "dc1", Healthy: true},
+		{ID: "_nomad-task-b2c3-echo-faas-fn-echo-http", Node: "worker-2", Datacenter: "dc2", Healthy: false},
+	}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var status FunctionStatusVerbose
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, "echo", status.Name)
+	assert.Equal(t, uint64(2), status.AvailableReplicas)
+	assert.Equal(t, []FunctionInstance{
+		{AllocationID: "a1b2", NodeID: "node-1", NodeName: "worker-1", Datacenter: "dc1", Status: "running", Healthy: true, Image: "functions/echo"},
+		{AllocationID: "b2c3", NodeID: "node-2", NodeName: "worker-2", Datacenter: "dc2", Status: "running", Healthy: false, Image: "functions/echo"},
+	}, status.Instances)
+}
+
+func TestReplicaReaderReportsImageDigestWhenVerbose(t *testing.T) {
+	jobs, instances, handler, request, recorder := setupReplicaReader("/system/function/echo?verbose=true")
+
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	pulledAt := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	version := uint64(3)
+
+	jobs.ExpectedCalls = nil
+	job := createWarmJob(2, "")
+	job.Version = &version
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(job, nil, nil)
+	jobs.On("Allocations", "faas-fn-echo", false, mock.Anything).Return([]*api.AllocationListStub{
+		{ID: "a1b2", NodeID: "node-1", NodeName: "worker-1", ClientStatus: api.AllocClientStatusRunning, JobVersion: 3, TaskStates: map[string]*api.TaskState{
+			"echo": {Events: []*api.TaskEvent{
+				{Type: api.TaskReceived, Time: pulledAt.Add(-time.Second).UnixNano()},
+				{Type: api.TaskDriverMessage, Time: pulledAt.UnixNano(), DisplayMessage: "Downloading image"},
+				{Type: api.TaskDriverMessage, Time: pulledAt.Add(time.Second).UnixNano(), Details: map[string]string{"image": "functions/echo@" + digest}},
+				{Type: api.TaskStarted, Time: pulledAt.Add(2 * time.Second).UnixNano()},
+			}},
+		}},
+		{ID: "b2c3", NodeID: "node-2", NodeName: "worker-2", ClientStatus: api.AllocClientStatusRunning, JobVersion: 2, TaskStates: map[string]*api.TaskState{
+			"echo": {Events: []*api.TaskEvent{{Type: api.TaskStarted, Time: pulledAt.UnixNano()}}},
+		}},
+	}, nil, nil)
+	instances.On("Instances", mock.Anything).Return([]services.ServiceInstance{}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var status FunctionStatusVerbose
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Len(t, status.Instances, 2)
+
+	assert.Equal(t, "functions/echo", status.Instances[0].Image)
+	assert.Equal(t, digest, status.Instances[0].ImageDigest)
+	if assert.NotNil(t, status.Instances[0].ImagePulledAt) {
+		assert.True(t, pulledAt.Equal(*status.Instances[0].ImagePulledAt))
+	}
+
+	// the instance of a previous version reports neither the image of the current version, nor a pull of a cached image
+	assert.Empty(t, status.Instances[1].Image)
+	assert.Empty(t, status.Instances[1].ImageDigest)
+	assert.Nil(t, status.Instances[1].ImagePulledAt)
+}
+
+func TestReadImagePullUsesPinnedDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0f", 32)
+
+	imageDigest, pulledAt := readImagePull("functions/echo@"+digest, nil)
+
+	assert.Equal(t, digest, imageDigest)
+	assert.Nil(t, pulledAt)
+}
+
+func TestReplicaReaderOmitsInstancesByDefault(t *testing.T) {
+	jobs, instances, handler, request, recorder := setupReplicaReader("/system/function/echo")
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "instances")
+	jobs.AssertNotCalled(t, "Allocations", mock.Anything, mock.Anything, mock.Anything)
+	instances.AssertNotCalled(t, "Instances", mock.Anything)
+}
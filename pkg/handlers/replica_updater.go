@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,7 +14,7 @@ import (
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
-func MakeReplicaUpdater(config *types.ProviderConfig, client services.Jobs, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
+func MakeReplicaUpdater(config *types.ProviderConfig, client services.Jobs, limiter *ScaleLimiter, pool *WarmPool, blueGreen *BlueGreenDeployments, quota *QuotaChecker, logger hclog.Logger) func(w http.ResponseWriter, r *http.Request) {
 	log := logger.Named("replica_updater")
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -29,23 +30,114 @@ func MakeReplicaUpdater(config *types.ProviderConfig, client services.Jobs, logg
 			return
 		}
 
-		options := &api.WriteOptions{
-			Namespace: namespace,
-		}
-
-		replicas := int(req.Replicas)
-		msg := "submitted using the faas-nomad provider"
-
-		jobID := fmt.Sprintf("%s%s", config.Scheduling.JobPrefix, req.ServiceName)
-		_, _, err = client.Scale(jobID, req.ServiceName, &replicas, msg, false, nil, options)
+		_, note, status, err := scaleFunction(config, client, limiter, pool, blueGreen, quota, req.ServiceName, int(req.Replicas), false)
 
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
+			writeError(w, status, err)
 			log.Error("Error scaling function", "function", req.ServiceName, "namespace", namespace, "error", err.Error())
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
+		if len(note) != 0 {
+			w.Write([]byte(note))
+		}
 		log.Debug("Function scaled successfully", "function", req.ServiceName, "namespace", namespace)
 	}
 }
+
+// scaleFunction scales the job of a function, returning the applied replicas or the status code matching the error.
+// When clamp is set, the replicas are clamped to the com.openfaas.scale.min and com.openfaas.scale.max labels of the function.
+// When a limiter is given, the rate of change of the replicas is limited as well, with a note when the replicas were limited.
+//
+// The replicas exclude the warm replicas of the function, which are added to the count of the job, and put in
+// rotation by the warm pool, if given, when scaling up. Scaling up is checked against the quota of the namespace.
+func scaleFunction(config *types.ProviderConfig, client services.Jobs, limiter *ScaleLimiter, pool *WarmPool, blueGreen *BlueGreenDeployments, quota *QuotaChecker, functionName string, replicas int, clamp bool) (int, string, int, error) {
+	namespace := config.Scheduling.Namespace
+
+	// a function deployed blue/green is scaled by the job of its active colour
+	functionName = blueGreen.Resolve(functionName)
+	jobID := fmt.Sprintf("%s%s", config.Scheduling.JobPrefix, functionName)
+
+	job, err := readFunctionJob(config, client, functionName)
+	if err == nil && job != nil && job.ID != nil {
+		jobID = *job.ID
+	}
+	if clamp && (err != nil || job == nil) {
+		if status, message, ok := lookupFailure(err); ok {
+			return 0, "", status, errors.New(message)
+		}
+		return 0, "", http.StatusNotFound, fmt.Errorf("function %s not found", functionName)
+	}
+
+	note := ""
+	release := func() {}
+	warm := 0
+
+	if err == nil && job != nil {
+		if job.Type != nil && *job.Type == api.JobTypeSystem {
+			return 0, "", http.StatusBadRequest, fmt.Errorf("function %s runs as a system job and can't be scaled", functionName)
+		}
+		warm = services.WarmReplicas(services.JobLabels(job))
+		if clamp {
+			replicas = clampReplicas(job, replicas)
+		}
+		if current, ok := currentReplicas(job); ok && limiter != nil {
+			current -= warm
+			var status int
+			replicas, note, release, status, err = limiter.limit(functionName, services.JobLabels(job), current, replicas)
+			if err != nil {
+				return 0, "", status, err
+			}
+		}
+	}
+
+	count := replicas + warm
+
+	// scaling up reserves the resources of the added instances, which have to fit in the quota of the namespace
+	if job != nil && err == nil {
+		if current, ok := currentReplicas(job); ok && count > current {
+			if err := quota.Check(namespace, job, &count); err != nil {
+				release()
+				if exceeded, ok := err.(*QuotaExceeded); ok {
+					return 0, "", http.StatusForbidden, exceeded
+				}
+				status, message := classifyNomadError(err)
+				return 0, "", status, errors.New(message)
+			}
+		}
+	}
+
+	msg := "submitted using the faas-nomad provider"
+	_, _, err = client.Scale(jobID, functionName, &count, msg, false, nil, &api.WriteOptions{Namespace: namespace})
+	if err != nil {
+		release()
+		status, message := classifyNomadError(err)
+		return 0, "", status, errors.New(message)
+	}
+
+	if pool != nil && warm > 0 {
+		pool.Scaled(job, count)
+	}
+
+	return replicas, note, http.StatusOK, nil
+}
+
+func currentReplicas(job *api.Job) (int, bool) {
+	if len(job.TaskGroups) == 0 || job.TaskGroups[0].Count == nil {
+		return 0, false
+	}
+	return *job.TaskGroups[0].Count, true
+}
+
+func clampReplicas(job *api.Job, replicas int) int {
+	labels := services.JobLabels(job)
+
+	if min := types.ParseIntValueFromMap(&labels, "com.openfaas.scale.min", 0); replicas < min {
+		replicas = min
+	}
+	if max := types.ParseIntValueFromMap(&labels, "com.openfaas.scale.max", 0); max > 0 && replicas > max {
+		replicas = max
+	}
+	return replicas
+}
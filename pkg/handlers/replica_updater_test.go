@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupReplicaUpdater(jobType string) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: 3})
+	request := httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(&api.Job{Type: &jobType}, nil, nil)
+
+	return jobs, MakeReplicaUpdater(config, jobs, NewScaleLimiter(), nil, nil, nil, hclog.Default()), request, response
+}
+
+func TestReplicaUpdaterScalesServiceJob(t *testing.T) {
+	jobs, handler, request, recorder := setupReplicaUpdater(api.JobTypeService)
+
+	replicas := 3
+	jobs.On("Scale", "faas-fn-echo", "echo", &replicas, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertExpectations(t)
+}
+
+func TestReplicaUpdaterReportsErrorForSystemJob(t *testing.T) {
+	jobs, handler, request, recorder := setupReplicaUpdater(api.JobTypeSystem)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReplicaUpdaterReportsErrorWhenScaleUpExceedsQuota(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {CPU: 1000}}
+
+	jobs := &services.MockJobs{}
+	_, _, quota := setupQuotaChecker(config, jobs)
+	jobs.On("Info", "faas-fn-Func123", mock.Anything).Return(createQuotaJob("faas-fn-Func123", 3, 100, 128), nil, nil)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "Func123", Replicas: 7})
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaUpdater(config, jobs, nil, nil, nil, quota, hclog.Default())(recorder, httptest.NewRequest("POST", "/system/scale-function/Func123", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "cpu 400 MHz in use, 700 MHz requested, limit 1000")
+	jobs.AssertNotCalled(t, "Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestReplicaUpdaterSkipsQuotaWhenScalingDown(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {CPU: 100}}
+
+	jobs := &services.MockJobs{}
+	allocations, _, quota := setupQuotaChecker(config, jobs)
+	jobs.On("Info", "faas-fn-Func123", mock.Anything).Return(createQuotaJob("faas-fn-Func123", 3, 100, 128), nil, nil)
+	replicas := 1
+	jobs.On("Scale", "faas-fn-Func123", "Func123", &replicas, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "Func123", Replicas: 1})
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaUpdater(config, jobs, nil, nil, nil, quota, hclog.Default())(recorder, httptest.NewRequest("POST", "/system/scale-function/Func123", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	allocations.AssertNotCalled(t, "List", mock.Anything)
+}
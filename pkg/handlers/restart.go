@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// RestartedAtMeta is the task meta changed by a restart, which makes Nomad replace the instances of the function.
+const RestartedAtMeta = "faas_nomad_restarted_at"
+
+type FunctionRestart struct {
+	Name        string    `json:"name"`
+	Namespace   string    `json:"namespace"`
+	RestartedAt time.Time `json:"restartedAt"`
+}
+
+type FunctionRestartStatus struct {
+	Name              string     `json:"name"`
+	Namespace         string     `json:"namespace"`
+	RestartedAt       *time.Time `json:"restartedAt,omitempty"`
+	Deployment        string     `json:"deployment,omitempty"`
+	Status            string     `json:"status,omitempty"`
+	StatusDescription string     `json:"statusDescription,omitempty"`
+	DesiredTotal      int        `json:"desiredTotal"`
+	PlacedAllocs      int        `json:"placedAllocs"`
+	HealthyAllocs     int        `json:"healthyAllocs"`
+	UnhealthyAllocs   int        `json:"unhealthyAllocs"`
+}
+
+// MakeRestartHandler restarts all instances of a function without changing its spec, by updating the restart
+// meta of the function task. Nomad replaces the instances in a deployment according to the update strategy
+// of the function, so that only max_parallel instances are replaced at a time and a new instance only counts
+// once it is healthy during min_healthy_time. As the resolver only selects instances passing their health
+// checks, the function keeps being served during the restart.
+//
+// A 409 is returned when the function is stopped, or when a deployment of the function is still in progress.
+func MakeRestartHandler(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("restart_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		queryOptions := &api.QueryOptions{Namespace: namespace}
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil || len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+		jobID := *job.ID
+
+		if job.Stop != nil && *job.Stop {
+			httputil.Errorf(w, http.StatusConflict, "function %s is stopped", functionName)
+			return
+		}
+
+		deployment, _, err := jobs.LatestDeployment(jobID, queryOptions)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function deployment", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		if deployment != nil && containsStatus(activeDeploymentStatuses, deployment.Status) {
+			httputil.Errorf(w, http.StatusConflict, "function %s has a deployment in progress", functionName)
+			return
+		}
+
+		restartedAt := time.Now().UTC()
+
+		task := job.TaskGroups[0].Tasks[0]
+		if task.Meta == nil {
+			task.Meta = map[string]string{}
+		}
+		task.Meta[RestartedAtMeta] = restartedAt.Format(time.RFC3339Nano)
+
+		registerOptions := &api.RegisterOptions{PreserveCounts: true}
+		if job.JobModifyIndex != nil {
+			// the job is only registered when it wasn't modified in the meantime
+			registerOptions.EnforceIndex = true
+			registerOptions.ModifyIndex = *job.JobModifyIndex
+		}
+		if _, _, err := jobs.RegisterOpts(job, registerOptions, &api.WriteOptions{Namespace: namespace}); err != nil {
+			writeNomadError(w, err)
+			log.Error("Error restarting function", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		response, _ := json.Marshal(FunctionRestart{
+			Name:        functionName,
+			Namespace:   namespace,
+			RestartedAt: restartedAt,
+		})
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(response)
+
+		log.Info("Function restart started", "function", functionName, "namespace", namespace)
+	}
+}
+
+// MakeRestartStatusHandler reports the progress of the latest restart of a function, from the latest deployment.
+func MakeRestartStatusHandler(config *types.ProviderConfig, jobs services.Jobs, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("restart_status_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		queryOptions := &api.QueryOptions{Namespace: namespace}
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil || len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+		jobID := *job.ID
+
+		deployment, _, err := jobs.LatestDeployment(jobID, queryOptions)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function deployment", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		status := FunctionRestartStatus{Name: functionName, Namespace: namespace}
+
+		if value, ok := job.TaskGroups[0].Tasks[0].Meta[RestartedAtMeta]; ok {
+			if restartedAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				status.RestartedAt = &restartedAt
+			}
+		}
+
+		if deployment != nil {
+			status.Deployment = deployment.ID
+			status.Status = deployment.Status
+			status.StatusDescription = deployment.StatusDescription
+			for _, state := range deployment.TaskGroups {
+				status.DesiredTotal += state.DesiredTotal
+				status.PlacedAllocs += state.PlacedAllocs
+				status.HealthyAllocs += state.HealthyAllocs
+				status.UnhealthyAllocs += state.UnhealthyAllocs
+			}
+		}
+
+		response, _ := json.Marshal(status)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
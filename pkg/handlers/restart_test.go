@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func restartJob(stop bool) *api.Job {
+	id := "faas-fn-echo"
+	modifyIndex := uint64(42)
+	return &api.Job{
+		ID:             &id,
+		Stop:           &stop,
+		JobModifyIndex: &modifyIndex,
+		TaskGroups: []*api.TaskGroup{{
+			Tasks: []*api.Task{{Name: "echo", Meta: map[string]string{"owner": "team"}}},
+		}},
+	}
+}
+
+func setupRestartHandler(method string) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	request := mux.SetURLVars(httptest.NewRequest(method, "/system/function/echo/restart", nil), map[string]string{"name": "echo"})
+	response := httptest.NewRecorder()
+
+	if method == http.MethodGet {
+		return jobs, MakeRestartStatusHandler(config, jobs, hclog.Default()), request, response
+	}
+	return jobs, MakeRestartHandler(config, jobs, hclog.Default()), request, response
+}
+
+func TestRestartHandlerUpdatesRestartMeta(t *testing.T) {
+	jobs, handler, request, recorder := setupRestartHandler(http.MethodPost)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(restartJob(false), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-1", Status: "successful"}, nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	before := time.Now().UTC()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var result FunctionRestart
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, "echo", result.Name)
+	assert.False(t, result.RestartedAt.Before(before))
+
+	job := jobs.Calls[2].Arguments.Get(0).(*api.Job)
+	options := jobs.Calls[2].Arguments.Get(1).(*api.RegisterOptions)
+
+	meta := job.TaskGroups[0].Tasks[0].Meta
+	assert.Equal(t, "team", meta["owner"])
+	assert.Equal(t, result.RestartedAt.Format(time.RFC3339Nano), meta[RestartedAtMeta])
+	assert.Equal(t, &api.RegisterOptions{EnforceIndex: true, ModifyIndex: 42, PreserveCounts: true}, options)
+}
+
+func TestRestartHandlerReportsConflictWhenDeploymentIsInProgress(t *testing.T) {
+	jobs, handler, request, recorder := setupRestartHandler(http.MethodPost)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(restartJob(false), nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-1", Status: "running"}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRestartHandlerReportsConflictWhenFunctionIsStopped(t *testing.T) {
+	jobs, handler, request, recorder := setupRestartHandler(http.MethodPost)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(restartJob(true), nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	jobs.AssertNotCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRestartHandlerReportsNotFound(t *testing.T) {
+	jobs, handler, request, recorder := setupRestartHandler(http.MethodPost)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("job not found"))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestRestartStatusHandlerReportsDeploymentProgress(t *testing.T) {
+	job := restartJob(false)
+	job.TaskGroups[0].Tasks[0].Meta[RestartedAtMeta] = "2021-05-01T10:00:00Z"
+
+	jobs, handler, request, recorder := setupRestartHandler(http.MethodGet)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(job, nil, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{
+		ID:                "d-2",
+		Status:            "running",
+		StatusDescription: "Deployment is running",
+		TaskGroups: map[string]*api.DeploymentState{
+			"echo": {DesiredTotal: 3, PlacedAllocs: 2, HealthyAllocs: 1},
+		},
+	}, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var status FunctionRestartStatus
+	restartedAt := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, FunctionRestartStatus{
+		Name:              "echo",
+		Namespace:         "default",
+		RestartedAt:       &restartedAt,
+		Deployment:        "d-2",
+		Status:            "running",
+		StatusDescription: "Deployment is running",
+		DesiredTotal:      3,
+		PlacedAllocs:      2,
+		HealthyAllocs:     1,
+	}, status)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+var activeDeploymentStatuses = []string{"running", "paused", "pending", "blocked", "unblocking"}
+
+type FunctionRollback struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	Version         uint64 `json:"version"`
+	PreviousVersion uint64 `json:"previousVersion"`
+}
+
+// MakeRollbackHandler aborts the deployment in progress of a function, if any, and reverts the function to
+// its last stable job version, which is the previous version that was deployed successfully.
+//
+// A 409 is returned when the function has no prior stable version to revert to.
+func MakeRollbackHandler(config *types.ProviderConfig, jobs services.Jobs, deployments services.Deployments, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("rollback_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+namespace)
+
+		queryOptions := &api.QueryOptions{Namespace: namespace}
+		writeOptions := &api.WriteOptions{Namespace: namespace}
+
+		job, err := readFunctionJob(config, jobs, functionName)
+		if job == nil || err != nil {
+			writeFunctionNotFound(w, err, functionName)
+			return
+		}
+		jobID := *job.ID
+
+		versions, _, _, err := jobs.Versions(jobID, false, queryOptions)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function versions", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		current := *job.Version
+		stable := lastStableVersion(versions, current)
+		if stable == nil {
+			httputil.Errorf(w, http.StatusConflict, "function %s has no stable version prior to version %d to roll back to", functionName, current)
+			return
+		}
+
+		deployment, _, err := jobs.LatestDeployment(jobID, queryOptions)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reading function deployment", "function", functionName, "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		if deployment != nil && deployment.JobVersion == current && containsStatus(activeDeploymentStatuses, deployment.Status) {
+			if _, _, err := deployments.Fail(deployment.ID, writeOptions); err != nil {
+				writeNomadError(w, err)
+				log.Error("Error failing function deployment", "function", functionName, "deployment", deployment.ID, "error", err.Error())
+				return
+			}
+			log.Debug("Function deployment failed", "function", functionName, "deployment", deployment.ID)
+		}
+
+		if _, _, err := jobs.Revert(jobID, *stable.Version, nil, writeOptions, "", ""); err != nil {
+			writeNomadError(w, err)
+			log.Error("Error reverting function", "function", functionName, "namespace", namespace, "version", *stable.Version, "error", err.Error())
+			return
+		}
+
+		response, _ := json.Marshal(FunctionRollback{
+			Name:            functionName,
+			Namespace:       namespace,
+			Version:         *stable.Version,
+			PreviousVersion: current,
+		})
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+
+		log.Info("Function rolled back", "function", functionName, "namespace", namespace, "version", *stable.Version, "previous_version", current)
+	}
+}
+
+// lastStableVersion returns the most recent stable version older than the current version.
+func lastStableVersion(versions []*api.Job, current uint64) *api.Job {
+	var stable *api.Job
+	for _, v := range versions {
+		if v.Version == nil || *v.Version >= current || v.Stable == nil || !*v.Stable {
+			continue
+		}
+		if stable == nil || *v.Version > *stable.Version {
+			stable = v
+		}
+	}
+	return stable
+}
+
+func containsStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
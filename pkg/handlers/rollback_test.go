@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func jobVersion(version uint64, stable bool) *api.Job {
+	id := "faas-fn-echo"
+	return &api.Job{ID: &id, Version: &version, Stable: &stable}
+}
+
+func setupRollbackHandler(name string) (*services.MockJobs, *services.MockDeployments, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	deployments := &services.MockDeployments{}
+
+	request := mux.SetURLVars(httptest.NewRequest("POST", "/system/function/"+name+"/rollback", nil), map[string]string{"name": name})
+	response := httptest.NewRecorder()
+
+	return jobs, deployments, MakeRollbackHandler(config, jobs, deployments, hclog.Default()), request, response
+}
+
+func TestRollbackHandlerFailsDeploymentAndRevertsToLastStableVersion(t *testing.T) {
+	jobs, deployments, handler, request, recorder := setupRollbackHandler("echo")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobVersion(4, false), nil, nil)
+	jobs.On("Versions", "faas-fn-echo", false, mock.Anything).Return([]*api.Job{
+		jobVersion(4, false), jobVersion(3, false), jobVersion(2, true), jobVersion(1, true),
+	}, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-4", JobVersion: 4, Status: "running"}, nil, nil)
+	jobs.On("Revert", "faas-fn-echo", uint64(2), (*uint64)(nil), mock.Anything).Return(nil, nil)
+	deployments.On("Fail", "d-4", mock.Anything).Return(nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var result FunctionRollback
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, FunctionRollback{Name: "echo", Namespace: "default", Version: 2, PreviousVersion: 4}, result)
+	deployments.AssertExpectations(t)
+	jobs.AssertExpectations(t)
+}
+
+func TestRollbackHandlerRevertsWithoutActiveDeployment(t *testing.T) {
+	jobs, deployments, handler, request, recorder := setupRollbackHandler("echo.default")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobVersion(2, true), nil, nil)
+	jobs.On("Versions", "faas-fn-echo", false, mock.Anything).Return([]*api.Job{jobVersion(2, true), jobVersion(1, true)}, nil)
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "d-2", JobVersion: 2, Status: "successful"}, nil, nil)
+	jobs.On("Revert", "faas-fn-echo", uint64(1), (*uint64)(nil), mock.Anything).Return(nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	deployments.AssertNotCalled(t, "Fail", mock.Anything, mock.Anything)
+}
+
+func TestRollbackHandlerReportsConflictWithoutStableVersion(t *testing.T) {
+	jobs, _, handler, request, recorder := setupRollbackHandler("echo")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobVersion(1, false), nil, nil)
+	jobs.On("Versions", "faas-fn-echo", false, mock.Anything).Return([]*api.Job{jobVersion(1, false), jobVersion(0, false)}, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "no stable version prior to version 1")
+	jobs.AssertNotCalled(t, "Revert", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRollbackHandlerReportsNotFound(t *testing.T) {
+	jobs, _, handler, request, recorder := setupRollbackHandler("echo")
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
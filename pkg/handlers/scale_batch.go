@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type BatchScaleRequest struct {
+	Name     string `json:"name"`
+	Replicas uint64 `json:"replicas"`
+}
+
+type BatchScaleResult struct {
+	Name     string `json:"name"`
+	Status   int    `json:"status"`
+	Replicas int    `json:"replicas"`
+	Note     string `json:"note,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// MakeBatchScaleHandler scales a batch of functions, clamping the replicas of each function to its
+// scale labels. The outcome of every function is reported individually, with a 207 Multi-Status when
+// some of them failed.
+func MakeBatchScaleHandler(config *types.ProviderConfig, client services.Jobs, limiter *ScaleLimiter, pool *WarmPool, blueGreen *BlueGreenDeployments, quota *QuotaChecker, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("batch_scale_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var req []BatchScaleRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if len(req) > config.Scheduling.ScaleBatchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("batch of %d functions exceeds the limit of %d", len(req), config.Scheduling.ScaleBatchLimit))
+			return
+		}
+
+		status := http.StatusOK
+		results := make([]BatchScaleResult, 0, len(req))
+
+		for _, item := range req {
+			result := BatchScaleResult{Name: item.Name}
+
+			if len(item.Name) == 0 {
+				result.Status = http.StatusBadRequest
+				result.Error = "function name is required"
+			} else {
+				replicas, note, code, err := scaleFunction(config, client, limiter, pool, blueGreen, quota, item.Name, int(item.Replicas), true)
+				result.Status = code
+				result.Replicas = replicas
+				result.Note = note
+				if err != nil {
+					result.Error = err.Error()
+					log.Error("Error scaling function", "function", item.Name, "namespace", config.Scheduling.Namespace, "error", err.Error())
+				}
+			}
+
+			if result.Status != http.StatusOK {
+				status = http.StatusMultiStatus
+			}
+			results = append(results, result)
+		}
+
+		resultsBytes, _ := json.Marshal(results)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(status)
+		w.Write(resultsBytes)
+
+		log.Debug("Functions scaled", "functions", len(results))
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func jobWithLabels(labels map[string]interface{}) *api.Job {
+	jobType := api.JobTypeService
+	return &api.Job{
+		Type: &jobType,
+		TaskGroups: []*api.TaskGroup{{
+			Tasks: []*api.Task{{Config: map[string]interface{}{"labels": []interface{}{labels}}}},
+		}},
+	}
+}
+
+func setupBatchScaleHandler(body []byte) (*services.MockJobs, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.ScaleBatchLimit = 3
+	jobs := &services.MockJobs{}
+
+	request := httptest.NewRequest("POST", "/system/scale/batch", bytes.NewReader(body))
+	response := httptest.NewRecorder()
+
+	return jobs, MakeBatchScaleHandler(config, jobs, NewScaleLimiter(), nil, nil, nil, hclog.Default()), request, response
+}
+
+func readBatchScaleResults(t *testing.T, recorder *httptest.ResponseRecorder) []BatchScaleResult {
+	var results []BatchScaleResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestBatchScaleHandlerReportsFunctionsScaledToZero(t *testing.T) {
+	body, _ := json.Marshal([]BatchScaleRequest{{Name: "echo", Replicas: 0}})
+	jobs, handler, request, recorder := setupBatchScaleHandler(body)
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobWithLabels(map[string]interface{}{}), nil, nil)
+	jobs.On("Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"replicas":0`)
+}
+
+func TestBatchScaleHandlerScalesFunctionsWithinClamps(t *testing.T) {
+	body, _ := json.Marshal([]BatchScaleRequest{{Name: "echo", Replicas: 10}, {Name: "figlet", Replicas: 0}})
+	jobs, handler, request, recorder := setupBatchScaleHandler(body)
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobWithLabels(map[string]interface{}{"com.openfaas.scale.max": "5"}), nil, nil)
+	jobs.On("Info", "faas-fn-figlet", mock.Anything).Return(jobWithLabels(map[string]interface{}{"com.openfaas.scale.min": "2"}), nil, nil)
+	jobs.On("Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []BatchScaleResult{
+		{Name: "echo", Status: http.StatusOK, Replicas: 5},
+		{Name: "figlet", Status: http.StatusOK, Replicas: 2},
+	}, readBatchScaleResults(t, recorder))
+}
+
+func TestBatchScaleHandlerReportsIndividualFailures(t *testing.T) {
+	body, _ := json.Marshal([]BatchScaleRequest{{Name: "echo", Replicas: 2}, {Name: "unknown", Replicas: 2}, {Name: "figlet", Replicas: 2}})
+	jobs, handler, request, recorder := setupBatchScaleHandler(body)
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobWithLabels(map[string]interface{}{}), nil, nil)
+	jobs.On("Info", "faas-fn-unknown", mock.Anything).Return(nil, nil, fmt.Errorf("job not found"))
+	jobs.On("Info", "faas-fn-figlet", mock.Anything).Return(jobWithLabels(map[string]interface{}{}), nil, nil)
+	jobs.On("Scale", "faas-fn-echo", mock.Anything, mock.Anything, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+	jobs.On("Scale", "faas-fn-figlet", mock.Anything, mock.Anything, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
+
+	handler(recorder, request)
+
+	results := readBatchScaleResults(t, recorder)
+
+	assert.Equal(t, http.StatusMultiStatus, recorder.Code)
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Equal(t, http.StatusNotFound, results[1].Status)
+	assert.Equal(t, http.StatusInternalServerError, results[2].Status)
+	assert.Equal(t, "failure", results[2].Error)
+}
+
+func TestBatchScaleHandlerReportsErrorWhenBatchExceedsLimit(t *testing.T) {
+	body, _ := json.Marshal([]BatchScaleRequest{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}})
+	jobs, handler, request, recorder := setupBatchScaleHandler(body)
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	jobs.AssertNotCalled(t, "Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBatchScaleHandlerReportsFunctionsExceedingQuota(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Quotas = map[string]types.QuotaConfig{"default": {Memory: 1024}}
+
+	jobs := &services.MockJobs{}
+	_, _, quota := setupQuotaChecker(config, jobs)
+	jobs.On("Info", "faas-fn-Func123", mock.Anything).Return(createQuotaJob("faas-fn-Func123", 3, 100, 128), nil, nil)
+
+	body, _ := json.Marshal([]BatchScaleRequest{{Name: "Func123", Replicas: 5}})
+	recorder := httptest.NewRecorder()
+
+	MakeBatchScaleHandler(config, jobs, nil, nil, nil, quota, hclog.Default())(recorder, httptest.NewRequest("POST", "/system/scale/batch", bytes.NewReader(body)))
+
+	results := readBatchScaleResults(t, recorder)
+
+	assert.Equal(t, http.StatusForbidden, results[0].Status)
+	assert.Contains(t, results[0].Error, "memory 512 MB in use, 640 MB requested, limit 1024")
+	jobs.AssertNotCalled(t, "Scale", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
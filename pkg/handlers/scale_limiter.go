@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	scaleStepLabel     = "com.openfaas.scale.step"
+	scaleCooldownLabel = "com.openfaas.scale.cooldown"
+)
+
+// ScaleLimiter limits the rate of change of the replicas of a function, based on its labels:
+//
+//   - `com.openfaas.scale.step` is the max number of replicas added or removed in one scale operation,
+//     larger changes are clamped to the step.
+//   - `com.openfaas.scale.cooldown` is the min duration between two scale operations, e.g. 30s, where
+//     scale operations within the cooldown are rejected with a 429.
+//
+// The time of the last scale operation of each function is only tracked in memory.
+type ScaleLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func NewScaleLimiter() *ScaleLimiter {
+	return &ScaleLimiter{last: map[string]time.Time{}, now: time.Now}
+}
+
+// limit returns the replicas to apply, with a note when the requested replicas were clamped to the step,
+// or the status code matching the error when the function is cooling down.
+//
+// A change of the replicas starts the cooldown of the function under the same lock as its check, so concurrent
+// scale operations can't both pass the cooldown. The returned release undoes the start of the cooldown, for a scale
+// operation which failed after all.
+func (l *ScaleLimiter) limit(functionName string, labels map[string]string, current, replicas int) (int, string, func(), int, error) {
+	if replicas == current {
+		return replicas, "", func() {}, http.StatusOK, nil
+	}
+
+	requested := replicas
+	step := types.ParseIntValueFromMap(&labels, scaleStepLabel, 0)
+	if step > 0 {
+		if replicas > current+step {
+			replicas = current + step
+		} else if replicas < current-step {
+			replicas = current - step
+		}
+	}
+
+	cooldown := types.ParseIntOrDurationValueFromMap(&labels, scaleCooldownLabel, 0)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	last, ok := l.last[functionName]
+	if elapsed := now.Sub(last); cooldown > 0 && ok && elapsed < cooldown {
+		return 0, "", nil, http.StatusTooManyRequests, fmt.Errorf("function %s was scaled %s ago, wait for the cooldown of %s", functionName, elapsed.Round(time.Second), cooldown)
+	}
+	l.last[functionName] = now
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.last[functionName] != now {
+			return
+		}
+		if ok {
+			l.last[functionName] = last
+		} else {
+			delete(l.last, functionName)
+		}
+	}
+
+	if replicas != requested {
+		return replicas, fmt.Sprintf("scaled to %d replicas instead of %d, limited to a step of %d", replicas, requested, step), release, http.StatusOK, nil
+	}
+	return replicas, "", release, http.StatusOK, nil
+}
+
+// RemoveCacheItem forgets the last scale operation of a deleted function.
+func (l *ScaleLimiter) RemoveCacheItem(functionName string) {
+	l.mu.Lock()
+	delete(l.last, functionName)
+	l.mu.Unlock()
+}
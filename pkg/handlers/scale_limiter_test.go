@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func jobWithCount(count int, labels map[string]interface{}) *api.Job {
+	job := jobWithLabels(labels)
+	job.TaskGroups[0].Count = &count
+	return job
+}
+
+func scaleRequest(replicas uint64) *http.Request {
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: replicas})
+	return httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body))
+}
+
+func TestScaleLimiterClampsToStep(t *testing.T) {
+	limiter := NewScaleLimiter()
+	labels := map[string]string{"com.openfaas.scale.step": "10"}
+
+	replicas, note, _, _, err := limiter.limit("echo", labels, 1, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, replicas)
+	assert.Equal(t, "scaled to 11 replicas instead of 100, limited to a step of 10", note)
+
+	replicas, _, _, _, err = limiter.limit("echo", labels, 50, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 40, replicas)
+
+	replicas, note, _, _, err = limiter.limit("echo", labels, 5, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, replicas)
+	assert.Empty(t, note)
+}
+
+func TestScaleLimiterRejectsScalingDuringCooldown(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleLimiter()
+	limiter.now = func() time.Time { return now }
+	labels := map[string]string{"com.openfaas.scale.cooldown": "1m"}
+
+	_, _, _, _, err := limiter.limit("echo", labels, 1, 3)
+	assert.NoError(t, err)
+
+	now = now.Add(30 * time.Second)
+	_, _, _, status, err := limiter.limit("echo", labels, 3, 5)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+
+	_, _, _, _, err = limiter.limit("echo", labels, 3, 3)
+	assert.NoError(t, err, "requests not changing the replicas are not limited")
+
+	_, _, _, _, err = limiter.limit("figlet", labels, 1, 3)
+	assert.NoError(t, err, "cooldowns are tracked per function")
+
+	now = now.Add(30 * time.Second)
+	_, _, _, _, err = limiter.limit("echo", labels, 3, 5)
+	assert.NoError(t, err)
+}
+
+func TestScaleLimiterAllowsOneOfConcurrentScaleOperations(t *testing.T) {
+	limiter := NewScaleLimiter()
+	labels := map[string]string{"com.openfaas.scale.cooldown": "1m"}
+
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, _, err := limiter.limit("echo", labels, 1, 3); err == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), allowed)
+}
+
+func TestScaleLimiterReleasesCooldownOfFailedScaleOperation(t *testing.T) {
+	limiter := NewScaleLimiter()
+	labels := map[string]string{"com.openfaas.scale.cooldown": "1m"}
+
+	_, _, release, _, err := limiter.limit("echo", labels, 1, 3)
+	assert.NoError(t, err)
+	release()
+
+	_, _, _, _, err = limiter.limit("echo", labels, 1, 3)
+	assert.NoError(t, err)
+
+	_, _, _, status, err := limiter.limit("echo", labels, 3, 5)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+func TestReplicaUpdaterLimitsScaling(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(jobWithCount(1, map[string]interface{}{
+		"com.openfaas.scale.step":     "10",
+		"com.openfaas.scale.cooldown": "1m",
+	}), nil, nil)
+
+	replicas := 11
+	jobs.On("Scale", "faas-fn-echo", "echo", &replicas, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	handler := MakeReplicaUpdater(config, jobs, NewScaleLimiter(), nil, nil, nil, hclog.Default())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, scaleRequest(100))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "scaled to 11 replicas instead of 100")
+	jobs.AssertExpectations(t)
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, scaleRequest(100))
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	jobs.AssertNumberOfCalls(t, "Scale", 1)
+}
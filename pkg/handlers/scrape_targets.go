@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	resolvers "github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// ScrapeTargetGroup is a target group of the Prometheus HTTP service discovery format.
+type ScrapeTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// MakeScrapeTargetsHandler lists the healthy instances of all functions as Prometheus HTTP SD target groups.
+//
+// The instances are read from the (watched) resolver cache, so every scrape of the document reflects
+// the current healthy instances without querying Consul. The `port` query parameter selects one of the named
+// ports of the functions, e.g. metrics, instead of the primary port.
+func MakeScrapeTargetsHandler(config *types.ProviderConfig, jobs services.Jobs, resolver resolvers.ServiceResolver, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("scrape_targets")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+
+		resolveAll := resolver.ResolveAll
+		port := r.URL.Query().Get("port")
+		if len(port) != 0 {
+			portResolver, ok := resolver.(resolvers.PortResolver)
+			if !ok {
+				httputil.Errorf(w, http.StatusBadRequest, "resolving named ports is not supported")
+				return
+			}
+			resolveAll = func(functionName string) ([]url.URL, error) {
+				return portResolver.ResolveAllPort(functionName, port)
+			}
+		}
+
+		options := &api.QueryOptions{Namespace: namespace}
+
+		list, err := services.ListFunctionJobs(jobs, config.Scheduling.JobPrefix, options)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error listing functions", "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		groups := make([]ScrapeTargetGroup, 0, len(list))
+
+		for _, j := range list {
+			if j.Status == "dead" {
+				continue
+			}
+
+			functionName := services.FunctionName(j, config.Scheduling.JobPrefix)
+			instances, err := resolveAll(functionName)
+			if err != nil {
+				log.Warn("Error resolving function", "function", functionName, "namespace", namespace, "error", err.Error())
+				continue
+			}
+			if len(instances) == 0 {
+				continue
+			}
+
+			targets := make([]string, 0, len(instances))
+			for _, instance := range instances {
+				targets = append(targets, instance.Host)
+			}
+
+			groups = append(groups, ScrapeTargetGroup{
+				Targets: targets,
+				Labels: map[string]string{
+					"function_name":      functionName,
+					"function_namespace": namespace,
+				},
+			})
+		}
+
+		groupsBytes, _ := json.Marshal(groups)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(groupsBytes)
+
+		log.Trace("Scrape targets listed successfully", "namespace", namespace)
+	}
+}
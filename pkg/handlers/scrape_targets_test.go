@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupScrapeTargetsHandler() (*services.MockJobs, *services.MockResolver, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	jobs := &services.MockJobs{}
+	resolver := &services.MockResolver{}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/system/functions/scrape-targets", nil)
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+		Namespace: "default",
+	}}
+
+	handler := MakeScrapeTargetsHandler(config, jobs, resolver, hclog.Default())
+
+	return jobs, resolver, handler, request, response
+}
+
+func TestScrapeTargetsHandlerListsHealthyInstances(t *testing.T) {
+	jobs, resolver, handler, request, recorder := setupScrapeTargetsHandler()
+
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-echo", Name: "faas-fn-echo", Status: "running"},
+		{ID: "faas-fn-figlet", Name: "faas-fn-figlet", Status: "running"},
+		{ID: "faas-fn-stopped", Name: "faas-fn-stopped", Status: "dead"},
+	}, nil, nil)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:21000"}, {Host: "10.0.0.2:21000"}}, nil)
+	resolver.On("ResolveAll", "figlet").Return([]url.URL{}, nil)
+
+	handler(recorder, request)
+
+	var groups []ScrapeTargetGroup
+	json.Unmarshal(recorder.Body.Bytes(), &groups)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []ScrapeTargetGroup{{
+		Targets: []string{"10.0.0.1:21000", "10.0.0.2:21000"},
+		Labels:  map[string]string{"function_name": "echo", "function_namespace": "default"},
+	}}, groups)
+	resolver.AssertNotCalled(t, "ResolveAll", "stopped")
+}
+
+func TestScrapeTargetsHandlerReportsErrorWhenListingJobsFails(t *testing.T) {
+	jobs, _, handler, request, recorder := setupScrapeTargetsHandler()
+	jobs.On("List", mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestScrapeTargetsHandlerWithNamedPort(t *testing.T) {
+	jobs, resolver, handler, _, recorder := setupScrapeTargetsHandler()
+
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-echo", Name: "faas-fn-echo", Status: "running"},
+	}, nil, nil)
+	resolver.On("ResolveAllPort", "echo", "metrics").Return([]url.URL{{Host: "10.0.0.1:29100"}}, nil)
+
+	handler(recorder, httptest.NewRequest("GET", "/system/functions/scrape-targets?port=metrics", nil))
+
+	var groups []ScrapeTargetGroup
+	json.Unmarshal(recorder.Body.Bytes(), &groups)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"10.0.0.1:29100"}, groups[0].Targets)
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
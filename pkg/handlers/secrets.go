@@ -8,6 +8,7 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
@@ -16,24 +17,25 @@ type SecretsResponse struct {
 	Body       []byte
 }
 
-func MakeSecretHandler(secrets services.Secrets, logger hclog.Logger) http.HandlerFunc {
+func MakeSecretHandler(config *types.ProviderConfig, secrets services.SecretStore, logger hclog.Logger) http.HandlerFunc {
 	log := logger.Named("secrets")
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		body, _ := ioutil.ReadAll(r.Body)
+		namespace := config.Scheduling.Namespace
 
 		switch r.Method {
 		case http.MethodGet:
-			getSecrets(secrets, w, log)
+			getSecrets(secrets, secretNamespace(r.URL.Query().Get("namespace"), namespace), w, log)
 			return
 		case http.MethodPost:
-			setSecret(true, secrets, body, w, log)
+			setSecret(true, secrets, namespace, body, w, log)
 			return
 		case http.MethodPut:
-			setSecret(false, secrets, body, w, log)
+			setSecret(false, secrets, namespace, body, w, log)
 			return
 		case http.MethodDelete:
-			deleteSecret(secrets, body, w, log)
+			deleteSecret(secrets, namespace, body, w, log)
 			return
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -42,8 +44,16 @@ func MakeSecretHandler(secrets services.Secrets, logger hclog.Logger) http.Handl
 	}
 }
 
-func getSecrets(vc services.Secrets, w http.ResponseWriter, log hclog.Logger) {
-	secrets, err := vc.List()
+// secretNamespace returns the requested namespace of a secret, or the default namespace when none is given.
+func secretNamespace(requested, defaultNamespace string) string {
+	if len(requested) == 0 {
+		return defaultNamespace
+	}
+	return requested
+}
+
+func getSecrets(vc services.SecretStore, namespace string, w http.ResponseWriter, log hclog.Logger) {
+	secrets, err := vc.List(namespace)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		log.Error("Error listing secrets", "error", err.Error())
@@ -60,7 +70,7 @@ func getSecrets(vc services.Secrets, w http.ResponseWriter, log hclog.Logger) {
 	log.Trace("Secrets listed successfully")
 }
 
-func setSecret(create bool, vc services.Secrets, body []byte, w http.ResponseWriter, log hclog.Logger) {
+func setSecret(create bool, vc services.SecretStore, namespace string, body []byte, w http.ResponseWriter, log hclog.Logger) {
 	var secret ftypes.Secret
 
 	if err := json.Unmarshal(body, &secret); err != nil {
@@ -76,7 +86,7 @@ func setSecret(create bool, vc services.Secrets, body []byte, w http.ResponseWri
 		value = base64.StdEncoding.EncodeToString([]byte(secret.Value))
 	}
 
-	if err := vc.Set(secret.Name, value); err != nil {
+	if err := vc.Set(secretNamespace(secret.Namespace, namespace), secret.Name, value); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		log.Error("Error creating/updating secret", "secret", secret.Name, "error", err.Error())
 	}
@@ -90,7 +100,7 @@ func setSecret(create bool, vc services.Secrets, body []byte, w http.ResponseWri
 	}
 }
 
-func deleteSecret(vc services.Secrets, body []byte, w http.ResponseWriter, log hclog.Logger) {
+func deleteSecret(vc services.SecretStore, namespace string, body []byte, w http.ResponseWriter, log hclog.Logger) {
 	var secret ftypes.Secret
 
 	if err := json.Unmarshal(body, &secret); err != nil {
@@ -98,7 +108,7 @@ func deleteSecret(vc services.Secrets, body []byte, w http.ResponseWriter, log h
 		log.Error("Error deleting secret", "error", err.Error())
 	}
 
-	if err := vc.Delete(secret.Name); err != nil {
+	if err := vc.Delete(secretNamespace(secret.Namespace, namespace), secret.Name); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		log.Error("Error deleting secret", "error", err.Error())
 	}
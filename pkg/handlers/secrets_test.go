@@ -10,12 +10,19 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/proxy"
 	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/assert"
 )
 
+func secretsConfig() *types.ProviderConfig {
+	return &types.ProviderConfig{Scheduling: types.SchedulingConfig{Namespace: "default"}}
+}
+
 func TestSecretsHandlerReportsAvailableSecrets(t *testing.T) {
 	actualValues := []ftypes.Secret{
 		{Name: "secret-a"},
@@ -26,9 +33,9 @@ func TestSecretsHandlerReportsAvailableSecrets(t *testing.T) {
 	request := httptest.NewRequest("GET", "/system/secrets", bytes.NewReader([]byte("")))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("List").Return(actualValues, nil)
+	secrets.On("List", "default").Return(actualValues, nil)
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
@@ -52,9 +59,9 @@ func TestSecretsHandlerReportsErrorWhenListingSecrets(t *testing.T) {
 	request := httptest.NewRequest("GET", "/system/secrets", bytes.NewReader([]byte("")))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("List").Return(nil, fmt.Errorf("error reading secrets"))
+	secrets.On("List", "default").Return(nil, fmt.Errorf("error reading secrets"))
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
@@ -67,9 +74,9 @@ func TestSecretsHandlerReportsCreatedWhenCreatingSecretSucceeds(t *testing.T) {
 	request := httptest.NewRequest("POST", "/system/secrets", bytes.NewReader(secretRequest("secret-a", value)))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Set", "secret-a", encoded).Return(nil)
+	secrets.On("Set", "default", "secret-a", encoded).Return(nil)
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusCreated, recorder.Code)
@@ -83,9 +90,9 @@ func TestSecretsHandlerReportsErrorWhenCreatingSecretFails(t *testing.T) {
 	request := httptest.NewRequest("POST", "/system/secrets", bytes.NewReader(secretRequest("secret-a", value)))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Set", "secret-a", encoded).Return(fmt.Errorf("error reading secrets"))
+	secrets.On("Set", "default", "secret-a", encoded).Return(fmt.Errorf("error reading secrets"))
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
@@ -99,9 +106,9 @@ func TestSecretsHandlerReportsOKWhenUpdatingSecretSucceeds(t *testing.T) {
 	request := httptest.NewRequest("PUT", "/system/secrets", bytes.NewReader(secretRequest("secret-a", value)))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Set", "secret-a", encoded).Return(nil)
+	secrets.On("Set", "default", "secret-a", encoded).Return(nil)
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
@@ -115,9 +122,9 @@ func TestSecretsHandlerReportsOKWhenCreatingBindarySecret(t *testing.T) {
 	request := httptest.NewRequest("POST", "/system/secrets", bytes.NewReader(secretRequestWithRawValue("secret-a", value)))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Set", "secret-a", encoded).Return(nil)
+	secrets.On("Set", "default", "secret-a", encoded).Return(nil)
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusCreated, recorder.Code)
@@ -131,9 +138,9 @@ func TestSecretsHandlerReportsErrorWhenUpdatingSecretFails(t *testing.T) {
 	request := httptest.NewRequest("PUT", "/system/secrets", bytes.NewReader(secretRequest("secret-a", value)))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Set", "secret-a", encoded).Return(fmt.Errorf("error reading secrets"))
+	secrets.On("Set", "default", "secret-a", encoded).Return(fmt.Errorf("error reading secrets"))
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
@@ -144,9 +151,9 @@ func TestSecretsHandlerReportsOKWhenDeletingSecretSucceeds(t *testing.T) {
 	request := httptest.NewRequest("DELETE", "/system/secrets", bytes.NewReader(deleteRequest("secret-a")))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Delete", "secret-a").Return(nil)
+	secrets.On("Delete", "default", "secret-a").Return(nil)
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
@@ -157,9 +164,9 @@ func TestSecretsHandlerReportsErrorWhenDeletingSecretFails(t *testing.T) {
 	request := httptest.NewRequest("DELETE", "/system/secrets", bytes.NewReader(deleteRequest("secret-a")))
 
 	secrets := &services.MockSecrets{}
-	secrets.On("Delete", "secret-a").Return(fmt.Errorf("error reading secrets"))
+	secrets.On("Delete", "default", "secret-a").Return(fmt.Errorf("error reading secrets"))
 
-	handler := MakeSecretHandler(secrets, hclog.Default())
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
 	handler(recorder, request)
 
 	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
@@ -182,3 +189,70 @@ func secretRequestWithRawValue(name, value string) []byte {
 	data, _ := json.Marshal(req)
 	return data
 }
+
+func TestSecretsHandlerListsSecretsOfRequestedNamespace(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/system/secrets?namespace=other", bytes.NewReader([]byte("")))
+
+	secrets := &services.MockSecrets{}
+	secrets.On("List", "other").Return([]ftypes.Secret{{Name: "secret-a", Namespace: "other"}}, nil)
+
+	handler := MakeSecretHandler(secretsConfig(), secrets, hclog.Default())
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	secrets.AssertExpectations(t)
+}
+
+// memorySecrets is a secret store keeping the values as they are written by the secret handler.
+type memorySecrets map[string]string
+
+func (m memorySecrets) List(namespace string) ([]ftypes.Secret, error) {
+	return nil, nil
+}
+
+func (m memorySecrets) Get(namespace, key string) (string, error) {
+	value, ok := m[namespace+"/"+key]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", key)
+	}
+	return value, nil
+}
+
+func (m memorySecrets) Set(namespace, key, value string) error {
+	m[namespace+"/"+key] = value
+	return nil
+}
+
+func (m memorySecrets) Exists(namespace, key string) bool {
+	_, ok := m[namespace+"/"+key]
+	return ok
+}
+
+func (m memorySecrets) Delete(namespace, key string) error {
+	delete(m, namespace+"/"+key)
+	return nil
+}
+
+func TestSecretsCreatedByHandlerAuthenticateInvocations(t *testing.T) {
+	secrets := memorySecrets{}
+
+	body, _ := json.Marshal(ftypes.Secret{Name: "echo-auth", Value: "s3cr3t"})
+	recorder := httptest.NewRecorder()
+	MakeSecretHandler(secretsConfig(), secrets, hclog.Default())(recorder, httptest.NewRequest("POST", "/system/secrets", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "bearer"}, nil)
+	handler := proxy.NewAuthMiddleware("default", labels, secrets)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for token, status := range map[string]int{"s3cr3t": http.StatusOK, base64.StdEncoding.EncodeToString([]byte("s3cr3t")): http.StatusUnauthorized} {
+		request := mux.SetURLVars(httptest.NewRequest("POST", "/function/echo", nil), map[string]string{"name": "echo"})
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		assert.Equal(t, status, recorder.Code, token)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/proxy"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// SLOReporter provides the state of the latency objective of a function.
+type SLOReporter interface {
+	Report(functionName string) (proxy.FunctionSLO, bool)
+}
+
+// MakeFunctionSLOHandler returns the fraction of the recent invocations of a function exceeding its latency
+// objective, and the burn rate of its error budget, per window. A 404 is returned when the function has no
+// latency objective, or wasn't invoked during the last hour.
+func MakeFunctionSLOHandler(config *types.ProviderConfig, slo SLOReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+config.Scheduling.Namespace)
+
+		report, ok := slo.Report(functionName)
+		if !ok {
+			httputil.Errorf(w, http.StatusNotFound, "function %s has no invocations with a latency objective", functionName)
+			return
+		}
+
+		reportBytes, _ := json.Marshal(report)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(reportBytes)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/proxy"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSLOReporter map[string]proxy.FunctionSLO
+
+func (f fakeSLOReporter) Report(functionName string) (proxy.FunctionSLO, bool) {
+	report, ok := f[functionName]
+	return report, ok
+}
+
+func requestFunctionSLO(reporter SLOReporter, name string) *httptest.ResponseRecorder {
+	config, _ := types.DefaultConfig()
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/system/function/"+name+"/slo", nil), map[string]string{"name": name})
+	recorder := httptest.NewRecorder()
+	MakeFunctionSLOHandler(config, reporter)(recorder, request)
+	return recorder
+}
+
+func TestFunctionSLOHandlerReturnsReport(t *testing.T) {
+	expected := proxy.FunctionSLO{
+		Name:      "echo",
+		Namespace: "default",
+		Latency:   "250ms",
+		Objective: 0.99,
+		Windows:   []proxy.SLOWindow{{Window: "5m", Requests: 10, SlowRequests: 1, SlowRatio: 0.1, BurnRate: 10}},
+	}
+
+	recorder := requestFunctionSLO(fakeSLOReporter{"echo": expected}, "echo.default")
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, TypeApplicationJson, recorder.Header().Get(HeaderContentType))
+
+	var report proxy.FunctionSLO
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, expected, report)
+}
+
+func TestFunctionSLOHandlerReportsFunctionsWithoutObjective(t *testing.T) {
+	recorder := requestFunctionSLO(fakeSLOReporter{}, "figlet")
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
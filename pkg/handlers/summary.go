@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type FunctionsSummary struct {
+	Functions         int            `json:"functions"`
+	Replicas          uint64         `json:"replicas"`
+	AvailableReplicas uint64         `json:"availableReplicas"`
+	InvocationCount   float64        `json:"invocationCount"`
+	Namespaces        map[string]int `json:"namespaces"`
+	Degraded          int            `json:"degraded"`
+}
+
+// MakeFunctionsSummaryHandler aggregates the state of all functions in a single response.
+//
+// The job summaries returned by a single Nomad list query are used for the replica counts, and the available
+// replicas of all functions are counted in a single Consul query when the resolver supports it, so no per-function
+// queries are needed. The invocations are the ones proxied by the provider since it started.
+func MakeFunctionsSummaryHandler(config *types.ProviderConfig, jobs services.Jobs, resolver resolver.ServiceResolver, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("functions_summary")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := config.Scheduling.Namespace
+
+		options := &api.QueryOptions{Namespace: namespace}
+
+		list, err := services.ListFunctionJobs(jobs, config.Scheduling.JobPrefix, options)
+		if err != nil {
+			writeNomadError(w, err)
+			log.Error("Error listing functions", "namespace", namespace, "error", err.Error())
+			return
+		}
+
+		summary := FunctionsSummary{Namespaces: map[string]int{namespace: 0}}
+
+		var live []*api.JobListStub
+		var functionNames []string
+		for _, j := range list {
+			summary.Functions++
+			summary.Namespaces[namespace]++
+
+			functionName := services.FunctionName(j, config.Scheduling.JobPrefix)
+			summary.InvocationCount += metrics.Invocations(functionName)
+
+			if j.Status == "dead" {
+				continue
+			}
+			live = append(live, j)
+			functionNames = append(functionNames, functionName)
+		}
+
+		available := countAvailable(resolver, functionNames, log)
+
+		for i, j := range live {
+			replicas := services.ScheduledReplicas(j.JobSummary)
+			summary.Replicas += replicas
+			summary.AvailableReplicas += uint64(available[functionNames[i]])
+
+			if uint64(available[functionNames[i]]) < replicas {
+				summary.Degraded++
+			}
+		}
+
+		summaryBytes, _ := json.Marshal(summary)
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(summaryBytes)
+
+		log.Trace("Functions summary read successfully", "namespace", namespace)
+	}
+}
+
+// countAvailable counts the available instances of the functions, in a single query when the resolver counts the
+// instances itself, or by resolving every function otherwise, e.g. when the single query failed.
+func countAvailable(serviceResolver resolver.ServiceResolver, functionNames []string, log hclog.Logger) map[string]int {
+	if len(functionNames) == 0 {
+		return map[string]int{}
+	}
+
+	if counter, ok := serviceResolver.(resolver.InstanceCounter); ok {
+		counts, err := counter.CountInstances(functionNames)
+		if err == nil {
+			return counts
+		}
+		log.Warn("Error counting instances of functions, resolving every function", "error", err.Error())
+	}
+
+	counts := make(map[string]int, len(functionNames))
+	for _, functionName := range functionNames {
+		available, err := serviceResolver.ResolveAll(functionName)
+		if err != nil {
+			log.Warn("Error resolving function", "function", functionName, "error", err.Error())
+		}
+		counts[functionName] = len(available)
+	}
+	return counts
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupFunctionsSummaryHandler() (*services.MockJobs, *services.MockResolver, http.HandlerFunc, *http.Request, *httptest.ResponseRecorder) {
+	jobs := &services.MockJobs{}
+	resolver := &services.MockResolver{}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/system/functions/summary", bytes.NewReader([]byte("")))
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+		Namespace: "default",
+	}}
+
+	handler := MakeFunctionsSummaryHandler(config, jobs, resolver, hclog.Default())
+
+	return jobs, resolver, handler, request, response
+}
+
+func jobSummary(running int) *api.JobSummary {
+	return &api.JobSummary{Summary: map[string]api.TaskGroupSummary{"group": {Running: running}}}
+}
+
+func TestFunctionsSummaryReportsZerosOnEmptyCluster(t *testing.T) {
+	jobs, _, handler, request, recorder := setupFunctionsSummaryHandler()
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{}, nil, nil)
+
+	handler(recorder, request)
+
+	summary := FunctionsSummary{}
+	json.Unmarshal(recorder.Body.Bytes(), &summary)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 0, summary.Functions)
+	assert.Equal(t, uint64(0), summary.Replicas)
+	assert.Equal(t, 0, summary.Degraded)
+	assert.Equal(t, 0, summary.Namespaces["default"])
+}
+
+func TestFunctionsSummaryReportsErrorWhenListingJobsFails(t *testing.T) {
+	jobs, _, handler, request, recorder := setupFunctionsSummaryHandler()
+	jobs.On("List", mock.Anything).Return(nil, nil, fmt.Errorf("failure"))
+
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestFunctionsSummaryAggregatesFunctions(t *testing.T) {
+	jobs, resolver, handler, request, recorder := setupFunctionsSummaryHandler()
+
+	list := []*api.JobListStub{
+		{ID: "faas-fn-healthy", Name: "faas-fn-healthy", Status: "running", JobSummary: jobSummary(2)},
+		{ID: "faas-fn-degraded", Name: "faas-fn-degraded", Status: "running", JobSummary: jobSummary(3)},
+		{ID: "faas-fn-stopped", Name: "faas-fn-stopped", Status: "dead", JobSummary: jobSummary(0)},
+	}
+
+	jobs.On("List", mock.Anything).Return(list, nil, nil)
+	resolver.On("ResolveAll", "healthy").Return([]url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}}, nil)
+	resolver.On("ResolveAll", "degraded").Return([]url.URL{{Host: "10.0.0.3:8080"}}, nil)
+
+	handler(recorder, request)
+
+	summary := FunctionsSummary{}
+	json.Unmarshal(recorder.Body.Bytes(), &summary)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 3, summary.Functions)
+	assert.Equal(t, uint64(5), summary.Replicas)
+	assert.Equal(t, uint64(3), summary.AvailableReplicas)
+	assert.Equal(t, 1, summary.Degraded)
+	assert.Equal(t, 3, summary.Namespaces["default"])
+	resolver.AssertNotCalled(t, "ResolveAll", "stopped")
+}
+
+type countingResolver struct {
+	*services.MockResolver
+}
+
+func (r countingResolver) CountInstances(functions []string) (map[string]int, error) {
+	args := r.Called(functions)
+	counts, _ := args.Get(0).(map[string]int)
+	return counts, args.Error(1)
+}
+
+func TestFunctionsSummaryCountsInstancesInSingleQuery(t *testing.T) {
+	jobs := &services.MockJobs{}
+	resolver := countingResolver{&services.MockResolver{}}
+
+	config := &types.ProviderConfig{Scheduling: types.SchedulingConfig{
+		JobPrefix: "faas-fn-",
+		Namespace: "default",
+	}}
+
+	list := []*api.JobListStub{
+		{ID: "faas-fn-healthy", Name: "faas-fn-healthy", Status: "running", JobSummary: jobSummary(2)},
+		{ID: "faas-fn-degraded", Name: "faas-fn-degraded", Status: "running", JobSummary: jobSummary(3)},
+		{ID: "faas-fn-stopped", Name: "faas-fn-stopped", Status: "dead", JobSummary: jobSummary(0)},
+	}
+
+	jobs.On("List", mock.Anything).Return(list, nil, nil)
+	resolver.On("CountInstances", []string{"healthy", "degraded"}).Return(map[string]int{"healthy": 2, "degraded": 1}, nil)
+
+	recorder := httptest.NewRecorder()
+	MakeFunctionsSummaryHandler(config, jobs, resolver, hclog.Default())(recorder, httptest.NewRequest("GET", "/system/functions/summary", nil))
+
+	summary := FunctionsSummary{}
+	json.Unmarshal(recorder.Body.Bytes(), &summary)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, uint64(3), summary.AvailableReplicas)
+	assert.Equal(t, 1, summary.Degraded)
+	resolver.AssertNumberOfCalls(t, "CountInstances", 1)
+	resolver.AssertNotCalled(t, "ResolveAll", mock.Anything)
+}
+
+func TestFunctionsSummaryReportsInvocationCount(t *testing.T) {
+	jobs, resolver, handler, request, recorder := setupFunctionsSummaryHandler()
+
+	list := []*api.JobListStub{
+		{ID: "faas-fn-summary-invoked", Name: "faas-fn-summary-invoked", Status: "running", JobSummary: jobSummary(1)},
+		{ID: "faas-fn-summary-stopped", Name: "faas-fn-summary-stopped", Status: "dead", JobSummary: jobSummary(0)},
+	}
+
+	jobs.On("List", mock.Anything).Return(list, nil, nil)
+	resolver.On("ResolveAll", "summary-invoked").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+
+	metrics.ProxyInvocations.WithLabelValues("summary-invoked").Add(3)
+	metrics.ProxyInvocations.WithLabelValues("summary-stopped").Add(2)
+	metrics.ProxyInvocations.WithLabelValues("summary-other").Add(5)
+
+	handler(recorder, request)
+
+	summary := FunctionsSummary{}
+	json.Unmarshal(recorder.Body.Bytes(), &summary)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, float64(5), summary.InvocationCount)
+}
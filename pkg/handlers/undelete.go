@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
+	"github.com/openfaas/faas-provider/httputil"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+// MakeDeletedFunctionsHandler lists the soft-deleted functions that are pending a purge.
+func MakeDeletedFunctionsHandler(deletes *softdelete.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pendingBytes, _ := json.Marshal(deletes.Pending())
+		w.Header().Set(HeaderContentType, TypeApplicationJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(pendingBytes)
+	}
+}
+
+// MakeUndeleteHandler restores a soft-deleted function during its grace period.
+func MakeUndeleteHandler(deletes *softdelete.Tracker, logger hclog.Logger) http.HandlerFunc {
+	log := logger.Named("undelete_handler")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := ftypes.DeleteFunctionRequest{}
+		if err := json.Unmarshal(body, &req); err != nil || len(req.FunctionName) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !deletes.IsPending(req.FunctionName) {
+			httputil.Errorf(w, http.StatusNotFound, "no pending delete found for function %s", req.FunctionName)
+			return
+		}
+
+		if err := deletes.Restore(req.FunctionName); err != nil {
+			writeNomadError(w, err)
+			log.Error("Error restoring function", "function", req.FunctionName, "error", err.Error())
+			return
+		}
+
+		log.Debug("Function restored successfully", "function", req.FunctionName)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/softdelete"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUndeleteHandlerRestoresFunction(t *testing.T) {
+	stop := true
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", false, mock.Anything).Return(nil, nil, nil)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(&api.Job{Stop: &stop}, nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	deletes := softdelete.NewTracker(jobs, time.Hour, hclog.Default())
+	_ = deletes.Delete("default", "faas-fn-echo", "echo")
+
+	request := httptest.NewRequest("POST", "/system/functions/undelete", bytes.NewReader([]byte(`{"functionName":"echo"}`)))
+	recorder := httptest.NewRecorder()
+
+	MakeUndeleteHandler(deletes, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, deletes.IsPending("echo"))
+	jobs.AssertCalled(t, "RegisterOpts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUndeleteHandlerReportsNotFoundWithoutPendingDelete(t *testing.T) {
+	deletes := softdelete.NewTracker(&services.MockJobs{}, time.Hour, hclog.Default())
+
+	request := httptest.NewRequest("POST", "/system/functions/undelete", bytes.NewReader([]byte(`{"functionName":"echo"}`)))
+	recorder := httptest.NewRecorder()
+
+	MakeUndeleteHandler(deletes, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestDeletedFunctionsHandlerListsPendingDeletes(t *testing.T) {
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", false, mock.Anything).Return(nil, nil, nil)
+
+	deletes := softdelete.NewTracker(jobs, time.Hour, hclog.Default())
+	_ = deletes.Delete("default", "faas-fn-echo", "echo")
+
+	request := httptest.NewRequest("GET", "/system/functions/deleted", nil)
+	recorder := httptest.NewRecorder()
+
+	MakeDeletedFunctionsHandler(deletes)(recorder, request)
+
+	var pending []softdelete.PendingDelete
+	_ = json.Unmarshal(recorder.Body.Bytes(), &pending)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "echo", pending[0].FunctionName)
+	assert.Equal(t, "default", pending[0].Namespace)
+}
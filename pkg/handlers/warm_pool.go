@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// WarmPool keeps the warm replicas of the functions with the `com.openfaas.warm` label drained from Consul.
+//
+// The job of such a function runs its replicas plus its warm replicas. The instances beyond the replicas are put
+// into Consul maintenance mode, so they are running but not resolved by the proxy. When the function scales up,
+// the drained instances are the first to be put in rotation, while Nomad places new allocations to replenish the
+// warm replicas, which are drained once they are healthy.
+type WarmPool struct {
+	config      *types.ProviderConfig
+	jobs        services.Jobs
+	maintenance services.ServiceMaintenance
+	interval    time.Duration
+	logger      hclog.Logger
+}
+
+func NewWarmPool(config *types.ProviderConfig, jobs services.Jobs, maintenance services.ServiceMaintenance, logger hclog.Logger) *WarmPool {
+	return &WarmPool{
+		config:      config,
+		jobs:        jobs,
+		maintenance: maintenance,
+		interval:    10 * time.Second,
+		logger:      logger.Named("warm_pool"),
+	}
+}
+
+// Start reconciles the warm replicas of all functions periodically, draining the allocations which became
+// healthy since the last run.
+func (p *WarmPool) Start() {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			p.reconcileAll()
+		}
+	}()
+}
+
+func (p *WarmPool) reconcileAll() {
+	namespace := p.config.Scheduling.Namespace
+	options := &api.QueryOptions{Namespace: namespace}
+
+	list, err := services.ListFunctionJobs(p.jobs, p.config.Scheduling.JobPrefix, options)
+	if err != nil {
+		p.logger.Warn("Error listing functions", "namespace", namespace, "error", err.Error())
+		return
+	}
+
+	for _, stub := range list {
+		if stub.Stop || stub.Type != api.JobTypeService {
+			continue
+		}
+		job, _, err := p.jobs.Info(stub.ID, options)
+		if err != nil {
+			p.logger.Warn("Error reading function", "function", stub.ID, "namespace", namespace, "error", err.Error())
+			continue
+		}
+		if err := p.reconcile(job); err != nil {
+			p.logger.Warn("Error reconciling warm replicas", "function", stub.ID, "namespace", namespace, "error", err.Error())
+		}
+	}
+}
+
+// Scaled puts the warm replicas of a function in rotation right after the job of the function was scaled to the
+// given count, so they take the traffic before the new allocations are placed.
+func (p *WarmPool) Scaled(job *api.Job, count int) {
+	if len(job.TaskGroups) == 0 {
+		return
+	}
+	job.TaskGroups[0].Count = &count
+
+	if err := p.reconcile(job); err != nil {
+		p.logger.Warn("Error reconciling warm replicas", "function", *job.ID, "namespace", p.config.Scheduling.Namespace, "error", err.Error())
+	}
+}
+
+func (p *WarmPool) reconcile(job *api.Job) error {
+	count, ok := currentReplicas(job)
+	if !ok {
+		return nil
+	}
+
+	// without warm replicas, no instance is drained, and a change of the label replaces the allocations anyway
+	warm := services.WarmReplicas(services.JobLabels(job))
+	if warm == 0 {
+		return nil
+	}
+
+	name := sanitiseJobName(job, p.config.Scheduling.JobPrefix)
+
+	service, err := p.config.Scheduling.ServiceName.Render(p.config.Scheduling.JobPrefix, name, p.config.Scheduling.Namespace)
+	if err != nil {
+		return err
+	}
+
+	instances, err := p.maintenance.Instances(service)
+	if err != nil {
+		return err
+	}
+
+	var drained, inRotation []services.ServiceInstance
+	for _, instance := range instances {
+		switch {
+		case instance.Drained:
+			drained = append(drained, instance)
+		case instance.Healthy:
+			inRotation = append(inRotation, instance)
+		}
+	}
+
+	// the healthy instances beyond the replicas are drained, up to the number of warm replicas
+	target := len(drained) + len(inRotation) - (count - warm)
+	if target > warm {
+		target = warm
+	}
+	if target < 0 {
+		target = 0
+	}
+
+	// instances are drained and put in rotation in a stable order, so runs don't flip the same instances
+	sort.Slice(drained, func(i, j int) bool { return drained[i].ID < drained[j].ID })
+	sort.Slice(inRotation, func(i, j int) bool { return inRotation[i].ID > inRotation[j].ID })
+
+	for i := 0; i < len(drained)-target; i++ {
+		if err := p.maintenance.SetMaintenance(drained[i], false); err != nil {
+			return err
+		}
+		p.logger.Debug("Warm replica put in rotation", "function", name, "instance", drained[i].ID)
+	}
+
+	for i := 0; i < target-len(drained) && i < len(inRotation); i++ {
+		if err := p.maintenance.SetMaintenance(inRotation[i], true); err != nil {
+			return err
+		}
+		p.logger.Debug("Warm replica drained", "function", name, "instance", inRotation[i].ID)
+	}
+
+	return nil
+}
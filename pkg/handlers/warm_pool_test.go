@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createWarmJob(count int, warm string) *api.Job {
+	id := "faas-fn-echo"
+	namespace := "default"
+	status := "running"
+	jobType := api.JobTypeService
+	submitTime := int64(0)
+	labels := []interface{}{map[string]interface{}{services.WarmLabel: warm}}
+	return &api.Job{
+		ID:         &id,
+		Name:       &id,
+		Namespace:  &namespace,
+		Status:     &status,
+		Type:       &jobType,
+		SubmitTime: &submitTime,
+		TaskGroups: []*api.TaskGroup{{
+			Count: &count,
+			Tasks: []*api.Task{{
+				Name:   "echo",
+				Config: map[string]interface{}{"image": "functions/echo", "labels": labels},
+			}},
+		}},
+	}
+}
+
+func setupWarmPool() (*services.MockServiceMaintenance, *WarmPool) {
+	config, _ := types.DefaultConfig()
+	maintenance := &services.MockServiceMaintenance{}
+	return maintenance, NewWarmPool(config, &services.MockJobs{}, maintenance, hclog.Default())
+}
+
+func TestWarmPoolDrainsWarmReplicas(t *testing.T) {
+	maintenance, pool := setupWarmPool()
+
+	instances := []services.ServiceInstance{
+		{ID: "a", Healthy: true},
+		{ID: "b", Healthy: true},
+		{ID: "c", Healthy: true},
+		{ID: "d", Healthy: false},
+	}
+	maintenance.On("Instances", "faas-fn-echo").Return(instances, nil)
+	maintenance.On("SetMaintenance", instances[2], true).Return(nil)
+
+	assert.NoError(t, pool.reconcile(createWarmJob(3, "1")))
+
+	maintenance.AssertExpectations(t)
+	maintenance.AssertNumberOfCalls(t, "SetMaintenance", 1)
+}
+
+func TestWarmPoolKeepsDrainedReplicas(t *testing.T) {
+	maintenance, pool := setupWarmPool()
+
+	instances := []services.ServiceInstance{
+		{ID: "a", Healthy: true},
+		{ID: "b", Healthy: true},
+		{ID: "c", Healthy: true, Drained: true},
+	}
+	maintenance.On("Instances", "faas-fn-echo").Return(instances, nil)
+
+	assert.NoError(t, pool.reconcile(createWarmJob(3, "1")))
+
+	maintenance.AssertNotCalled(t, "SetMaintenance", mock.Anything, mock.Anything)
+}
+
+func TestWarmPoolPutsWarmReplicasInRotationOnScaleUp(t *testing.T) {
+	maintenance, pool := setupWarmPool()
+
+	instances := []services.ServiceInstance{
+		{ID: "a", Healthy: true},
+		{ID: "b", Healthy: true, Drained: true},
+		{ID: "c", Healthy: true, Drained: true},
+	}
+	maintenance.On("Instances", "faas-fn-echo").Return(instances, nil)
+	maintenance.On("SetMaintenance", instances[1], false).Return(nil)
+	maintenance.On("SetMaintenance", instances[2], false).Return(nil)
+
+	// scaled from 1 to 3 replicas, with 2 warm replicas
+	pool.Scaled(createWarmJob(3, "2"), 5)
+
+	maintenance.AssertExpectations(t)
+}
+
+func TestWarmPoolIgnoresFunctionsWithoutWarmReplicas(t *testing.T) {
+	maintenance, pool := setupWarmPool()
+
+	assert.NoError(t, pool.reconcile(createWarmJob(3, "0")))
+
+	maintenance.AssertNotCalled(t, "Instances", mock.Anything)
+}
+
+func TestReplicaUpdaterAddsWarmReplicas(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	jobs := &services.MockJobs{}
+
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(createWarmJob(3, "2"), nil, nil)
+
+	count := 6
+	jobs.On("Scale", "faas-fn-echo", "echo", &count, mock.Anything, false, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	body, _ := json.Marshal(ftypes.ScaleServiceRequest{ServiceName: "echo", Replicas: 4})
+	request := httptest.NewRequest("POST", "/system/scale-function/echo", bytes.NewReader(body))
+	recorder := httptest.NewRecorder()
+
+	MakeReplicaUpdater(config, jobs, nil, nil, nil, nil, hclog.Default())(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	jobs.AssertExpectations(t)
+}
+
+func TestFunctionStatusExcludesWarmReplicas(t *testing.T) {
+	status := createFunctionStatus(createWarmJob(5, "2"), "faas-fn-")
+
+	assert.Equal(t, uint64(3), status.Replicas)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	warmupCountLabel   = "com.openfaas.warmup.count"
+	warmupPathLabel    = "com.openfaas.warmup.path"
+	warmupTimeoutLabel = "com.openfaas.warmup.timeout"
+
+	defaultWarmupPath    = "/_/health"
+	defaultWarmupTimeout = 5 * time.Minute
+)
+
+// FunctionWarmer issues warmup requests to the instances of a function once the deployment of its registered
+// version is healthy. The warmups in progress are abandoned when the warmer is stopped.
+type FunctionWarmer struct {
+	jobs         services.Jobs
+	resolver     resolver.ServiceResolver
+	client       *http.Client
+	pollInterval time.Duration
+	logger       hclog.Logger
+	stop         chan struct{}
+	once         sync.Once
+}
+
+func NewFunctionWarmer(jobs services.Jobs, resolver resolver.ServiceResolver, logger hclog.Logger) *FunctionWarmer {
+	return &FunctionWarmer{
+		jobs:         jobs,
+		resolver:     resolver,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pollInterval: 2 * time.Second,
+		logger:       logger.Named("warmup"),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Stop abandons the warmups in progress, e.g. when the provider shuts down.
+func (fw *FunctionWarmer) Stop() {
+	fw.once.Do(func() {
+		close(fw.stop)
+	})
+}
+
+// Warmup waits for the deployment of the registered version of the function to become healthy and then sends the number of warmup
+// requests configured with the `com.openfaas.warmup.count` label to every instance. Failures are logged and metered,
+// but never fail the deployment.
+func (fw *FunctionWarmer) Warmup(namespace, jobID, functionName string, labels *map[string]string) {
+	count := types.ParseIntValueFromMap(labels, warmupCountLabel, 0)
+	if count <= 0 {
+		return
+	}
+
+	path := types.ParseStringValueFromMap(labels, warmupPathLabel, defaultWarmupPath)
+	timeout := types.ParseIntOrDurationValueFromMap(labels, warmupTimeoutLabel, defaultWarmupTimeout)
+
+	ctx, cancel := fw.context(timeout)
+	defer cancel()
+
+	if err := fw.waitForDeployment(ctx, namespace, jobID, timeout); err != nil {
+		metrics.WarmupRequests.WithLabelValues(functionName, "skipped").Inc()
+		fw.logger.Warn("Skipping function warmup", "function", functionName, "namespace", namespace, "error", err.Error())
+		return
+	}
+
+	instances, err := fw.resolver.ResolveAll(functionName)
+	if err != nil {
+		metrics.WarmupRequests.WithLabelValues(functionName, "skipped").Inc()
+		fw.logger.Warn("Skipping function warmup", "function", functionName, "namespace", namespace, "error", err.Error())
+		return
+	}
+
+	for _, instance := range instances {
+		target := instance
+		target.Path = path
+
+		for i := 0; i < count; i++ {
+			if err := fw.warmupRequest(ctx, target.String()); err != nil {
+				metrics.WarmupRequests.WithLabelValues(functionName, "failure").Inc()
+				fw.logger.Warn("Function warmup request failed", "function", functionName, "target", target.String(), "error", err.Error())
+				continue
+			}
+			metrics.WarmupRequests.WithLabelValues(functionName, "success").Inc()
+		}
+	}
+
+	fw.logger.Debug("Function warmed up", "function", functionName, "namespace", namespace, "instances", len(instances), "requests", count)
+}
+
+// context returns the context of a warmup, which is done after the timeout of the warmup, or when the warmer is
+// stopped.
+func (fw *FunctionWarmer) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	go func() {
+		select {
+		case <-fw.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// waitForDeployment waits for the deployment of the registered version of the function, as the latest deployment
+// is the one of the previous version until the deployment of the registered version is created.
+func (fw *FunctionWarmer) waitForDeployment(ctx context.Context, namespace, jobID string, timeout time.Duration) error {
+	options := (&api.QueryOptions{Namespace: namespace}).WithContext(ctx)
+
+	job, _, err := fw.jobs.Info(jobID, options)
+	if err != nil {
+		return err
+	}
+	// system jobs have no deployments
+	if job.Version == nil || (job.Type != nil && *job.Type == api.JobTypeSystem) {
+		return nil
+	}
+
+	for {
+		deployment, _, err := fw.jobs.LatestDeployment(jobID, options)
+		if err != nil {
+			return err
+		}
+
+		if deployment != nil && registeredVersion(job, deployment.JobVersion) {
+			switch deployment.Status {
+			case "successful":
+				return nil
+			case "failed", "cancelled":
+				return fmt.Errorf("deployment %s is %s", deployment.ID, deployment.Status)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("deployment of version %d not healthy after %s", *job.Version, timeout)
+			}
+			return ctx.Err()
+		case <-time.After(fw.pollInterval):
+		}
+	}
+}
+
+func (fw *FunctionWarmer) warmupRequest(ctx context.Context, target string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := fw.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupFunctionWarmer(handler http.HandlerFunc) (*services.MockJobs, *services.MockResolver, *FunctionWarmer, *httptest.Server) {
+	jobs := &services.MockJobs{}
+	resolver := &services.MockResolver{}
+
+	server := httptest.NewServer(handler)
+	target, _ := url.Parse(server.URL)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{*target}, nil)
+
+	jobID := "faas-fn-echo"
+	version := uint64(2)
+	jobs.On("Info", jobID, mock.Anything).Return(&api.Job{ID: &jobID, Version: &version}, nil, nil)
+
+	warmer := NewFunctionWarmer(jobs, resolver, hclog.Default())
+	warmer.pollInterval = time.Millisecond
+
+	return jobs, resolver, warmer, server
+}
+
+func TestFunctionWarmerSendsWarmupRequestsWhenDeploymentIsHealthy(t *testing.T) {
+	var requests int32
+	var path atomic.Value
+
+	jobs, _, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		path.Store(r.URL.Path)
+	})
+	defer server.Close()
+
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 2, Status: "running"}, nil, nil).Once()
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 2, Status: "successful"}, nil, nil)
+
+	labels := map[string]string{
+		"com.openfaas.warmup.count": "3",
+		"com.openfaas.warmup.path":  "/warmup",
+	}
+	warmer.Warmup("default", "faas-fn-echo", "echo", &labels)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, "/warmup", path.Load())
+}
+
+func TestFunctionWarmerSkipsWarmupWhenDeploymentFails(t *testing.T) {
+	var requests int32
+
+	jobs, resolver, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+	defer server.Close()
+
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 2, Status: "failed"}, nil, nil)
+
+	labels := map[string]string{"com.openfaas.warmup.count": "3"}
+	warmer.Warmup("default", "faas-fn-echo", "echo", &labels)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
+
+func TestFunctionWarmerIsDisabledWithoutWarmupCount(t *testing.T) {
+	jobs, resolver, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	warmer.Warmup("default", "faas-fn-echo", "echo", nil)
+
+	jobs.AssertNotCalled(t, "LatestDeployment", mock.Anything, mock.Anything)
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
+
+func TestFunctionWarmerDoesNotFailOnWarmupErrors(t *testing.T) {
+	var requests int32
+
+	jobs, _, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer server.Close()
+
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 2, Status: "successful"}, nil, nil)
+
+	labels := map[string]string{"com.openfaas.warmup.count": "2"}
+	warmer.Warmup("default", "faas-fn-echo", "echo", &labels)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestFunctionWarmerAwaitsDeploymentOfRegisteredVersion(t *testing.T) {
+	var requests int32
+
+	jobs, resolver, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+	defer server.Close()
+
+	// the deployment of the previous version is returned until the deployment of the registered version is created
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 1, Status: "successful"}, nil, nil)
+
+	labels := map[string]string{"com.openfaas.warmup.count": "3", "com.openfaas.warmup.timeout": "20ms"}
+	warmer.Warmup("default", "faas-fn-echo", "echo", &labels)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
+
+func TestFunctionWarmerAbandonsWarmupWhenStopped(t *testing.T) {
+	jobs, resolver, warmer, server := setupFunctionWarmer(func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	jobs.On("LatestDeployment", "faas-fn-echo", mock.Anything).Return(&api.Deployment{ID: "1", JobVersion: 2, Status: "running"}, nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		labels := map[string]string{"com.openfaas.warmup.count": "3"}
+		warmer.Warmup("default", "faas-fn-echo", "echo", &labels)
+		close(done)
+	}()
+
+	warmer.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("warmup not abandoned after the warmer was stopped")
+	}
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
@@ -0,0 +1,136 @@
+package logbuffer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	queueSize = 256
+)
+
+// Entry is a log line of the provider.
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Name    string            `json:"name"`
+	Message string            `json:"message"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+type entry struct {
+	Entry
+	level hclog.Level
+}
+
+// Buffer is a hclog sink keeping the most recent log lines of the provider in memory.
+//
+// Log lines are handed over to the buffer through a queue, and dropped when the queue is full,
+// so logging never blocks on readers of the buffer.
+type Buffer struct {
+	level   int32
+	queue   chan entry
+	dropped uint64
+
+	mu      sync.RWMutex
+	entries []entry
+	next    int
+	full    bool
+}
+
+// NewBuffer creates a buffer of the given number of log lines, which keeps no lines when the size isn't positive.
+func NewBuffer(size int, level hclog.Level) *Buffer {
+	if size < 0 {
+		size = 0
+	}
+	b := &Buffer{
+		level:   int32(level),
+		queue:   make(chan entry, queueSize),
+		entries: make([]entry, size),
+	}
+	go b.run()
+	return b
+}
+
+// SetLevel changes the minimum level of the log lines kept by the buffer.
+func (b *Buffer) SetLevel(level hclog.Level) {
+	atomic.StoreInt32(&b.level, int32(level))
+}
+
+// Dropped returns the number of log lines dropped because the queue was full.
+func (b *Buffer) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Accept implements hclog.SinkAdapter.
+func (b *Buffer) Accept(name string, level hclog.Level, msg string, args ...interface{}) {
+	if level < hclog.Level(atomic.LoadInt32(&b.level)) {
+		return
+	}
+
+	e := entry{
+		Entry: Entry{
+			Time:    time.Now(),
+			Level:   level.String(),
+			Name:    name,
+			Message: msg,
+		},
+		level: level,
+	}
+
+	if len(args) != 0 {
+		e.Args = map[string]string{}
+		for i := 0; i < len(args); i += 2 {
+			key := fmt.Sprintf("%v", args[i])
+			if i+1 < len(args) {
+				e.Args[key] = fmt.Sprintf("%v", args[i+1])
+			} else {
+				e.Args[key] = ""
+			}
+		}
+	}
+
+	select {
+	case b.queue <- e:
+	default:
+		atomic.AddUint64(&b.dropped, 1)
+	}
+}
+
+// Entries returns the buffered log lines at or above the given level, oldest first.
+func (b *Buffer) Entries(level hclog.Level) []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var ordered []entry
+	if b.full {
+		ordered = append(ordered, b.entries[b.next:]...)
+	}
+	ordered = append(ordered, b.entries[:b.next]...)
+
+	result := []Entry{}
+	for _, e := range ordered {
+		if e.level >= level {
+			result = append(result, e.Entry)
+		}
+	}
+	return result
+}
+
+func (b *Buffer) run() {
+	for e := range b.queue {
+		b.mu.Lock()
+		if len(b.entries) != 0 {
+			b.entries[b.next] = e
+			b.next = (b.next + 1) % len(b.entries)
+			if b.next == 0 {
+				b.full = true
+			}
+		}
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,83 @@
+package logbuffer
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func interceptLogger(buffer *Buffer) hclog.Logger {
+	logger := hclog.NewInterceptLogger(&hclog.LoggerOptions{Name: "faas-nomad", Level: hclog.Info, Output: ioutil.Discard})
+	logger.RegisterSink(buffer)
+	return logger
+}
+
+func waitForEntries(t *testing.T, buffer *Buffer, count int) []Entry {
+	assert.Eventually(t, func() bool {
+		return len(buffer.Entries(hclog.Trace)) == count
+	}, time.Second, 5*time.Millisecond)
+	return buffer.Entries(hclog.Trace)
+}
+
+func TestBufferKeepsMostRecentEntries(t *testing.T) {
+	buffer := NewBuffer(3, hclog.Info)
+	logger := interceptLogger(buffer)
+
+	logger.Info("first")
+	logger.Info("second")
+	waitForEntries(t, buffer, 2)
+
+	logger.Info("third")
+	logger.Info("fourth")
+	time.Sleep(10 * time.Millisecond)
+
+	entries := waitForEntries(t, buffer, 3)
+	assert.Equal(t, "second", entries[0].Message)
+	assert.Equal(t, "third", entries[1].Message)
+	assert.Equal(t, "fourth", entries[2].Message)
+}
+
+func TestBufferFiltersOnLevel(t *testing.T) {
+	buffer := NewBuffer(10, hclog.Info)
+	logger := interceptLogger(buffer).Named("proxy")
+
+	logger.Debug("ignored")
+	logger.Info("started", "port", 8080)
+	logger.Error("failed", "error", "timeout")
+
+	entries := waitForEntries(t, buffer, 2)
+	assert.Equal(t, "faas-nomad.proxy", entries[0].Name)
+	assert.Equal(t, "info", entries[0].Level)
+	assert.Equal(t, map[string]string{"port": "8080"}, entries[0].Args)
+
+	errors := buffer.Entries(hclog.Error)
+	assert.Len(t, errors, 1)
+	assert.Equal(t, "failed", errors[0].Message)
+
+	buffer.SetLevel(hclog.Debug)
+	logger.Debug("included")
+	entries = waitForEntries(t, buffer, 3)
+	assert.Equal(t, "included", entries[2].Message)
+}
+
+func TestBufferDropsEntriesWhenQueueIsFull(t *testing.T) {
+	buffer := &Buffer{queue: make(chan entry, 1), entries: make([]entry, 10)}
+
+	buffer.Accept("faas-nomad", hclog.Info, "first")
+	buffer.Accept("faas-nomad", hclog.Info, "second")
+
+	assert.Equal(t, uint64(1), buffer.Dropped())
+}
+
+func TestBufferOfNegativeSizeKeepsNoEntries(t *testing.T) {
+	buffer := NewBuffer(-1, hclog.Info)
+	logger := interceptLogger(buffer)
+
+	logger.Info("ignored")
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Empty(t, buffer.Entries(hclog.Trace))
+}
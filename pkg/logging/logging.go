@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/logbuffer"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses a log level, e.g. info or debug.
+func ParseLevel(value string) (hclog.Level, error) {
+	level := hclog.LevelFromString(value)
+	if level == hclog.NoLevel {
+		return level, fmt.Errorf("invalid log level '%s'", value)
+	}
+	return level, nil
+}
+
+// New creates the logger shared by the provider, writing in the configured format and level to stdout or the
+// configured file. When the log buffer is enabled, the logger also feeds the returned buffer.
+func New(config types.LogConfig) (hclog.Logger, *logbuffer.Buffer, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format := strings.ToLower(config.Format)
+	if format != FormatText && format != FormatJSON {
+		return nil, nil, fmt.Errorf("invalid log format '%s'", config.Format)
+	}
+
+	output, fileErr := openOutput(config.File)
+
+	options := &hclog.LoggerOptions{
+		Name:       "faas-nomad",
+		Level:      level,
+		JSONFormat: format == FormatJSON,
+		Output:     output,
+	}
+
+	var logger hclog.Logger
+	var buffer *logbuffer.Buffer
+
+	if config.BufferEnabled {
+		intercept := hclog.NewInterceptLogger(options)
+		buffer = logbuffer.NewBuffer(config.BufferSize, level)
+		intercept.RegisterSink(buffer)
+		logger = intercept
+	} else {
+		logger = hclog.New(options)
+	}
+
+	if fileErr != nil {
+		logger.Warn("Unable to open file for output, defaulting to stdout", "file", config.File, "error", fileErr.Error())
+	}
+
+	return logger, buffer, nil
+}
+
+// RedirectStandardLog sends the output of the standard logger, as used by libraries, to the logger.
+func RedirectStandardLog(logger hclog.Logger) {
+	options := &hclog.StandardLoggerOptions{InferLevels: true}
+	if intercept, ok := logger.(hclog.InterceptLogger); ok {
+		log.SetOutput(intercept.StandardWriterIntercept(options))
+	} else {
+		log.SetOutput(logger.StandardWriter(options))
+	}
+	log.SetPrefix("")
+	log.SetFlags(0)
+}
+
+func openOutput(file string) (io.Writer, error) {
+	if file == "" {
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return os.Stdout, err
+	}
+	return f, nil
+}
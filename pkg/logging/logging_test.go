@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func logToFile(t *testing.T, config types.LogConfig) (hclog.Logger, string) {
+	config.File = filepath.Join(t.TempDir(), "provider.log")
+
+	logger, _, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, config.File
+}
+
+func readLines(t *testing.T, file string) []string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestLoggerHonorsConfiguredLevel(t *testing.T) {
+	logger, file := logToFile(t, types.LogConfig{Level: "warn", Format: "text"})
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Warn("warn message")
+	logger.Error("error message")
+
+	lines := readLines(t, file)
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "warn message")
+	assert.Contains(t, lines[1], "error message")
+	assert.False(t, logger.IsInfo())
+	assert.True(t, logger.IsWarn())
+}
+
+func TestLoggerWritesJSON(t *testing.T) {
+	logger, file := logToFile(t, types.LogConfig{Level: "info", Format: "JSON"})
+
+	logger.Named("resolver").Info("resolved", "function", "echo")
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(readLines(t, file)[0]), &entry))
+	assert.Equal(t, "resolved", entry["@message"])
+	assert.Equal(t, "faas-nomad.resolver", entry["@module"])
+	assert.Equal(t, "echo", entry["function"])
+}
+
+func TestLoggerFeedsBufferAtConfiguredLevel(t *testing.T) {
+	logger, buffer, err := New(types.LogConfig{Level: "error", Format: "text", File: filepath.Join(t.TempDir(), "provider.log"), BufferEnabled: true, BufferSize: 10})
+	assert.NoError(t, err)
+	assert.NotNil(t, buffer)
+
+	logger.Info("info message")
+	logger.Error("error message")
+
+	assert.Eventually(t, func() bool { return len(buffer.Entries(hclog.Trace)) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	_, _, err := New(types.LogConfig{Level: "verbose", Format: "text"})
+	assert.Error(t, err)
+
+	_, _, err = New(types.LogConfig{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package maintenance
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+const (
+	defaultRetryAfter = 5 * time.Second
+)
+
+// Mode keeps track of the draining state of the provider.
+//
+// While draining, new function invocations are rejected and the health endpoint reports
+// not-ready, but invocations that were already accepted are allowed to complete.
+type Mode struct {
+	draining   int32
+	inflight   int64
+	retryAfter time.Duration
+	logger     hclog.Logger
+}
+
+func NewMode(logger hclog.Logger) *Mode {
+	return &Mode{
+		retryAfter: defaultRetryAfter,
+		logger:     logger.Named("maintenance"),
+	}
+}
+
+func (m *Mode) Draining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
+func (m *Mode) SetDraining(draining bool) {
+	var value int32
+	if draining {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&m.draining, value) == value {
+		return
+	}
+
+	metrics.MaintenanceMode.Set(float64(value))
+
+	if draining {
+		m.logger.Info("Provider entered maintenance mode, rejecting new invocations", "inflight", m.InFlight())
+	} else {
+		m.logger.Info("Provider left maintenance mode, accepting invocations")
+	}
+}
+
+func (m *Mode) InFlight() int64 {
+	return atomic.LoadInt64(&m.inflight)
+}
+
+// Wrap decorates a function proxy handler so that new requests are rejected with a 503 while draining.
+func (m *Mode) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.Draining() {
+			metrics.RejectedRequests.WithLabelValues("maintenance").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+			httputil.Errorf(w, http.StatusServiceUnavailable, "Provider is in maintenance mode.")
+			return
+		}
+
+		atomic.AddInt64(&m.inflight, 1)
+		metrics.InflightRequests.Inc()
+		defer func() {
+			atomic.AddInt64(&m.inflight, -1)
+			metrics.InflightRequests.Dec()
+		}()
+
+		next(w, r)
+	}
+}
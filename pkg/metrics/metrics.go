@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	namespace = "faas_nomad"
+)
+
+var (
+	MaintenanceMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "maintenance_mode",
+		Help:      "Whether the provider is draining (1) or serving (0).",
+	})
+
+	InflightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_inflight_requests",
+		Help:      "Number of function invocations currently being proxied.",
+	})
+
+	RejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_rejected_requests_total",
+		Help:      "Number of function invocations rejected by the provider.",
+	}, []string{"reason"})
+
+	ProxyInvocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_invocations_total",
+		Help:      "Number of function invocations proxied by the provider.",
+	}, []string{"function"})
+
+	ProxyMirroredRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_mirrored_requests_total",
+		Help:      "Number of function invocations mirrored to a shadow function, by result (sent, skipped or dropped).",
+	}, []string{"function", "result"})
+
+	ProxyRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_retries_total",
+		Help:      "Number of failed function invocations retried on another instance, by result (sent or suppressed by the retry budget).",
+	}, []string{"function", "result"})
+
+	ProxyRetryRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_retry_ratio",
+		Help:      "Ratio of the retries to the function invocations over the window of the retry budget.",
+	})
+
+	ConsulWatchErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consul_watch_errors_total",
+		Help:      "Number of errors reported by the Consul watcher of the resolver.",
+	})
+
+	ResolverDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "resolver_degraded",
+		Help:      "Whether the Consul watcher of the resolver is failing repeatedly (1) or healthy (0).",
+	})
+
+	ResolverDroppedChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resolver_dropped_changes_total",
+		Help:      "Number of service changes dropped because a subscriber of the resolver didn't keep up.",
+	})
+
+	ColdStarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cold_starts_total",
+		Help:      "Number of cold starts of functions, started by an invocation finding no available instances.",
+	}, []string{"function"})
+
+	ColdStartDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cold_start_duration_seconds",
+		Help:      "Time from the first invocation finding no available instances to the first invocation resolving an instance again.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"function"})
+
+	FunctionsAtZero = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "functions_at_zero_replicas",
+		Help:      "Number of invoked functions currently without available instances, whose cold start is in progress.",
+	})
+
+	WarmupRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "warmup_requests_total",
+		Help:      "Number of warmup requests issued to function instances after a deploy.",
+	}, []string{"function", "result"})
+
+	ProxyLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_latency_seconds",
+		Help:      "Time to respond to the function invocations with a latency objective, as seen by the proxy.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"function"})
+
+	SLOSlowRatio = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "slo", "slow_requests_ratio"),
+		"Fraction of the function invocations exceeding the latency objective of the function, per window.",
+		[]string{"function", "window"}, nil,
+	)
+
+	SLOBurnRate = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "slo", "burn_rate"),
+		"Rate at which the error budget of the latency objective of the function is spent, per window: 1 spends the budget exactly.",
+		[]string{"function", "window"}, nil,
+	)
+)
+
+// Register registers a collector computing its metrics when they are scraped.
+func Register(collector prometheus.Collector) {
+	prometheus.MustRegister(collector)
+}
+
+// Invocations returns the number of invocations of a function proxied by the provider since it started.
+func Invocations(function string) float64 {
+	counter, err := ProxyInvocations.GetMetricWithLabelValues(function)
+	if err != nil {
+		return 0
+	}
+	var m dto.Metric
+	if err := counter.Write(&m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+func MakeHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	SignatureHeader = "X-Faas-Signature"
+
+	AuthNone   = "none"
+	AuthBearer = "bearer"
+	AuthHMAC   = "hmac"
+
+	authLabel       = "com.openfaas.auth"
+	authSecretLabel = "com.openfaas.auth.secret"
+
+	authSecretCacheExpiry = 30 * time.Second
+)
+
+// SecretReader provides the value of a secret, so that middlewares can verify credentials against it.
+type SecretReader interface {
+	Get(namespace, key string) (string, error)
+}
+
+type authSecret struct {
+	value  string
+	expiry time.Time
+}
+
+type authenticator struct {
+	namespace string
+	labels    LabelsReader
+	secrets   SecretReader
+	cache     sync.Map
+}
+
+// NewAuthMiddleware rejects function invocations that are not authenticated with a 401, before the request is
+// resolved or proxied to an instance of the function.
+//
+// The scheme is selected per function with the `com.openfaas.auth` label:
+//
+//   - `none` (default) does not authenticate the invocations.
+//   - `bearer` requires an `Authorization: Bearer <token>` header matching the secret.
+//   - `hmac` requires an `X-Faas-Signature: sha256=<hex>` header with the HMAC-SHA256 of the request body,
+//     keyed with the secret.
+//
+// The secret is read from the secret store and defaults to `<function>-auth`; it can be overridden with the
+// `com.openfaas.auth.secret` label. Secret values are cached for a short while to keep them off the hot path.
+// Invocations of functions whose labels or secret can't be read are rejected as well.
+func NewAuthMiddleware(namespace string, labels LabelsReader, secrets SecretReader) Middleware {
+	a := &authenticator{namespace: namespace, labels: labels, secrets: secrets}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			functionName := mux.Vars(r)["name"]
+
+			scheme, secretName, err := a.scheme(functionName)
+			if err != nil {
+				writeProxyError(w, r, http.StatusUnauthorized, functionName, "unable to authenticate invocation of %s: %s", functionName, err.Error())
+				return
+			}
+
+			if scheme == AuthNone {
+				next(w, r)
+				return
+			}
+
+			secret, err := a.secret(secretName)
+			if err != nil {
+				writeProxyError(w, r, http.StatusUnauthorized, functionName, "unable to authenticate invocation of %s: %s", functionName, err.Error())
+				return
+			}
+
+			switch scheme {
+			case AuthBearer:
+				err = verifyBearer(r, secret)
+			case AuthHMAC:
+				err = verifySignature(r, secret)
+			}
+
+			if err != nil {
+				if scheme == AuthBearer {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				writeProxyError(w, r, http.StatusUnauthorized, functionName, "%s", err.Error())
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func (a *authenticator) scheme(functionName string) (string, string, error) {
+	if a.labels == nil || functionName == "" {
+		return AuthNone, "", nil
+	}
+
+	values, err := a.labels.Labels(functionName)
+	if err != nil {
+		return "", "", err
+	}
+
+	scheme := strings.ToLower(strings.TrimSpace(values[authLabel]))
+	switch scheme {
+	case "", AuthNone:
+		return AuthNone, "", nil
+	case AuthBearer, AuthHMAC:
+	default:
+		return "", "", fmt.Errorf("unsupported auth scheme %s", scheme)
+	}
+
+	secretName := values[authSecretLabel]
+	if secretName == "" {
+		name := strings.TrimSuffix(functionName, "."+a.namespace)
+		secretName = name + "-auth"
+	}
+
+	return scheme, secretName, nil
+}
+
+func (a *authenticator) secret(name string) (string, error) {
+	if val, ok := a.cache.Load(name); ok {
+		item := val.(*authSecret)
+		if time.Now().Before(item.expiry) {
+			return item.value, nil
+		}
+	}
+
+	encoded, err := a.secrets.Get(a.namespace, name)
+	if err != nil {
+		return "", err
+	}
+
+	// secrets are stored base64 encoded, as they are rendered with base64Decode in the templates of the functions
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("secret %s is not base64 encoded", name)
+	}
+	value := string(decoded)
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", name)
+	}
+
+	a.cache.Store(name, &authSecret{value: value, expiry: time.Now().Add(authSecretCacheExpiry)})
+
+	return value, nil
+}
+
+func verifyBearer(r *http.Request, secret string) error {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return fmt.Errorf("missing bearer token")
+	}
+
+	token := strings.TrimSpace(header[7:])
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return fmt.Errorf("invalid bearer token")
+	}
+
+	return nil
+}
+
+func verifySignature(r *http.Request, secret string) error {
+	header := r.Header.Get(SignatureHeader)
+	if !strings.HasPrefix(header, "sha256=") {
+		return fmt.Errorf("missing %s header", SignatureHeader)
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read request body")
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func authRequest(name string, body string) *http.Request {
+	request := httptest.NewRequest("POST", "/function/"+name, strings.NewReader(body))
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthMiddlewarePassesFunctionsWithoutAuth(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{}, nil)
+	secrets := &services.MockSecrets{}
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, authRequest("echo", "hello"))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+	secrets.AssertNotCalled(t, "Get", "default", "echo-auth")
+}
+
+func TestAuthMiddlewareAcceptsValidBearerToken(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "bearer"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "echo-auth").Return(base64.StdEncoding.EncodeToString([]byte("s3cr3t")), nil).Once()
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	for i := 0; i < 2; i++ {
+		request := authRequest("echo", "hello")
+		request.Header.Set("Authorization", "Bearer s3cr3t")
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "hello", recorder.Body.String())
+	}
+	secrets.AssertExpectations(t)
+}
+
+func TestAuthMiddlewareRejectsInvalidBearerToken(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "bearer"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "echo-auth").Return(base64.StdEncoding.EncodeToString([]byte("s3cr3t")), nil)
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	for _, header := range []string{"", "Bearer wrong", "Basic s3cr3t"} {
+		request := authRequest("echo", "hello")
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, header)
+		assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAuthMiddlewareUsesSecretFromLabel(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo.default").Return(map[string]string{"com.openfaas.auth": "bearer", "com.openfaas.auth.secret": "shared-token"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "shared-token").Return(base64.StdEncoding.EncodeToString([]byte("s3cr3t")), nil)
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	request := authRequest("echo.default", "hello")
+	request.Header.Set("Authorization", "Bearer s3cr3t")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestAuthMiddlewareAcceptsValidSignature(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "hmac"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "echo-auth").Return(base64.StdEncoding.EncodeToString([]byte("s3cr3t")), nil)
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	request := authRequest("echo", "hello")
+	request.Header.Set(SignatureHeader, sign("s3cr3t", "hello"))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+}
+
+func TestAuthMiddlewareRejectsInvalidSignature(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "hmac"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "echo-auth").Return(base64.StdEncoding.EncodeToString([]byte("s3cr3t")), nil)
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	for _, header := range []string{"", sign("wrong", "hello"), sign("s3cr3t", "tampered"), "sha256=zz"} {
+		request := authRequest("echo", "hello")
+		if header != "" {
+			request.Header.Set(SignatureHeader, header)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, header)
+	}
+}
+
+func TestAuthMiddlewareRejectsWhenSecretIsMissing(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "bearer"}, nil)
+	secrets := &services.MockSecrets{}
+	secrets.On("Get", "default", "echo-auth").Return("", fmt.Errorf("secret echo-auth not found"))
+
+	handler := NewAuthMiddleware("default", labels, secrets)(echoBody)
+
+	request := authRequest("echo", "hello")
+	request.Header.Set("Authorization", "Bearer ")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestAuthMiddlewareRejectsUnsupportedScheme(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{"com.openfaas.auth": "digest"}, nil)
+
+	handler := NewAuthMiddleware("default", labels, &services.MockSecrets{})(echoBody)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, authRequest("echo", "hello"))
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ColourReader provides the active colour of a function deployed blue/green, or an empty colour for any other
+// function.
+type ColourReader interface {
+	Active(functionName string) (string, error)
+}
+
+// NewBlueGreenMiddleware routes the requests of a function deployed blue/green to the job of its active colour,
+// e.g. echo to echo-green, so the labels and the instances of the active colour apply to the request. When the
+// active colour can't be read, the last known colour is used.
+func NewBlueGreenMiddleware(colours ColourReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			functionName := vars["name"]
+			if functionName == "" {
+				next(w, r)
+				return
+			}
+
+			name, suffix := functionName, ""
+			if idx := strings.LastIndex(functionName, "."); idx > 0 {
+				name, suffix = functionName[:idx], functionName[idx:]
+			}
+
+			colour, _ := colours.Active(name)
+			if len(colour) == 0 {
+				next(w, r)
+				return
+			}
+
+			routed := make(map[string]string, len(vars))
+			for k, v := range vars {
+				routed[k] = v
+			}
+			routed["name"] = name + "-" + colour + suffix
+
+			next(w, mux.SetURLVars(r, routed))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticColours map[string]string
+
+func (c staticColours) Active(functionName string) (string, error) {
+	return c[functionName], nil
+}
+
+func TestBlueGreenMiddlewareRoutesToActiveColour(t *testing.T) {
+	colours := staticColours{"echo": "green"}
+
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"echo", "echo-green"},
+		{"echo.default", "echo-green.default"},
+		{"figlet", "figlet"},
+	} {
+		var routed string
+		handler := NewBlueGreenMiddleware(colours)(func(w http.ResponseWriter, r *http.Request) {
+			routed = mux.Vars(r)["name"]
+		})
+
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/function/"+tc.name, nil), map[string]string{"name": tc.name})
+		handler(httptest.NewRecorder(), request)
+
+		assert.Equal(t, tc.expected, routed, tc.name)
+	}
+}
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	CacheHeader = "X-Faas-Cache"
+
+	cacheTTLLabel     = "com.openfaas.cache-ttl"
+	maxCacheEntrySize = 1024 * 1024
+)
+
+type cacheEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	expiry time.Time
+}
+
+// ResponseCache caches successful GET and HEAD responses of functions with a `com.openfaas.cache-ttl` label,
+// or of all functions when the settings have a default cache TTL.
+//
+// Responses are keyed by function, method, path and query, and are served from the cache until the TTL expires.
+// Requests or responses with `Cache-Control: no-store` bypass the cache, as well as requests pinned to an instance.
+// The number of cached responses is bounded by the configured cache size, evicting the least recently used,
+// and a cache size of 0 disables the cache.
+type ResponseCache struct {
+	cache    *lru.Cache
+	labels   LabelsReader
+	settings *Settings
+
+	mu sync.Mutex
+	// removals counts the removals per function, so a response still in flight when its function was removed
+	// isn't cached after the removal
+	removals map[string]uint64
+}
+
+func NewResponseCache(config types.ProxyConfig, labels LabelsReader, settings *Settings) (*ResponseCache, error) {
+	c := &ResponseCache{labels: labels, settings: settings, removals: map[string]uint64{}}
+	if config.CacheSize <= 0 {
+		return c, nil
+	}
+
+	cache, err := lru.New(config.CacheSize)
+	if err != nil {
+		return nil, err
+	}
+	c.cache = cache
+	return c, nil
+}
+
+// NewCacheMiddleware returns the middleware of a new response cache.
+func NewCacheMiddleware(config types.ProxyConfig, labels LabelsReader, settings *Settings) (Middleware, error) {
+	c, err := NewResponseCache(config, labels, settings)
+	if err != nil {
+		return nil, err
+	}
+	return c.Middleware(), nil
+}
+
+// Middleware serves the cached responses, and caches the responses of the functions with a cache TTL.
+func (c *ResponseCache) Middleware() Middleware {
+	if c.cache == nil {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			functionName := mux.Vars(r)["name"]
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead || noStore(r.Header) {
+				next(w, r)
+				return
+			}
+
+			if _, pinned := pinnedInstance(r.Context()); pinned {
+				next(w, r)
+				return
+			}
+
+			ttl := cacheTTL(c.labels, functionName, c.settings.CacheTTL())
+			if ttl <= 0 {
+				next(w, r)
+				return
+			}
+
+			key := cacheKey(functionName, r.Method, mux.Vars(r)["params"], r.URL.RawQuery)
+
+			if value, ok := c.cache.Get(key); ok {
+				entry := value.(*cacheEntry)
+				if time.Now().Before(entry.expiry) {
+					copyHeaders(w.Header(), &entry.header)
+					w.Header().Set(CacheHeader, "HIT")
+					w.WriteHeader(entry.status)
+					w.Write(entry.body)
+					return
+				}
+				c.cache.Remove(key)
+			}
+
+			w.Header().Set(CacheHeader, "MISS")
+
+			removals := c.removalsOf(functionName)
+
+			cw := &cacheResponseWriter{ResponseWriter: w}
+			next(cw, r)
+
+			if cw.status == http.StatusOK && !cw.overflow && !noStore(cw.Header()) {
+				header := http.Header{}
+				copyHeaders(header, &cw.header)
+				header.Del(CacheHeader)
+
+				c.add(functionName, removals, key, &cacheEntry{
+					status: cw.status,
+					header: header,
+					body:   cw.body,
+					expiry: time.Now().Add(ttl),
+				})
+			}
+		}
+	}
+}
+
+// RemoveCacheItem drops the cached responses of a function, e.g. when it is deleted.
+func (c *ResponseCache) RemoveCacheItem(functionName string) {
+	if c.cache == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removals[functionName]++
+	prefix := cacheKey(functionName, "")
+	for _, key := range c.cache.Keys() {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.cache.Remove(key)
+		}
+	}
+}
+
+func (c *ResponseCache) removalsOf(functionName string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removals[functionName]
+}
+
+// add caches a response, unless the function was removed since the request was proxied.
+func (c *ResponseCache) add(functionName string, removals uint64, key string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.removals[functionName] != removals {
+		return
+	}
+	c.cache.Add(key, entry)
+}
+
+func cacheKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+func cacheTTL(labels LabelsReader, functionName string, defaultTTL time.Duration) time.Duration {
+	if labels == nil || functionName == "" {
+		return 0
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return 0
+	}
+	return types.ParseIntOrDurationValueFromMap(&values, cacheTTLLabel, defaultTTL)
+}
+
+func noStore(header http.Header) bool {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if strings.TrimSpace(strings.ToLower(directive)) == "no-store" {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheResponseWriter records the response of a function while it is written to the client,
+// giving up on recording the body once it exceeds the maximum size of a cache entry.
+type cacheResponseWriter struct {
+	http.ResponseWriter
+
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+}
+
+func (c *cacheResponseWriter) WriteHeader(status int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = status
+	c.header = c.Header().Clone()
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheResponseWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if !c.overflow {
+		if len(c.body)+len(b) > maxCacheEntrySize {
+			c.overflow = true
+			c.body = nil
+		} else {
+			c.body = append(c.body, b...)
+		}
+	}
+
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cacheResponseWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *cacheResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func cacheRequest(method string, name string, query string) *http.Request {
+	request := httptest.NewRequest(method, "/function/"+name+"?"+query, nil)
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func countingHandler(calls *int, status int, cacheControl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "response %d", *calls)
+	}
+}
+
+func setupCacheMiddleware(t *testing.T, size int, labels map[string]string, next http.HandlerFunc) http.HandlerFunc {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "catalog").Return(labels, nil)
+
+	middleware, err := NewCacheMiddleware(types.ProxyConfig{CacheSize: size}, reader, NewSettings(&types.ProviderConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return middleware(next)
+}
+
+func TestCacheMiddlewareServesCachedResponses(t *testing.T) {
+	calls := 0
+	handler := setupCacheMiddleware(t, 10, map[string]string{"com.openfaas.cache-ttl": "1m"}, countingHandler(&calls, http.StatusOK, ""))
+
+	first := httptest.NewRecorder()
+	handler(first, cacheRequest("GET", "catalog", "page=1"))
+
+	second := httptest.NewRecorder()
+	handler(second, cacheRequest("GET", "catalog", "page=1"))
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "MISS", first.Header().Get(CacheHeader))
+	assert.Equal(t, "HIT", second.Header().Get(CacheHeader))
+	assert.Equal(t, "response 1", second.Body.String())
+
+	other := httptest.NewRecorder()
+	handler(other, cacheRequest("GET", "catalog", "page=2"))
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "MISS", other.Header().Get(CacheHeader))
+}
+
+func TestCacheMiddlewareSkipsFunctionsWithoutTTL(t *testing.T) {
+	calls := 0
+	handler := setupCacheMiddleware(t, 10, map[string]string{}, countingHandler(&calls, http.StatusOK, ""))
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		handler(recorder, cacheRequest("GET", "catalog", ""))
+		assert.Equal(t, "", recorder.Header().Get(CacheHeader))
+	}
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestCacheMiddlewareSkipsUncacheableResponses(t *testing.T) {
+	labels := map[string]string{"com.openfaas.cache-ttl": "60"}
+
+	tests := []struct {
+		name         string
+		method       string
+		status       int
+		cacheControl string
+	}{
+		{name: "post", method: "POST", status: http.StatusOK},
+		{name: "error", method: "GET", status: http.StatusInternalServerError},
+		{name: "no-store", method: "GET", status: http.StatusOK, cacheControl: "private, no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := setupCacheMiddleware(t, 10, labels, countingHandler(&calls, tt.status, tt.cacheControl))
+
+			for i := 0; i < 2; i++ {
+				handler(httptest.NewRecorder(), cacheRequest(tt.method, "catalog", ""))
+			}
+
+			assert.Equal(t, 2, calls)
+		})
+	}
+}
+
+func TestCacheMiddlewareEvictsLeastRecentlyUsed(t *testing.T) {
+	calls := 0
+	handler := setupCacheMiddleware(t, 1, map[string]string{"com.openfaas.cache-ttl": "1m"}, countingHandler(&calls, http.StatusOK, ""))
+
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog", "page=1"))
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog", "page=2"))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, cacheRequest("GET", "catalog", "page=1"))
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "MISS", recorder.Header().Get(CacheHeader))
+}
+
+func TestResponseCacheRemovesResponsesOfFunction(t *testing.T) {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", mock.Anything).Return(map[string]string{"com.openfaas.cache-ttl": "1m"}, nil)
+
+	cache, err := NewResponseCache(types.ProxyConfig{CacheSize: 10}, reader, NewSettings(&types.ProviderConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	handler := cache.Middleware()(countingHandler(&calls, http.StatusOK, ""))
+
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog", "page=1"))
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog", "page=2"))
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog-v2", "page=1"))
+
+	cache.RemoveCacheItem("catalog")
+
+	removed := httptest.NewRecorder()
+	handler(removed, cacheRequest("GET", "catalog", "page=1"))
+	other := httptest.NewRecorder()
+	handler(other, cacheRequest("GET", "catalog-v2", "page=1"))
+
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, "MISS", removed.Header().Get(CacheHeader))
+	assert.Equal(t, "HIT", other.Header().Get(CacheHeader))
+}
+
+func TestResponseCacheSkipsResponsesOfFunctionRemovedInFlight(t *testing.T) {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "catalog").Return(map[string]string{"com.openfaas.cache-ttl": "1m"}, nil)
+
+	cache, err := NewResponseCache(types.ProxyConfig{CacheSize: 10}, reader, NewSettings(&types.ProviderConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	handler := cache.Middleware()(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// the function is deleted while its response is written
+		cache.RemoveCacheItem("catalog")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler(httptest.NewRecorder(), cacheRequest("GET", "catalog", "page=1"))
+
+	second := httptest.NewRecorder()
+	handler(second, cacheRequest("GET", "catalog", "page=1"))
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "MISS", second.Header().Get(CacheHeader))
+}
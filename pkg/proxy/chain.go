@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MiddlewareFactory creates a middleware of the chain from its own config and labels, or returns a nil middleware
+// when the middleware is disabled by its config, e.g. the access log without a sink.
+type MiddlewareFactory func() (Middleware, error)
+
+// MiddlewareRegistry holds the named middlewares which can wrap the proxy, in their default order, outermost first.
+type MiddlewareRegistry struct {
+	names     []string
+	factories map[string]MiddlewareFactory
+}
+
+func NewMiddlewareRegistry() *MiddlewareRegistry {
+	return &MiddlewareRegistry{factories: map[string]MiddlewareFactory{}}
+}
+
+// Register adds a middleware to the registry, after the middlewares registered before it in the default order.
+func (r *MiddlewareRegistry) Register(name string, factory MiddlewareFactory) {
+	if _, ok := r.factories[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.factories[name] = factory
+}
+
+// Names returns the names of the registered middlewares in their default order.
+func (r *MiddlewareRegistry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// Build wraps a handler with the chain of the named middlewares, of which the first is the outermost, or with all
+// registered middlewares in their default order when no names are given. Only the middlewares in the chain are
+// created, and an unknown or repeated name is an error.
+func (r *MiddlewareRegistry) Build(names []string, handler http.HandlerFunc) (http.HandlerFunc, error) {
+	if len(names) == 0 {
+		names = r.names
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, ok := r.factories[name]; !ok {
+			return nil, fmt.Errorf("unknown proxy middleware '%s', must be one of %s", name, strings.Join(r.names, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("proxy middleware '%s' is repeated in the chain", name)
+		}
+		seen[name] = true
+	}
+
+	middlewares := make([]Middleware, len(names))
+	for i, name := range names {
+		middleware, err := r.factories[name]()
+		if err != nil {
+			return nil, fmt.Errorf("proxy middleware '%s': %w", name, err)
+		}
+		middlewares[i] = middleware
+	}
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			handler = middlewares[i](handler)
+		}
+	}
+	return handler, nil
+}
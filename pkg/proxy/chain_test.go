@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupMiddlewareRegistry returns a registry of middlewares recording their execution, of which the disabled
+// middleware is disabled by its config.
+func setupMiddlewareRegistry(calls *[]string) (*MiddlewareRegistry, *[]string) {
+	var created []string
+	registry := NewMiddlewareRegistry()
+	for _, name := range []string{"errors", "auth", "gzip", "disabled", "timeout"} {
+		name := name
+		registry.Register(name, func() (Middleware, error) {
+			created = append(created, name)
+			if name == "disabled" {
+				return nil, nil
+			}
+			return func(next http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					*calls = append(*calls, name)
+					next(w, r)
+				}
+			}, nil
+		})
+	}
+	return registry, &created
+}
+
+func invokeChain(t *testing.T, registry *MiddlewareRegistry, names []string, calls *[]string) {
+	handler, err := registry.Build(names, func(w http.ResponseWriter, r *http.Request) {
+		*calls = append(*calls, "proxy")
+	})
+	assert.NoError(t, err)
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/function/echo", nil))
+}
+
+func TestMiddlewareChainRunsInConfiguredOrder(t *testing.T) {
+	var calls []string
+	registry, created := setupMiddlewareRegistry(&calls)
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Middlewares = []string{"timeout", "errors", "gzip"}
+
+	invokeChain(t, registry, config.Proxy.Middlewares, &calls)
+
+	assert.Equal(t, []string{"timeout", "errors", "gzip", "proxy"}, calls)
+	// the middlewares left out of the chain are not created
+	assert.Equal(t, []string{"timeout", "errors", "gzip"}, *created)
+}
+
+func TestMiddlewareChainDefaultsToRegistrationOrder(t *testing.T) {
+	var calls []string
+	registry, _ := setupMiddlewareRegistry(&calls)
+
+	config, _ := types.DefaultConfig()
+	assert.Empty(t, config.Proxy.Middlewares)
+
+	invokeChain(t, registry, config.Proxy.Middlewares, &calls)
+
+	assert.Equal(t, []string{"errors", "auth", "gzip", "timeout", "proxy"}, calls)
+	assert.Equal(t, []string{"errors", "auth", "gzip", "disabled", "timeout"}, registry.Names())
+}
+
+func TestMiddlewareChainRejectsInvalidChains(t *testing.T) {
+	var calls []string
+	registry, created := setupMiddlewareRegistry(&calls)
+	registry.Register("broken", func() (Middleware, error) {
+		return nil, fmt.Errorf("invalid config")
+	})
+
+	for _, names := range [][]string{
+		{"auth", "ratelimit"},
+		{"auth", "gzip", "auth"},
+		{"auth", "broken"},
+	} {
+		_, err := registry.Build(names, func(w http.ResponseWriter, r *http.Request) {})
+		assert.Error(t, err, names)
+	}
+	assert.Equal(t, []string{"auth"}, *created)
+}
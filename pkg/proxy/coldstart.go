@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+)
+
+const (
+	// coldStartSamples is the number of recent cold starts of a function the estimate is based on
+	coldStartSamples = 10
+	// coldStartMinSamples is the number of cold starts observed before the estimate replaces the default
+	coldStartMinSamples = 3
+	// coldStartMaxDuration discards cold starts that took longer, e.g. functions that were deleted or never scaled up
+	coldStartMaxDuration = 10 * time.Minute
+)
+
+// RetryEstimator estimates when a function without available instances can be retried.
+type RetryEstimator interface {
+	RetryAfter(functionName string) time.Duration
+}
+
+// ColdStartTracker observes the cold starts of functions through the resolver, measured from the first request
+// finding no available instances to the first request resolving an instance again, to estimate the `Retry-After`
+// of the requests rejected in the meantime.
+//
+// The estimate is the rolling average of the recent cold starts of the function, minus the time the current cold
+// start is already in progress. Until enough cold starts are observed, the default is returned.
+//
+// The cold starts are exported as metrics as well: their number and duration per function, and the number of
+// functions of which a cold start is in progress.
+type ColdStartTracker struct {
+	resolver     BaseURLResolver
+	defaultRetry time.Duration
+	now          func() time.Time
+
+	mu        sync.Mutex
+	functions map[string]*coldStarts
+}
+
+type coldStarts struct {
+	pending time.Time
+	samples []time.Duration
+}
+
+func NewColdStartTracker(resolver BaseURLResolver, defaultRetry time.Duration) *ColdStartTracker {
+	return &ColdStartTracker{
+		resolver:     resolver,
+		defaultRetry: defaultRetry,
+		now:          time.Now,
+		functions:    map[string]*coldStarts{},
+	}
+}
+
+func (t *ColdStartTracker) Resolve(functionName string) (url.URL, error) {
+	instance, err := t.resolver.Resolve(functionName)
+	t.observe(functionName, err)
+	return instance, err
+}
+
+// ResolveSelection resolves an instance like Resolve, reporting its selection when the resolver supports it.
+func (t *ColdStartTracker) ResolveSelection(functionName string) (url.URL, resolver.Selection, error) {
+	instance, selection, err := resolveSelection(t.resolver, functionName)
+	t.observe(functionName, err)
+	return instance, selection, err
+}
+
+// observe starts a cold start of a function when it has no available instances, and completes it once
+// an instance is resolved again.
+func (t *ColdStartTracker) observe(functionName string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	entry := t.functions[functionName]
+
+	if err != nil {
+		if entry == nil {
+			entry = &coldStarts{}
+			t.functions[functionName] = entry
+		}
+		if entry.pending.IsZero() {
+			metrics.FunctionsAtZero.Inc()
+		}
+		if entry.pending.IsZero() || now.Sub(entry.pending) > coldStartMaxDuration {
+			entry.pending = now
+			metrics.ColdStarts.WithLabelValues(functionName).Inc()
+		}
+		return
+	}
+
+	if entry != nil && !entry.pending.IsZero() {
+		if duration := now.Sub(entry.pending); duration <= coldStartMaxDuration {
+			entry.samples = append(entry.samples, duration)
+			if len(entry.samples) > coldStartSamples {
+				entry.samples = entry.samples[len(entry.samples)-coldStartSamples:]
+			}
+			metrics.ColdStartDuration.WithLabelValues(functionName).Observe(duration.Seconds())
+		}
+		entry.pending = time.Time{}
+		metrics.FunctionsAtZero.Dec()
+	}
+}
+
+func (t *ColdStartTracker) RetryAfter(functionName string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.functions[functionName]
+	if entry == nil || len(entry.samples) < coldStartMinSamples {
+		return t.defaultRetry
+	}
+
+	var total time.Duration
+	for _, sample := range entry.samples {
+		total += sample
+	}
+	remaining := total / time.Duration(len(entry.samples))
+
+	if !entry.pending.IsZero() {
+		remaining -= t.now().Sub(entry.pending)
+	}
+	if remaining < time.Second {
+		return time.Second
+	}
+	return remaining
+}
+
+// RemoveCacheItem forgets the cold starts of a deleted function.
+func (t *ColdStartTracker) RemoveCacheItem(functionName string) {
+	t.mu.Lock()
+	if entry := t.functions[functionName]; entry != nil && !entry.pending.IsZero() {
+		metrics.FunctionsAtZero.Dec()
+	}
+	delete(t.functions, functionName)
+	t.mu.Unlock()
+}
+
+// formatRetryAfter formats a duration as the seconds of a `Retry-After` header, rounded up to at least a second.
+func formatRetryAfter(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type switchResolver struct {
+	available bool
+}
+
+func (r *switchResolver) Resolve(functionName string) (url.URL, error) {
+	if !r.available {
+		return url.URL{}, fmt.Errorf("no candidate available")
+	}
+	return url.URL{Scheme: "http", Host: "10.0.0.1:8080"}, nil
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func setupColdStartTracker() (*ColdStartTracker, *switchResolver, *fakeClock) {
+	resolver := &switchResolver{}
+	clock := &fakeClock{now: time.Now()}
+	tracker := NewColdStartTracker(resolver, 5*time.Second)
+	tracker.now = clock.Now
+	return tracker, resolver, clock
+}
+
+func coldStart(tracker *ColdStartTracker, resolver *switchResolver, clock *fakeClock, duration time.Duration) {
+	resolver.available = false
+	tracker.Resolve("echo")
+	clock.Advance(duration)
+	resolver.available = true
+	tracker.Resolve("echo")
+}
+
+func TestColdStartTrackerUsesDefaultUntilEnoughSamples(t *testing.T) {
+	tracker, resolver, clock := setupColdStartTracker()
+
+	assert.Equal(t, 5*time.Second, tracker.RetryAfter("echo"))
+
+	coldStart(tracker, resolver, clock, 20*time.Second)
+	coldStart(tracker, resolver, clock, 20*time.Second)
+
+	assert.Equal(t, 5*time.Second, tracker.RetryAfter("echo"))
+}
+
+func TestColdStartTrackerEstimatesRemainingColdStart(t *testing.T) {
+	tracker, resolver, clock := setupColdStartTracker()
+
+	coldStart(tracker, resolver, clock, 10*time.Second)
+	coldStart(tracker, resolver, clock, 20*time.Second)
+	coldStart(tracker, resolver, clock, 30*time.Second)
+
+	assert.Equal(t, 20*time.Second, tracker.RetryAfter("echo"))
+	assert.Equal(t, 5*time.Second, tracker.RetryAfter("figlet"), "other functions use the default")
+
+	resolver.available = false
+	tracker.Resolve("echo")
+	clock.Advance(12 * time.Second)
+	tracker.Resolve("echo")
+
+	assert.Equal(t, 8*time.Second, tracker.RetryAfter("echo"))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Second, tracker.RetryAfter("echo"))
+}
+
+func TestColdStartTrackerDiscardsLongColdStarts(t *testing.T) {
+	tracker, resolver, clock := setupColdStartTracker()
+
+	coldStart(tracker, resolver, clock, 10*time.Second)
+	coldStart(tracker, resolver, clock, 10*time.Second)
+	coldStart(tracker, resolver, clock, time.Hour)
+	coldStart(tracker, resolver, clock, 10*time.Second)
+
+	assert.Equal(t, 10*time.Second, tracker.RetryAfter("echo"))
+}
+
+func TestProxySetsRetryAfterWhenNoEndpointsAvailable(t *testing.T) {
+	tracker, resolver, clock := setupColdStartTracker()
+	for i := 0; i < coldStartMinSamples; i++ {
+		coldStart(tracker, resolver, clock, 1500*time.Millisecond)
+	}
+	resolver.available = false
+
+	handler := NewHandlerFunc(types.FaaSConfig{}, tracker, hclog.NewNullLogger())
+
+	request := httptest.NewRequest("GET", "/function/echo", nil)
+	request = mux.SetURLVars(request, map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, request)
+
+	assert.Equal(t, 503, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+}
+
+func TestColdStartTrackerCountsColdStartOfInvokedFunction(t *testing.T) {
+	tracker, resolver, clock := setupColdStartTracker()
+	handler := NewHandlerFunc(types.FaaSConfig{}, tracker, hclog.NewNullLogger())
+
+	atZero := testutil.ToFloat64(metrics.FunctionsAtZero)
+	durations := testutil.CollectAndCount(metrics.ColdStartDuration)
+
+	invoke := func() {
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/function/metrics-echo", nil), map[string]string{"name": "metrics-echo"})
+		handler(httptest.NewRecorder(), request)
+	}
+
+	// the function has no instances, requests are rejected until it is scaled up
+	invoke()
+	invoke()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ColdStarts.WithLabelValues("metrics-echo")), "a cold start is counted once")
+	assert.Equal(t, atZero+1, testutil.ToFloat64(metrics.FunctionsAtZero))
+
+	clock.Advance(3 * time.Second)
+	resolver.available = true
+	tracker.Resolve("metrics-echo")
+
+	assert.Equal(t, atZero, testutil.ToFloat64(metrics.FunctionsAtZero))
+	assert.Equal(t, durations+1, testutil.CollectAndCount(metrics.ColdStartDuration), "the duration of the cold start is observed")
+}
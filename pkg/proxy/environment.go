@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	EnvironmentHeader = "X-Faas-Environment"
+)
+
+// EnvironmentReader tells whether an environment is known by the resolvers of the proxy.
+type EnvironmentReader interface {
+	HasEnvironment(environment string) bool
+}
+
+// NewEnvironmentMiddleware selects the environment of a request from the `X-Faas-Environment` header,
+// by qualifying the function name with the environment, e.g. echo.staging, before it is resolved.
+//
+// Requests without the header are left untouched, so the environment can also be selected with a
+// name.namespace function name.
+func NewEnvironmentMiddleware(environments EnvironmentReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			environment := r.Header.Get(EnvironmentHeader)
+			if len(environment) == 0 {
+				next(w, r)
+				return
+			}
+
+			if !environments.HasEnvironment(environment) {
+				writeProxyError(w, r, http.StatusBadRequest, mux.Vars(r)["name"], "Unknown environment: %s.", environment)
+				return
+			}
+
+			vars := mux.Vars(r)
+			qualified := map[string]string{}
+			for k, v := range vars {
+				qualified[k] = v
+			}
+			qualified["name"] = fmt.Sprintf("%s.%s", strings.SplitN(vars["name"], ".", 2)[0], environment)
+
+			next(w, mux.SetURLVars(r, qualified))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type environments []string
+
+func (e environments) HasEnvironment(environment string) bool {
+	for _, v := range e {
+		if v == environment {
+			return true
+		}
+	}
+	return false
+}
+
+func environmentRequest(name string, environment string) *http.Request {
+	request := httptest.NewRequest("GET", "/function/"+name, nil)
+	if len(environment) != 0 {
+		request.Header.Set(EnvironmentHeader, environment)
+	}
+	return mux.SetURLVars(request, map[string]string{"name": name, "params": "/path"})
+}
+
+func TestEnvironmentMiddlewareQualifiesFunctionName(t *testing.T) {
+	var vars map[string]string
+	handler := NewEnvironmentMiddleware(environments{"staging"})(func(w http.ResponseWriter, r *http.Request) {
+		vars = mux.Vars(r)
+	})
+
+	cases := map[string]string{
+		"echo":             "echo.staging",
+		"echo.openfaas-fn": "echo.staging",
+	}
+
+	for name, expected := range cases {
+		recorder := httptest.NewRecorder()
+		handler(recorder, environmentRequest(name, "staging"))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, expected, vars["name"])
+		assert.Equal(t, "/path", vars["params"])
+	}
+}
+
+func TestEnvironmentMiddlewareWithoutHeader(t *testing.T) {
+	var vars map[string]string
+	handler := NewEnvironmentMiddleware(environments{"staging"})(func(w http.ResponseWriter, r *http.Request) {
+		vars = mux.Vars(r)
+	})
+
+	handler(httptest.NewRecorder(), environmentRequest("echo.openfaas-fn", ""))
+
+	assert.Equal(t, "echo.openfaas-fn", vars["name"])
+}
+
+func TestEnvironmentMiddlewareRejectsUnknownEnvironment(t *testing.T) {
+	called := false
+	handler := NewEnvironmentMiddleware(environments{"staging"})(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, environmentRequest("echo", "production"))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.False(t, called)
+}
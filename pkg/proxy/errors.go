@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+type errorPagesKey struct{}
+
+// ProxyError is the default body of the 404 and 503 errors returned by the proxy itself.
+type ProxyError struct {
+	Error    string `json:"error"`
+	Function string `json:"function,omitempty"`
+	Status   int    `json:"status"`
+}
+
+// ErrorPages renders the bodies of the errors returned by the proxy itself with the templates configured per
+// status, e.g. to match the error conventions of an API. The errors of the functions are proxied as they are.
+//
+// A template is executed with the Function, Status, StatusText and Message of the error, as well as the Timeout
+// of a 504, and may use the json function to quote a value, e.g. `{"message": {{json .Message}}}`. Without a
+// template, a 404 or 503 is reported as a ProxyError, a 504 as a FunctionTimeout, and any other status as text.
+type ErrorPages struct {
+	pages map[int]*errorPage
+}
+
+type errorPage struct {
+	tmpl        *template.Template
+	contentType string
+}
+
+type errorPageData struct {
+	Function   string
+	Status     int
+	StatusText string
+	Message    string
+	Timeout    string
+}
+
+var errorPageFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func NewErrorPages(config types.ProxyConfig) (*ErrorPages, error) {
+	pages := map[int]*errorPage{}
+	for status, page := range config.ErrorPages {
+		tmpl, err := template.New(fmt.Sprintf("error_page_%d", status)).Funcs(errorPageFuncs).Option("missingkey=error").Parse(page.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error page for status %d: %s", status, err)
+		}
+		pages[status] = &errorPage{tmpl: tmpl, contentType: page.ContentType}
+	}
+	return &ErrorPages{pages: pages}, nil
+}
+
+// Middleware makes the error pages available to the proxy and the middlewares it wraps, which write their errors
+// with writeProxyError.
+func (p *ErrorPages) Middleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(w, r.WithContext(context.WithValue(r.Context(), errorPagesKey{}, p)))
+		}
+	}
+}
+
+// render writes the error with the template of its status, if any. The template is rendered before anything is
+// written, so an error whose template fails is still reported with its default body.
+func (p *ErrorPages) render(w http.ResponseWriter, status int, data errorPageData) bool {
+	page, ok := p.pages[status]
+	if !ok {
+		return false
+	}
+
+	var body bytes.Buffer
+	if err := page.tmpl.Execute(&body, data); err != nil {
+		return false
+	}
+
+	w.Header().Set("Content-Type", page.contentType)
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+	return true
+}
+
+// writeProxyError writes an error returned by the proxy itself, with the error page of its status.
+func writeProxyError(w http.ResponseWriter, r *http.Request, status int, functionName string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	if pages, ok := r.Context().Value(errorPagesKey{}).(*ErrorPages); ok {
+		data := errorPageData{Function: functionName, Status: status, StatusText: http.StatusText(status), Message: message}
+		if pages.render(w, status, data) {
+			return
+		}
+	}
+
+	switch status {
+	case http.StatusNotFound, http.StatusServiceUnavailable:
+		body, _ := json.Marshal(ProxyError{Error: message, Function: functionName, Status: status})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	default:
+		httputil.Errorf(w, status, "%s", message)
+	}
+}
+
+func writeTimeout(w http.ResponseWriter, r *http.Request, functionName string, timeout time.Duration) {
+	if pages, ok := r.Context().Value(errorPagesKey{}).(*ErrorPages); ok {
+		data := errorPageData{
+			Function:   functionName,
+			Status:     http.StatusGatewayTimeout,
+			StatusText: http.StatusText(http.StatusGatewayTimeout),
+			Message:    "function timed out",
+			Timeout:    timeout.String(),
+		}
+		if pages.render(w, http.StatusGatewayTimeout, data) {
+			return
+		}
+	}
+
+	body, _ := json.Marshal(FunctionTimeout{
+		Error:    "function timed out",
+		Function: functionName,
+		Timeout:  timeout.String(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(body)
+}
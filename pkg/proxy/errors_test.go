@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupErrorPagesProxy(t *testing.T, pages map[int]types.ErrorPageConfig) http.HandlerFunc {
+	config, _ := types.DefaultConfig()
+	config.Proxy.ErrorPages = pages
+
+	errorPages, err := NewErrorPages(config.Proxy)
+	assert.NoError(t, err)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(url.URL{}, fmt.Errorf("no instances"))
+
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	return errorPages.Middleware()(handler)
+}
+
+func TestProxyRendersCustomErrorPage(t *testing.T) {
+	handler := setupErrorPagesProxy(t, map[int]types.ErrorPageConfig{
+		http.StatusServiceUnavailable: {
+			Template:    `{"code": "{{.Status}}", "detail": "{{.Function}} is unavailable", "support": "https://example.com/support"}`,
+			ContentType: "application/problem+json",
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code": "503", "detail": "echo is unavailable", "support": "https://example.com/support"}`, recorder.Body.String())
+}
+
+func TestProxyRendersHTMLErrorPage(t *testing.T) {
+	handler := setupErrorPagesProxy(t, map[int]types.ErrorPageConfig{
+		http.StatusServiceUnavailable: {
+			Template:    `<h1>{{.StatusText}}</h1><p>{{html .Message}}</p>`,
+			ContentType: "text/html",
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, "text/html", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "<h1>Service Unavailable</h1><p>No endpoints available for: echo.</p>", recorder.Body.String())
+}
+
+func TestProxyReportsDefaultErrorAsJSON(t *testing.T) {
+	handler := setupErrorPagesProxy(t, nil)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var body ProxyError
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, ProxyError{Error: "No endpoints available for: echo.", Function: "echo", Status: http.StatusServiceUnavailable}, body)
+}
+
+func TestProxyRendersCustomTimeoutPage(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Proxy.ErrorPages = map[int]types.ErrorPageConfig{
+		http.StatusGatewayTimeout: {Template: `{"message": {{json .Message}}, "timeout": {{json .Timeout}}}`, ContentType: "application/json"},
+	}
+	errorPages, err := NewErrorPages(config.Proxy)
+	assert.NoError(t, err)
+
+	instance, _ := slowUpstream(t, 5*time.Second)
+	handler := errorPages.Middleware()(setupTimeoutProxy(config, instance, map[string]string{timeoutLabel: "50ms"}))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.JSONEq(t, `{"message": "function timed out", "timeout": "50ms"}`, recorder.Body.String())
+}
+
+func TestNewErrorPagesReportsInvalidTemplate(t *testing.T) {
+	_, err := NewErrorPages(types.ProxyConfig{ErrorPages: map[int]types.ErrorPageConfig{503: {Template: "{{.Function"}}})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "status 503")
+}
+
+func TestProxyCountsInvocationsOfFunctions(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Scheduling.Namespace = "default"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	instance, _ := url.Parse(upstream.URL)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "counted-echo.default").Return(*instance, nil)
+
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	invocations := testutil.ToFloat64(metrics.ProxyInvocations.WithLabelValues("counted-echo"))
+
+	handler(httptest.NewRecorder(), waitRequest("counted-echo.default"))
+
+	assert.Equal(t, invocations+1, testutil.ToFloat64(metrics.ProxyInvocations.WithLabelValues("counted-echo")))
+}
+
+func TestProxyDoesNotCountInvocationsOfUnresolvedFunctions(t *testing.T) {
+	config, _ := types.DefaultConfig()
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "mistyped-echo").Return(url.URL{}, fmt.Errorf("no instances"))
+
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	series := testutil.CollectAndCount(metrics.ProxyInvocations)
+
+	handler(httptest.NewRecorder(), waitRequest("mistyped-echo"))
+
+	assert.Equal(t, series, testutil.CollectAndCount(metrics.ProxyInvocations))
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	gzipLabel = "com.openfaas.gzip"
+)
+
+var uncompressibleContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"text/event-stream",
+}
+
+// Middleware decorates a function proxy handler with additional behaviour.
+type Middleware func(next http.HandlerFunc) http.HandlerFunc
+
+// LabelsReader provides the labels of a function, so that middlewares can be configured per function.
+type LabelsReader interface {
+	Labels(functionName string) (map[string]string, error)
+}
+
+// NewGzipMiddleware compresses function responses when the client accepts gzip encoding.
+//
+// Compression is enabled globally with the proxy configuration, and can be overridden per function
+// with the `com.openfaas.gzip` label. Responses that are already encoded, have an uncompressible
+// content type or are smaller than the configured minimum size are passed through untouched.
+func NewGzipMiddleware(config types.ProxyConfig, labels LabelsReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || !acceptsGzip(r) || !gzipEnabled(config, labels, mux.Vars(r)["name"]) {
+				next(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: config.GzipMinSize}
+			defer gw.Close()
+
+			next(gw, r)
+		}
+	}
+}
+
+func gzipEnabled(config types.ProxyConfig, labels LabelsReader, functionName string) bool {
+	if labels == nil || functionName == "" {
+		return config.Gzip
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return config.Gzip
+	}
+	return types.ParseBoolValueFromMap(&values, gzipLabel, config.Gzip)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(encoding, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it can decide whether the response
+// should be compressed, which is when the buffer reaches the minimum size, the handler flushes
+// or the response is complete.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	compress    bool
+	buffer      []byte
+	gz          *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	g.status = status
+
+	h := g.Header()
+	if !bodyAllowed(status) || h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		g.decide(false)
+		return
+	}
+
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		g.decide(cl >= g.minSize)
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+
+	if !g.decided {
+		g.buffer = append(g.buffer, b...)
+		if len(g.buffer) < g.minSize {
+			return len(b), nil
+		}
+		g.decide(true)
+		if err := g.writeBuffer(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if g.compress {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided && g.wroteHeader {
+		g.decide(true)
+		g.writeBuffer()
+	}
+
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := g.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if !g.wroteHeader {
+		return nil
+	}
+
+	if !g.decided {
+		g.decide(false)
+		if err := g.writeBuffer(); err != nil {
+			return err
+		}
+	}
+
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	return nil
+}
+
+func (g *gzipResponseWriter) decide(compress bool) {
+	g.decided = true
+	g.compress = compress
+
+	h := g.Header()
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+func (g *gzipResponseWriter) writeBuffer() error {
+	if len(g.buffer) == 0 {
+		return nil
+	}
+
+	var err error
+	if g.compress {
+		_, err = g.gz.Write(g.buffer)
+	} else {
+		_, err = g.ResponseWriter.Write(g.buffer)
+	}
+	g.buffer = nil
+	return err
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, t := range uncompressibleContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
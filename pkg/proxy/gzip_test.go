@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipRequest(name string) *http.Request {
+	request := httptest.NewRequest("GET", "/function/"+name, nil)
+	request.Header.Set("Accept-Encoding", "gzip, deflate")
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func writeBody(contentType string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+}
+
+func TestGzipMiddlewareCompressesLargeResponses(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{}, nil)
+
+	body := strings.Repeat(`{"key": "value"}`, 100)
+	handler := NewGzipMiddleware(types.ProxyConfig{Gzip: true, GzipMinSize: 1024}, labels)(writeBody("application/json", body))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, gzipRequest("echo"))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, body, string(decompressed))
+}
+
+func TestGzipMiddlewareSkipsSmallResponses(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{}, nil)
+
+	handler := NewGzipMiddleware(types.ProxyConfig{Gzip: true, GzipMinSize: 1024}, labels)(writeBody("application/json", `{"key": "value"}`))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, gzipRequest("echo"))
+
+	assert.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"key": "value"}`, recorder.Body.String())
+}
+
+func TestGzipMiddlewareSkipsUncompressibleContentTypes(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "echo").Return(map[string]string{}, nil)
+
+	body := strings.Repeat("x", 2048)
+	handler := NewGzipMiddleware(types.ProxyConfig{Gzip: true, GzipMinSize: 1024}, labels)(writeBody("image/png", body))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, gzipRequest("echo"))
+
+	assert.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, recorder.Body.String())
+}
+
+func TestGzipMiddlewareHonorsFunctionLabel(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "enabled").Return(map[string]string{"com.openfaas.gzip": "true"}, nil)
+	labels.On("Labels", "disabled").Return(map[string]string{"com.openfaas.gzip": "false"}, nil)
+
+	body := strings.Repeat(`{"key": "value"}`, 100)
+
+	enabled := NewGzipMiddleware(types.ProxyConfig{Gzip: false, GzipMinSize: 1024}, labels)(writeBody("application/json", body))
+	recorder := httptest.NewRecorder()
+	enabled(recorder, gzipRequest("enabled"))
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+
+	disabled := NewGzipMiddleware(types.ProxyConfig{Gzip: true, GzipMinSize: 1024}, labels)(writeBody("application/json", body))
+	recorder = httptest.NewRecorder()
+	disabled(recorder, gzipRequest("disabled"))
+	assert.Equal(t, "", recorder.Header().Get("Content-Encoding"))
+}
+
+func TestGzipMiddlewareFlushesStreamingResponses(t *testing.T) {
+	labels := &services.MockFunctionLabels{}
+	labels.On("Labels", "stream").Return(map[string]string{}, nil)
+
+	flushed := make(chan string, 1)
+	handler := NewGzipMiddleware(types.ProxyConfig{Gzip: true, GzipMinSize: 1024}, labels)(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("chunk 1\n"))
+		w.(http.Flusher).Flush()
+
+		recorder := w.(*gzipResponseWriter).ResponseWriter.(*httptest.ResponseRecorder)
+		reader, _ := gzip.NewReader(strings.NewReader(recorder.Body.String()))
+		buf := make([]byte, 8)
+		n, _ := reader.Read(buf)
+		flushed <- string(buf[:n])
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, gzipRequest("stream"))
+
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, "chunk 1\n", <-flushed)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// HostRouter routes the requests for the hostnames of functions exposed on their own domain, e.g. behind a
+// shared ingress, to those functions, next to the path based routing of /function/{name}.
+//
+// The hostname is taken from the TLS SNI when the request was received over TLS, and from the Host header
+// otherwise. All paths of a mapped hostname are routed to its function, so the system endpoints of the
+// provider are not reachable on those hostnames.
+type HostRouter struct {
+	hosts map[string]string
+}
+
+func NewHostRouter(hosts map[string]string) *HostRouter {
+	normalized := make(map[string]string, len(hosts))
+	for host, function := range hosts {
+		normalized[normalizeHost(host)] = function
+	}
+	return &HostRouter{hosts: normalized}
+}
+
+// Match implements mux.MatcherFunc, matching the requests for a mapped hostname.
+func (h *HostRouter) Match(r *http.Request, _ *mux.RouteMatch) bool {
+	_, ok := h.function(r)
+	return ok
+}
+
+// Handler sets the function and the path of the request as route variables, as for path based routing,
+// so that the request is resolved and proxied by the function proxy handler.
+func (h *HostRouter) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		function, ok := h.function(r)
+		if !ok {
+			writeProxyError(w, r, http.StatusNotFound, "", "no function is mapped to host %s", r.Host)
+			return
+		}
+
+		vars := map[string]string{
+			"name":   function,
+			"params": strings.TrimPrefix(r.URL.Path, "/"),
+		}
+		next(w, mux.SetURLVars(r, vars))
+	}
+}
+
+func (h *HostRouter) function(r *http.Request) (string, bool) {
+	host := r.Host
+	if r.TLS != nil && len(r.TLS.ServerName) != 0 {
+		host = r.TLS.ServerName
+	}
+	function, ok := h.hosts[normalizeHost(host)]
+	return function, ok
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
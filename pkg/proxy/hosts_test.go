@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func hostRoutes() *mux.Router {
+	target := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mux.Vars(r)["name"] + " " + mux.Vars(r)["params"]))
+	}
+
+	hosts := NewHostRouter(map[string]string{"API.example.com": "echo", "www.example.com": "site.staging"})
+
+	router := mux.NewRouter()
+	router.MatcherFunc(hosts.Match).HandlerFunc(hosts.Handler(target))
+	router.HandleFunc("/function/{name}", target)
+	router.HandleFunc("/function/{name}/", target)
+	router.HandleFunc("/function/{name}/{params:.*}", target)
+	router.HandleFunc("/system/info", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("info")) })
+	return router
+}
+
+func routeRequest(router *mux.Router, request *http.Request) string {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Body.String()
+}
+
+func TestHostRouterRoutesOnHostHeader(t *testing.T) {
+	router := hostRoutes()
+
+	request := httptest.NewRequest("GET", "http://api.example.com:8080/users/1?q=x", nil)
+	assert.Equal(t, "echo users/1", routeRequest(router, request))
+
+	request = httptest.NewRequest("POST", "http://www.example.com/", nil)
+	assert.Equal(t, "site.staging ", routeRequest(router, request))
+
+	request = httptest.NewRequest("GET", "http://api.example.com/system/info", nil)
+	assert.Equal(t, "echo system/info", routeRequest(router, request), "system endpoints are not reachable on function hostnames")
+}
+
+func TestHostRouterPrefersTLSServerName(t *testing.T) {
+	router := hostRoutes()
+
+	request := httptest.NewRequest("GET", "http://faas-nomad:8080/status", nil)
+	request.TLS = &tls.ConnectionState{ServerName: "api.example.com"}
+	assert.Equal(t, "echo status", routeRequest(router, request))
+}
+
+func TestHostRouterCoexistsWithPathRouting(t *testing.T) {
+	router := hostRoutes()
+
+	request := httptest.NewRequest("GET", "http://faas-nomad:8080/function/figlet/render", nil)
+	assert.Equal(t, "figlet render", routeRequest(router, request))
+
+	request = httptest.NewRequest("GET", "http://faas-nomad:8080/function/figlet", nil)
+	assert.Equal(t, "figlet ", routeRequest(router, request))
+
+	request = httptest.NewRequest("GET", "http://faas-nomad:8080/system/info", nil)
+	assert.Equal(t, "info", routeRequest(router, request))
+}
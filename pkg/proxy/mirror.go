@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	mirrorTargetLabel  = "com.openfaas.mirror.target"
+	mirrorRateLabel    = "com.openfaas.mirror.rate"
+	mirrorTimeoutLabel = "com.openfaas.mirror.timeout"
+
+	// MirrorHeader is set on mirrored requests, with the name of the function the request was sent to.
+	MirrorHeader = "X-Faas-Mirror"
+
+	defaultMirrorTimeout = 10 * time.Second
+	maxMirrorBodySize    = 1 << 20
+	maxMirrorsInFlight   = 256
+)
+
+// NewMirrorMiddleware mirrors a fraction of the requests of a function to a shadow function, e.g. to validate a new
+// version of the function against real traffic.
+//
+// The shadow function is given by the `com.openfaas.mirror.target` label, in the namespace of the function, and the
+// fraction by the `com.openfaas.mirror.rate` label, which defaults to all requests. Mirrored requests are sent in the
+// background, with the timeout of the `com.openfaas.mirror.timeout` label, and their responses are discarded.
+// Requests with a body larger than 1 MiB aren't mirrored, nor are requests when too many mirrored requests are
+// still in flight, so the shadow function never slows down the function.
+func NewMirrorMiddleware(labels LabelsReader, logger hclog.Logger) Middleware {
+	log := logger.Named("mirror")
+	inFlight := make(chan struct{}, maxMirrorsInFlight)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			functionName := vars["name"]
+
+			target, timeout, ok := mirrorTarget(labels, functionName)
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			var body []byte
+			if r.Body != nil {
+				buffered, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMirrorBodySize+1))
+				if err != nil || len(buffered) > maxMirrorBodySize {
+					// the part which was read is replayed in front of the remainder of the body
+					r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffered), r.Body), Closer: r.Body}
+					metrics.ProxyMirroredRequests.WithLabelValues(functionName, "skipped").Inc()
+					next(w, r)
+					return
+				}
+				r.Body.Close()
+				r.Body = ioutil.NopCloser(bytes.NewReader(buffered))
+				body = buffered
+			}
+
+			select {
+			case inFlight <- struct{}{}:
+				mirror := mirrorRequest(r, vars, functionName, target, body)
+				go func() {
+					defer func() { <-inFlight }()
+
+					// the context of the mirrored request holds its route vars, but not the cancellation of the request
+					ctx, cancel := context.WithTimeout(mirror.Context(), timeout)
+					defer cancel()
+
+					recorder := &discardResponseWriter{header: http.Header{}, status: http.StatusOK}
+					next(recorder, mirror.WithContext(ctx))
+
+					metrics.ProxyMirroredRequests.WithLabelValues(functionName, "sent").Inc()
+					log.Trace("request mirrored", "function", functionName, "target", target, "status", recorder.status)
+				}()
+			default:
+				metrics.ProxyMirroredRequests.WithLabelValues(functionName, "dropped").Inc()
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// mirrorTarget returns the shadow function a request of the function is mirrored to, if the request is sampled.
+func mirrorTarget(labels LabelsReader, functionName string) (string, time.Duration, bool) {
+	if labels == nil || functionName == "" {
+		return "", 0, false
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return "", 0, false
+	}
+
+	target := types.ParseStringValueFromMap(&values, mirrorTargetLabel, "")
+	if len(target) == 0 {
+		return "", 0, false
+	}
+
+	rate := types.ParseFloatValueFromMap(&values, mirrorRateLabel, 1)
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return "", 0, false
+	}
+
+	if idx := strings.LastIndex(functionName, "."); idx > 0 && !strings.Contains(target, ".") {
+		target = target + functionName[idx:]
+	}
+
+	timeout := types.ParseIntOrDurationValueFromMap(&values, mirrorTimeoutLabel, defaultMirrorTimeout)
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+
+	return target, timeout, true
+}
+
+// mirrorRequest copies a request of a function to the shadow function, with a copy of the buffered body. The copy
+// is detached from the context of the request, so it isn't cancelled when the function responds.
+func mirrorRequest(r *http.Request, vars map[string]string, functionName, target string, body []byte) *http.Request {
+	mirror := r.Clone(context.Background())
+	mirror.Header.Set(MirrorHeader, functionName)
+	if body != nil {
+		mirror.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	mirrorVars := make(map[string]string, len(vars))
+	for k, v := range vars {
+		mirrorVars[k] = v
+	}
+	mirrorVars["name"] = target
+
+	return mux.SetURLVars(mirror, mirrorVars)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// discardResponseWriter discards the response of a mirrored request, keeping its status for logging.
+type discardResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) WriteHeader(status int) {
+	if !d.wroteHeader {
+		d.status = status
+		d.wroteHeader = true
+	}
+}
+
+func (d *discardResponseWriter) Write(b []byte) (int, error) {
+	d.wroteHeader = true
+	return len(b), nil
+}
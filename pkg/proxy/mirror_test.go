@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type mirroredRequest struct {
+	body   string
+	header string
+}
+
+func setupMirrorProxy(t *testing.T, labels map[string]string, shadowDelay time.Duration) (http.HandlerFunc, chan mirroredRequest) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte("primary: " + string(body)))
+	}))
+	t.Cleanup(primary.Close)
+
+	mirrored := make(chan mirroredRequest, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		select {
+		case <-time.After(shadowDelay):
+		case <-r.Context().Done():
+		}
+		mirrored <- mirroredRequest{body: string(body), header: r.Header.Get(MirrorHeader)}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(shadow.Close)
+
+	primaryURL, _ := url.Parse(primary.URL)
+	shadowURL, _ := url.Parse(shadow.URL)
+
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(*primaryURL, nil)
+	resolver.On("Resolve", "echo-shadow").Return(*shadowURL, nil)
+
+	config, _ := types.DefaultConfig()
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	return NewMirrorMiddleware(reader, hclog.NewNullLogger())(handler), mirrored
+}
+
+func mirrorRequestOf(name, body string) *http.Request {
+	request := httptest.NewRequest("POST", "/function/"+name, strings.NewReader(body))
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func TestMirrorMiddlewareMirrorsRequestToShadowFunction(t *testing.T) {
+	handler, mirrored := setupMirrorProxy(t, map[string]string{mirrorTargetLabel: "echo-shadow"}, 500*time.Millisecond)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler(recorder, mirrorRequestOf("echo", "hello"))
+
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the function doesn't wait for the shadow function")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "primary: hello", recorder.Body.String())
+
+	select {
+	case request := <-mirrored:
+		assert.Equal(t, "hello", request.body)
+		assert.Equal(t, "echo", request.header)
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
+
+func TestMirrorMiddlewareCancelsSlowShadowFunction(t *testing.T) {
+	handler, mirrored := setupMirrorProxy(t, map[string]string{mirrorTargetLabel: "echo-shadow", mirrorTimeoutLabel: "100ms"}, 5*time.Second)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, mirrorRequestOf("echo", "hello"))
+
+	assert.Equal(t, "primary: hello", recorder.Body.String())
+
+	select {
+	case <-mirrored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("mirrored request was not cancelled after its timeout")
+	}
+}
+
+func TestMirrorMiddlewareDoesNotMirrorWithoutTarget(t *testing.T) {
+	handler, mirrored := setupMirrorProxy(t, map[string]string{}, 0)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, mirrorRequestOf("echo", "hello"))
+
+	assert.Equal(t, "primary: hello", recorder.Body.String())
+
+	select {
+	case <-mirrored:
+		t.Fatal("request was mirrored without a target")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorMiddlewareDoesNotMirrorWithZeroRate(t *testing.T) {
+	handler, mirrored := setupMirrorProxy(t, map[string]string{mirrorTargetLabel: "echo-shadow", mirrorRateLabel: "0"}, 0)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, mirrorRequestOf("echo", "hello"))
+
+	assert.Equal(t, "primary: hello", recorder.Body.String())
+
+	select {
+	case <-mirrored:
+		t.Fatal("request was mirrored with a zero rate")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorMiddlewareSkipsLargeBodies(t *testing.T) {
+	handler, mirrored := setupMirrorProxy(t, map[string]string{mirrorTargetLabel: "echo-shadow"}, 0)
+
+	body := strings.Repeat("a", maxMirrorBodySize+1)
+	recorder := httptest.NewRecorder()
+	handler(recorder, mirrorRequestOf("echo", body))
+
+	assert.Equal(t, "primary: "+body, recorder.Body.String(), "the body is proxied in full")
+
+	select {
+	case <-mirrored:
+		t.Fatal("request with a large body was mirrored")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorTargetKeepsNamespaceOfFunction(t *testing.T) {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo.staging").Return(map[string]string{mirrorTargetLabel: "echo-shadow"}, nil)
+
+	target, timeout, ok := mirrorTarget(reader, "echo.staging")
+
+	assert.True(t, ok)
+	assert.Equal(t, "echo-shadow.staging", target)
+	assert.Equal(t, defaultMirrorTimeout, timeout)
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+)
+
+// PassthroughPath is the path under which the passthrough routes are served, apart from /function/, as the
+// services behind them aren't functions.
+const PassthroughPath = "/service/"
+
+// PassthroughRouter proxies the requests of the passthrough routes, e.g. /service/legacy/orders, to the plain
+// Consul service mapped to the route, without the job prefix of the functions. Only the mapped routes are served,
+// so the other Consul services of the cluster aren't reachable through the provider.
+type PassthroughRouter struct {
+	routes   map[string]string
+	resolver resolver.PassthroughResolver
+}
+
+func NewPassthroughRouter(routes map[string]string, resolver resolver.PassthroughResolver) *PassthroughRouter {
+	return &PassthroughRouter{routes: routes, resolver: resolver}
+}
+
+// Handler rejects the requests for routes which aren't mapped to a service.
+func (p *PassthroughRouter) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["name"]
+		if _, ok := p.routes[route]; !ok {
+			writeProxyError(w, r, http.StatusNotFound, "", "no service is mapped to route %s", route)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Resolve implements BaseURLResolver, resolving an instance of the service mapped to a route.
+func (p *PassthroughRouter) Resolve(route string) (url.URL, error) {
+	service, ok := p.routes[route]
+	if !ok {
+		return url.URL{}, fmt.Errorf("no service is mapped to route %s", route)
+	}
+	return p.resolver.ResolveService(service)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePassthroughResolver map[string]url.URL
+
+func (f fakePassthroughResolver) ResolveService(service string) (url.URL, error) {
+	if instance, ok := f[service]; ok {
+		return instance, nil
+	}
+	return url.URL{}, fmt.Errorf("no instances of %s", service)
+}
+
+func passthroughRoutes(t *testing.T) *mux.Router {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("legacy-api " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	instance, _ := url.Parse(server.URL)
+
+	config, _ := types.DefaultConfig()
+	passthrough := NewPassthroughRouter(
+		map[string]string{"legacy": "legacy-api", "billing": "billing"},
+		fakePassthroughResolver{"legacy-api": *instance},
+	)
+	handler := passthrough.Handler(NewReloadableHandlerFunc(NewSettings(config), passthrough, hclog.NewNullLogger()))
+
+	router := mux.NewRouter()
+	router.HandleFunc(PassthroughPath+"{name}", handler)
+	router.HandleFunc(PassthroughPath+"{name}/", handler)
+	router.HandleFunc(PassthroughPath+"{name}/{params:.*}", handler)
+	return router
+}
+
+func TestPassthroughRouterProxiesToUnprefixedService(t *testing.T) {
+	router := passthroughRoutes(t)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/legacy/orders/1", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "legacy-api /orders/1", recorder.Body.String())
+}
+
+func TestPassthroughRouterRejectsUnmappedRoutes(t *testing.T) {
+	router := passthroughRoutes(t)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/legacy-api/orders/1", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// a mapped service without instances
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/service/billing/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
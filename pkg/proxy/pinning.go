@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	InstanceHeader = "X-Faas-Instance"
+)
+
+type pinnedInstanceKey struct{}
+
+// InstanceResolver provides all current candidates of a function.
+type InstanceResolver interface {
+	ResolveAll(functionName string) ([]url.URL, error)
+}
+
+// NewInstancePinningMiddleware pins a request to the instance given in the `X-Faas-Instance` header,
+// bypassing the load balancing of the proxy.
+//
+// The requested instance, in the form of host:port, must be a current healthy candidate of the function,
+// otherwise the request is rejected with a 409 Conflict.
+func NewInstancePinningMiddleware(resolver InstanceResolver) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			instance := r.Header.Get(InstanceHeader)
+			if len(instance) == 0 {
+				next(w, r)
+				return
+			}
+
+			functionName := mux.Vars(r)["name"]
+			candidates, err := resolver.ResolveAll(functionName)
+			if err != nil {
+				writeProxyError(w, r, http.StatusServiceUnavailable, functionName, "No endpoints available for: %s.", functionName)
+				return
+			}
+
+			for _, candidate := range candidates {
+				if candidate.Host == instance {
+					next(w, r.WithContext(context.WithValue(r.Context(), pinnedInstanceKey{}, candidate)))
+					return
+				}
+			}
+
+			writeProxyError(w, r, http.StatusConflict, functionName, "Instance %s is not an available endpoint for: %s.", instance, functionName)
+		}
+	}
+}
+
+func pinnedInstance(ctx context.Context) (url.URL, bool) {
+	instance, ok := ctx.Value(pinnedInstanceKey{}).(url.URL)
+	return instance, ok
+}
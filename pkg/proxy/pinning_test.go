@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func instanceServer(name string) (*httptest.Server, url.URL) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	target, _ := url.Parse(server.URL)
+	return server, *target
+}
+
+func pinnedRequest(name string, instance string) *http.Request {
+	request := httptest.NewRequest("GET", "/function/"+name, nil)
+	request.Header.Set(InstanceHeader, instance)
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func TestInstancePinningMiddlewareProxiesToPinnedInstance(t *testing.T) {
+	first, firstURL := instanceServer("first")
+	defer first.Close()
+	second, secondURL := instanceServer("second")
+	defer second.Close()
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(firstURL, nil)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{firstURL, secondURL}, nil)
+
+	handler := NewInstancePinningMiddleware(resolver)(NewHandlerFunc(types.FaaSConfig{}, resolver, hclog.Default()))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, pinnedRequest("echo", secondURL.Host))
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "second", string(body))
+	resolver.AssertNotCalled(t, "Resolve", "echo")
+}
+
+func TestInstancePinningMiddlewareRejectsUnknownInstance(t *testing.T) {
+	first, firstURL := instanceServer("first")
+	defer first.Close()
+
+	resolver := &services.MockResolver{}
+	resolver.On("ResolveAll", "echo").Return([]url.URL{firstURL}, nil)
+
+	handler := NewInstancePinningMiddleware(resolver)(NewHandlerFunc(types.FaaSConfig{}, resolver, hclog.Default()))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, pinnedRequest("echo", "10.0.0.99:8080"))
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+}
+
+func TestInstancePinningMiddlewareIgnoresRequestsWithoutHeader(t *testing.T) {
+	first, firstURL := instanceServer("first")
+	defer first.Close()
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(firstURL, nil)
+
+	handler := NewInstancePinningMiddleware(resolver)(NewHandlerFunc(types.FaaSConfig{}, resolver, hclog.Default()))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, pinnedRequest("echo", ""))
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	assert.Equal(t, "first", string(body))
+	resolver.AssertNotCalled(t, "ResolveAll", "echo")
+}
@@ -21,15 +21,20 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"github.com/hashicorp/go-hclog"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/openfaas/faas-provider/httputil"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
 	"github.com/openfaas/faas-provider/types"
 )
 
@@ -58,14 +63,20 @@ type BaseURLResolver interface {
 //
 // Note that this will panic if `resolver` is nil.
 func NewHandlerFunc(config types.FaaSConfig, resolver BaseURLResolver, logger hclog.Logger) http.HandlerFunc {
+	settings := &Settings{}
+	settings.client.Store(NewProxyClientFromConfig(config))
+	return NewReloadableHandlerFunc(settings, resolver, logger)
+}
+
+// NewReloadableHandlerFunc creates a http.HandlerFunc to proxy function requests, with the proxy client
+// of the (reloadable) settings.
+func NewReloadableHandlerFunc(settings *Settings, resolver BaseURLResolver, logger hclog.Logger) http.HandlerFunc {
 	if resolver == nil {
 		panic("NewHandlerFunc: empty proxy handler resolver, cannot be nil")
 	}
 
 	log := logger.Named("proxy")
 
-	proxyClient := NewProxyClientFromConfig(config)
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
@@ -79,7 +90,7 @@ func NewHandlerFunc(config types.FaaSConfig, resolver BaseURLResolver, logger hc
 			http.MethodGet,
 			http.MethodOptions,
 			http.MethodHead:
-			proxyRequest(w, r, proxyClient, resolver, log)
+			proxyRequest(w, r, settings, resolver, log)
 
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -132,41 +143,94 @@ func NewProxyClient(timeout time.Duration, maxIdleConns int, maxIdleConnsPerHost
 }
 
 // proxyRequest handles the actual resolution of and then request to the function service.
-func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient *http.Client, resolver BaseURLResolver, log hclog.Logger) {
+func proxyRequest(w http.ResponseWriter, originalReq *http.Request, settings *Settings, resolver BaseURLResolver, log hclog.Logger) {
 	ctx := originalReq.Context()
+	proxyClient := settings.Client()
+	debugHeaders, debugVerbose := settings.DebugHeaders()
 
 	pathVars := mux.Vars(originalReq)
 	functionName := pathVars["name"]
 	if functionName == "" {
-		httputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+		writeProxyError(w, originalReq, http.StatusBadRequest, functionName, "Provide function name in the request path")
 		return
 	}
 
-	functionAddr, resolveErr := resolver.Resolve(functionName)
+	functionAddr, selection, resolveErr := resolveFunction(ctx, resolver, functionName, debugHeaders)
+	if debugHeaders {
+		setSelectionHeaders(w.Header(), selection, functionAddr, debugVerbose)
+	}
 	if resolveErr != nil {
 		// TODO: Should record the 404/not found error in Prometheus.
-		httputil.Errorf(w, http.StatusServiceUnavailable, "No endpoints available for: %s.", functionName)
+		if estimator, ok := resolver.(RetryEstimator); ok {
+			w.Header().Set("Retry-After", formatRetryAfter(estimator.RetryAfter(functionName)))
+		}
+		writeProxyError(w, originalReq, http.StatusServiceUnavailable, functionName, "No endpoints available for: %s.", functionName)
 		return
 	}
 
-	proxyReq, err := buildProxyRequest(originalReq, functionAddr, pathVars["params"])
-	if err != nil {
-		httputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", functionName)
-		return
+	// only the resolved functions are counted, so the names of unknown functions don't create series of the metric,
+	// and the routes of the passthrough services aren't functions
+	if _, passthrough := resolver.(*PassthroughRouter); !passthrough {
+		metrics.ProxyInvocations.WithLabelValues(strings.TrimSuffix(functionName, "."+settings.namespace)).Inc()
 	}
 
-	if proxyReq.Body != nil {
-		defer proxyReq.Body.Close()
+	// a failed request is retried on another instance, unless it is pinned, within the retry budget
+	retries, budget := settings.Retries()
+	if _, pinned := pinnedInstance(ctx); pinned {
+		retries = 0
+	}
+	body, replayable := replayableBody(originalReq, retries)
+	if replayable {
+		budget.Request(functionName)
 	}
 
+	var proxyReq *http.Request
+	var response *http.Response
+	var err error
+
 	start := time.Now()
-	response, err := proxyClient.Do(proxyReq.WithContext(ctx))
+	for attempt := 0; ; attempt++ {
+		if replayable && body != nil {
+			originalReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		proxyReq, err = buildProxyRequest(originalReq, functionAddr, pathVars["params"])
+		if err != nil {
+			writeProxyError(w, originalReq, http.StatusInternalServerError, functionName, "Failed to resolve service: %s.", functionName)
+			return
+		}
+
+		response, err = proxyClient.Do(proxyReq.WithContext(ctx))
+		if !replayable || attempt >= retries || !retryable(ctx, originalReq.Method, response, err) || !budget.Retry(functionName) {
+			break
+		}
+
+		next, nextSelection, resolveErr := resolveFunction(ctx, resolver, functionName, debugHeaders)
+		if resolveErr != nil {
+			break
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		log.Debug("retrying request on another instance", "function", functionName, "target", proxyReq.URL.String(), "attempt", attempt+1)
+		functionAddr, selection = next, nextSelection
+	}
 	seconds := time.Since(start)
 
+	if proxyReq.Body != nil {
+		defer proxyReq.Body.Close()
+	}
+
 	if err != nil {
+		if timeout, ok := requestTimeout(ctx, err, proxyClient); ok {
+			log.Warn("function timed out", "function", functionName, "target", proxyReq.URL.String(), "timeout", timeout)
+			writeTimeout(w, originalReq, functionName, timeout)
+			return
+		}
+
 		log.Error("error with proxy request", "target", proxyReq.URL.String(), "error", err.Error())
 
-		httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", functionName)
+		writeProxyError(w, originalReq, http.StatusInternalServerError, functionName, "Can't reach service for: %s.", functionName)
 		return
 	}
 
@@ -178,11 +242,51 @@ func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient
 
 	clientHeader := w.Header()
 	copyHeaders(clientHeader, &response.Header)
+	if debugHeaders {
+		setSelectionHeaders(clientHeader, selection, functionAddr, debugVerbose)
+	}
 	w.Header().Set("Content-Type", getContentType(originalReq.Header, response.Header))
 
 	w.WriteHeader(response.StatusCode)
 	if response.Body != nil {
-		io.Copy(w, response.Body)
+		copyResponse(w, response.Body, response.ContentLength < 0)
+	}
+}
+
+// resolveFunction returns the instance the request was pinned to, or otherwise resolves one of the function instances,
+// together with the selection of the instance when requested.
+func resolveFunction(ctx context.Context, r BaseURLResolver, functionName string, selection bool) (url.URL, resolver.Selection, error) {
+	if instance, ok := pinnedInstance(ctx); ok {
+		return instance, resolver.Selection{Strategy: strategyPinned}, nil
+	}
+	if selection {
+		return resolveSelection(r, functionName)
+	}
+	instance, err := r.Resolve(functionName)
+	return instance, resolver.Selection{}, err
+}
+
+// copyResponse copies the function response to the client, flushing after every read when the
+// response is streamed, e.g. when the upstream response has no known content length.
+func copyResponse(w http.ResponseWriter, body io.Reader, stream bool) {
+	flusher, ok := w.(http.Flusher)
+	if !stream || !ok {
+		io.Copy(w, body)
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
 	}
 }
 
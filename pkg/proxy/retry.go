@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+)
+
+const (
+	// retryBudgetBuckets is the number of buckets of the sliding window of a retry budget
+	retryBudgetBuckets = 10
+	// maxRetryBodySize is the largest body buffered to retry a request, larger requests aren't retried
+	maxRetryBodySize = 1 << 20
+)
+
+// RetryBudget limits the retries of failed requests on another instance to a ratio of the requests, over a sliding
+// window, both for all functions and per function, so retries don't amplify the load during an outage. A minimum
+// number of retries per window is always allowed, so functions with little traffic can still retry.
+//
+// The budgets are only recorded for the functions which were resolved, and the budget of a function is evicted once
+// the function was idle for longer than the window.
+type RetryBudget struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	ratio      float64
+	minRetries int
+	window     time.Duration
+	global     *retryWindow
+	functions  map[string]*retryWindow
+	swept      time.Time
+}
+
+// retryWindow counts the requests and retries in the buckets of a sliding window.
+type retryWindow struct {
+	buckets [retryBudgetBuckets]retryBucket
+}
+
+type retryBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+func NewRetryBudget(ratio float64, minRetries int, window time.Duration) *RetryBudget {
+	b := &RetryBudget{now: time.Now, global: &retryWindow{}, functions: map[string]*retryWindow{}}
+	b.Configure(ratio, minRetries, window)
+	return b
+}
+
+// Configure changes the ratio, minimum and window of the budget, where a change of the window resets the budget.
+func (b *RetryBudget) Configure(ratio float64, minRetries int, window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+	if window != b.window {
+		b.global = &retryWindow{}
+		b.functions = map[string]*retryWindow{}
+	}
+	b.ratio, b.minRetries, b.window = ratio, minRetries, window
+}
+
+// Request records a request of a function.
+func (b *RetryBudget) Request(functionName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.evictIdle(now)
+	b.global.bucket(now, b.window).requests++
+	b.function(functionName).bucket(now, b.window).requests++
+	b.updateRatio(now)
+}
+
+// Retry withdraws a retry of a function from the budget, reporting whether the retry is allowed.
+func (b *RetryBudget) Retry(functionName string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.evictIdle(now)
+	function := b.function(functionName)
+	if !b.allows(b.global, now) || !b.allows(function, now) {
+		metrics.ProxyRetries.WithLabelValues(functionName, "suppressed").Inc()
+		return false
+	}
+
+	b.global.bucket(now, b.window).retries++
+	function.bucket(now, b.window).retries++
+	b.updateRatio(now)
+
+	metrics.ProxyRetries.WithLabelValues(functionName, "sent").Inc()
+	return true
+}
+
+// Ratio returns the ratio of the retries to the requests of all functions over the window.
+func (b *RetryBudget) Ratio() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries := b.global.totals(b.now(), b.window)
+	return ratio(requests, retries)
+}
+
+func (b *RetryBudget) allows(w *retryWindow, now time.Time) bool {
+	requests, retries := w.totals(now, b.window)
+	return retries < b.minRetries || float64(retries+1) <= b.ratio*float64(requests)
+}
+
+func (b *RetryBudget) function(functionName string) *retryWindow {
+	w, ok := b.functions[functionName]
+	if !ok {
+		w = &retryWindow{}
+		b.functions[functionName] = w
+	}
+	return w
+}
+
+// evictIdle removes the budgets of the functions without requests or retries in the window, at most once per window.
+func (b *RetryBudget) evictIdle(now time.Time) {
+	if now.Sub(b.swept) < b.window {
+		return
+	}
+	b.swept = now
+
+	for functionName, w := range b.functions {
+		if requests, retries := w.totals(now, b.window); requests == 0 && retries == 0 {
+			delete(b.functions, functionName)
+		}
+	}
+}
+
+func (b *RetryBudget) updateRatio(now time.Time) {
+	requests, retries := b.global.totals(now, b.window)
+	metrics.ProxyRetryRatio.Set(ratio(requests, retries))
+}
+
+func ratio(requests, retries int) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(retries) / float64(requests)
+}
+
+// bucket returns the bucket of the current time, resetting it when it belongs to an earlier window.
+func (w *retryWindow) bucket(now time.Time, window time.Duration) *retryBucket {
+	width := window / retryBudgetBuckets
+	start := now.Truncate(width)
+	bucket := &w.buckets[(start.UnixNano()/int64(width))%retryBudgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = retryBucket{start: start}
+	}
+	return bucket
+}
+
+func (w *retryWindow) totals(now time.Time, window time.Duration) (int, int) {
+	requests, retries := 0, 0
+	for _, bucket := range w.buckets {
+		if now.Sub(bucket.start) < window {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	return requests, retries
+}
+
+// retryable reports whether a failed attempt may be retried on another instance: a failure to connect to the
+// instance, so nothing was sent, or a 502, 503 or 504 of the instance for an idempotent request.
+func retryable(ctx context.Context, method string, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	}
+	return false
+}
+
+// replayableBody buffers the body of a request which may be retried, reporting whether the request can be sent
+// again. A body larger than 1 MiB isn't buffered, and is replayed in full to the first attempt only.
+func replayableBody(r *http.Request, retries int) ([]byte, bool) {
+	if retries <= 0 {
+		return nil, false
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	buffered, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRetryBodySize+1))
+	if err != nil || len(buffered) > maxRetryBodySize {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(buffered), r.Body), Closer: r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	return buffered, true
+}
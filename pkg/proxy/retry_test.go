@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingUpstream returns an instance answering with the given status, counting the requests it receives.
+func countingUpstream(t *testing.T, status int) (url.URL, *int32) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	instance, _ := url.Parse(server.URL)
+	return *instance, &hits
+}
+
+func retryConfig(retries int, budget float64, min int) *types.ProviderConfig {
+	config, _ := types.DefaultConfig()
+	config.Proxy.Retries = retries
+	config.Proxy.RetryBudget = budget
+	config.Proxy.RetryBudgetMin = min
+	return config
+}
+
+func TestProxyRetriesFailedRequestOnAnotherInstance(t *testing.T) {
+	failing, failed := countingUpstream(t, http.StatusServiceUnavailable)
+	healthy, served := countingUpstream(t, http.StatusOK)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(failing, nil).Once()
+	resolver.On("Resolve", "echo").Return(healthy, nil).Once()
+
+	handler := NewReloadableHandlerFunc(NewSettings(retryConfig(1, 0.1, 10)), resolver, hclog.NewNullLogger())
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/function/echo", bytes.NewBufferString("hello")), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(failed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(served))
+}
+
+func TestProxyDoesNotRetryNonIdempotentRequestOnFailedResponse(t *testing.T) {
+	failing, failed := countingUpstream(t, http.StatusServiceUnavailable)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(failing, nil)
+
+	handler := NewReloadableHandlerFunc(NewSettings(retryConfig(3, 0.1, 10)), resolver, hclog.NewNullLogger())
+
+	request := mux.SetURLVars(httptest.NewRequest("POST", "/function/echo", nil), map[string]string{"name": "echo"})
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(failed))
+}
+
+func TestProxySuppressesRetriesWhenBudgetIsExhausted(t *testing.T) {
+	failing, failed := countingUpstream(t, http.StatusServiceUnavailable)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(failing, nil)
+
+	// every request fails, so without a budget each request would be sent three times
+	settings := NewSettings(retryConfig(2, 0.1, 2))
+	handler := NewReloadableHandlerFunc(settings, resolver, hclog.NewNullLogger())
+
+	for i := 0; i < 100; i++ {
+		recorder := httptest.NewRecorder()
+		handler(recorder, waitRequest("echo"))
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	retries := atomic.LoadInt32(failed) - 100
+	assert.LessOrEqual(t, retries, int32(10))
+	assert.Greater(t, retries, int32(0))
+
+	_, budget := settings.Retries()
+	assert.LessOrEqual(t, budget.Ratio(), 0.1)
+}
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	budget := NewRetryBudget(0.1, 1, 10*time.Second)
+	budget.now = func() time.Time { return now }
+
+	// the minimum is allowed without requests
+	assert.True(t, budget.Retry("echo"))
+	assert.False(t, budget.Retry("echo"))
+
+	for i := 0; i < 20; i++ {
+		budget.Request("echo")
+	}
+	assert.True(t, budget.Retry("echo"))
+	assert.False(t, budget.Retry("echo"))
+
+	// the budget of a function is limited by the global budget as well
+	assert.False(t, budget.Retry("other"))
+
+	// the retries move out of the window
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, 0.0, budget.Ratio())
+	assert.True(t, budget.Retry("echo"))
+}
+
+func TestRetryBudgetLimitsEachFunction(t *testing.T) {
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	budget := NewRetryBudget(0.5, 0, 10*time.Second)
+	budget.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		budget.Request("echo")
+	}
+	budget.Request("other")
+	budget.Request("other")
+
+	assert.True(t, budget.Retry("other"))
+	assert.False(t, budget.Retry("other"))
+	assert.True(t, budget.Retry("echo"))
+}
+
+func TestRetryBudgetEvictsIdleFunctions(t *testing.T) {
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	budget := NewRetryBudget(0.5, 0, 10*time.Second)
+	budget.now = func() time.Time { return now }
+
+	budget.Request("echo")
+	budget.Request("other")
+	assert.Len(t, budget.functions, 2)
+
+	now = now.Add(5 * time.Second)
+	budget.Request("echo")
+
+	now = now.Add(11 * time.Second)
+	budget.Request("figlet")
+	assert.Len(t, budget.functions, 1)
+	assert.Contains(t, budget.functions, "figlet")
+}
+
+func TestProxyDoesNotRecordBudgetOfUnresolvedFunctions(t *testing.T) {
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "mistyped-echo").Return(url.URL{}, fmt.Errorf("no instances"))
+
+	settings := NewSettings(retryConfig(2, 0.1, 2))
+	handler := NewReloadableHandlerFunc(settings, resolver, hclog.NewNullLogger())
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("mistyped-echo"))
+
+	_, budget := settings.Retries()
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Empty(t, budget.functions)
+}
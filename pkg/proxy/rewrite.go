@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const pathPrefixLabel = "com.openfaas.path-prefix"
+
+// pathRewrite strips a prefix from the path of a request to a function, and then prepends another prefix.
+type pathRewrite struct {
+	strip   string
+	prepend string
+}
+
+// NewPathRewriteMiddleware rewrites the path forwarded to a function with the `com.openfaas.path-prefix` label, for
+// functions serving their routes at a fixed sub-path. The path is the path following /function/{name}, of which the
+// label strips and prepends a prefix, e.g. strip=/v1,prepend=/api forwards /function/echo/v1/users to /api/users. A
+// label with a single prefix, e.g. /api, only prepends the prefix. The query string is always forwarded as is.
+//
+// An invalid label is ignored, forwarding the path unchanged.
+func NewPathRewriteMiddleware(labels LabelsReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			rewrite, ok := functionPathRewrite(labels, vars["name"])
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			rewritten := make(map[string]string, len(vars))
+			for k, v := range vars {
+				rewritten[k] = v
+			}
+			rewritten["params"] = strings.TrimPrefix(rewrite.apply("/"+vars["params"]), "/")
+
+			next(w, mux.SetURLVars(r, rewritten))
+		}
+	}
+}
+
+func functionPathRewrite(labels LabelsReader, functionName string) (pathRewrite, bool) {
+	if labels == nil || functionName == "" {
+		return pathRewrite{}, false
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return pathRewrite{}, false
+	}
+	value, ok := values[pathPrefixLabel]
+	if !ok {
+		return pathRewrite{}, false
+	}
+	rewrite, err := parsePathRewrite(value)
+	if err != nil {
+		return pathRewrite{}, false
+	}
+	return rewrite, true
+}
+
+// parsePathRewrite parses a rewrite as comma separated strip=prefix and prepend=prefix pairs, or a single prefix
+// to prepend.
+func parsePathRewrite(value string) (pathRewrite, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "=") {
+		prefix, err := parsePathPrefix(value)
+		return pathRewrite{prepend: prefix}, err
+	}
+
+	var rewrite pathRewrite
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return pathRewrite{}, fmt.Errorf("expected strip=prefix or prepend=prefix, got '%s'", pair)
+		}
+
+		prefix, err := parsePathPrefix(parts[1])
+		if err != nil {
+			return pathRewrite{}, err
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "strip":
+			rewrite.strip = prefix
+		case "prepend":
+			rewrite.prepend = prefix
+		default:
+			return pathRewrite{}, fmt.Errorf("expected strip=prefix or prepend=prefix, got '%s'", pair)
+		}
+	}
+	return rewrite, nil
+}
+
+func parsePathPrefix(value string) (string, error) {
+	prefix := strings.TrimSpace(value)
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("invalid prefix '%s', must start with a slash", prefix)
+	}
+	return strings.TrimRight(prefix, "/"), nil
+}
+
+// apply rewrites a path, where the strip prefix only matches whole segments of the path.
+func (p pathRewrite) apply(path string) string {
+	if len(p.strip) != 0 {
+		if path == p.strip {
+			path = "/"
+		} else if strings.HasPrefix(path, p.strip+"/") {
+			path = path[len(p.strip):]
+		}
+	}
+	if len(p.prepend) != 0 {
+		if path == "/" {
+			return p.prepend
+		}
+		return p.prepend + path
+	}
+	return path
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupRewriteProxy proxies to an upstream which responds with the request URI it received.
+func setupRewriteProxy(t *testing.T, labels map[string]string) http.HandlerFunc {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	t.Cleanup(server.Close)
+	instance, _ := url.Parse(server.URL)
+
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(*instance, nil)
+
+	config, _ := types.DefaultConfig()
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	return NewPathRewriteMiddleware(reader)(handler)
+}
+
+func rewriteRequest(params, query string) *http.Request {
+	target := "/function/echo/" + params
+	if len(query) != 0 {
+		target += "?" + query
+	}
+	request := httptest.NewRequest("GET", target, nil)
+	return mux.SetURLVars(request, map[string]string{"name": "echo", "params": params})
+}
+
+func proxiedURI(handler http.HandlerFunc, params, query string) string {
+	recorder := httptest.NewRecorder()
+	handler(recorder, rewriteRequest(params, query))
+	return recorder.Body.String()
+}
+
+func TestPathRewriteStripsPrefix(t *testing.T) {
+	handler := setupRewriteProxy(t, map[string]string{pathPrefixLabel: "strip=/v1"})
+
+	assert.Equal(t, "/users?page=2", proxiedURI(handler, "v1/users", "page=2"))
+	assert.Equal(t, "/", proxiedURI(handler, "v1", ""))
+	// only whole segments are stripped
+	assert.Equal(t, "/v10/users", proxiedURI(handler, "v10/users", ""))
+	assert.Equal(t, "/v2/users", proxiedURI(handler, "v2/users", ""))
+}
+
+func TestPathRewritePrependsPrefix(t *testing.T) {
+	handler := setupRewriteProxy(t, map[string]string{pathPrefixLabel: "/api/"})
+
+	assert.Equal(t, "/api/users?page=2&sort=name", proxiedURI(handler, "users", "page=2&sort=name"))
+	assert.Equal(t, "/api", proxiedURI(handler, "", ""))
+}
+
+func TestPathRewriteStripsAndPrependsPrefix(t *testing.T) {
+	handler := setupRewriteProxy(t, map[string]string{pathPrefixLabel: "strip=/v1, prepend=/api"})
+
+	assert.Equal(t, "/api/users?page=2", proxiedURI(handler, "v1/users", "page=2"))
+	assert.Equal(t, "/api/v2/users", proxiedURI(handler, "v2/users", ""))
+}
+
+func TestPathRewriteIgnoresInvalidLabel(t *testing.T) {
+	for _, value := range []string{"api", "strip=v1", "remove=/v1", "strip=/v1,"} {
+		handler := setupRewriteProxy(t, map[string]string{pathPrefixLabel: value})
+
+		assert.Equal(t, "/v1/users?page=2", proxiedURI(handler, "v1/users", "page=2"), value)
+	}
+}
+
+func TestPathRewriteWithoutLabel(t *testing.T) {
+	handler := setupRewriteProxy(t, map[string]string{})
+
+	assert.Equal(t, "/users?page=2", proxiedURI(handler, "users", "page=2"))
+}
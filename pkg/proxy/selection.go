@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+)
+
+const (
+	CandidatesHeader = "X-Faas-Candidates"
+	SelectedHeader   = "X-Faas-Selected"
+	StrategyHeader   = "X-Faas-LB"
+	UpstreamHeader   = "X-Faas-Upstream"
+
+	// strategyPinned is reported for requests pinned to an instance with the `X-Faas-Instance` header
+	strategyPinned = "pinned"
+)
+
+// SelectionResolver resolves an instance of a function, together with how the load balancer selected it.
+type SelectionResolver interface {
+	ResolveSelection(functionName string) (url.URL, resolver.Selection, error)
+}
+
+// resolveSelection resolves an instance of a function, reporting its selection when the resolver supports it.
+func resolveSelection(r BaseURLResolver, functionName string) (url.URL, resolver.Selection, error) {
+	if selector, ok := r.(SelectionResolver); ok {
+		return selector.ResolveSelection(functionName)
+	}
+	instance, err := r.Resolve(functionName)
+	return instance, resolver.Selection{}, err
+}
+
+// setSelectionHeaders reports the selection of the instance in the response headers, to debug the load balancing.
+// The address of the instance is only included when verbose, as it exposes the internal network of the cluster.
+func setSelectionHeaders(header http.Header, selection resolver.Selection, instance url.URL, verbose bool) {
+	if len(selection.Strategy) == 0 {
+		return
+	}
+
+	header.Set(StrategyHeader, selection.Strategy)
+	if selection.Strategy != strategyPinned {
+		header.Set(CandidatesHeader, strconv.Itoa(selection.Candidates))
+		if selection.Candidates > 0 {
+			header.Set(SelectedHeader, strconv.Itoa(selection.Index))
+		}
+	}
+	if verbose && len(instance.Host) != 0 {
+		header.Set(UpstreamHeader, instance.Host)
+	}
+}
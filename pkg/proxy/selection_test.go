@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/resolver"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type selectionResolver struct {
+	instance  url.URL
+	selection resolver.Selection
+	err       error
+}
+
+func (s *selectionResolver) Resolve(functionName string) (url.URL, error) {
+	return s.instance, s.err
+}
+
+func (s *selectionResolver) ResolveSelection(functionName string) (url.URL, resolver.Selection, error) {
+	return s.instance, s.selection, s.err
+}
+
+func setupSelectionProxy(debug, verbose bool, r BaseURLResolver) http.HandlerFunc {
+	config, _ := types.DefaultConfig()
+	config.Proxy.DebugHeaders = debug
+	config.Proxy.DebugVerbose = verbose
+
+	return NewReloadableHandlerFunc(NewSettings(config), r, hclog.NewNullLogger())
+}
+
+func selectionRequest() *http.Request {
+	return mux.SetURLVars(httptest.NewRequest("GET", "/function/echo", nil), map[string]string{"name": "echo"})
+}
+
+func selectionUpstream(t *testing.T) (*httptest.Server, url.URL) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	instance, _ := url.Parse(server.URL)
+	return server, *instance
+}
+
+func TestProxyReportsSelectionHeaders(t *testing.T) {
+	_, instance := selectionUpstream(t)
+	handler := setupSelectionProxy(true, false, &selectionResolver{
+		instance:  instance,
+		selection: resolver.Selection{Candidates: 5, Index: 2, Strategy: resolver.StrategyRoundRobin},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, selectionRequest())
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "5", recorder.Header().Get(CandidatesHeader))
+	assert.Equal(t, "2", recorder.Header().Get(SelectedHeader))
+	assert.Equal(t, "roundrobin", recorder.Header().Get(StrategyHeader))
+	assert.Empty(t, recorder.Header().Get(UpstreamHeader), "the address of the instance is only reported when verbose")
+}
+
+func TestProxyReportsUpstreamWhenVerbose(t *testing.T) {
+	_, instance := selectionUpstream(t)
+	handler := setupSelectionProxy(true, true, &selectionResolver{
+		instance:  instance,
+		selection: resolver.Selection{Candidates: 1, Index: 0, Strategy: resolver.StrategyRandom},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, selectionRequest())
+
+	assert.Equal(t, instance.Host, recorder.Header().Get(UpstreamHeader))
+}
+
+func TestProxyReportsSelectionWithoutCandidates(t *testing.T) {
+	handler := setupSelectionProxy(true, true, &selectionResolver{
+		selection: resolver.Selection{Strategy: resolver.StrategyRandom},
+		err:       fmt.Errorf("no candidate available"),
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, selectionRequest())
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "0", recorder.Header().Get(CandidatesHeader))
+	assert.Empty(t, recorder.Header().Get(SelectedHeader))
+	assert.Empty(t, recorder.Header().Get(UpstreamHeader))
+}
+
+func TestProxyOmitsSelectionHeadersByDefault(t *testing.T) {
+	_, instance := selectionUpstream(t)
+	handler := setupSelectionProxy(false, true, &selectionResolver{
+		instance:  instance,
+		selection: resolver.Selection{Candidates: 5, Index: 2, Strategy: resolver.StrategyRoundRobin},
+	})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, selectionRequest())
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get(CandidatesHeader))
+	assert.Empty(t, recorder.Header().Get(StrategyHeader))
+	assert.Empty(t, recorder.Header().Get(UpstreamHeader))
+}
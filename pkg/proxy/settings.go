@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// Settings holds the proxy settings which can be reloaded without a restart: the read timeout of the
+// proxy client, the cache TTL of functions without a `com.openfaas.cache-ttl` label, the debug headers and
+// the retries of failed requests with their budget.
+//
+// A reload swaps the proxy client, so requests in flight complete with the client they started with.
+type Settings struct {
+	namespace   string
+	client      atomic.Value
+	readTimeout int64
+	cacheTTL    int64
+	debug       int32
+	retries     int32
+	retryBudget *RetryBudget
+}
+
+const (
+	debugHeaders int32 = 1 << iota
+	debugVerbose
+)
+
+func NewSettings(config *types.ProviderConfig) *Settings {
+	s := &Settings{namespace: config.Scheduling.Namespace, retryBudget: NewRetryBudget(config.Proxy.RetryBudget, config.Proxy.RetryBudgetMin, config.Proxy.RetryBudgetWindow)}
+	_ = s.Reload(config)
+	return s
+}
+
+func (s *Settings) Client() *http.Client {
+	return s.client.Load().(*http.Client)
+}
+
+func (s *Settings) CacheTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.cacheTTL))
+}
+
+// DebugHeaders returns whether the selection of the instance is reported in the response headers, and whether
+// the address of the instance is included.
+func (s *Settings) DebugHeaders() (enabled bool, verbose bool) {
+	debug := atomic.LoadInt32(&s.debug)
+	return debug&debugHeaders != 0, debug&debugVerbose != 0
+}
+
+// Retries returns the number of times a failed request is retried on another instance, and the budget limiting
+// the retries.
+func (s *Settings) Retries() (int, *RetryBudget) {
+	return int(atomic.LoadInt32(&s.retries)), s.retryBudget
+}
+
+func (s *Settings) Reload(config *types.ProviderConfig) error {
+	timeout := config.FaaS.GetReadTimeout()
+	if atomic.SwapInt64(&s.readTimeout, int64(timeout)) != int64(timeout) || s.client.Load() == nil {
+		s.client.Store(NewProxyClientFromConfig(config.FaaS))
+	}
+	atomic.StoreInt64(&s.cacheTTL, int64(config.Proxy.CacheDefaultTTL))
+
+	var debug int32
+	if config.Proxy.DebugHeaders {
+		debug |= debugHeaders
+		if config.Proxy.DebugVerbose {
+			debug |= debugVerbose
+		}
+	}
+	atomic.StoreInt32(&s.debug, debug)
+
+	atomic.StoreInt32(&s.retries, int32(config.Proxy.Retries))
+	if s.retryBudget != nil {
+		s.retryBudget.Configure(config.Proxy.RetryBudget, config.Proxy.RetryBudgetMin, config.Proxy.RetryBudgetWindow)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsReloadReadTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.FaaS.ReadTimeout = 10 * time.Second
+
+	settings := NewSettings(config)
+	client := settings.Client()
+	assert.Equal(t, 10*time.Second, client.Timeout)
+
+	assert.NoError(t, settings.Reload(config))
+	assert.Same(t, client, settings.Client())
+
+	config.FaaS.ReadTimeout = 30 * time.Second
+	assert.NoError(t, settings.Reload(config))
+	assert.Equal(t, 30*time.Second, settings.Client().Timeout)
+	assert.Equal(t, 10*time.Second, client.Timeout)
+}
+
+func TestSettingsReloadCacheTTL(t *testing.T) {
+	config, _ := types.DefaultConfig()
+
+	settings := NewSettings(config)
+	assert.Equal(t, time.Duration(0), settings.CacheTTL())
+
+	config.Proxy.CacheDefaultTTL = time.Minute
+	assert.NoError(t, settings.Reload(config))
+	assert.Equal(t, time.Minute, settings.CacheTTL())
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	sloLatencyLabel   = "com.openfaas.slo.latency"
+	sloObjectiveLabel = "com.openfaas.slo.objective"
+
+	defaultSLOObjective = 0.99
+
+	// sloResolution is the width of the buckets counting the invocations, so the windows move in steps of it.
+	sloResolution = 10 * time.Second
+)
+
+// SLOWindows are the windows over which the burn rate of the latency objective of a function is computed.
+var SLOWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// FunctionSLO is the state of the latency objective of a function.
+type FunctionSLO struct {
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Latency   string      `json:"latency"`
+	Objective float64     `json:"objective"`
+	Windows   []SLOWindow `json:"windows"`
+}
+
+// SLOWindow is the state of the latency objective of a function over a window.
+type SLOWindow struct {
+	Window       string  `json:"window"`
+	Requests     uint64  `json:"requests"`
+	SlowRequests uint64  `json:"slowRequests"`
+	SlowRatio    float64 `json:"slowRatio"`
+	BurnRate     float64 `json:"burnRate"`
+}
+
+type sloBucket struct {
+	index int64
+	total uint64
+	slow  uint64
+}
+
+type functionSLO struct {
+	latency   time.Duration
+	objective float64
+	buckets   []sloBucket
+}
+
+// SLOTracker tracks the latency objective of the functions with the `com.openfaas.slo.latency` label, e.g. 250ms:
+// the fraction of the invocations of a function taking longer than the latency, over the last 5 minutes and the
+// last hour. The burn rate is that fraction relative to the error budget of the `com.openfaas.slo.objective` label,
+// 0.99 by default, so a burn rate above 1 spends the budget faster than the objective allows.
+//
+// The latencies are exported as histogram per function, and the tracker is a collector computing the slow ratio
+// and the burn rate per function and window when the metrics are scraped.
+type SLOTracker struct {
+	namespace string
+	labels    LabelsReader
+	now       func() time.Time
+
+	mu        sync.Mutex
+	functions map[string]*functionSLO
+}
+
+func NewSLOTracker(namespace string, labels LabelsReader) *SLOTracker {
+	return &SLOTracker{
+		namespace: namespace,
+		labels:    labels,
+		now:       time.Now,
+		functions: map[string]*functionSLO{},
+	}
+}
+
+// Middleware times the invocations of the functions with a latency objective.
+func (t *SLOTracker) Middleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			functionName := strings.TrimSuffix(mux.Vars(r)["name"], "."+t.namespace)
+			latency, objective := t.objective(functionName)
+			if latency <= 0 {
+				next(w, r)
+				return
+			}
+
+			start := t.now()
+			next(w, r)
+			t.observe(functionName, latency, objective, t.now().Sub(start))
+		}
+	}
+}
+
+func (t *SLOTracker) objective(functionName string) (time.Duration, float64) {
+	if t.labels == nil || functionName == "" {
+		return 0, 0
+	}
+	values, err := t.labels.Labels(functionName)
+	if err != nil {
+		return 0, 0
+	}
+	latency := types.ParseIntOrDurationValueFromMap(&values, sloLatencyLabel, 0)
+	objective := types.ParseFloatValueFromMap(&values, sloObjectiveLabel, defaultSLOObjective)
+	if objective <= 0 || objective >= 1 {
+		objective = defaultSLOObjective
+	}
+	return latency, objective
+}
+
+func (t *SLOTracker) observe(functionName string, latency time.Duration, objective float64, duration time.Duration) {
+	metrics.ProxyLatency.WithLabelValues(functionName).Observe(duration.Seconds())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.functions[functionName]
+	if !ok {
+		f = &functionSLO{buckets: make([]sloBucket, sloBucketCount())}
+		t.functions[functionName] = f
+	}
+	// a changed label applies to the invocations from now on
+	f.latency = latency
+	f.objective = objective
+
+	index := t.now().UnixNano() / int64(sloResolution)
+	b := &f.buckets[index%int64(len(f.buckets))]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+	b.total++
+	if duration > latency {
+		b.slow++
+	}
+}
+
+// Report returns the state of the latency objective of a function, or false when the function has no invocations
+// with a latency objective during the longest window.
+func (t *SLOTracker) Report(functionName string) (FunctionSLO, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.functions[functionName]
+	if !ok {
+		return FunctionSLO{}, false
+	}
+	return t.report(functionName, f), true
+}
+
+func (t *SLOTracker) report(functionName string, f *functionSLO) FunctionSLO {
+	result := FunctionSLO{
+		Name:      functionName,
+		Namespace: t.namespace,
+		Latency:   f.latency.String(),
+		Objective: f.objective,
+	}
+
+	current := t.now().UnixNano() / int64(sloResolution)
+	for _, window := range SLOWindows {
+		// the current bucket is partial, so a window covers at most one resolution step more than its width
+		oldest := current - int64(window/sloResolution)
+		w := SLOWindow{Window: formatWindow(window)}
+		for _, b := range f.buckets {
+			if b.index > oldest && b.index <= current {
+				w.Requests += b.total
+				w.SlowRequests += b.slow
+			}
+		}
+		if w.Requests != 0 {
+			w.SlowRatio = float64(w.SlowRequests) / float64(w.Requests)
+			w.BurnRate = w.SlowRatio / (1 - f.objective)
+		}
+		result.Windows = append(result.Windows, w)
+	}
+	return result
+}
+
+// RemoveCacheItem drops the invocations of a function, e.g. when it is deleted.
+func (t *SLOTracker) RemoveCacheItem(functionName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.functions, functionName)
+	metrics.ProxyLatency.DeleteLabelValues(functionName)
+}
+
+func (t *SLOTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.SLOSlowRatio
+	ch <- metrics.SLOBurnRate
+}
+
+// Collect reports the slow ratio and the burn rate per function and window, and drops the functions without
+// invocations during the longest window.
+func (t *SLOTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for functionName, f := range t.functions {
+		report := t.report(functionName, f)
+		if report.Windows[len(report.Windows)-1].Requests == 0 {
+			delete(t.functions, functionName)
+			continue
+		}
+		for _, w := range report.Windows {
+			ch <- prometheus.MustNewConstMetric(metrics.SLOSlowRatio, prometheus.GaugeValue, w.SlowRatio, functionName, w.Window)
+			ch <- prometheus.MustNewConstMetric(metrics.SLOBurnRate, prometheus.GaugeValue, w.BurnRate, functionName, w.Window)
+		}
+	}
+}
+
+func sloBucketCount() int {
+	longest := time.Duration(0)
+	for _, window := range SLOWindows {
+		if window > longest {
+			longest = window
+		}
+	}
+	return int(longest/sloResolution) + 1
+}
+
+// formatWindow formats a window the way burn rate alerts name them, e.g. 5m or 1h.
+func formatWindow(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return strings.TrimSuffix(window.String(), "0m0s")
+	}
+	return strings.TrimSuffix(window.String(), "0s")
+}
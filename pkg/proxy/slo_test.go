@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupSLOTracker returns a tracker with a fake clock, and a handler taking the latency given to each invocation.
+func setupSLOTracker(labels map[string]string) (*SLOTracker, *time.Time, func(name string, latency time.Duration)) {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+	reader.On("Labels", "figlet").Return(map[string]string{}, nil)
+
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewSLOTracker("default", reader)
+	tracker.now = func() time.Time { return now }
+
+	var latency time.Duration
+	handler := tracker.Middleware()(func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(latency)
+	})
+
+	invoke := func(name string, l time.Duration) {
+		latency = l
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/function/"+name, nil), map[string]string{"name": name})
+		handler(httptest.NewRecorder(), request)
+	}
+	return tracker, &now, invoke
+}
+
+func TestSLOTrackerComputesBurnRatePerWindow(t *testing.T) {
+	tracker, now, invoke := setupSLOTracker(map[string]string{sloLatencyLabel: "250ms"})
+
+	// an hour ago, 100 invocations of which 1 was slow
+	for i := 0; i < 99; i++ {
+		invoke("echo", 100*time.Millisecond)
+	}
+	invoke("echo.default", time.Second)
+
+	*now = now.Add(58 * time.Minute)
+
+	// the last minute, 10 invocations of which 5 were slow
+	for i := 0; i < 5; i++ {
+		invoke("echo", 50*time.Millisecond)
+		invoke("echo", 300*time.Millisecond)
+	}
+
+	report, ok := tracker.Report("echo")
+	assert.True(t, ok)
+	assert.Equal(t, "echo", report.Name)
+	assert.Equal(t, "default", report.Namespace)
+	assert.Equal(t, "250ms", report.Latency)
+	assert.Equal(t, 0.99, report.Objective)
+	assert.Len(t, report.Windows, 2)
+
+	assert.Equal(t, "5m", report.Windows[0].Window)
+	assert.Equal(t, uint64(10), report.Windows[0].Requests)
+	assert.Equal(t, uint64(5), report.Windows[0].SlowRequests)
+	assert.InDelta(t, 0.5, report.Windows[0].SlowRatio, 1e-9)
+	assert.InDelta(t, 50, report.Windows[0].BurnRate, 1e-9)
+
+	assert.Equal(t, "1h", report.Windows[1].Window)
+	assert.Equal(t, uint64(110), report.Windows[1].Requests)
+	assert.Equal(t, uint64(6), report.Windows[1].SlowRequests)
+	assert.InDelta(t, 6.0/110, report.Windows[1].SlowRatio, 1e-9)
+	assert.InDelta(t, 600.0/110, report.Windows[1].BurnRate, 1e-9)
+
+	// the first invocations slide out of the hour
+	*now = now.Add(5 * time.Minute)
+
+	report, _ = tracker.Report("echo")
+	assert.Equal(t, uint64(0), report.Windows[0].Requests)
+	assert.Equal(t, 0.0, report.Windows[0].BurnRate)
+	assert.Equal(t, uint64(10), report.Windows[1].Requests)
+	assert.InDelta(t, 50, report.Windows[1].BurnRate, 1e-9)
+}
+
+func TestSLOTrackerUsesObjectiveLabel(t *testing.T) {
+	tracker, _, invoke := setupSLOTracker(map[string]string{sloLatencyLabel: "1s", sloObjectiveLabel: "0.9"})
+
+	for i := 0; i < 9; i++ {
+		invoke("echo", 500*time.Millisecond)
+	}
+	invoke("echo", 2*time.Second)
+
+	report, _ := tracker.Report("echo")
+	assert.Equal(t, 0.9, report.Objective)
+	assert.InDelta(t, 1, report.Windows[0].BurnRate, 1e-9)
+}
+
+func TestSLOTrackerIgnoresFunctionsWithoutLatencyObjective(t *testing.T) {
+	tracker, _, invoke := setupSLOTracker(map[string]string{sloLatencyLabel: "250ms"})
+
+	invoke("figlet", time.Second)
+
+	_, ok := tracker.Report("figlet")
+	assert.False(t, ok)
+}
+
+func TestSLOTrackerCollectsBurnRates(t *testing.T) {
+	tracker, now, invoke := setupSLOTracker(map[string]string{sloLatencyLabel: "250ms"})
+
+	invoke("echo", 100*time.Millisecond)
+	invoke("echo", time.Second)
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(tracker))
+	assert.Equal(t, 4, testutil.CollectAndCount(tracker))
+
+	// functions without invocations during the last hour are dropped
+	*now = now.Add(2 * time.Hour)
+	assert.Equal(t, 0, testutil.CollectAndCount(tracker))
+
+	_, ok := tracker.Report("echo")
+	assert.False(t, ok)
+}
+
+func TestSLOTrackerRemovesDeletedFunctions(t *testing.T) {
+	tracker, _, invoke := setupSLOTracker(map[string]string{sloLatencyLabel: "250ms"})
+
+	invoke("echo", time.Second)
+	tracker.RemoveCacheItem("echo")
+
+	_, ok := tracker.Report("echo")
+	assert.False(t, ok)
+}
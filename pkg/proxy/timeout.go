@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	timeoutLabel = "com.openfaas.timeout"
+
+	// DeadlineHeader carries the deadline of a request as RFC 3339 timestamp, e.g. 2021-04-01T12:00:00.5Z.
+	DeadlineHeader = "X-Faas-Deadline"
+)
+
+type functionTimeoutKey struct{}
+
+// FunctionTimeout is the body of the 504 returned when a function doesn't respond within its timeout.
+type FunctionTimeout struct {
+	Error    string `json:"error"`
+	Function string `json:"function"`
+	Timeout  string `json:"timeout"`
+}
+
+// NewTimeoutMiddleware limits the time a function gets to respond with the `com.openfaas.timeout` label, e.g. 30s,
+// by cancelling the upstream request when the timeout passes, so the function instance stops processing a request
+// the client no longer waits for. Without the label, the read timeout of the proxy client applies.
+//
+// A deadline set by the caller with the `X-Faas-Deadline` header is honoured as well, whichever passes first. The
+// deadline of the request is passed to the function with the same header, so cooperative functions can abort early.
+func NewTimeoutMiddleware(labels LabelsReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			functionName := mux.Vars(r)["name"]
+			now := time.Now()
+
+			timeout := functionTimeout(labels, functionName)
+			deadline, ok := requestDeadline(r)
+			if timeout > 0 && (!ok || now.Add(timeout).Before(deadline)) {
+				deadline, ok = now.Add(timeout), true
+			} else if ok {
+				timeout = deadline.Sub(now).Round(time.Millisecond)
+			}
+			if !ok {
+				next(w, r)
+				return
+			}
+
+			if !deadline.After(now) {
+				writeTimeout(w, r, functionName, 0)
+				return
+			}
+
+			ctx, cancel := context.WithDeadline(context.WithValue(r.Context(), functionTimeoutKey{}, timeout), deadline)
+			defer cancel()
+
+			r.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// requestDeadline returns the deadline set by the caller of a request, ignoring a malformed deadline.
+func requestDeadline(r *http.Request) (time.Time, bool) {
+	value := r.Header.Get(DeadlineHeader)
+	if len(value) == 0 {
+		return time.Time{}, false
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return deadline, true
+}
+
+func functionTimeout(labels LabelsReader, functionName string) time.Duration {
+	if labels == nil || functionName == "" {
+		return 0
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return 0
+	}
+	return types.ParseIntOrDurationValueFromMap(&values, timeoutLabel, 0)
+}
+
+// requestTimeout returns the timeout which was exceeded by a failed upstream request, either the timeout of the
+// function or the timeout of the proxy client.
+func requestTimeout(ctx context.Context, err error, client *http.Client) (time.Duration, bool) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timeout, _ := ctx.Value(functionTimeoutKey{}).(time.Duration)
+		return timeout, true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return client.Timeout, true
+	}
+	return 0, false
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func slowUpstream(t *testing.T, delay time.Duration) (url.URL, chan struct{}) {
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		case <-time.After(delay):
+			w.Write([]byte("done"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	instance, _ := url.Parse(server.URL)
+	return *instance, cancelled
+}
+
+func setupTimeoutProxy(config *types.ProviderConfig, instance url.URL, labels map[string]string) http.HandlerFunc {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+
+	resolver := &services.MockResolver{}
+	resolver.On("Resolve", "echo").Return(instance, nil)
+
+	handler := NewReloadableHandlerFunc(NewSettings(config), resolver, hclog.NewNullLogger())
+	return NewTimeoutMiddleware(reader)(handler)
+}
+
+func TestProxyReportsFunctionTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, cancelled := slowUpstream(t, 5*time.Second)
+	handler := setupTimeoutProxy(config, instance, map[string]string{timeoutLabel: "100ms"})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var body FunctionTimeout
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, FunctionTimeout{Error: "function timed out", Function: "echo", Timeout: "100ms"}, body)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestProxyReportsReadTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.FaaS.ReadTimeout = 100 * time.Millisecond
+	instance, _ := slowUpstream(t, 5*time.Second)
+	handler := setupTimeoutProxy(config, instance, map[string]string{})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"timeout":"100ms"`)
+}
+
+func TestProxyRespondsWithinFunctionTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, _ := slowUpstream(t, 10*time.Millisecond)
+	handler := setupTimeoutProxy(config, instance, map[string]string{timeoutLabel: "2s"})
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "done", recorder.Body.String())
+}
+
+func deadlineUpstream(t *testing.T) (url.URL, chan string) {
+	deadlines := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadlines <- r.Header.Get(DeadlineHeader)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("done"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	instance, _ := url.Parse(server.URL)
+	return *instance, deadlines
+}
+
+func TestProxyPassesDeadlineOfFunctionTimeout(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, deadlines := deadlineUpstream(t)
+	handler := setupTimeoutProxy(config, instance, map[string]string{timeoutLabel: "100ms"})
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.True(t, time.Since(start) < time.Second, "upstream request should be cancelled at the deadline")
+
+	deadline, err := time.Parse(time.RFC3339Nano, <-deadlines)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, start.Add(100*time.Millisecond), deadline, 50*time.Millisecond)
+}
+
+func TestProxyHonoursDeadlineOfCaller(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, deadlines := deadlineUpstream(t)
+	handler := setupTimeoutProxy(config, instance, map[string]string{timeoutLabel: "2s"})
+
+	expected := time.Now().Add(150 * time.Millisecond).UTC()
+	request := waitRequest("echo")
+	request.Header.Set(DeadlineHeader, expected.Format(time.RFC3339Nano))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.True(t, time.Now().Before(expected.Add(time.Second)), "upstream request should be cancelled at the deadline of the caller")
+	assert.Equal(t, expected.Format(time.RFC3339Nano), <-deadlines)
+}
+
+func TestProxyRejectsRequestPastDeadline(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, deadlines := deadlineUpstream(t)
+	handler := setupTimeoutProxy(config, instance, map[string]string{})
+
+	request := waitRequest("echo")
+	request.Header.Set(DeadlineHeader, time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Empty(t, deadlines, "the function should not be called")
+}
+
+func TestProxyIgnoresMalformedDeadline(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	instance, _ := slowUpstream(t, 10*time.Millisecond)
+	handler := setupTimeoutProxy(config, instance, map[string]string{})
+
+	request := waitRequest("echo")
+	request.Header.Set(DeadlineHeader, "soon")
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
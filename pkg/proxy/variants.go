@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// VariantWeightsHeader selects the variant of a function serving a request, as comma separated variant=weight
+// pairs, e.g. v1=70,v2=30.
+const VariantWeightsHeader = "X-Faas-Variant-Weights"
+
+var variantNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+type variantWeight struct {
+	variant string
+	weight  int
+}
+
+// NewVariantMiddleware routes the requests with the X-Faas-Variant-Weights header to a variant of the function,
+// deployed as the function name suffixed with the variant, e.g. echo to echo-v2, selected at random by weight.
+// Variants which aren't deployed are ignored, and requests without the header, or without any deployed variant,
+// are served by the function itself as the default variant. A malformed header is rejected with a 400.
+func NewVariantMiddleware(reader LabelsReader) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			vars := mux.Vars(r)
+			functionName := vars["name"]
+			header := r.Header.Get(VariantWeightsHeader)
+			if functionName == "" || header == "" {
+				next(w, r)
+				return
+			}
+
+			weights, err := parseVariantWeights(header)
+			if err != nil {
+				writeProxyError(w, r, http.StatusBadRequest, functionName, "Invalid %s header: %s.", VariantWeightsHeader, err)
+				return
+			}
+
+			name, suffix := functionName, ""
+			if idx := strings.LastIndex(functionName, "."); idx > 0 {
+				name, suffix = functionName[:idx], functionName[idx:]
+			}
+
+			known := weights[:0]
+			total := 0
+			for _, vw := range weights {
+				if vw.weight == 0 {
+					continue
+				}
+				if _, err := reader.Labels(name + "-" + vw.variant + suffix); err != nil {
+					continue
+				}
+				known = append(known, vw)
+				total += vw.weight
+			}
+			if total == 0 {
+				next(w, r)
+				return
+			}
+
+			pick := rand.Intn(total)
+			variant := known[len(known)-1].variant
+			for _, vw := range known {
+				if pick < vw.weight {
+					variant = vw.variant
+					break
+				}
+				pick -= vw.weight
+			}
+
+			routed := make(map[string]string, len(vars))
+			for k, v := range vars {
+				routed[k] = v
+			}
+			routed["name"] = name + "-" + variant + suffix
+
+			next(w, mux.SetURLVars(r, routed))
+		}
+	}
+}
+
+func parseVariantWeights(header string) ([]variantWeight, error) {
+	var weights []variantWeight
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected variant=weight, got '%s'", pair)
+		}
+
+		variant := strings.TrimSpace(parts[0])
+		if !variantNameRe.MatchString(variant) {
+			return nil, fmt.Errorf("invalid variant '%s'", variant)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight '%s' of variant %s", parts[1], variant)
+		}
+
+		weights = append(weights, variantWeight{variant: variant, weight: weight})
+	}
+	return weights, nil
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupVariantMiddleware(routed *string) http.HandlerFunc {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo-v1").Return(map[string]string{}, nil)
+	reader.On("Labels", "echo-v2").Return(map[string]string{}, nil)
+	reader.On("Labels", "echo-v3").Return(nil, fmt.Errorf("function echo-v3 not found"))
+
+	return NewVariantMiddleware(reader)(func(w http.ResponseWriter, r *http.Request) {
+		*routed = mux.Vars(r)["name"]
+	})
+}
+
+func variantRequest(name, weights string) *http.Request {
+	request := waitRequest(name)
+	if len(weights) != 0 {
+		request.Header.Set(VariantWeightsHeader, weights)
+	}
+	return request
+}
+
+func TestVariantMiddlewareSplitsRequestsByWeight(t *testing.T) {
+	var routed string
+	handler := setupVariantMiddleware(&routed)
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		handler(httptest.NewRecorder(), variantRequest("echo", "v1=70, v2=30, v3=50"))
+		counts[routed]++
+	}
+
+	assert.Len(t, counts, 2, "unknown variants are ignored")
+	assert.InDelta(t, 1400, counts["echo-v1"], 100)
+	assert.InDelta(t, 600, counts["echo-v2"], 100)
+}
+
+func TestVariantMiddlewareRoutesToDefaultVariant(t *testing.T) {
+	var routed string
+	handler := setupVariantMiddleware(&routed)
+
+	for _, tc := range []struct {
+		name     string
+		weights  string
+		expected string
+	}{
+		{"echo", "", "echo"},
+		{"echo", "v3=100", "echo"},
+		{"echo", "v1=0,v2=0", "echo"},
+		{"echo", "v2=1", "echo-v2"},
+	} {
+		handler(httptest.NewRecorder(), variantRequest(tc.name, tc.weights))
+		assert.Equal(t, tc.expected, routed, tc.weights)
+	}
+}
+
+func TestVariantMiddlewareRejectsInvalidHeader(t *testing.T) {
+	var routed string
+	handler := setupVariantMiddleware(&routed)
+
+	for _, weights := range []string{"v1", "v1=abc", "v1=-1", "V1=10", "v1=10,"} {
+		recorder := httptest.NewRecorder()
+		routed = ""
+		handler(recorder, variantRequest("echo", weights))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, weights)
+		assert.Empty(t, routed, weights)
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	waitLabel        = "com.openfaas.proxy.wait"
+	waitPollInterval = 100 * time.Millisecond
+)
+
+// NewWaitMiddleware holds requests for a function without healthy instances, e.g. during a rolling update,
+// until an instance becomes available, instead of rejecting them right away.
+//
+// The grace period is configured globally with the proxy configuration, and can be overridden per function
+// with the `com.openfaas.proxy.wait` label, e.g. 5s, where 0 disables waiting. Requests still without an
+// instance after the grace period are rejected with a 503.
+func NewWaitMiddleware(config types.ProxyConfig, labels LabelsReader, resolver InstanceResolver) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			functionName := mux.Vars(r)["name"]
+
+			if hasInstances(resolver, functionName) {
+				next(w, r)
+				return
+			}
+
+			wait := waitPeriod(config, labels, functionName)
+			if wait <= 0 {
+				next(w, r)
+				return
+			}
+
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			ticker := time.NewTicker(waitPollInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-timer.C:
+					writeProxyError(w, r, http.StatusServiceUnavailable, functionName, "No endpoints available for: %s.", functionName)
+					return
+				case <-ticker.C:
+					if hasInstances(resolver, functionName) {
+						next(w, r)
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func hasInstances(resolver InstanceResolver, functionName string) bool {
+	instances, err := resolver.ResolveAll(functionName)
+	return err == nil && len(instances) != 0
+}
+
+func waitPeriod(config types.ProxyConfig, labels LabelsReader, functionName string) time.Duration {
+	if labels == nil || functionName == "" {
+		return config.WaitTimeout
+	}
+	values, err := labels.Labels(functionName)
+	if err != nil {
+		return config.WaitTimeout
+	}
+	return types.ParseIntOrDurationValueFromMap(&values, waitLabel, config.WaitTimeout)
+}
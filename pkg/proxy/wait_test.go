@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitRequest(name string) *http.Request {
+	request := httptest.NewRequest("GET", "/function/"+name, nil)
+	return mux.SetURLVars(request, map[string]string{"name": name})
+}
+
+func setupWaitMiddleware(config types.ProxyConfig, labels map[string]string) (*services.MockResolver, http.HandlerFunc) {
+	reader := &services.MockFunctionLabels{}
+	reader.On("Labels", "echo").Return(labels, nil)
+
+	resolver := &services.MockResolver{}
+
+	handler := NewWaitMiddleware(config, reader, resolver)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	})
+	return resolver, handler
+}
+
+func TestWaitMiddlewareWaitsForHealthyInstance(t *testing.T) {
+	resolver, handler := setupWaitMiddleware(types.ProxyConfig{}, map[string]string{waitLabel: "2s"})
+	resolver.On("ResolveAll", "echo").Return(nil, fmt.Errorf("no candidate available")).Times(3)
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "proxied", recorder.Body.String())
+	resolver.AssertNumberOfCalls(t, "ResolveAll", 4)
+}
+
+func TestWaitMiddlewareRejectsAfterGracePeriod(t *testing.T) {
+	resolver, handler := setupWaitMiddleware(types.ProxyConfig{WaitTimeout: 300 * time.Millisecond}, map[string]string{})
+	resolver.On("ResolveAll", "echo").Return([]url.URL{}, nil)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+}
+
+func TestWaitMiddlewareIsDisabledByLabel(t *testing.T) {
+	resolver, handler := setupWaitMiddleware(types.ProxyConfig{WaitTimeout: time.Minute}, map[string]string{waitLabel: "0"})
+	resolver.On("ResolveAll", "echo").Return(nil, fmt.Errorf("no candidate available"))
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, "proxied", recorder.Body.String(), "the proxy reports the missing instances itself")
+	resolver.AssertNumberOfCalls(t, "ResolveAll", 1)
+}
+
+func TestWaitMiddlewarePassesThroughWithHealthyInstance(t *testing.T) {
+	resolver, handler := setupWaitMiddleware(types.ProxyConfig{WaitTimeout: time.Minute}, map[string]string{})
+	resolver.On("ResolveAll", "echo").Return([]url.URL{{Host: "10.0.0.1:8080"}}, nil)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, waitRequest("echo"))
+
+	assert.Equal(t, "proxied", recorder.Body.String())
+	resolver.AssertNumberOfCalls(t, "ResolveAll", 1)
+}
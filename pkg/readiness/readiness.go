@@ -0,0 +1,126 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// Pinger is a dependency of the provider, e.g. Nomad or Consul, which has to be reachable before the provider
+// is ready.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PingFunc is an adapter to use an ordinary function as a Pinger.
+type PingFunc func(ctx context.Context) error
+
+func (f PingFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+type gate struct {
+	name   string
+	pinger Pinger
+	passed bool
+}
+
+// Gates holds the readiness of the provider at startup. Every gate is checked until it passes, at the check
+// interval, and the provider is ready once all of them passed. A gate which passed isn't checked again, as a
+// dependency failing later on is reported by the health checks of the component using it.
+type Gates struct {
+	timeout  time.Duration
+	interval time.Duration
+	logger   hclog.Logger
+	mu       sync.Mutex
+	gates    []*gate
+	ready    int32
+}
+
+func NewGates(config types.ReadinessConfig, logger hclog.Logger) *Gates {
+	return &Gates{
+		timeout:  config.CheckTimeout,
+		interval: config.CheckInterval,
+		logger:   logger.Named("readiness"),
+	}
+}
+
+// Add adds a gate, which has to be added before the gates are started.
+func (g *Gates) Add(name string, pinger Pinger) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gates = append(g.gates, &gate{name: name, pinger: pinger})
+}
+
+// Start checks the gates in the background, until all of them passed.
+func (g *Gates) Start() {
+	go g.Run(context.Background())
+}
+
+// Run checks the gates until all of them passed, or the context is done.
+func (g *Gates) Run(ctx context.Context) {
+	for !g.check(ctx) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.interval):
+		}
+	}
+
+	atomic.StoreInt32(&g.ready, 1)
+	g.logger.Info("Provider is ready")
+}
+
+// check checks the gates which didn't pass yet, and returns whether all of them passed.
+func (g *Gates) check(ctx context.Context) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ready := true
+	for _, gate := range g.gates {
+		if gate.passed {
+			continue
+		}
+		if err := g.ping(ctx, gate.pinger); err != nil {
+			g.logger.Warn("Readiness gate is blocking", "gate", gate.name, "error", err.Error())
+			ready = false
+			continue
+		}
+		gate.passed = true
+		g.logger.Debug("Readiness gate passed", "gate", gate.name)
+	}
+	return ready
+}
+
+// ping pings a dependency within the check timeout, also when the dependency doesn't honour the context.
+func (g *Gates) ping(ctx context.Context, pinger Pinger) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- pinger.Ping(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no response after %s", g.timeout)
+	}
+}
+
+// Ready returns whether all the gates passed.
+func (g *Gates) Ready() bool {
+	return atomic.LoadInt32(&g.ready) == 1
+}
+
+// Degraded returns whether a gate didn't pass yet, so that the health endpoint reports not-ready until then.
+func (g *Gates) Degraded() bool {
+	return !g.Ready()
+}
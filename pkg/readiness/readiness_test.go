@@ -0,0 +1,102 @@
+package readiness
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// switchPinger fails until it is switched on, counting its pings.
+type switchPinger struct {
+	on    int32
+	pings int32
+}
+
+func (p *switchPinger) Ping(ctx context.Context) error {
+	atomic.AddInt32(&p.pings, 1)
+	if atomic.LoadInt32(&p.on) == 0 {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (p *switchPinger) switchOn() {
+	atomic.StoreInt32(&p.on, 1)
+}
+
+func newTestGates() *Gates {
+	return NewGates(types.ReadinessConfig{CheckTimeout: 50 * time.Millisecond, CheckInterval: 5 * time.Millisecond}, hclog.NewNullLogger())
+}
+
+func TestGatesBecomeReadyOnlyAfterAllGatesPass(t *testing.T) {
+	nomad := &switchPinger{}
+	consul := &switchPinger{}
+
+	gates := newTestGates()
+	gates.Add("nomad", nomad)
+	gates.Add("consul", consul)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gates.Run(ctx)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&consul.pings) > 2 }, time.Second, time.Millisecond)
+	assert.False(t, gates.Ready())
+	assert.True(t, gates.Degraded())
+
+	nomad.switchOn()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&nomad.pings) > 3 }, time.Second, time.Millisecond)
+	assert.False(t, gates.Ready())
+
+	consul.switchOn()
+	assert.Eventually(t, gates.Ready, time.Second, time.Millisecond)
+	assert.False(t, gates.Degraded())
+}
+
+func TestGatesDoNotCheckPassedGatesAgain(t *testing.T) {
+	nomad := &switchPinger{on: 1}
+	consul := &switchPinger{}
+
+	gates := newTestGates()
+	gates.Add("nomad", nomad)
+	gates.Add("consul", consul)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gates.Run(ctx)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&consul.pings) > 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&nomad.pings))
+
+	consul.switchOn()
+	assert.Eventually(t, gates.Ready, time.Second, time.Millisecond)
+}
+
+func TestGatesTimeOutUnresponsiveDependency(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	gates := newTestGates()
+	gates.Add("vault", PingFunc(func(ctx context.Context) error {
+		<-unblock
+		return nil
+	}))
+
+	start := time.Now()
+	assert.False(t, gates.check(context.Background()))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.False(t, gates.Ready())
+}
+
+func TestGatesWithoutGatesAreReady(t *testing.T) {
+	gates := newTestGates()
+	gates.Run(context.Background())
+
+	assert.True(t, gates.Ready())
+}
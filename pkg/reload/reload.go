@@ -0,0 +1,144 @@
+package reload
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// Reloadable is a component of the provider whose settings can be changed without a restart.
+type Reloadable interface {
+	Reload(config *types.ProviderConfig) error
+}
+
+// ReloadFunc is an adapter to use an ordinary function as a Reloadable.
+type ReloadFunc func(config *types.ProviderConfig) error
+
+func (f ReloadFunc) Reload(config *types.ProviderConfig) error {
+	return f(config)
+}
+
+// Reloader re-reads the config and applies it to the registered components.
+//
+// Only a subset of the config is reloadable; changes to the other settings, e.g. the port of the provider or
+// the connection to Consul, are logged as requiring a restart, and are otherwise ignored.
+type Reloader struct {
+	load       func() (*types.ProviderConfig, error)
+	logger     hclog.Logger
+	mu         sync.Mutex
+	current    *types.ProviderConfig
+	components []Reloadable
+}
+
+func NewReloader(config *types.ProviderConfig, load func() (*types.ProviderConfig, error), logger hclog.Logger) *Reloader {
+	return &Reloader{
+		load:    load,
+		logger:  logger.Named("reload"),
+		current: config,
+	}
+}
+
+func (r *Reloader) Register(component Reloadable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components = append(r.components, component)
+}
+
+// Current returns the config of the last successful (re)load.
+func (r *Reloader) Current() *types.ProviderConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads the config and applies it to all components. When a component rejects the config, the components
+// to which it was already applied are reloaded with the current config again, so the components and the current
+// config stay consistent.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range restartRequired(r.current, config) {
+		r.logger.Warn("Setting changed, but requires a restart of the provider", "setting", setting)
+	}
+
+	for i, component := range r.components {
+		if err := component.Reload(config); err != nil {
+			r.rollback(r.components[:i])
+			return err
+		}
+	}
+
+	r.current = config
+	r.logger.Info("Config reloaded")
+	return nil
+}
+
+// rollback reapplies the current config to the components, in reverse order.
+func (r *Reloader) rollback(components []Reloadable) {
+	for i := len(components) - 1; i >= 0; i-- {
+		if err := components[i].Reload(r.current); err != nil {
+			r.logger.Error("Error restoring the current config after a failed reload", "error", err.Error())
+		}
+	}
+}
+
+// WatchSignal reloads the config whenever the provider receives a SIGHUP.
+func (r *Reloader) WatchSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := r.Reload(); err != nil {
+				r.logger.Error("Error reloading config", "error", err.Error())
+			}
+		}
+	}()
+}
+
+type setting struct {
+	name    string
+	current interface{}
+	config  interface{}
+}
+
+// restartRequired returns the settings which changed, but are only applied when the provider is started.
+func restartRequired(current, config *types.ProviderConfig) []string {
+	settings := []setting{
+		{"port", current.FaaS.TCPPort, config.FaaS.TCPPort},
+		{"write_timeout", current.FaaS.WriteTimeout, config.FaaS.WriteTimeout},
+		{"consul_addr", current.Consul.Addr, config.Consul.Addr},
+		{"consul_token", current.Consul.ACLToken, config.Consul.ACLToken},
+		{"consul_tls_ca", current.Consul.CACert, config.Consul.CACert},
+		{"consul_tls_cert", current.Consul.ClientCert, config.Consul.ClientCert},
+		{"consul_tls_key", current.Consul.ClientKey, config.Consul.ClientKey},
+		{"consul_tls_skip_verify", current.Consul.TLSSkipVerify, config.Consul.TLSSkipVerify},
+		{"nomad_addr", current.Nomad.Addr, config.Nomad.Addr},
+		{"nomad_token", current.Nomad.ACLToken, config.Nomad.ACLToken},
+		{"nomad_tls_ca", current.Nomad.CACert, config.Nomad.CACert},
+		{"nomad_tls_cert", current.Nomad.ClientCert, config.Nomad.ClientCert},
+		{"nomad_tls_key", current.Nomad.ClientKey, config.Nomad.ClientKey},
+		{"nomad_tls_skip_verify", current.Nomad.TLSSkipVerify, config.Nomad.TLSSkipVerify},
+		{"vault_addr", current.Vault.Addr, config.Vault.Addr},
+		{"vault_token", current.Vault.Token, config.Vault.Token},
+	}
+
+	var changed []string
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.current, s.config) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
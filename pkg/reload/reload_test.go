@@ -0,0 +1,85 @@
+package reload
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloaderAppliesConfigToComponents(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	reloaded, _ := types.DefaultConfig()
+	reloaded.Proxy.Strategy = "random"
+	reloaded.FaaS.ReadTimeout = 30 * time.Second
+
+	reloader := NewReloader(config, func() (*types.ProviderConfig, error) { return reloaded, nil }, hclog.NewNullLogger())
+
+	var applied []*types.ProviderConfig
+	reloader.Register(ReloadFunc(func(c *types.ProviderConfig) error {
+		applied = append(applied, c)
+		return nil
+	}))
+
+	assert.Equal(t, config, reloader.Current())
+	assert.NoError(t, reloader.Reload())
+	assert.Equal(t, []*types.ProviderConfig{reloaded}, applied)
+	assert.Equal(t, reloaded, reloader.Current())
+}
+
+func TestReloaderReportsErrors(t *testing.T) {
+	config, _ := types.DefaultConfig()
+
+	failing := NewReloader(config, func() (*types.ProviderConfig, error) { return nil, fmt.Errorf("invalid config") }, hclog.NewNullLogger())
+	assert.Error(t, failing.Reload())
+	assert.Equal(t, config, failing.Current())
+
+	reloader := NewReloader(config, func() (*types.ProviderConfig, error) { return config, nil }, hclog.NewNullLogger())
+	reloader.Register(ReloadFunc(func(c *types.ProviderConfig) error { return fmt.Errorf("invalid strategy") }))
+	assert.Error(t, reloader.Reload())
+}
+
+func TestRestartRequiredSettings(t *testing.T) {
+	current, _ := types.DefaultConfig()
+	config, _ := types.DefaultConfig()
+
+	assert.Empty(t, restartRequired(current, config))
+
+	port := 9090
+	config.FaaS.TCPPort = &port
+	config.Consul.Addr = "http://consul:8500"
+	config.Nomad.TLSSkipVerify = true
+	config.Proxy.Strategy = "random"
+	config.Log.Level = "debug"
+
+	assert.Equal(t, []string{"port", "consul_addr", "nomad_tls_skip_verify"}, restartRequired(current, config))
+}
+
+func TestReloaderRestoresAppliedComponentsWhenReloadFails(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	reloaded, _ := types.DefaultConfig()
+	reloaded.Proxy.Strategy = "random"
+
+	reloader := NewReloader(config, func() (*types.ProviderConfig, error) { return reloaded, nil }, hclog.NewNullLogger())
+
+	var applied []*types.ProviderConfig
+	reloader.Register(ReloadFunc(func(c *types.ProviderConfig) error {
+		applied = append(applied, c)
+		return nil
+	}))
+	reloader.Register(ReloadFunc(func(c *types.ProviderConfig) error { return fmt.Errorf("invalid strategy") }))
+
+	var skipped []*types.ProviderConfig
+	reloader.Register(ReloadFunc(func(c *types.ProviderConfig) error {
+		skipped = append(skipped, c)
+		return nil
+	}))
+
+	assert.Error(t, reloader.Reload())
+	assert.Equal(t, []*types.ProviderConfig{reloaded, config}, applied)
+	assert.Empty(t, skipped)
+	assert.Equal(t, config, reloader.Current())
+}
@@ -0,0 +1,184 @@
+package resolver
+
+import (
+	"math/rand"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/serf/coordinate"
+)
+
+// rttTolerance is the margin within which instances are considered as close as the nearest one,
+// so that the load is still spread over the instances in the same zone.
+const rttTolerance = time.Millisecond
+
+// coordinateSource returns the name of the node of the provider's agent, and the network coordinates of the
+// nodes in its datacenter.
+type coordinateSource func() (string, []*consulapi.CoordinateEntry, error)
+
+// networkCoordinates caches the Consul network coordinates, to estimate the RTT from the provider's node
+// to the nodes of the function instances.
+type networkCoordinates struct {
+	source   coordinateSource
+	interval time.Duration
+	logger   hclog.Logger
+
+	once     sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.RWMutex
+	self  *coordinate.Coordinate
+	nodes map[string]*coordinate.Coordinate
+}
+
+func newNetworkCoordinates(source coordinateSource, interval time.Duration, logger hclog.Logger) *networkCoordinates {
+	return &networkCoordinates{
+		source:   source,
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		nodes:    map[string]*coordinate.Coordinate{},
+	}
+}
+
+func consulCoordinates(client *consulapi.Client) coordinateSource {
+	return func() (string, []*consulapi.CoordinateEntry, error) {
+		self, err := client.Agent().NodeName()
+		if err != nil {
+			return "", nil, err
+		}
+		entries, _, err := client.Coordinate().Nodes(&consulapi.QueryOptions{AllowStale: true})
+		if err != nil {
+			return "", nil, err
+		}
+		return self, entries, nil
+	}
+}
+
+// start fetches the coordinates and keeps refreshing them, only once the coordinates are needed.
+func (nc *networkCoordinates) start() {
+	nc.once.Do(func() {
+		nc.refresh()
+		go func() {
+			ticker := time.NewTicker(nc.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-nc.stop:
+					return
+				case <-ticker.C:
+					nc.refresh()
+				}
+			}
+		}()
+	})
+}
+
+// Stop stops refreshing the coordinates.
+func (nc *networkCoordinates) Stop() {
+	if nc == nil {
+		return
+	}
+	nc.stopOnce.Do(func() { close(nc.stop) })
+}
+
+func (nc *networkCoordinates) refresh() {
+	name, entries, err := nc.source()
+	if err != nil {
+		nc.logger.Warn("Unable to fetch network coordinates", "error", err.Error())
+		return
+	}
+
+	nodes := map[string]*coordinate.Coordinate{}
+	for _, entry := range entries {
+		if entry.Coord == nil || !entry.Coord.IsValid() {
+			continue
+		}
+		// nodes without a network segment are in the default segment, which is preferred
+		if _, ok := nodes[entry.Node]; !ok || entry.Segment == "" {
+			nodes[entry.Node] = entry.Coord
+		}
+	}
+
+	nc.mu.Lock()
+	nc.self = nodes[name]
+	nc.nodes = nodes
+	nc.mu.Unlock()
+}
+
+// rtt estimates the RTT from the provider's node to a node, when the coordinates of both are known.
+func (nc *networkCoordinates) rtt(node string) (time.Duration, bool) {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+
+	other, ok := nc.nodes[node]
+	if nc.self == nil || !ok || !nc.self.IsCompatibleWith(other) {
+		return 0, false
+	}
+	return nc.self.DistanceTo(other), true
+}
+
+// nearest picks a random instance among the ones closest to the provider's node, falling back to a random
+// instance when no coordinates are available for the nodes of the instances.
+func (nc *networkCoordinates) nearest(candidates []url.URL, nodes map[string]string) url.URL {
+	nc.start()
+
+	type candidate struct {
+		address url.URL
+		rtt     time.Duration
+	}
+
+	var known []candidate
+	for _, address := range candidates {
+		if rtt, ok := nc.rtt(nodes[address.Host]); ok {
+			known = append(known, candidate{address: address, rtt: rtt})
+		}
+	}
+
+	if len(known) == 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+
+	sort.Slice(known, func(i, j int) bool { return known[i].rtt < known[j].rtt })
+
+	closest := 1
+	for closest < len(known) && known[closest].rtt-known[0].rtt <= rttTolerance {
+		closest++
+	}
+	return known[rand.Intn(closest)].address
+}
+
+// byDistance orders the candidates by their estimated RTT from the provider's node, nearest first, followed by the
+// candidates of which the coordinates are unknown in a random order.
+func (nc *networkCoordinates) byDistance(candidates []url.URL, nodes map[string]string) []url.URL {
+	nc.start()
+
+	type candidate struct {
+		address url.URL
+		rtt     time.Duration
+	}
+
+	var known []candidate
+	var unknown []url.URL
+	for _, address := range candidates {
+		if rtt, ok := nc.rtt(nodes[address.Host]); ok {
+			known = append(known, candidate{address: address, rtt: rtt})
+		} else {
+			unknown = append(unknown, address)
+		}
+	}
+
+	sort.SliceStable(known, func(i, j int) bool { return known[i].rtt < known[j].rtt })
+	rand.Shuffle(len(unknown), func(i, j int) { unknown[i], unknown[j] = unknown[j], unknown[i] })
+
+	ordered := make([]url.URL, 0, len(candidates))
+	for _, c := range known {
+		ordered = append(ordered, c.address)
+	}
+	return append(ordered, unknown...)
+}
@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// Registry dispatches the resolution of a function to the resolver of an environment.
+//
+// The environment is selected by the suffix of the function name, which is either the name or the
+// namespace of an environment, e.g. echo.staging. Functions without a matching suffix are resolved by
+// the default resolver.
+type Registry struct {
+	fallback     ServiceResolver
+	environments map[string]ServiceResolver
+	namespaces   map[string]ServiceResolver
+}
+
+func NewRegistry(fallback ServiceResolver) *Registry {
+	return &Registry{
+		fallback:     fallback,
+		environments: map[string]ServiceResolver{},
+		namespaces:   map[string]ServiceResolver{},
+	}
+}
+
+// NewRegistryFromConfig creates a registry with a Consul resolver for every configured environment.
+func NewRegistryFromConfig(config *types.ProviderConfig, fallback ServiceResolver, logger hclog.Logger) (*Registry, error) {
+	registry := NewRegistry(fallback)
+
+	for _, environment := range config.Consul.Environments {
+		c := *config
+		c.Scheduling.JobPrefix = environment.JobPrefix
+		c.Scheduling.Namespace = environment.Namespace
+		c.Consul.Datacenter = environment.Datacenter
+
+		resolver, err := NewConsulResolver(&c, logger.Named(environment.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		registry.Register(environment.Name, environment.Namespace, resolver)
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Register(environment string, namespace string, resolver ServiceResolver) {
+	r.environments[environment] = resolver
+	if _, ok := r.namespaces[namespace]; !ok {
+		r.namespaces[namespace] = resolver
+	}
+}
+
+func (r *Registry) HasEnvironment(environment string) bool {
+	_, ok := r.environments[environment]
+	return ok
+}
+
+func (r *Registry) Resolve(functionName string) (url.URL, error) {
+	resolver, name := r.lookup(functionName)
+	return resolver.Resolve(name)
+}
+
+// ResolveSelection resolves an instance of a function with the resolver of its environment, reporting how
+// the instance was selected when the resolver supports it.
+func (r *Registry) ResolveSelection(functionName string) (url.URL, Selection, error) {
+	resolver, name := r.lookup(functionName)
+	if selector, ok := resolver.(SelectionResolver); ok {
+		return selector.ResolveSelection(name)
+	}
+	instance, err := resolver.Resolve(name)
+	return instance, Selection{}, err
+}
+
+func (r *Registry) ResolveAll(functionName string) ([]url.URL, error) {
+	resolver, name := r.lookup(functionName)
+	return resolver.ResolveAll(name)
+}
+
+// ResolveN resolves at most max instances of a function with the resolver of its environment, truncating all the
+// instances when the resolver doesn't select them itself.
+func (r *Registry) ResolveN(functionName string, max int) ([]url.URL, error) {
+	resolver, name := r.lookup(functionName)
+	if limited, ok := resolver.(LimitedResolver); ok {
+		return limited.ResolveN(name, max)
+	}
+	instances, err := resolver.ResolveAll(name)
+	if err != nil || max <= 0 || max >= len(instances) {
+		return instances, err
+	}
+	return instances[:max], nil
+}
+
+// Reload applies the reloadable settings to the default resolver and the resolvers of all environments.
+func (r *Registry) Reload(config *types.ProviderConfig) error {
+	resolvers := []ServiceResolver{r.fallback}
+	for _, resolver := range r.environments {
+		resolvers = append(resolvers, resolver)
+	}
+
+	for _, resolver := range resolvers {
+		if reloadable, ok := resolver.(interface {
+			Reload(config *types.ProviderConfig) error
+		}); ok {
+			if err := reloadable.Reload(config); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stop stops the background work of the default resolver and the resolvers of all environments.
+func (r *Registry) Stop() {
+	resolvers := []ServiceResolver{r.fallback}
+	for _, resolver := range r.environments {
+		resolvers = append(resolvers, resolver)
+	}
+
+	for _, resolver := range resolvers {
+		if stoppable, ok := resolver.(interface{ Stop() }); ok {
+			stoppable.Stop()
+		}
+	}
+}
+
+func (r *Registry) lookup(functionName string) (ServiceResolver, string) {
+	idx := strings.LastIndex(functionName, ".")
+	if idx < 0 {
+		return r.fallback, functionName
+	}
+
+	name, suffix := functionName[:idx], functionName[idx+1:]
+	if resolver, ok := r.environments[suffix]; ok {
+		return resolver, name
+	}
+	if resolver, ok := r.namespaces[suffix]; ok {
+		return resolver, name
+	}
+	return r.fallback, functionName
+}
@@ -0,0 +1,56 @@
+package resolver
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticResolver string
+
+func (s staticResolver) Resolve(functionName string) (url.URL, error) {
+	return url.URL{Scheme: "http", Host: string(s), Path: functionName}, nil
+}
+
+func (s staticResolver) ResolveAll(functionName string) ([]url.URL, error) {
+	u, err := s.Resolve(functionName)
+	return []url.URL{u}, err
+}
+
+func TestRegistryDispatchesOnEnvironmentAndNamespace(t *testing.T) {
+	registry := NewRegistry(staticResolver("default"))
+	registry.Register("staging", "openfaas-staging", staticResolver("staging"))
+
+	cases := map[string]url.URL{
+		"echo":                  {Scheme: "http", Host: "default", Path: "echo"},
+		"echo.staging":          {Scheme: "http", Host: "staging", Path: "echo"},
+		"echo.openfaas-staging": {Scheme: "http", Host: "staging", Path: "echo"},
+		"echo.openfaas-fn":      {Scheme: "http", Host: "default", Path: "echo.openfaas-fn"},
+	}
+
+	for name, expected := range cases {
+		actual, err := registry.Resolve(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual, name)
+
+		all, err := registry.ResolveAll(name)
+		assert.NoError(t, err)
+		assert.Equal(t, []url.URL{expected}, all, name)
+	}
+
+	limited, err := registry.ResolveN("echo.staging", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{{Scheme: "http", Host: "staging", Path: "echo"}}, limited)
+
+	assert.True(t, registry.HasEnvironment("staging"))
+	assert.False(t, registry.HasEnvironment("openfaas-staging"))
+}
+
+func TestServiceQueryTargetsDatacenter(t *testing.T) {
+	cr := &ConsulServiceResolver{datacenter: "dc2"}
+
+	query, err := cr.serviceQuery("faas-fn-echo")
+	assert.NoError(t, err)
+	assert.Equal(t, "health.service(faas-fn-echo@dc2|passing)", query.String())
+}
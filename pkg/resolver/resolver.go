@@ -1,35 +1,154 @@
 package resolver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/consul-template/dependency"
 	"github.com/hashicorp/consul-template/watch"
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	"math/rand"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	ConsistencyModeStale      = "stale"
+	ConsistencyModeDefault    = "default"
+	ConsistencyModeConsistent = "consistent"
+
+	StrategyRandom     = "random"
+	StrategyRoundRobin = "roundrobin"
+	StrategyNetworkRTT = "network-rtt"
+
+	watchRestartDelay = 5 * time.Second
+
+	// PrimaryPort is the name of the port a function service is registered with.
+	PrimaryPort = "http"
+	// PortMetaPrefix prefixes the service meta holding the other named ports of a function, as registered
+	// by the job factory, e.g. port_metrics.
+	PortMetaPrefix = "port_"
+
+	// passthroughKeyPrefix can't be part of a function name
+	passthroughKeyPrefix = "service:"
+)
+
 type ServiceResolver interface {
 	Resolve(functionName string) (url.URL, error)
 	ResolveAll(functionName string) ([]url.URL, error)
 }
 
+// Selection describes how the load balancer selected an instance of a function among its candidates.
+type Selection struct {
+	Candidates int
+	Index      int
+	Strategy   string
+}
+
+// SelectionResolver resolves an instance of a function, together with how it was selected.
+type SelectionResolver interface {
+	ResolveSelection(functionName string) (url.URL, Selection, error)
+}
+
+// LimitedResolver resolves at most a number of instances of a function, e.g. for a client doing its own load
+// balancing over a handful of the instances of a large function.
+type LimitedResolver interface {
+	ResolveN(functionName string, max int) ([]url.URL, error)
+}
+
+// PassthroughResolver resolves the instances of a plain Consul service by its name, as registered outside the
+// provider, without the job prefix or the service name template of the functions.
+type PassthroughResolver interface {
+	ResolveService(service string) (url.URL, error)
+}
+
+// PortResolver resolves the instances of a function on one of its named ports.
+type PortResolver interface {
+	ResolveAllPort(functionName string, port string) ([]url.URL, error)
+}
+
+// InstanceCounter counts the healthy instances of many functions at once, e.g. for a summary of all functions.
+type InstanceCounter interface {
+	CountInstances(functions []string) (map[string]int, error)
+}
+
 type ConsulServiceResolver struct {
-	clientSet *dependency.ClientSet
-	watcher   *watch.Watcher
-	cache     sync.Map
-	prefix    string
-	namespace string
-	logger    hclog.Logger
+	// lastProbe is accessed atomically, first in the struct for its 64-bit alignment
+	lastProbe int64
+
+	clientSet        *dependency.ClientSet
+	watcher          *watch.Watcher
+	cache            sync.Map
+	prefix           string
+	namespace        string
+	logger           hclog.Logger
+	resolveHostnames bool
+	dnsCache         sync.Map
+	dnsCacheTTL      time.Duration
+	lookupHost       func(host string) ([]string, error)
+	consistencyMode  string
+	maxStale         time.Duration
+	includeWarning   bool
+	datacenter       string
+	strategy         atomic.Value
+	counters         sync.Map
+	queries          sync.Map
+	randoms          sync.Pool
+	portName         string
+	serviceName      *types.ServiceNameTemplate
+	coordinates      *networkCoordinates
+
+	watcherMu      sync.RWMutex
+	restarted      chan struct{}
+	restartPending int32
+	restartDelay   time.Duration
+	restart        func()
+	waitTime       time.Duration
+	retryFunc      watch.RetryFunc
+	errorThreshold int
+	maxInstances   int
+	watchErrors    int32
+	degraded       int32
+	unavailable    int32
+
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]*subscriberGroup
+}
+
+type functionQuery struct {
+	query *dependency.HealthServiceQuery
+	key   string
 }
 
 type serviceItem struct {
 	serviceQuery dependency.Dependency
 	addresses    []url.URL
+	ports        map[string][]url.URL
+	nodes        map[string]string
+}
+
+// port returns the addresses of the instances on a named port.
+func (s *serviceItem) port(name string) []url.URL {
+	if name == "" || name == PrimaryPort {
+		return s.addresses
+	}
+	if addresses, ok := s.ports[name]; ok {
+		return addresses
+	}
+	return []url.URL{}
+}
+
+type dnsItem struct {
+	address string
+	expiry  time.Time
 }
 
 func NewConsulResolver(config *types.ProviderConfig, logger hclog.Logger) (ServiceResolver, error) {
@@ -48,19 +167,45 @@ func NewConsulResolver(config *types.ProviderConfig, logger hclog.Logger) (Servi
 		return nil, err
 	}
 
-	watcher, _ := watch.NewWatcher(&watch.NewWatcherInput{
-		Clients:  clientSet,
-		MaxStale: 10000 * time.Millisecond,
-	})
+	switch config.Consul.ConsistencyMode {
+	case ConsistencyModeStale, ConsistencyModeDefault, ConsistencyModeConsistent:
+	default:
+		return nil, fmt.Errorf("invalid consul consistency mode '%s'", config.Consul.ConsistencyMode)
+	}
 
 	resolver := &ConsulServiceResolver{
-		clientSet: clientSet,
-		watcher:   watcher,
-		prefix:    config.Scheduling.JobPrefix,
-		namespace: config.Scheduling.Namespace,
-		logger:    logger,
+		clientSet:        clientSet,
+		prefix:           config.Scheduling.JobPrefix,
+		namespace:        config.Scheduling.Namespace,
+		logger:           logger,
+		resolveHostnames: config.Consul.ResolveHostnames,
+		dnsCacheTTL:      config.Consul.DNSCacheTTL,
+		lookupHost:       net.LookupHost,
+		consistencyMode:  config.Consul.ConsistencyMode,
+		maxStale:         config.Consul.MaxStale,
+		includeWarning:   config.Consul.IncludeWarning,
+		datacenter:       config.Consul.Datacenter,
+		portName:         config.Consul.PortName,
+		serviceName:      config.Scheduling.ServiceName,
+		restarted:        make(chan struct{}, 1),
+		restartDelay:     watchRestartDelay,
+		errorThreshold:   config.Consul.WatchErrorThreshold,
+		waitTime:         config.Consul.WatchWaitTime,
+		retryFunc:        watchRetryFunc(config.Consul.WatchRetries, config.Consul.WatchRetryBackoff, config.Consul.WatchRetryMaxBackoff),
+		maxInstances:     config.Consul.MaxInstances,
+	}
+	if config.Consul.WatchRestartDelay > 0 {
+		resolver.restartDelay = config.Consul.WatchRestartDelay
+	}
+	resolver.restart = resolver.restartWatcher
+	resolver.coordinates = newNetworkCoordinates(consulCoordinates(clientSet.Consul()), config.Consul.CoordinatesRefresh, logger.Named("coordinates"))
+
+	if err := resolver.Reload(config); err != nil {
+		return nil, err
 	}
 
+	resolver.watcher = resolver.newWatcher()
+
 	go resolver.watch()
 	go resolver.reset()
 
@@ -71,87 +216,666 @@ func (cr *ConsulServiceResolver) reset() {
 	ticker := time.NewTicker(time.Duration(30) * time.Minute)
 
 	for range ticker.C {
-		cr.watcher.Stop()
+		cr.resetCache()
+	}
+}
+
+// resetCache replaces the watcher and clears the cache, with the parsed queries and the round-robin counters of the
+// functions, so the ones of functions which were only looked up don't pile up. While the resolver is degraded, the
+// cache is kept, as the last known instances are the only ones which can be resolved.
+func (cr *ConsulServiceResolver) resetCache() {
+	if cr.Degraded() {
+		return
+	}
+
+	cr.watcherMu.Lock()
+	cr.watcher.Stop()
+
+	watcher := cr.newWatcher()
+
+	cr.cache = sync.Map{}
+	cr.queries = sync.Map{}
+	cr.counters = sync.Map{}
+	cr.watcher = watcher
+	cr.watcherMu.Unlock()
+
+	cr.notifyRestarted()
+}
+
+// restartWatcher replaces the watcher, watching the services in the cache again. Unlike a reset, the cache is
+// kept, so that the last known instances are still resolved while Consul can't be reached.
+func (cr *ConsulServiceResolver) restartWatcher() {
+	atomic.StoreInt32(&cr.restartPending, 0)
+
+	cr.watcherMu.Lock()
+	cr.watcher.Stop()
+	watcher := cr.newWatcher()
+	cr.cache.Range(func(key, value interface{}) bool {
+		_, _ = watcher.Add(value.(*serviceItem).serviceQuery)
+		return true
+	})
+	cr.watcher = watcher
+	cr.watcherMu.Unlock()
+
+	cr.notifyRestarted()
+}
+
+func (cr *ConsulServiceResolver) notifyRestarted() {
+	select {
+	case cr.restarted <- struct{}{}:
+	default:
+	}
+}
+
+func (cr *ConsulServiceResolver) currentWatcher() *watch.Watcher {
+	cr.watcherMu.RLock()
+	defer cr.watcherMu.RUnlock()
+	return cr.watcher
+}
+
+func (cr *ConsulServiceResolver) newWatcher() *watch.Watcher {
+	watcher, _ := watch.NewWatcher(cr.watcherInput())
+	return watcher
+}
+
+func (cr *ConsulServiceResolver) watcherInput() *watch.NewWatcherInput {
+	return &watch.NewWatcherInput{
+		Clients:            cr.clientSet,
+		MaxStale:           cr.watcherMaxStale(),
+		BlockQueryWaitTime: cr.waitTime,
+		RetryFuncConsul:    cr.retryFunc,
+	}
+}
 
-		watcher, _ := watch.NewWatcher(&watch.NewWatcherInput{
-			Clients:  cr.clientSet,
-			MaxStale: 10000 * time.Millisecond,
-		})
+// watchRetryFunc returns the retries of the failed queries of the watcher, doubling the backoff after every attempt
+// up to the max backoff. Without retries, a failed query is reported to the resolver right away.
+func watchRetryFunc(retries int, backoff, maxBackoff time.Duration) watch.RetryFunc {
+	if retries <= 0 {
+		return nil
+	}
+	return func(attempt int) (bool, time.Duration) {
+		if attempt >= retries {
+			return false, 0
+		}
+		sleep := backoff
+		for i := 0; i < attempt && sleep < maxBackoff; i++ {
+			sleep *= 2
+		}
+		if maxBackoff > 0 && sleep > maxBackoff {
+			sleep = maxBackoff
+		}
+		return true, sleep
+	}
+}
 
-		cr.cache = sync.Map{}
-		cr.watcher = watcher
+// queryOptions returns the options used for the initial fetch of a service, based on the configured consistency mode.
+func (cr *ConsulServiceResolver) queryOptions() *dependency.QueryOptions {
+	switch cr.consistencyMode {
+	case ConsistencyModeStale:
+		return &dependency.QueryOptions{AllowStale: true}
+	case ConsistencyModeConsistent:
+		return &dependency.QueryOptions{RequireConsistent: true}
+	default:
+		return &dependency.QueryOptions{}
 	}
 }
 
+// watcherMaxStale returns the max staleness allowed for the blocking queries of the watcher.
+// The watcher only supports stale or default reads, so the consistent mode falls back to default reads.
+func (cr *ConsulServiceResolver) watcherMaxStale() time.Duration {
+	if cr.consistencyMode == ConsistencyModeStale {
+		return cr.maxStale
+	}
+	return 0
+}
+
 func (cr *ConsulServiceResolver) ResolveAll(function string) ([]url.URL, error) {
-	return cr.resolveInternal(fmt.Sprintf("%s%s", cr.prefix, strings.TrimSuffix(function, "."+cr.namespace)))
+	return cr.ResolveAllPort(function, cr.portName)
+}
+
+// ResolveAllPort resolves the instances of a function on a named port, where the primary port is used
+// when no port name is given.
+func (cr *ConsulServiceResolver) ResolveAllPort(function string, port string) ([]url.URL, error) {
+	item, err := cr.resolveFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	return item.port(port), nil
+}
+
+// ResolveN resolves at most max instances of a function, selected by the load balancing strategy: the next max
+// instances in turn with round-robin, the nearest ones with network-rtt, and otherwise a random sample. All the
+// instances are resolved when max is zero or negative.
+func (cr *ConsulServiceResolver) ResolveN(function string, max int) ([]url.URL, error) {
+	item, err := cr.resolveFunction(function)
+	if err != nil {
+		return nil, err
+	}
+	candidates, _ := cr.selectCandidates(function, item.port(cr.portName), item.nodes, max)
+	return candidates, nil
 }
 
 func (cr *ConsulServiceResolver) Resolve(function string) (url.URL, error) {
-	candidates, err := cr.ResolveAll(function)
+	item, err := cr.resolveFunction(function)
+	if err != nil {
+		return url.URL{}, err
+	}
+	return cr.balance(function, item.port(cr.portName), item.nodes)
+}
+
+// ResolveSelection resolves an instance of a function like Resolve, reporting the number of candidates, the index
+// of the selected candidate and the strategy used to select it.
+func (cr *ConsulServiceResolver) ResolveSelection(function string) (url.URL, Selection, error) {
+	item, err := cr.resolveFunction(function)
+	if err != nil {
+		return url.URL{}, Selection{}, err
+	}
+
+	candidates := item.port(cr.portName)
+	index, strategy, err := cr.selectCandidate(function, candidates, item.nodes)
+	if err != nil {
+		return url.URL{}, Selection{Strategy: strategy}, err
+	}
+	return candidates[index], Selection{Candidates: len(candidates), Index: index, Strategy: strategy}, nil
+}
+
+func (cr *ConsulServiceResolver) resolveFunction(function string) (*serviceItem, error) {
+	fq, err := cr.functionQuery(function)
+	if err != nil {
+		return nil, err
+	}
+	return cr.resolveInternal(fq)
+}
+
+// functionQuery returns the health query of the service of a function. Rendering the service name and
+// parsing the query allocate, so the query is only created on the first resolve of a function.
+func (cr *ConsulServiceResolver) functionQuery(function string) (*functionQuery, error) {
+	if val, ok := cr.queries.Load(function); ok {
+		return val.(*functionQuery), nil
+	}
+
+	service, err := cr.serviceName.Render(cr.prefix, strings.TrimSuffix(function, "."+cr.namespace), cr.namespace)
+	if err != nil {
+		return nil, err
+	}
+	query, err := cr.serviceQuery(service)
+	if err != nil {
+		return nil, err
+	}
+
+	fq := &functionQuery{query: query, key: query.String()}
+	cr.queries.Store(function, fq)
+	return fq, nil
+}
+
+// ResolveService resolves an instance of a plain Consul service on its service port, balanced by the configured
+// strategy like the instances of a function.
+func (cr *ConsulServiceResolver) ResolveService(service string) (url.URL, error) {
+	// the queries and counters of the services are kept apart from those of functions with the same name
+	key := passthroughKeyPrefix + service
+
+	var fq *functionQuery
+	if val, ok := cr.queries.Load(key); ok {
+		fq = val.(*functionQuery)
+	} else {
+		query, err := cr.serviceQuery(service)
+		if err != nil {
+			return url.URL{}, err
+		}
+		fq = &functionQuery{query: query, key: query.String()}
+		cr.queries.Store(key, fq)
+	}
+
+	item, err := cr.resolveInternal(fq)
 	if err != nil {
 		return url.URL{}, err
 	}
-	return balance(candidates)
+	return cr.balance(key, item.addresses, item.nodes)
 }
 
-func (cr *ConsulServiceResolver) resolveInternal(service string) ([]url.URL, error) {
-	query, err := dependency.NewHealthServiceQuery(service)
+// CountInstances counts the healthy instances of the services of the functions in a single query of the health
+// checks in Consul, rather than resolving every function. An instance is healthy when its checks and the checks of
+// its node are passing, or warning when warnings are included.
+func (cr *ConsulServiceResolver) CountInstances(functions []string) (map[string]int, error) {
+	services := map[string]string{}
+	for _, function := range functions {
+		service, err := cr.serviceName.Render(cr.prefix, strings.TrimSuffix(function, "."+cr.namespace), cr.namespace)
+		if err != nil {
+			return nil, err
+		}
+		services[service] = function
+	}
+
+	options := &consulapi.QueryOptions{Datacenter: cr.datacenter, AllowStale: cr.consistencyMode == ConsistencyModeStale}
+	checks, _, err := cr.clientSet.Consul().Health().State(consulapi.HealthAny, options)
 	if err != nil {
 		return nil, err
 	}
 
-	if val, ok := cr.cache.Load(query.String()); ok {
-		return val.(*serviceItem).addresses, nil
+	healthy := func(status string) bool {
+		return status == consulapi.HealthPassing || (cr.includeWarning && status == consulapi.HealthWarning)
+	}
+
+	type instance struct {
+		node      string
+		serviceID string
+	}
+	unhealthyNodes := map[string]bool{}
+	instances := map[instance]string{}
+	passing := map[instance]bool{}
+	for _, check := range checks {
+		if len(check.ServiceID) == 0 {
+			if !healthy(check.Status) {
+				unhealthyNodes[check.Node] = true
+			}
+			continue
+		}
+		function, ok := services[check.ServiceName]
+		if !ok {
+			continue
+		}
+		key := instance{node: check.Node, serviceID: check.ServiceID}
+		if _, seen := instances[key]; !seen {
+			instances[key] = function
+			passing[key] = true
+		}
+		passing[key] = passing[key] && healthy(check.Status)
 	}
 
-	fetch, _, err := query.Fetch(cr.clientSet, nil)
+	counts := make(map[string]int, len(functions))
+	for _, function := range functions {
+		counts[function] = 0
+	}
+	for key, function := range instances {
+		if passing[key] && !unhealthyNodes[key.node] {
+			counts[function]++
+		}
+	}
+	return counts, nil
+}
+
+// Stop stops the background refresh of the network coordinates, on shutdown of the provider.
+func (cr *ConsulServiceResolver) Stop() {
+	cr.coordinates.Stop()
+}
+
+// RemoveCacheItem evicts the instances of a deleted function from the cache and stops watching its service,
+// so the function is no longer resolved to instances which are being stopped.
+func (cr *ConsulServiceResolver) RemoveCacheItem(function string) {
+	name := strings.TrimSuffix(function, "."+cr.namespace)
+
+	fq, err := cr.functionQuery(name)
 	if err != nil {
+		return
+	}
+
+	if val, ok := cr.cache.Load(fq.key); ok {
+		cr.cache.Delete(fq.key)
+		if watcher := cr.currentWatcher(); watcher != nil {
+			watcher.Remove(val.(*serviceItem).serviceQuery)
+		}
+	}
+	cr.publish(fq.key, nil)
+
+	for _, key := range []string{name, name + "." + cr.namespace} {
+		cr.queries.Delete(key)
+		cr.counters.Delete(key)
+	}
+}
+
+// Reload applies the load balancing strategy of the config, which can be changed without a restart.
+func (cr *ConsulServiceResolver) Reload(config *types.ProviderConfig) error {
+	switch config.Proxy.Strategy {
+	case StrategyRandom, StrategyRoundRobin, StrategyNetworkRTT:
+	default:
+		return fmt.Errorf("invalid load balancing strategy '%s'", config.Proxy.Strategy)
+	}
+	cr.strategy.Store(config.Proxy.Strategy)
+	return nil
+}
+
+func (cr *ConsulServiceResolver) resolveInternal(fq *functionQuery) (*serviceItem, error) {
+	if val, ok := cr.cache.Load(fq.key); ok {
+		return val.(*serviceItem), nil
+	}
+
+	// while the agent is unavailable, functions which aren't cached fail fast, except for a periodic probe
+	if atomic.LoadInt32(&cr.unavailable) == 1 && !cr.probe() {
+		return nil, fmt.Errorf("consul is unavailable, no cached instances of %s", fq.query)
+	}
+
+	query := fq.query
+	fetch, _, err := query.Fetch(cr.clientSet, cr.queryOptions())
+	if err != nil {
+		if consulUnavailable(err) {
+			cr.agentUnavailable(err)
+		}
 		return nil, err
 	}
+	if atomic.LoadInt32(&cr.unavailable) == 1 {
+		cr.watchSucceeded()
+	}
 
 	services := fetch.([]*dependency.HealthService)
 	item := cr.updateCatalog(query, services)
 
-	_, _ = cr.watcher.Add(query)
+	_, _ = cr.currentWatcher().Add(query)
+
+	return item, nil
+}
 
-	return item.addresses, nil
+// serviceQuery creates the health query of a service in the configured datacenter, accepting instances
+// with a warning status when enabled.
+func (cr *ConsulServiceResolver) serviceQuery(service string) (*dependency.HealthServiceQuery, error) {
+	if len(cr.datacenter) != 0 {
+		service = fmt.Sprintf("%s@%s", service, cr.datacenter)
+	}
+	if cr.includeWarning {
+		return dependency.NewHealthServiceQuery(fmt.Sprintf("%s|%s,%s", service, dependency.HealthPassing, dependency.HealthWarning))
+	}
+	return dependency.NewHealthServiceQuery(service)
 }
 
 func (cr *ConsulServiceResolver) updateCatalog(dep dependency.Dependency, services []*dependency.HealthService) *serviceItem {
 	addresses := make([]url.URL, 0)
+	ports := map[string][]url.URL{}
+	nodes := map[string]string{}
 
-	for _, s := range services {
-		if len(s.Checks) > 1 {
-			addresses = append(addresses, toUrl(s.Address, s.Port))
+	for _, s := range cr.sampleInstances(services) {
+		address, ok := cr.resolveAddress(s.Address)
+		if !ok {
+			continue
+		}
+		addresses = append(addresses, toUrl(address, s.Port))
+		nodes[addresses[len(addresses)-1].Host] = s.Node
+
+		for key, value := range s.ServiceMeta {
+			if !strings.HasPrefix(key, PortMetaPrefix) {
+				continue
+			}
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			name := strings.TrimPrefix(key, PortMetaPrefix)
+			ports[name] = append(ports[name], toUrl(address, port))
+			nodes[ports[name][len(ports[name])-1].Host] = s.Node
 		}
 	}
 
 	item := &serviceItem{
 		serviceQuery: dep,
 		addresses:    addresses,
+		ports:        ports,
+		nodes:        nodes,
 	}
 
 	cr.cache.Store(dep.String(), item)
+	cr.publish(dep.String(), item.port(cr.portName))
 
 	return item
 }
 
+// inMaintenance reports whether an instance is in maintenance mode, e.g. drained as warm replica. Such instances
+// are critical, so not returned by the health queries, but are skipped anyway should a query include them.
+func inMaintenance(s *dependency.HealthService) bool {
+	for _, check := range s.Checks {
+		if strings.HasPrefix(check.CheckID, "_service_maintenance:") {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleInstances returns the healthy instances of a service. When the service has more healthy instances than
+// the configured max, a random sample of the max is retained, which bounds the memory and the lookup cost of
+// functions with a very large number of instances.
+func (cr *ConsulServiceResolver) sampleInstances(services []*dependency.HealthService) []*dependency.HealthService {
+	healthy := make([]*dependency.HealthService, 0, len(services))
+	for _, s := range services {
+		// the serf check of the node, and at least one check of the service, of any type, e.g. http, tcp or grpc
+		if len(s.Checks) > 1 && !inMaintenance(s) {
+			healthy = append(healthy, s)
+		}
+	}
+
+	if cr.maxInstances <= 0 || len(healthy) <= cr.maxInstances {
+		return healthy
+	}
+
+	// partial Fisher-Yates shuffle, selecting the first max instances
+	for i := 0; i < cr.maxInstances; i++ {
+		j := i + cr.randomIndex(len(healthy)-i)
+		healthy[i], healthy[j] = healthy[j], healthy[i]
+	}
+	return healthy[:cr.maxInstances]
+}
+
+// resolveAddress resolves service addresses registered as hostnames to an IP address, when enabled.
+// Resolutions are cached so that the proxy doesn't pay the DNS cost per request.
+func (cr *ConsulServiceResolver) resolveAddress(address string) (string, bool) {
+	if !cr.resolveHostnames || net.ParseIP(address) != nil {
+		return address, true
+	}
+
+	if val, ok := cr.dnsCache.Load(address); ok {
+		item := val.(*dnsItem)
+		if time.Now().Before(item.expiry) {
+			return item.address, true
+		}
+	}
+
+	ips, err := cr.lookupHost(address)
+	if err != nil || len(ips) == 0 {
+		cr.logger.Warn("Unable to resolve service address, dropping candidate", "address", address, "error", err)
+		return "", false
+	}
+
+	resolved := ips[0]
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+			resolved = ip
+			break
+		}
+	}
+
+	cr.dnsCache.Store(address, &dnsItem{address: resolved, expiry: time.Now().Add(cr.dnsCacheTTL)})
+
+	return resolved, true
+}
+
 func (cr *ConsulServiceResolver) watch() {
-	for d := range cr.watcher.DataCh() {
-		cr.updateCatalog(d.Dependency(), d.Data().([]*dependency.HealthService))
+	for {
+		watcher := cr.currentWatcher()
+		select {
+		case d := <-watcher.DataCh():
+			// data of a service that was removed in the meantime would add the service to the cache again
+			if watcher.Watching(d.Dependency()) {
+				cr.updateCatalog(d.Dependency(), d.Data().([]*dependency.HealthService))
+			}
+			cr.watchSucceeded()
+		case err := <-watcher.ErrCh():
+			cr.watchFailed(err)
+		case <-cr.restarted:
+		}
+	}
+}
+
+// watchSucceeded clears the consecutive errors of the watcher, and the degraded state.
+func (cr *ConsulServiceResolver) watchSucceeded() {
+	atomic.StoreInt32(&cr.watchErrors, 0)
+	atomic.StoreInt32(&cr.unavailable, 0)
+	if atomic.CompareAndSwapInt32(&cr.degraded, 1, 0) {
+		metrics.ResolverDegraded.Set(0)
+		cr.logger.Info("Consul watcher recovered")
+	}
+}
+
+// watchFailed handles an error of the watcher. The service whose query failed is no longer watched,
+// so the watcher is restarted after a delay. After the configured number of consecutive errors, the
+// resolver is marked as degraded until the watcher receives data again.
+func (cr *ConsulServiceResolver) watchFailed(err error) {
+	metrics.ConsulWatchErrors.Inc()
+	count := atomic.AddInt32(&cr.watchErrors, 1)
+	cr.logger.Warn("Error watching Consul services", "consecutive_errors", count, "error", err.Error())
+
+	if consulUnavailable(err) {
+		cr.agentUnavailable(err)
+	}
+
+	if cr.errorThreshold > 0 && int(count) >= cr.errorThreshold && atomic.CompareAndSwapInt32(&cr.degraded, 0, 1) {
+		metrics.ResolverDegraded.Set(1)
+		cr.logger.Error("Consul watcher is degraded, resolved instances may be stale", "consecutive_errors", count)
+	}
+
+	if atomic.CompareAndSwapInt32(&cr.restartPending, 0, 1) {
+		time.AfterFunc(cr.restartDelay, cr.restart)
+	}
+}
+
+// agentUnavailable marks the resolver as degraded right away when the Consul agent can't be reached, so that only
+// cached instances are resolved until Consul recovers, even when stale.
+func (cr *ConsulServiceResolver) agentUnavailable(err error) {
+	if !atomic.CompareAndSwapInt32(&cr.unavailable, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&cr.lastProbe, time.Now().UnixNano())
+	cr.logger.Error("Consul agent is unavailable, resolving cached instances only", "error", err.Error())
+
+	if atomic.CompareAndSwapInt32(&cr.degraded, 0, 1) {
+		metrics.ResolverDegraded.Set(1)
+	}
+}
+
+// probe allows a single fetch per restart delay while the Consul agent is unavailable, to detect its recovery.
+func (cr *ConsulServiceResolver) probe() bool {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&cr.lastProbe)
+	return now-last >= int64(cr.restartDelay) && atomic.CompareAndSwapInt64(&cr.lastProbe, last, now)
+}
+
+// consulUnavailable reports whether an error of Consul is a failure to reach the agent, rather than a failed query.
+func consulUnavailable(err error) bool {
+	var urlErr *url.Error
+	var opErr *net.OpError
+	return errors.As(err, &urlErr) || errors.As(err, &opErr)
+}
+
+// Ping verifies that the watcher isn't degraded and the Consul agent can be reached, so that functions can be
+// resolved.
+func (cr *ConsulServiceResolver) Ping(ctx context.Context) error {
+	if cr.Degraded() {
+		return errors.New("consul watcher is degraded")
 	}
+	leader, err := cr.clientSet.Consul().Status().Leader()
+	if err != nil {
+		return err
+	}
+	if len(leader) == 0 {
+		return errors.New("consul has no leader")
+	}
+	return nil
+}
+
+// Degraded returns whether the watcher failed repeatedly, or the Consul agent is unavailable, in which case the
+// resolved instances may be stale.
+func (cr *ConsulServiceResolver) Degraded() bool {
+	return atomic.LoadInt32(&cr.degraded) == 1
 }
 
-func balance(candidates []url.URL) (url.URL, error) {
+// balance selects one of the candidates with the configured strategy, where the nodes of the candidates,
+// keyed by host, are used to select the nearest instance with the network-rtt strategy.
+func (cr *ConsulServiceResolver) balance(function string, candidates []url.URL, nodes map[string]string) (url.URL, error) {
+	index, _, err := cr.selectCandidate(function, candidates, nodes)
+	if err != nil {
+		return url.URL{}, err
+	}
+	return candidates[index], nil
+}
+
+// selectCandidate returns the index of the candidate selected by the configured strategy, and the strategy
+// which was actually applied, as the network-rtt strategy falls back to random without coordinates.
+func (cr *ConsulServiceResolver) selectCandidate(function string, candidates []url.URL, nodes map[string]string) (int, string, error) {
+	strategy, _ := cr.strategy.Load().(string)
+	if strategy == StrategyNetworkRTT && cr.coordinates == nil {
+		strategy = StrategyRandom
+	}
+
 	if candidates == nil || len(candidates) == 0 {
-		return url.URL{}, fmt.Errorf("no candidate available")
+		return 0, strategy, fmt.Errorf("no candidate available")
 	}
-	idx := 0
-	if len(candidates) > 1 {
-		idx = rand.Intn(len(candidates))
+	if len(candidates) == 1 {
+		return 0, strategy, nil
+	}
+
+	switch strategy {
+	case StrategyRoundRobin:
+		counter, ok := cr.counters.Load(function)
+		if !ok {
+			counter, _ = cr.counters.LoadOrStore(function, new(uint64))
+		}
+		return int((atomic.AddUint64(counter.(*uint64), 1) - 1) % uint64(len(candidates))), strategy, nil
+	case StrategyNetworkRTT:
+		nearest := cr.coordinates.nearest(candidates, nodes)
+		for i, candidate := range candidates {
+			if candidate.Host == nearest.Host {
+				return i, strategy, nil
+			}
+		}
+		return 0, strategy, nil
+	default:
+		return cr.randomIndex(len(candidates)), StrategyRandom, nil
+	}
+}
+
+// selectCandidates selects max of the candidates with the load balancing strategy, like selectCandidate does for a
+// single one, so that the round-robin counter of a function is shared with Resolve. The candidates are never
+// modified, as they are the cached instances of the function.
+func (cr *ConsulServiceResolver) selectCandidates(function string, candidates []url.URL, nodes map[string]string, max int) ([]url.URL, string) {
+	strategy, _ := cr.strategy.Load().(string)
+	if strategy == StrategyNetworkRTT && cr.coordinates == nil {
+		strategy = StrategyRandom
+	}
+
+	if max <= 0 || max >= len(candidates) {
+		return candidates, strategy
+	}
+
+	selected := make([]url.URL, 0, max)
+	switch strategy {
+	case StrategyRoundRobin:
+		counter, ok := cr.counters.Load(function)
+		if !ok {
+			counter, _ = cr.counters.LoadOrStore(function, new(uint64))
+		}
+		start := atomic.AddUint64(counter.(*uint64), uint64(max)) - uint64(max)
+		for i := 0; i < max; i++ {
+			selected = append(selected, candidates[(start+uint64(i))%uint64(len(candidates))])
+		}
+		return selected, strategy
+	case StrategyNetworkRTT:
+		return append(selected, cr.coordinates.byDistance(candidates, nodes)[:max]...), strategy
+	default:
+		shuffled := append([]url.URL(nil), candidates...)
+		// partial Fisher-Yates shuffle, selecting the first max candidates
+		for i := 0; i < max; i++ {
+			j := i + cr.randomIndex(len(shuffled)-i)
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		}
+		return append(selected, shuffled[:max]...), StrategyRandom
+	}
+}
+
+// randomIndex picks a random index from a pool of sources, as the global source of math/rand is guarded by a
+// mutex which is contended when the functions are invoked concurrently.
+func (cr *ConsulServiceResolver) randomIndex(n int) int {
+	r, ok := cr.randoms.Get().(*rand.Rand)
+	if !ok {
+		r = rand.New(rand.NewSource(rand.Int63()))
 	}
-	return candidates[idx], nil
+	i := r.Intn(n)
+	cr.randoms.Put(r)
+	return i
 }
 
 func toUrl(address string, port int) url.URL {
@@ -0,0 +1,881 @@
+package resolver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-template/dependency"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/serf/coordinate"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func healthService(address string, port int) *dependency.HealthService {
+	return &dependency.HealthService{
+		Address: address,
+		Port:    port,
+		Checks:  api.HealthChecks{{Status: "passing"}, {Status: "passing"}},
+	}
+}
+
+func TestUpdateCatalogResolvesHostnames(t *testing.T) {
+	lookups := 0
+	resolver := &ConsulServiceResolver{
+		logger:           hclog.Default(),
+		resolveHostnames: true,
+		dnsCacheTTL:      time.Minute,
+		lookupHost: func(host string) ([]string, error) {
+			lookups++
+			if host == "node-1.example" {
+				return []string{"::1", "10.0.0.1"}, nil
+			}
+			return nil, fmt.Errorf("no such host")
+		},
+	}
+
+	query, _ := dependency.NewHealthServiceQuery("faas-fn-echo")
+	services := []*dependency.HealthService{
+		healthService("node-1.example", 8080),
+		healthService("node-2.invalid", 8080),
+		healthService("10.0.0.3", 8080),
+	}
+
+	item := resolver.updateCatalog(query, services)
+
+	assert.Equal(t, 2, len(item.addresses))
+	assert.Equal(t, "10.0.0.1:8080", item.addresses[0].Host)
+	assert.Equal(t, "10.0.0.3:8080", item.addresses[1].Host)
+
+	resolver.updateCatalog(query, services)
+
+	assert.Equal(t, 3, lookups, "resolved hostnames should be cached, failed ones retried")
+}
+
+func TestUpdateCatalogKeepsHostnamesWhenResolutionIsDisabled(t *testing.T) {
+	resolver := &ConsulServiceResolver{
+		logger: hclog.Default(),
+		lookupHost: func(host string) ([]string, error) {
+			t.Fatal("unexpected lookup")
+			return nil, nil
+		},
+	}
+
+	query, _ := dependency.NewHealthServiceQuery("faas-fn-echo")
+	item := resolver.updateCatalog(query, []*dependency.HealthService{healthService("node-1.example", 8080)})
+
+	assert.Equal(t, 1, len(item.addresses))
+	assert.Equal(t, "node-1.example:8080", item.addresses[0].Host)
+}
+
+func TestQueryOptionsReflectConsistencyMode(t *testing.T) {
+	tests := []struct {
+		mode              string
+		allowStale        bool
+		requireConsistent bool
+		watcherMaxStale   time.Duration
+	}{
+		{mode: ConsistencyModeStale, allowStale: true, watcherMaxStale: 10 * time.Second},
+		{mode: ConsistencyModeDefault},
+		{mode: ConsistencyModeConsistent, requireConsistent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			resolver := &ConsulServiceResolver{
+				consistencyMode: tt.mode,
+				maxStale:        10 * time.Second,
+			}
+
+			options := resolver.queryOptions()
+
+			assert.Equal(t, tt.allowStale, options.AllowStale)
+			assert.Equal(t, tt.requireConsistent, options.RequireConsistent)
+			assert.Equal(t, tt.watcherMaxStale, resolver.watcherMaxStale())
+		})
+	}
+}
+
+func TestServiceQueryFiltersOnHealthStatus(t *testing.T) {
+	passingOnly := &ConsulServiceResolver{}
+	query, err := passingOnly.serviceQuery("faas-fn-echo")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "health.service(faas-fn-echo|passing)", query.String())
+
+	includeWarning := &ConsulServiceResolver{includeWarning: true}
+	query, err = includeWarning.serviceQuery("faas-fn-echo")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "health.service(faas-fn-echo|passing,warning)", query.String())
+}
+
+func TestReloadLoadBalancingStrategy(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}, {Host: "10.0.0.3:8080"}}
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyRoundRobin
+
+	cr := &ConsulServiceResolver{}
+	assert.NoError(t, cr.Reload(config))
+
+	for i := 0; i < 6; i++ {
+		selected, err := cr.balance("echo", candidates, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, candidates[i%3], selected)
+	}
+
+	config.Proxy.Strategy = StrategyRandom
+	assert.NoError(t, cr.Reload(config))
+	assert.Equal(t, StrategyRandom, cr.strategy.Load())
+
+	config.Proxy.Strategy = "leastconn"
+	assert.Error(t, cr.Reload(config))
+	assert.Equal(t, StrategyRandom, cr.strategy.Load())
+}
+
+func TestSelectCandidateReportsSelection(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}, {Host: "10.0.0.3:8080"}}
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyRoundRobin
+
+	cr := &ConsulServiceResolver{}
+	assert.NoError(t, cr.Reload(config))
+
+	for i := 0; i < 4; i++ {
+		index, strategy, err := cr.selectCandidate("echo", candidates, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, i%3, index)
+		assert.Equal(t, StrategyRoundRobin, strategy)
+	}
+
+	config.Proxy.Strategy = StrategyNetworkRTT
+	assert.NoError(t, cr.Reload(config))
+
+	index, strategy, err := cr.selectCandidate("echo", candidates, nil)
+	assert.NoError(t, err)
+	assert.True(t, index >= 0 && index < 3)
+	assert.Equal(t, StrategyRandom, strategy, "network-rtt falls back to random without coordinates")
+
+	_, _, err = cr.selectCandidate("echo", []url.URL{}, nil)
+	assert.Error(t, err)
+}
+
+func TestResolveNHonoursRoundRobin(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyRoundRobin
+
+	resolver := &ConsulServiceResolver{
+		logger:   hclog.Default(),
+		prefix:   "faas-fn-",
+		portName: PrimaryPort,
+	}
+	assert.NoError(t, resolver.Reload(config))
+
+	var services []*dependency.HealthService
+	var instances []url.URL
+	for i := 1; i <= 5; i++ {
+		services = append(services, healthService(fmt.Sprintf("10.0.0.%d", i), 8080))
+		instances = append(instances, toUrl(fmt.Sprintf("10.0.0.%d", i), 8080))
+	}
+	query, _ := resolver.serviceQuery("faas-fn-echo")
+	resolver.updateCatalog(query, services)
+
+	for _, expected := range [][]url.URL{
+		{instances[0], instances[1]},
+		{instances[2], instances[3]},
+		{instances[4], instances[0]},
+	} {
+		selected, err := resolver.ResolveN("echo", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, selected)
+	}
+
+	next, err := resolver.Resolve("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, instances[1], next, "the round-robin counter is shared with Resolve")
+
+	all, err := resolver.ResolveN("echo", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, instances, all)
+
+	all, err = resolver.ResolveN("echo", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, instances, all)
+}
+
+func TestSelectCandidatesSamplesRandomly(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}, {Host: "10.0.0.3:8080"}, {Host: "10.0.0.4:8080"}}
+	original := append([]url.URL(nil), candidates...)
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyRandom
+
+	cr := &ConsulServiceResolver{}
+	assert.NoError(t, cr.Reload(config))
+
+	selected := map[string]int{}
+	for i := 0; i < 100; i++ {
+		sample, strategy := cr.selectCandidates("echo", candidates, nil, 2)
+		assert.Equal(t, StrategyRandom, strategy)
+		assert.Len(t, sample, 2)
+		assert.NotEqual(t, sample[0], sample[1])
+		for _, address := range sample {
+			selected[address.Host]++
+		}
+	}
+
+	assert.Len(t, selected, 4)
+	assert.Equal(t, original, candidates, "the cached candidates are not modified")
+}
+
+func TestSelectCandidatesPrefersNearestInstances(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}, {Host: "10.0.0.3:8080"}, {Host: "10.0.0.4:8080"}}
+	nodes := map[string]string{
+		"10.0.0.1:8080": "node-far",
+		"10.0.0.2:8080": "node-near",
+		"10.0.0.3:8080": "node-near-2",
+		"10.0.0.4:8080": "node-unknown",
+	}
+
+	source := func() (string, []*api.CoordinateEntry, error) {
+		return "provider", []*api.CoordinateEntry{
+			{Node: "provider", Coord: nodeCoordinate(0)},
+			{Node: "node-far", Coord: nodeCoordinate(40 * time.Millisecond)},
+			{Node: "node-near", Coord: nodeCoordinate(200 * time.Microsecond)},
+			{Node: "node-near-2", Coord: nodeCoordinate(500 * time.Microsecond)},
+		}, nil
+	}
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyNetworkRTT
+
+	cr := &ConsulServiceResolver{coordinates: newNetworkCoordinates(source, time.Hour, hclog.NewNullLogger())}
+	assert.NoError(t, cr.Reload(config))
+
+	selected, strategy := cr.selectCandidates("echo", candidates, nodes, 2)
+	assert.Equal(t, StrategyNetworkRTT, strategy)
+	assert.Equal(t, []url.URL{candidates[1], candidates[2]}, selected)
+
+	selected, _ = cr.selectCandidates("echo", candidates, nodes, 3)
+	assert.Equal(t, []url.URL{candidates[1], candidates[2], candidates[0]}, selected)
+}
+
+func TestResolveNamedPorts(t *testing.T) {
+	resolver := &ConsulServiceResolver{
+		logger:   hclog.Default(),
+		prefix:   "faas-fn-",
+		portName: PrimaryPort,
+	}
+
+	first := healthService("10.0.0.1", 21000)
+	first.ServiceMeta = map[string]string{"port_metrics": "29100", "port_grpc": "29000", "version": "1"}
+	second := healthService("10.0.0.2", 21001)
+	second.ServiceMeta = map[string]string{"port_metrics": "29101"}
+
+	query, _ := resolver.serviceQuery("faas-fn-echo")
+	resolver.updateCatalog(query, []*dependency.HealthService{first, second})
+
+	primary, err := resolver.ResolveAll("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 21000), toUrl("10.0.0.2", 21001)}, primary)
+
+	metrics, err := resolver.ResolveAllPort("echo", "metrics")
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 29100), toUrl("10.0.0.2", 29101)}, metrics)
+
+	grpc, err := resolver.ResolveAllPort("echo", "grpc")
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 29000)}, grpc)
+
+	unknown, err := resolver.ResolveAllPort("echo", "admin")
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+
+	resolver.portName = "metrics"
+	selected, err := resolver.Resolve("echo")
+	assert.NoError(t, err)
+	assert.Contains(t, metrics, selected)
+}
+
+func TestResolveWithServiceNameTemplate(t *testing.T) {
+	serviceName, err := types.ParseServiceNameTemplate("{{.Namespace}}-fn-{{.Name}}")
+	assert.NoError(t, err)
+
+	resolver := &ConsulServiceResolver{
+		logger:      hclog.Default(),
+		prefix:      "faas-fn-",
+		namespace:   "default",
+		serviceName: serviceName,
+	}
+
+	query, _ := resolver.serviceQuery("default-fn-echo")
+	resolver.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 21000)})
+
+	instances, err := resolver.ResolveAll("echo.default")
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 21000)}, instances)
+
+	_, err = resolver.ResolveAll("echo.other")
+	assert.Error(t, err)
+}
+
+func TestResolveServiceBypassesFunctionPrefix(t *testing.T) {
+	resolver := &ConsulServiceResolver{
+		logger:    hclog.Default(),
+		prefix:    "faas-fn-",
+		namespace: "default",
+	}
+	resolver.strategy.Store(StrategyRoundRobin)
+
+	query, _ := resolver.serviceQuery("legacy-api")
+	resolver.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 8080), healthService("10.0.0.2", 8080)})
+	query, _ = resolver.serviceQuery("faas-fn-legacy-api")
+	resolver.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.9", 21000)})
+
+	first, err := resolver.ResolveService("legacy-api")
+	assert.NoError(t, err)
+	second, err := resolver.ResolveService("legacy-api")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []url.URL{toUrl("10.0.0.1", 8080), toUrl("10.0.0.2", 8080)}, []url.URL{first, second})
+
+	// a function with the same name still resolves the prefixed service
+	instance, err := resolver.Resolve("legacy-api")
+	assert.NoError(t, err)
+	assert.Equal(t, toUrl("10.0.0.9", 21000), instance)
+}
+
+func TestParseServiceNameTemplateRejectsInvalidTemplate(t *testing.T) {
+	_, err := types.ParseServiceNameTemplate("{{.Name")
+	assert.Error(t, err)
+
+	serviceName, err := types.ParseServiceNameTemplate("")
+	assert.NoError(t, err)
+	assert.Nil(t, serviceName)
+}
+
+func nodeCoordinate(offset time.Duration) *coordinate.Coordinate {
+	c := coordinate.NewCoordinate(coordinate.DefaultConfig())
+	c.Vec[0] = offset.Seconds()
+	return c
+}
+
+func TestBalanceNetworkRTTPrefersNearestInstances(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}, {Host: "10.0.0.3:8080"}, {Host: "10.0.0.4:8080"}}
+	nodes := map[string]string{
+		"10.0.0.1:8080": "node-far",
+		"10.0.0.2:8080": "node-near",
+		"10.0.0.3:8080": "node-near-2",
+		"10.0.0.4:8080": "node-unknown",
+	}
+
+	source := func() (string, []*api.CoordinateEntry, error) {
+		return "provider", []*api.CoordinateEntry{
+			{Node: "provider", Coord: nodeCoordinate(0)},
+			{Node: "node-far", Coord: nodeCoordinate(40 * time.Millisecond)},
+			{Node: "node-near", Coord: nodeCoordinate(200 * time.Microsecond)},
+			{Node: "node-near-2", Coord: nodeCoordinate(500 * time.Microsecond)},
+		}, nil
+	}
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyNetworkRTT
+
+	cr := &ConsulServiceResolver{coordinates: newNetworkCoordinates(source, time.Hour, hclog.NewNullLogger())}
+	assert.NoError(t, cr.Reload(config))
+
+	selected := map[string]int{}
+	for i := 0; i < 100; i++ {
+		address, err := cr.balance("echo", candidates, nodes)
+		assert.NoError(t, err)
+		selected[address.Host]++
+	}
+
+	assert.Zero(t, selected["10.0.0.1:8080"])
+	assert.Zero(t, selected["10.0.0.4:8080"])
+	assert.NotZero(t, selected["10.0.0.2:8080"])
+	assert.NotZero(t, selected["10.0.0.3:8080"])
+}
+
+func TestBalanceNetworkRTTFallsBackToRandom(t *testing.T) {
+	candidates := []url.URL{{Host: "10.0.0.1:8080"}, {Host: "10.0.0.2:8080"}}
+	nodes := map[string]string{"10.0.0.1:8080": "node-1", "10.0.0.2:8080": "node-2"}
+
+	source := func() (string, []*api.CoordinateEntry, error) {
+		return "", nil, fmt.Errorf("coordinates are disabled")
+	}
+
+	config, _ := types.DefaultConfig()
+	config.Proxy.Strategy = StrategyNetworkRTT
+
+	cr := &ConsulServiceResolver{coordinates: newNetworkCoordinates(source, time.Hour, hclog.NewNullLogger())}
+	assert.NoError(t, cr.Reload(config))
+
+	selected := map[string]int{}
+	for i := 0; i < 100; i++ {
+		address, err := cr.balance("echo", candidates, nodes)
+		assert.NoError(t, err)
+		selected[address.Host]++
+	}
+
+	assert.Len(t, selected, 2)
+}
+
+func TestWatchErrorsDegradeResolverAndRestartWatcher(t *testing.T) {
+	restarts := make(chan struct{}, 10)
+	cr := &ConsulServiceResolver{
+		logger:         hclog.NewNullLogger(),
+		errorThreshold: 3,
+		restartDelay:   10 * time.Millisecond,
+	}
+	cr.restart = func() {
+		atomic.StoreInt32(&cr.restartPending, 0)
+		restarts <- struct{}{}
+	}
+
+	cr.watchFailed(fmt.Errorf("connection refused"))
+	cr.watchFailed(fmt.Errorf("connection refused"))
+	assert.False(t, cr.Degraded())
+
+	cr.watchFailed(fmt.Errorf("connection refused"))
+	assert.True(t, cr.Degraded())
+
+	select {
+	case <-restarts:
+	case <-time.After(time.Second):
+		t.Fatal("watcher was not restarted")
+	}
+	assert.Len(t, restarts, 0, "pending restarts are not scheduled twice")
+
+	cr.watchSucceeded()
+	assert.False(t, cr.Degraded())
+
+	cr.watchFailed(fmt.Errorf("connection refused"))
+	assert.False(t, cr.Degraded(), "consecutive errors are cleared when the watcher receives data")
+}
+
+func TestRemoveCacheItemResolvesDeletedFunctionFromConsul(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("index") != "" {
+			// blocking queries of the watcher
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("X-Consul-Index", "2")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer consul.Close()
+
+	clientSet := dependency.NewClientSet()
+	assert.NoError(t, clientSet.CreateConsulClient(&dependency.CreateConsulClientInput{Address: strings.TrimPrefix(consul.URL, "http://")}))
+
+	cr := &ConsulServiceResolver{
+		clientSet: clientSet,
+		logger:    hclog.NewNullLogger(),
+		prefix:    "faas-fn-",
+		namespace: "default",
+	}
+	cr.watcher = cr.newWatcher()
+	defer cr.watcher.Stop()
+
+	query, _ := cr.serviceQuery("faas-fn-echo")
+	cr.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 21000)})
+	_, _ = cr.watcher.Add(query)
+
+	instances, err := cr.ResolveAll("echo")
+	assert.NoError(t, err)
+	assert.Len(t, instances, 1)
+
+	cr.RemoveCacheItem("echo.default")
+	assert.False(t, cr.watcher.Watching(query), "the service of a deleted function is no longer watched")
+
+	instances, err = cr.ResolveAll("echo")
+	assert.NoError(t, err)
+	assert.Empty(t, instances)
+}
+
+func benchmarkResolver(b *testing.B, strategy string) *ConsulServiceResolver {
+	cr := &ConsulServiceResolver{
+		logger:    hclog.NewNullLogger(),
+		prefix:    "faas-fn-",
+		namespace: "default",
+	}
+	cr.strategy.Store(strategy)
+
+	query, _ := cr.serviceQuery("faas-fn-echo")
+	cr.updateCatalog(query, []*dependency.HealthService{
+		healthService("10.0.0.1", 21000),
+		healthService("10.0.0.2", 21001),
+		healthService("10.0.0.3", 21002),
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	return cr
+}
+
+func BenchmarkResolveRoundRobin(b *testing.B) {
+	cr := benchmarkResolver(b, StrategyRoundRobin)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := cr.Resolve("echo"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkResolveRandom(b *testing.B) {
+	cr := benchmarkResolver(b, StrategyRandom)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := cr.Resolve("echo"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkResolveAll(b *testing.B) {
+	cr := benchmarkResolver(b, StrategyRoundRobin)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := cr.ResolveAll("echo"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUpdateCatalogRetainsSampleOfLargeService(t *testing.T) {
+	resolver := &ConsulServiceResolver{logger: hclog.Default(), maxInstances: 100}
+
+	services := make([]*dependency.HealthService, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		services = append(services, healthService(fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256), 8080))
+	}
+	// instances without passing checks are never sampled
+	services = append(services, &dependency.HealthService{Address: "10.255.255.255", Port: 8080})
+
+	query, _ := dependency.NewHealthServiceQuery("faas-fn-echo")
+	item := resolver.updateCatalog(query, services)
+
+	assert.Equal(t, 100, len(item.addresses))
+
+	seen := map[string]bool{}
+	for _, address := range item.addresses {
+		assert.False(t, seen[address.Host], "instances are sampled without duplicates")
+		assert.NotEqual(t, "10.255.255.255:8080", address.Host)
+		seen[address.Host] = true
+	}
+
+	other := resolver.updateCatalog(query, services)
+	assert.NotEqual(t, item.addresses, other.addresses, "a random sample is retained")
+}
+
+func TestUpdateCatalogRetainsAllInstancesWithoutMax(t *testing.T) {
+	resolver := &ConsulServiceResolver{logger: hclog.Default()}
+
+	services := make([]*dependency.HealthService, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		services = append(services, healthService(fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256), 8080))
+	}
+
+	query, _ := dependency.NewHealthServiceQuery("faas-fn-echo")
+	item := resolver.updateCatalog(query, services)
+
+	assert.Equal(t, 5000, len(item.addresses))
+	assert.Equal(t, "10.0.0.0:8080", item.addresses[0].Host)
+}
+
+func TestSubscribeEmitsChangesOfInstances(t *testing.T) {
+	cr := &ConsulServiceResolver{
+		logger:    hclog.NewNullLogger(),
+		prefix:    "faas-fn-",
+		namespace: "default",
+	}
+
+	query, _ := cr.serviceQuery("faas-fn-echo")
+	cr.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 21000)})
+
+	changes, cancel, err := cr.Subscribe("echo")
+	assert.NoError(t, err)
+
+	cr.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 21000)})
+	cr.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.2", 21000)})
+
+	select {
+	case change := <-changes:
+		assert.Equal(t, "echo", change.Function)
+		assert.Equal(t, []url.URL{toUrl("10.0.0.2", 21000)}, change.Added)
+		assert.Equal(t, []url.URL{toUrl("10.0.0.1", 21000)}, change.Removed)
+		assert.Equal(t, []url.URL{toUrl("10.0.0.2", 21000)}, change.Addresses)
+	default:
+		t.Fatal("no change emitted")
+	}
+	assert.Len(t, changes, 0, "unchanged instances are not emitted")
+
+	cancel()
+	_, open := <-changes
+	assert.False(t, open)
+	cancel()
+}
+
+func TestSubscribeDropsChangesOfSlowSubscriber(t *testing.T) {
+	cr := &ConsulServiceResolver{
+		logger:    hclog.NewNullLogger(),
+		prefix:    "faas-fn-",
+		namespace: "default",
+	}
+
+	query, _ := cr.serviceQuery("faas-fn-echo")
+	cr.updateCatalog(query, []*dependency.HealthService{})
+
+	changes, cancel, err := cr.Subscribe("echo")
+	assert.NoError(t, err)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriptionBuffer*2; i++ {
+			cr.updateCatalog(query, []*dependency.HealthService{healthService(fmt.Sprintf("10.0.0.%d", i+1), 21000)})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow subscriber blocks the catalog updates")
+	}
+	assert.Len(t, changes, subscriptionBuffer)
+}
+
+func TestUpdateCatalogSkipsInstancesInMaintenance(t *testing.T) {
+	resolver := &ConsulServiceResolver{
+		logger: hclog.Default(),
+		prefix: "faas-fn-",
+	}
+
+	drained := healthService("10.0.0.2", 21001)
+	drained.Checks = append(drained.Checks, &api.HealthCheck{CheckID: "_service_maintenance:_nomad-task-2", Status: "critical"})
+
+	query, _ := resolver.serviceQuery("faas-fn-echo")
+	item := resolver.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 21000), drained})
+
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 21000)}, item.addresses)
+}
+
+func TestUpdateCatalogResolvesInstancesRegardlessOfCheckType(t *testing.T) {
+	resolver := &ConsulServiceResolver{
+		logger: hclog.Default(),
+		prefix: "faas-fn-",
+	}
+
+	var services []*dependency.HealthService
+	for i, checkType := range []string{"http", "tcp", "grpc", "script"} {
+		instance := healthService(fmt.Sprintf("10.0.0.%d", i+1), 8080)
+		instance.Checks = api.HealthChecks{
+			{CheckID: "serfHealth", Status: "passing"},
+			{CheckID: "_nomad-check-" + checkType, Type: checkType, Status: "passing"},
+		}
+		services = append(services, instance)
+	}
+	unchecked := healthService("10.0.0.5", 8080)
+	unchecked.Checks = api.HealthChecks{{CheckID: "serfHealth", Status: "passing"}}
+
+	query, _ := resolver.serviceQuery("faas-fn-echo")
+	item := resolver.updateCatalog(query, append(services, unchecked))
+
+	assert.Equal(t, []url.URL{toUrl("10.0.0.1", 8080), toUrl("10.0.0.2", 8080), toUrl("10.0.0.3", 8080), toUrl("10.0.0.4", 8080)}, item.addresses)
+}
+
+func TestWatcherInputAppliesConfiguredWaitTimeAndRetries(t *testing.T) {
+	config, _ := types.DefaultConfig()
+	config.Consul.WatchWaitTime = 30 * time.Second
+	config.Consul.WatchRetries = 3
+	config.Consul.WatchRetryBackoff = time.Second
+	config.Consul.WatchRetryMaxBackoff = 3 * time.Second
+	config.Consul.WatchRestartDelay = time.Minute
+
+	sr, err := NewConsulResolver(config, hclog.NewNullLogger())
+	assert.NoError(t, err)
+	cr := sr.(*ConsulServiceResolver)
+
+	input := cr.watcherInput()
+	assert.Equal(t, 30*time.Second, input.BlockQueryWaitTime)
+	assert.Equal(t, 10*time.Second, input.MaxStale)
+	assert.Equal(t, time.Minute, cr.restartDelay)
+
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		retry, sleep := input.RetryFuncConsul(attempt)
+		assert.True(t, retry)
+		assert.Equal(t, expected, sleep)
+	}
+	retry, _ := input.RetryFuncConsul(3)
+	assert.False(t, retry)
+}
+
+func TestWatcherInputDefaultsToConsulWaitTimeWithoutRetries(t *testing.T) {
+	config, _ := types.DefaultConfig()
+
+	sr, err := NewConsulResolver(config, hclog.NewNullLogger())
+	assert.NoError(t, err)
+	cr := sr.(*ConsulServiceResolver)
+
+	input := cr.watcherInput()
+	assert.Equal(t, time.Duration(0), input.BlockQueryWaitTime)
+	assert.Nil(t, input.RetryFuncConsul)
+	assert.Equal(t, watchRestartDelay, cr.restartDelay)
+}
+
+func TestResolverServesCachedInstancesWhileConsulAgentIsUnavailable(t *testing.T) {
+	var down int32
+	var requests int32
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			// the agent drops the connection, as when it is restarted
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Query().Get("index") != "" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("X-Consul-Index", "2")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer consul.Close()
+
+	clientSet := dependency.NewClientSet()
+	assert.NoError(t, clientSet.CreateConsulClient(&dependency.CreateConsulClientInput{Address: strings.TrimPrefix(consul.URL, "http://")}))
+
+	cr := &ConsulServiceResolver{
+		clientSet:      clientSet,
+		logger:         hclog.NewNullLogger(),
+		prefix:         "faas-fn-",
+		namespace:      "default",
+		errorThreshold: 5,
+		restartDelay:   time.Hour,
+	}
+	cr.watcher = cr.newWatcher()
+	defer cr.watcher.Stop()
+
+	// a warm cache
+	query, _ := cr.serviceQuery("faas-fn-echo")
+	cr.updateCatalog(query, []*dependency.HealthService{healthService("10.0.0.1", 8080)})
+
+	atomic.StoreInt32(&down, 1)
+
+	_, err := cr.ResolveAll("figlet")
+	assert.Error(t, err)
+	assert.True(t, cr.Degraded(), "the resolver is degraded as soon as the agent is unavailable")
+
+	instances, err := cr.ResolveAll("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, []url.URL{{Scheme: "http", Host: "10.0.0.1:8080"}}, instances)
+
+	// the cache is kept by the periodic reset, and functions which aren't cached fail fast
+	cr.resetCache()
+	_, err = cr.ResolveAll("echo")
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(&down, 0)
+	_, err = cr.ResolveAll("figlet")
+	assert.EqualError(t, err, "consul is unavailable, no cached instances of health.service(faas-fn-figlet|passing)")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	// a probe after the restart delay detects the recovery of the agent
+	atomic.StoreInt64(&cr.lastProbe, 0)
+	instances, err = cr.ResolveAll("figlet")
+	assert.NoError(t, err)
+	assert.Empty(t, instances)
+	assert.False(t, cr.Degraded())
+}
+
+func TestCountInstancesCountsHealthyInstancesInSingleQuery(t *testing.T) {
+	var requests int32
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, "/v1/health/state/any", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"Node": "node-1", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-2", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-3", "CheckID": "serfHealth", "Status": "critical"},
+			{"Node": "node-1", "CheckID": "a", "Status": "passing", "ServiceID": "echo-1", "ServiceName": "faas-fn-echo"},
+			{"Node": "node-2", "CheckID": "b", "Status": "passing", "ServiceID": "echo-2", "ServiceName": "faas-fn-echo"},
+			{"Node": "node-2", "CheckID": "c", "Status": "critical", "ServiceID": "echo-2", "ServiceName": "faas-fn-echo"},
+			{"Node": "node-3", "CheckID": "d", "Status": "passing", "ServiceID": "echo-3", "ServiceName": "faas-fn-echo"},
+			{"Node": "node-2", "CheckID": "e", "Status": "warning", "ServiceID": "figlet-1", "ServiceName": "faas-fn-figlet"},
+			{"Node": "node-1", "CheckID": "f", "Status": "passing", "ServiceID": "other-1", "ServiceName": "other"}
+		]`))
+	}))
+	defer consul.Close()
+
+	clientSet := dependency.NewClientSet()
+	assert.NoError(t, clientSet.CreateConsulClient(&dependency.CreateConsulClientInput{Address: strings.TrimPrefix(consul.URL, "http://")}))
+
+	cr := &ConsulServiceResolver{clientSet: clientSet, logger: hclog.NewNullLogger(), prefix: "faas-fn-", namespace: "default"}
+
+	counts, err := cr.CountInstances([]string{"echo", "figlet", "unknown"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"echo": 1, "figlet": 0, "unknown": 0}, counts)
+
+	cr.includeWarning = true
+	counts, err = cr.CountInstances([]string{"echo", "figlet"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"echo": 1, "figlet": 1}, counts)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestNetworkCoordinatesStopRefreshing(t *testing.T) {
+	var refreshes int32
+	source := func() (string, []*api.CoordinateEntry, error) {
+		atomic.AddInt32(&refreshes, 1)
+		return "provider", nil, nil
+	}
+
+	cr := &ConsulServiceResolver{coordinates: newNetworkCoordinates(source, 5*time.Millisecond, hclog.NewNullLogger())}
+	cr.coordinates.start()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&refreshes) > 1 }, time.Second, time.Millisecond)
+
+	cr.Stop()
+	cr.Stop()
+	time.Sleep(10 * time.Millisecond)
+	stopped := atomic.LoadInt32(&refreshes)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&refreshes))
+
+	(&ConsulServiceResolver{}).Stop()
+}
+
+func TestResetCacheClearsQueriesAndCounters(t *testing.T) {
+	clientSet := dependency.NewClientSet()
+	assert.NoError(t, clientSet.CreateConsulClient(&dependency.CreateConsulClientInput{}))
+
+	cr := &ConsulServiceResolver{clientSet: clientSet, logger: hclog.NewNullLogger(), prefix: "faas-fn-", namespace: "default"}
+	cr.watcher = cr.newWatcher()
+	defer func() { cr.watcher.Stop() }()
+
+	_, err := cr.functionQuery("random-name")
+	assert.NoError(t, err)
+	cr.counters.Store("random-name", new(uint64))
+
+	cr.resetCache()
+
+	_, ok := cr.queries.Load("random-name")
+	assert.False(t, ok)
+	_, ok = cr.counters.Load("random-name")
+	assert.False(t, ok)
+}
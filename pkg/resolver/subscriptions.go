@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"net/url"
+
+	"github.com/jsiebens/faas-nomad/pkg/metrics"
+)
+
+// subscriptionBuffer is the number of changes buffered per subscriber, changes of a subscriber with a full
+// buffer are dropped so that a slow subscriber never blocks the watcher.
+const subscriptionBuffer = 16
+
+// ServiceChange is emitted to the subscribers of a function when the instances of the function change.
+// Addresses holds all current instances, so a subscriber which missed a change can catch up with the next one.
+type ServiceChange struct {
+	Function  string
+	Added     []url.URL
+	Removed   []url.URL
+	Addresses []url.URL
+}
+
+type subscriber struct {
+	function string
+	changes  chan ServiceChange
+}
+
+// subscriberGroup holds the subscribers of a service, with the instances last emitted to them.
+type subscriberGroup struct {
+	addresses   []url.URL
+	subscribers map[*subscriber]struct{}
+}
+
+// Subscribe returns a channel emitting the changes of the instances of a function, as seen by the watcher, and a
+// function to cancel the subscription, which closes the channel. The function is resolved first, which makes
+// the watcher watch its service when it didn't yet.
+func (cr *ConsulServiceResolver) Subscribe(function string) (<-chan ServiceChange, func(), error) {
+	fq, err := cr.functionQuery(function)
+	if err != nil {
+		return nil, nil, err
+	}
+	item, err := cr.resolveInternal(fq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &subscriber{function: function, changes: make(chan ServiceChange, subscriptionBuffer)}
+
+	cr.subscriptionsMu.Lock()
+	if cr.subscriptions == nil {
+		cr.subscriptions = map[string]*subscriberGroup{}
+	}
+	group, ok := cr.subscriptions[fq.key]
+	if !ok {
+		group = &subscriberGroup{addresses: item.port(cr.portName), subscribers: map[*subscriber]struct{}{}}
+		cr.subscriptions[fq.key] = group
+	}
+	group.subscribers[s] = struct{}{}
+	cr.subscriptionsMu.Unlock()
+
+	cancelled := false
+	cancel := func() {
+		cr.subscriptionsMu.Lock()
+		defer cr.subscriptionsMu.Unlock()
+		if cancelled {
+			return
+		}
+		cancelled = true
+		delete(group.subscribers, s)
+		if len(group.subscribers) == 0 && cr.subscriptions[fq.key] == group {
+			delete(cr.subscriptions, fq.key)
+		}
+		close(s.changes)
+	}
+
+	return s.changes, cancel, nil
+}
+
+// publish emits the change of the instances of a service to its subscribers, if the instances changed since
+// the last change emitted.
+func (cr *ConsulServiceResolver) publish(key string, addresses []url.URL) {
+	cr.subscriptionsMu.Lock()
+	defer cr.subscriptionsMu.Unlock()
+
+	group, ok := cr.subscriptions[key]
+	if !ok {
+		return
+	}
+
+	added, removed := diffAddresses(group.addresses, addresses)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	group.addresses = addresses
+
+	for s := range group.subscribers {
+		change := ServiceChange{Function: s.function, Added: added, Removed: removed, Addresses: addresses}
+		select {
+		case s.changes <- change:
+		default:
+			metrics.ResolverDroppedChanges.Inc()
+			cr.logger.Debug("Dropping service change of slow subscriber", "function", s.function)
+		}
+	}
+}
+
+// diffAddresses returns the addresses added to and removed from the previous addresses.
+func diffAddresses(previous, current []url.URL) ([]url.URL, []url.URL) {
+	hosts := make(map[string]bool, len(previous))
+	for _, address := range previous {
+		hosts[address.Host] = true
+	}
+
+	var added []url.URL
+	for _, address := range current {
+		if !hosts[address.Host] {
+			added = append(added, address)
+		}
+		delete(hosts, address.Host)
+	}
+
+	var removed []url.URL
+	for _, address := range previous {
+		if hosts[address.Host] {
+			removed = append(removed, address)
+		}
+	}
+
+	return added, removed
+}
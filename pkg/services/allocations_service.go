@@ -0,0 +1,36 @@
+package services
+
+import (
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type Allocations interface {
+	List(q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	Info(allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error)
+	Signal(alloc *api.Allocation, q *api.QueryOptions, task, signal string) error
+}
+
+type AllocFS interface {
+	Logs(alloc *api.Allocation, follow bool, task, logType, origin string, offset int64, cancel <-chan struct{}, q *api.QueryOptions) (<-chan *api.StreamFrame, <-chan error)
+}
+
+func NewNomadAllocations(config types.NomadConfig) (Allocations, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.Allocations(), nil
+}
+
+func NewNomadAllocFS(config types.NomadConfig) (AllocFS, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.AllocFS(), nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	// BlueGreenLabel deploys a function blue/green, as two jobs suffixed with the colours, of which the active
+	// one serves the requests of the function.
+	BlueGreenLabel = "com.openfaas.bluegreen"
+
+	ColourBlue  = "blue"
+	ColourGreen = "green"
+
+	colourCacheTTL = 5 * time.Second
+)
+
+type ConsulKV interface {
+	Get(key string, q *consulapi.QueryOptions) (*consulapi.KVPair, *consulapi.QueryMeta, error)
+	Put(p *consulapi.KVPair, q *consulapi.WriteOptions) (*consulapi.WriteMeta, error)
+	Delete(key string, w *consulapi.WriteOptions) (*consulapi.WriteMeta, error)
+}
+
+func NewConsulKV(config types.ConsulConfig) (ConsulKV, error) {
+	client, err := newConsulClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.KV(), nil
+}
+
+// BlueGreen reports whether the labels of a function deploy the function blue/green.
+func BlueGreen(labels *map[string]string) bool {
+	return types.ParseBoolValueFromMap(labels, BlueGreenLabel, false)
+}
+
+// OtherColour returns the colour which isn't the given one, blue when no colour is given.
+func OtherColour(colour string) string {
+	if colour == ColourBlue {
+		return ColourGreen
+	}
+	return ColourBlue
+}
+
+// Colours stores the active colour of the functions deployed blue/green in the Consul KV store, under
+// <prefix>/<namespace>/<function>. The colours are cached for a short time, as they are read on every request
+// of a function, so a promote through another provider is picked up after the cache ttl.
+type Colours struct {
+	kv        ConsulKV
+	prefix    string
+	namespace string
+	ttl       time.Duration
+	cache     sync.Map
+}
+
+type colourItem struct {
+	colour string
+	expiry time.Time
+}
+
+func NewColours(kv ConsulKV, prefix, namespace string) *Colours {
+	return &Colours{kv: kv, prefix: prefix, namespace: namespace, ttl: colourCacheTTL}
+}
+
+// Active returns the active colour of a function, or an empty colour when the function isn't deployed blue/green.
+// When the KV store can't be read, the last known colour is returned with the error.
+func (c *Colours) Active(function string) (string, error) {
+	val, cached := c.cache.Load(function)
+	if cached && time.Now().Before(val.(*colourItem).expiry) {
+		return val.(*colourItem).colour, nil
+	}
+
+	pair, _, err := c.kv.Get(c.key(function), nil)
+	if err != nil {
+		if cached {
+			return val.(*colourItem).colour, err
+		}
+		return "", err
+	}
+
+	colour := ""
+	if pair != nil {
+		colour = string(pair.Value)
+	}
+	c.store(function, colour)
+	return colour, nil
+}
+
+// SetActive makes the colour the active colour of a function.
+func (c *Colours) SetActive(function, colour string) error {
+	if _, err := c.kv.Put(&consulapi.KVPair{Key: c.key(function), Value: []byte(colour)}, nil); err != nil {
+		return err
+	}
+	c.store(function, colour)
+	return nil
+}
+
+// Remove forgets the active colour of a deleted function.
+func (c *Colours) Remove(function string) error {
+	if _, err := c.kv.Delete(c.key(function), nil); err != nil {
+		return err
+	}
+	c.cache.Delete(function)
+	return nil
+}
+
+func (c *Colours) store(function, colour string) {
+	c.cache.Store(function, &colourItem{colour: colour, expiry: time.Now().Add(c.ttl)})
+}
+
+func (c *Colours) key(function string) string {
+	return fmt.Sprintf("%s/%s/%s", c.prefix, c.namespace, function)
+}
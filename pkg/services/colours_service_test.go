@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestColoursReadsActiveColourOnce(t *testing.T) {
+	kv := &MockConsulKV{}
+	kv.On("Get", "faas-nomad/blue-green/default/echo", mock.Anything).Return(&consulapi.KVPair{Value: []byte("green")}, nil).Once()
+
+	colours := NewColours(kv, "faas-nomad/blue-green", "default")
+
+	for i := 0; i < 3; i++ {
+		colour, err := colours.Active("echo")
+		assert.NoError(t, err)
+		assert.Equal(t, ColourGreen, colour)
+	}
+	kv.AssertExpectations(t)
+}
+
+func TestColoursReportsNoColourForOtherFunctions(t *testing.T) {
+	kv := &MockConsulKV{}
+	kv.On("Get", "faas-nomad/blue-green/default/echo", mock.Anything).Return(nil, nil)
+
+	colour, err := NewColours(kv, "faas-nomad/blue-green", "default").Active("echo")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", colour)
+}
+
+func TestColoursKeepsLastKnownColourWhenKVFails(t *testing.T) {
+	kv := &MockConsulKV{}
+	kv.On("Get", "faas-nomad/blue-green/default/echo", mock.Anything).Return(nil, fmt.Errorf("connection refused"))
+
+	colours := NewColours(kv, "faas-nomad/blue-green", "default")
+	colours.ttl = 0
+	colours.store("echo", ColourBlue)
+
+	colour, err := colours.Active("echo")
+
+	assert.Error(t, err)
+	assert.Equal(t, ColourBlue, colour)
+}
+
+func TestColoursSetsActiveColour(t *testing.T) {
+	kv := &MockConsulKV{}
+	kv.On("Put", &consulapi.KVPair{Key: "faas-nomad/blue-green/default/echo", Value: []byte("green")}, mock.Anything).Return(nil)
+
+	colours := NewColours(kv, "faas-nomad/blue-green", "default")
+
+	assert.NoError(t, colours.SetActive("echo", ColourGreen))
+
+	colour, err := colours.Active("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, ColourGreen, colour)
+	kv.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type Deployments interface {
+	Fail(deploymentID string, q *api.WriteOptions) (*api.DeploymentUpdateResponse, *api.WriteMeta, error)
+}
+
+func NewNomadDeployments(config types.NomadConfig) (Deployments, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.Deployments(), nil
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type Evaluations interface {
+	Info(evalID string, q *api.QueryOptions) (*api.Evaluation, *api.QueryMeta, error)
+}
+
+func NewNomadEvaluations(config types.NomadConfig) (Evaluations, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.Evaluations(), nil
+}
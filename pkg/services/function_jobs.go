@@ -0,0 +1,75 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// notFoundRe matches the errors of the Nomad API client for a job which doesn't exist.
+var notFoundRe = regexp.MustCompile(`Unexpected response code: 404`)
+
+// ReadFunctionJob reads the job of a function, which is the job prefix followed by the function name, unless the
+// function was deployed with the com.openfaas.nomad-job-id annotation.
+func ReadFunctionJob(jobs Jobs, prefix string, options *api.QueryOptions, functionName string) (*api.Job, error) {
+	job, _, err := jobs.Info(prefix+functionName, options)
+	if id, ok := OverriddenJobID(jobs, prefix, options, functionName, err); ok {
+		job, _, err = jobs.Info(id, options)
+	}
+	return job, err
+}
+
+// OverriddenJobID looks up the job of a function deployed with the com.openfaas.nomad-job-id annotation, after its
+// job wasn't found by the prefix convention. The job is identified by its name, which keeps following the convention.
+func OverriddenJobID(jobs Jobs, prefix string, options *api.QueryOptions, functionName string, err error) (string, bool) {
+	if err == nil || !notFoundRe.MatchString(err.Error()) {
+		return "", false
+	}
+
+	name := prefix + functionName
+	list, _, err := jobs.List(&api.QueryOptions{Namespace: options.Namespace})
+	if err != nil {
+		return "", false
+	}
+	for _, stub := range list {
+		if stub.Name == name && stub.ID != name {
+			return stub.ID, true
+		}
+	}
+	return "", false
+}
+
+// ListFunctionJobs lists the jobs of the functions in a namespace. The jobs are selected by their name, which starts
+// with the job prefix, rather than by the ID prefix, so the jobs of which the ID was overridden are listed as well.
+func ListFunctionJobs(jobs Jobs, prefix string, options *api.QueryOptions) ([]*api.JobListStub, error) {
+	list, _, err := jobs.List(options)
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]*api.JobListStub, 0, len(list))
+	for _, stub := range list {
+		if strings.HasPrefix(stub.Name, prefix) {
+			functions = append(functions, stub)
+		}
+	}
+	return functions, nil
+}
+
+// FunctionName returns the name of the function of a listed job.
+func FunctionName(stub *api.JobListStub, prefix string) string {
+	return strings.TrimPrefix(stub.Name, prefix)
+}
+
+// ScheduledReplicas returns the instances of a listed job which are queued, starting or running.
+func ScheduledReplicas(summary *api.JobSummary) uint64 {
+	if summary == nil {
+		return 0
+	}
+	var replicas uint64
+	for _, s := range summary.Summary {
+		replicas += uint64(s.Queued + s.Starting + s.Running)
+	}
+	return replicas
+}
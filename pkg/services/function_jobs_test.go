@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockFunctionJobs() *MockJobs {
+	jobs := &MockJobs{}
+	jobs.On("List", mock.Anything).Return([]*api.JobListStub{
+		{ID: "faas-fn-figlet", Name: "faas-fn-figlet"},
+		{ID: "legacy-echo", Name: "faas-fn-echo"},
+		{ID: "prepull-faas-fn-figlet", Name: "prepull-faas-fn-figlet"},
+		{ID: "faas-fn-other", Name: "other"},
+	}, nil, nil)
+	return jobs
+}
+
+func TestListFunctionJobsSelectsJobsByName(t *testing.T) {
+	jobs := mockFunctionJobs()
+
+	list, err := ListFunctionJobs(jobs, "faas-fn-", &api.QueryOptions{Namespace: "default"})
+
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "figlet", FunctionName(list[0], "faas-fn-"))
+	assert.Equal(t, "echo", FunctionName(list[1], "faas-fn-"))
+	assert.Equal(t, "legacy-echo", list[1].ID)
+}
+
+func TestReadFunctionJobFindsOverriddenJobID(t *testing.T) {
+	jobID := "legacy-echo"
+	jobs := mockFunctionJobs()
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 404 (job not found)"))
+	jobs.On("Info", "legacy-echo", mock.Anything).Return(&api.Job{ID: &jobID}, nil, nil)
+
+	job, err := ReadFunctionJob(jobs, "faas-fn-", &api.QueryOptions{Namespace: "default"}, "echo")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-echo", *job.ID)
+}
+
+func TestReadFunctionJobReportsOtherErrors(t *testing.T) {
+	jobs := mockFunctionJobs()
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(nil, nil, fmt.Errorf("Unexpected response code: 403 (Permission denied)"))
+
+	_, err := ReadFunctionJob(jobs, "faas-fn-", &api.QueryOptions{Namespace: "default"}, "echo")
+
+	assert.Error(t, err)
+	jobs.AssertNotCalled(t, "List", mock.Anything)
+}
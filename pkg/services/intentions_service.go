@@ -0,0 +1,105 @@
+package services
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	intentionManagedBy = "faas-nomad"
+)
+
+type ConsulConnect interface {
+	IntentionMatch(args *consulapi.IntentionMatch, q *consulapi.QueryOptions) (map[string][]*consulapi.Intention, *consulapi.QueryMeta, error)
+	IntentionCreate(ixn *consulapi.Intention, q *consulapi.WriteOptions) (string, *consulapi.WriteMeta, error)
+	IntentionDelete(id string, q *consulapi.WriteOptions) (*consulapi.WriteMeta, error)
+}
+
+func NewConsulConnect(config types.ConsulConfig) (ConsulConnect, error) {
+	client, err := newConsulClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(), nil
+}
+
+// Intentions manages the Consul Connect intentions allowing a function to reach the upstream services of its
+// com.openfaas.egress label. Only the intentions created by the provider, marked with a managed-by meta, are
+// updated or removed; intentions created by operators are left untouched.
+type Intentions struct {
+	connect ConsulConnect
+}
+
+func NewIntentions(connect ConsulConnect) *Intentions {
+	return &Intentions{connect: connect}
+}
+
+// Sync creates an allow intention from the source service to each of the destinations, and removes the
+// managed intentions of the source to any other destination.
+func (i *Intentions) Sync(source string, destinations []string) error {
+	current, err := i.list(source)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	for _, destination := range destinations {
+		desired[destination] = true
+	}
+
+	existing := map[string]bool{}
+	for _, ixn := range current {
+		if !desired[ixn.DestinationName] && ixn.Meta["managed-by"] == intentionManagedBy {
+			if _, err := i.connect.IntentionDelete(ixn.ID, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		existing[ixn.DestinationName] = true
+	}
+
+	for _, destination := range destinations {
+		if existing[destination] {
+			continue
+		}
+		ixn := &consulapi.Intention{
+			SourceName:      source,
+			DestinationName: destination,
+			SourceType:      consulapi.IntentionSourceConsul,
+			Action:          consulapi.IntentionActionAllow,
+			Description:     "egress of function " + source,
+			Meta:            map[string]string{"managed-by": intentionManagedBy},
+		}
+		if _, _, err := i.connect.IntentionCreate(ixn, nil); err != nil {
+			return err
+		}
+		existing[destination] = true
+	}
+
+	return nil
+}
+
+// Delete removes all managed intentions of the source service.
+func (i *Intentions) Delete(source string) error {
+	return i.Sync(source, nil)
+}
+
+// list returns the intentions with the source service itself as source, ignoring wildcard intentions.
+func (i *Intentions) list(source string) ([]*consulapi.Intention, error) {
+	matches, _, err := i.connect.IntentionMatch(&consulapi.IntentionMatch{
+		By:    consulapi.IntentionMatchSource,
+		Names: []string{source},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var intentions []*consulapi.Intention
+	for _, ixn := range matches[source] {
+		if ixn.SourceName == source {
+			intentions = append(intentions, ixn)
+		}
+	}
+	return intentions, nil
+}
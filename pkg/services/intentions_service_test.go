@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func managedIntention(id, source, destination string) *consulapi.Intention {
+	return &consulapi.Intention{
+		ID:              id,
+		SourceName:      source,
+		DestinationName: destination,
+		Meta:            map[string]string{"managed-by": "faas-nomad"},
+	}
+}
+
+func TestIntentionsSyncCreatesMissingIntentions(t *testing.T) {
+	connect := &MockConsulConnect{}
+	connect.On("IntentionMatch", mock.Anything, mock.Anything).Return(map[string][]*consulapi.Intention{
+		"faas-fn-echo": {managedIntention("1", "faas-fn-echo", "payments")},
+	}, nil)
+	connect.On("IntentionCreate", mock.Anything, mock.Anything).Return("2", nil)
+
+	err := NewIntentions(connect).Sync("faas-fn-echo", []string{"payments", "users"})
+	assert.NoError(t, err)
+
+	connect.AssertNumberOfCalls(t, "IntentionCreate", 1)
+	created := connect.Calls[1].Arguments.Get(0).(*consulapi.Intention)
+	assert.Equal(t, "faas-fn-echo", created.SourceName)
+	assert.Equal(t, "users", created.DestinationName)
+	assert.Equal(t, consulapi.IntentionActionAllow, created.Action)
+	assert.Equal(t, "faas-nomad", created.Meta["managed-by"])
+}
+
+func TestIntentionsSyncRemovesStaleManagedIntentions(t *testing.T) {
+	operator := &consulapi.Intention{ID: "3", SourceName: "faas-fn-echo", DestinationName: "database"}
+	wildcard := managedIntention("4", "*", "metrics")
+
+	connect := &MockConsulConnect{}
+	connect.On("IntentionMatch", mock.Anything, mock.Anything).Return(map[string][]*consulapi.Intention{
+		"faas-fn-echo": {managedIntention("1", "faas-fn-echo", "payments"), operator, wildcard},
+	}, nil)
+	connect.On("IntentionDelete", "1", mock.Anything).Return(nil)
+
+	err := NewIntentions(connect).Sync("faas-fn-echo", nil)
+	assert.NoError(t, err)
+
+	connect.AssertNumberOfCalls(t, "IntentionDelete", 1)
+	connect.AssertNotCalled(t, "IntentionDelete", "3", mock.Anything)
+	connect.AssertNotCalled(t, "IntentionDelete", "4", mock.Anything)
+	connect.AssertNotCalled(t, "IntentionCreate", mock.Anything, mock.Anything)
+}
+
+func TestIntentionsSyncKeepsExistingIntentions(t *testing.T) {
+	connect := &MockConsulConnect{}
+	connect.On("IntentionMatch", mock.Anything, mock.Anything).Return(map[string][]*consulapi.Intention{
+		"faas-fn-echo": {{ID: "3", SourceName: "faas-fn-echo", DestinationName: "payments"}},
+	}, nil)
+
+	err := NewIntentions(connect).Sync("faas-fn-echo", []string{"payments"})
+	assert.NoError(t, err)
+
+	connect.AssertNotCalled(t, "IntentionCreate", mock.Anything, mock.Anything)
+	connect.AssertNotCalled(t, "IntentionDelete", mock.Anything, mock.Anything)
+}
@@ -5,7 +5,11 @@ import (
 	"github.com/hashicorp/nomad/api"
 	"github.com/jsiebens/faas-nomad/pkg/types"
 	ftypes "github.com/openfaas/faas-provider/types"
+	"net"
+	"net/url"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,15 +17,157 @@ import (
 
 const (
 	EnvProcessName = "fprocess"
+
+	defaultSpreadAttribute = "${node.unique.id}"
+	defaultSpreadWeight    = 50
+
+	minJobPriority = 1
+	maxJobPriority = 100
+
+	// JobTypeLabel selects the Nomad scheduler of a function. System jobs run one instance on every
+	// eligible node, and therefore ignore the com.openfaas.scale.min and com.openfaas.scale.max labels.
+	JobTypeLabel = "com.openfaas.job-type"
+
+	// JobIDAnnotation overrides the job ID of a function, e.g. to adopt a job which predates the provider, and takes
+	// precedence over the job prefix followed by the function name. The job name and the Consul service of the
+	// function keep following the prefix convention, by which the job is found and the function is resolved.
+	JobIDAnnotation = "com.openfaas.nomad-job-id"
+
+	// ConsulEnvPrefix marks an env value as a reference to a Consul KV key, e.g. consul:config/db/host.
+	// The referenced keys are rendered by a template which is watched by Nomad, so changes propagate
+	// to the function without a redeploy, according to the com.openfaas.consul.change-mode label.
+	ConsulEnvPrefix = "consul:"
+
+	// DriverLabel selects the Nomad task driver of a function. Functions running with the exec, raw_exec
+	// or java driver are fetched as an artifact and have to listen on the port given in the port env var.
+	DriverLabel = "com.openfaas.driver"
+
+	driverDocker  = "docker"
+	driverExec    = "exec"
+	driverRawExec = "raw_exec"
+	driverJava    = "java"
+
+	// ImagesLabel gives an image per cpu architecture, e.g. amd64=fn:1.0-amd64,arm64=fn:1.0-arm64, placing the
+	// function on nodes of the listed architectures only. As the instances of a function share a single task group,
+	// the images may only differ in the name of the architecture, which is interpolated by Nomad on every node.
+	ImagesLabel = "com.openfaas.images"
+
+	// ArchsLabel restricts a function to nodes of the listed cpu architectures, e.g. for a multi-arch image
+	// (manifest list) supporting only some architectures.
+	ArchsLabel = "com.openfaas.archs"
+
+	archAttribute = "${attr.cpu.arch}"
+
+	// UlimitLabelPrefix sets a ulimit of a function running with the docker driver, as soft or soft:hard limit,
+	// e.g. com.openfaas.ulimit.nofile=65536:65536.
+	UlimitLabelPrefix = "com.openfaas.ulimit."
+
+	// SysctlLabelPrefix sets a namespaced kernel parameter of a function running with the docker driver,
+	// e.g. com.openfaas.sysctl.net.core.somaxconn=4096.
+	SysctlLabelPrefix = "com.openfaas.sysctl."
+
+	maxUlimit = 1 << 20
+
+	// KillTimeoutLabel gives an instance of a function time to finish its requests after it is signalled to stop,
+	// e.g. 60s, up to the max kill timeout of the Nomad clients. ShutdownDelayLabel delays the signal after the
+	// instance is deregistered from Consul, so the proxy stops sending requests to the instance first.
+	KillTimeoutLabel   = "com.openfaas.kill-timeout"
+	ShutdownDelayLabel = "com.openfaas.shutdown-delay"
+
+	// WarmLabel keeps a number of warm replicas running next to the replicas of a function, which are drained
+	// from Consul until a scale-up puts them in rotation, e.g. com.openfaas.warm=2.
+	WarmLabel = "com.openfaas.warm"
+
+	// CostMetaPrefix prefixes the job meta holding the cost attribution labels of a function, e.g. faas_nomad_cost_team.
+	CostMetaPrefix = "faas_nomad_cost_"
+
+	consulEnvFile             = "local/consul.env"
+	defaultConsulChangeMode   = "restart"
+	defaultConsulChangeSignal = "SIGHUP"
+
+	// SecretsChangeModeLabel selects what Nomad does when a secret of a function changes, e.g. when it is rotated in
+	// Vault: restart the function, signal it with the com.openfaas.secrets.change-signal label, or nothing (noop).
+	SecretsChangeModeLabel   = "com.openfaas.secrets.change-mode"
+	SecretsChangeSignalLabel = "com.openfaas.secrets.change-signal"
+
+	defaultSecretsChangeMode = "restart"
+
+	// HTTPProxyLabel, HTTPSProxyLabel and NoProxyLabel override the outbound proxy of a function, configured with
+	// job_http_proxy, job_https_proxy and job_no_proxy, where none disables the proxy of the function.
+	HTTPProxyLabel  = "com.openfaas.http-proxy"
+	HTTPSProxyLabel = "com.openfaas.https-proxy"
+	NoProxyLabel    = "com.openfaas.no-proxy"
+
+	noProxy = "none"
+
+	// HealthTypeLabel selects the type of the Consul check of a function: http on the /_/health path (default), tcp
+	// on the port of the function, grpc with the gRPC health checking protocol, or script, running the command of the
+	// com.openfaas.health.command label in the task of the function, where an exit code of 0 is passing.
+	HealthTypeLabel = "com.openfaas.health.type"
+
+	// HealthGRPCServiceLabel and HealthGRPCTLSLabel set the service reported by the gRPC health check of a function,
+	// by default the overall health of the server, and whether the check connects with TLS.
+	HealthGRPCServiceLabel = "com.openfaas.health.grpc-service"
+	HealthGRPCTLSLabel     = "com.openfaas.health.grpc-tls"
+	HealthCommandLabel     = "com.openfaas.health.command"
+
+	// DNSServersLabel sets the DNS servers of a function running with the docker driver, as a comma-separated list
+	// of IP addresses, and ExtraHostsLabel adds entries to its /etc/hosts, as a comma-separated list of host:ip, e.g.
+	// legacy.example.com:10.0.0.5. Without them, the DNS config of the node applies.
+	DNSServersLabel = "com.openfaas.dns-servers"
+	ExtraHostsLabel = "com.openfaas.extra-hosts"
+
+	healthTypeHTTP   = "http"
+	healthTypeTCP    = "tcp"
+	healthTypeGRPC   = "grpc"
+	healthTypeScript = "script"
 )
 
 var (
+	vaultSecretTemplate    = `{{with secret "%s"}}{{base64Decode .Data.value}}{{end}}`
+	variableSecretTemplate = `{{with nomadVar "%s"}}{{base64Decode .value}}{{end}}`
+
 	logFiles = 5
 	logSize  = 2
+
+	spreadAttributeRe = regexp.MustCompile(`^\$\{(node|attr|meta)\.[a-zA-Z0-9_.\-]+\}$`)
+	consulKeyRe       = regexp.MustCompile(`^[a-zA-Z0-9_\-]+(/[a-zA-Z0-9_.\-]+)*$`)
+
+	templateChangeModes = []string{"restart", "signal", "noop"}
+	changeSignals       = []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGUSR1", "SIGUSR2", "SIGTERM", "SIGWINCH", "SIGCONT", "SIGALRM"}
+	drivers             = []string{driverDocker, driverExec, driverRawExec, driverJava}
+
+	// internalAddresses are always reached directly by the functions, bypassing their outbound proxy: the loopback
+	// and private addresses, e.g. of the other services of the cluster, and the names resolved by Consul DNS.
+	internalAddresses = []string{"localhost", "127.0.0.1", "::1", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", ".consul"}
+
+	artifactLabelPrefix        = "com.openfaas.artifact."
+	defaultArtifactDestination = "local/"
+	artifactGetterRe           = regexp.MustCompile(`^[a-z0-9]+::`)
+	hexRe                      = regexp.MustCompile(`^[a-fA-F0-9]+$`)
+	checksumLengths            = map[string]int{"md5": 32, "sha1": 40, "sha256": 64, "sha512": 128}
+
+	networkModes = []string{"bridge", "host"}
+	portLabelRe  = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	upstreamRe   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_\-]*[a-zA-Z0-9])?$`)
+	archRe       = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+	ulimits        = []string{"core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice", "nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack"}
+	sysctlPrefixes = []string{"net.", "kernel.msg", "kernel.sem", "kernel.shm", "fs.mqueue."}
+	sysctlKeyRe    = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)+$`)
+	sysctlValueRe  = regexp.MustCompile(`^[0-9]+( [0-9]+)*$`)
+
+	hostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9\-]*[a-zA-Z0-9])?)*$`)
+
+	healthTypes   = []string{healthTypeHTTP, healthTypeTCP, healthTypeGRPC, healthTypeScript}
+	grpcServiceRe = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+$`)
+
+	serviceTagRe     = regexp.MustCompile(`^[a-zA-Z0-9_.:=/\-]+$`)
+	serviceMetaKeyRe = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
 )
 
 type JobFactory interface {
-	CreateJob(namespace string, fd ftypes.FunctionDeployment) *api.Job
+	CreateJob(namespace string, fd ftypes.FunctionDeployment) (*api.Job, error)
 }
 
 func NewJobFactory(config *types.ProviderConfig) JobFactory {
@@ -32,22 +178,97 @@ type jobFactory struct {
 	config *types.ProviderConfig
 }
 
-func (f *jobFactory) CreateJob(namespace string, fd ftypes.FunctionDeployment) *api.Job {
+func (f *jobFactory) CreateJob(namespace string, fd ftypes.FunctionDeployment) (*api.Job, error) {
 
 	region := f.config.Scheduling.Region
 	constraints, datacenters := f.createConstraints(f.config, fd)
 	name := fmt.Sprintf("%s%s", f.config.Scheduling.JobPrefix, fd.Service)
-	priority := 50
+	priority, err := f.getPriority(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	jobType := types.ParseStringValueFromMap(fd.Labels, JobTypeLabel, api.JobTypeService)
+	if jobType != api.JobTypeService && jobType != api.JobTypeSystem {
+		return nil, fmt.Errorf("invalid job type '%s', must be one of %s or %s", jobType, api.JobTypeService, api.JobTypeSystem)
+	}
 
-	job := api.NewServiceJob(name, name, region, priority)
+	warm, err := parseWarmReplicas(fd)
+	if err != nil {
+		return nil, err
+	}
+	if warm > 0 && jobType == api.JobTypeSystem {
+		return nil, fmt.Errorf("warm replicas are not supported for system jobs")
+	}
+
+	jobID, err := createJobID(fd, name)
+	if err != nil {
+		return nil, err
+	}
+
+	job := api.NewServiceJob(jobID, name, region, priority)
+	job.Type = &jobType
 	job.Namespace = &namespace
 	job.Meta = f.createAnnotations(fd)
 	job.Update = f.createUpdateStrategy(fd)
 	job.Datacenters = datacenters
 	job.Constraints = constraints
-	job.TaskGroups = f.createTaskGroups(fd)
 
-	return job
+	_, archs, err := createImage(fd)
+	if err != nil {
+		return nil, err
+	}
+	if len(archs) == 1 {
+		job.Constraints = append(job.Constraints, api.NewConstraint(archAttribute, "=", archs[0]))
+	} else if len(archs) > 1 {
+		job.Constraints = append(job.Constraints, api.NewConstraint(archAttribute, api.ConstraintSetContainsAny, strings.Join(archs, ",")))
+	}
+
+	taskGroups, err := f.createTaskGroups(namespace, fd)
+	if err != nil {
+		return nil, err
+	}
+	job.TaskGroups = taskGroups
+
+	if jobType == api.JobTypeSystem {
+		// system jobs are placed on every eligible node, so the count, canaries and spreads don't apply
+		job.Update.Canary = nil
+		job.Update.AutoPromote = nil
+		job.Update.AutoRevert = nil
+		for _, group := range job.TaskGroups {
+			group.Count = nil
+		}
+		return job, nil
+	}
+
+	spreads, err := f.createSpreads(fd)
+	if err != nil {
+		return nil, err
+	}
+	job.Spreads = spreads
+
+	return job, nil
+}
+
+func (f *jobFactory) getPriority(fd ftypes.FunctionDeployment) (int, error) {
+	defaultPriority := f.config.Scheduling.DefaultPriority
+	if defaultPriority < minJobPriority {
+		defaultPriority = minJobPriority
+	} else if defaultPriority > maxJobPriority {
+		defaultPriority = maxJobPriority
+	}
+
+	value, ok := labelValue(fd, "com.openfaas.priority")
+	if !ok {
+		return defaultPriority, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority < minJobPriority || priority > maxJobPriority {
+		return 0, fmt.Errorf("invalid priority '%s', must be a number between %d and %d", value, minJobPriority, maxJobPriority)
+	}
+
+	return priority, nil
 }
 
 func (f *jobFactory) createConstraints(config *types.ProviderConfig, r ftypes.FunctionDeployment) ([]*api.Constraint, []string) {
@@ -97,6 +318,82 @@ func (f *jobFactory) createConstraints(config *types.ProviderConfig, r ftypes.Fu
 	}
 }
 
+func (f *jobFactory) createSpreads(fd ftypes.FunctionDeployment) ([]*api.Spread, error) {
+	attribute := types.ParseStringValueFromMap(fd.Labels, "com.openfaas.spread", defaultSpreadAttribute)
+	if attribute == "none" {
+		return nil, nil
+	}
+
+	match, _ := regexp.MatchString("^\\${.*}$", attribute)
+	if !match {
+		attribute = fmt.Sprintf("${%v}", attribute)
+	}
+
+	if !spreadAttributeRe.MatchString(attribute) {
+		return nil, fmt.Errorf("invalid spread attribute '%s'", attribute)
+	}
+
+	weight := defaultSpreadWeight
+	if value, ok := labelValue(fd, "com.openfaas.spread.weight"); ok {
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 0 || w > 100 {
+			return nil, fmt.Errorf("invalid spread weight '%s', must be a number between 0 and 100", value)
+		}
+		weight = w
+	}
+
+	var targets []*api.SpreadTarget
+	if value, ok := labelValue(fd, "com.openfaas.spread.targets"); ok {
+		total := 0
+		for _, t := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(t), "=", 2)
+			if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+				return nil, fmt.Errorf("invalid spread target '%s', expected format is <value>=<percent>", t)
+			}
+
+			percent, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || percent < 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid spread target '%s', percent must be a number between 0 and 100", t)
+			}
+
+			total += percent
+			targets = append(targets, api.NewSpreadTarget(strings.TrimSpace(parts[0]), uint8(percent)))
+		}
+
+		if total > 100 {
+			return nil, fmt.Errorf("invalid spread targets '%s', percentages add up to more than 100", value)
+		}
+	}
+
+	return []*api.Spread{api.NewSpread(attribute, int8(weight), targets)}, nil
+}
+
+func labelValue(fd ftypes.FunctionDeployment, key string) (string, bool) {
+	if fd.Labels == nil {
+		return "", false
+	}
+	value, ok := (*fd.Labels)[key]
+	return value, ok && len(value) != 0
+}
+
+// createJobID returns the job ID of the com.openfaas.nomad-job-id annotation, or otherwise the job name.
+func createJobID(fd ftypes.FunctionDeployment, name string) (string, error) {
+	if fd.Annotations == nil {
+		return name, nil
+	}
+	id, ok := (*fd.Annotations)[JobIDAnnotation]
+	if !ok {
+		return name, nil
+	}
+	if !types.ValidJobID(id) {
+		return "", fmt.Errorf("invalid job ID '%s', must be at most 128 alphanumeric characters, dots, dashes or underscores, and start and end with an alphanumeric character", id)
+	}
+	if BlueGreen(fd.Labels) {
+		return "", fmt.Errorf("the job ID of a function deployed blue/green can't be overridden")
+	}
+	return id, nil
+}
+
 func (f *jobFactory) createAnnotations(r ftypes.FunctionDeployment) map[string]string {
 	annotations := map[string]string{}
 	if r.Annotations != nil {
@@ -104,9 +401,19 @@ func (f *jobFactory) createAnnotations(r ftypes.FunctionDeployment) map[string]s
 			annotations[k] = v
 		}
 	}
+	for _, label := range f.config.Scheduling.CostLabels {
+		if value, ok := labelValue(r, label); ok && len(value) != 0 {
+			annotations[CostMetaKey(label)] = value
+		}
+	}
 	return annotations
 }
 
+// CostMetaKey returns the job meta key of a cost attribution label.
+func CostMetaKey(label string) string {
+	return CostMetaPrefix + serviceMetaKeyRe.ReplaceAllString(label, "_")
+}
+
 func (f *jobFactory) createUpdateStrategy(fd ftypes.FunctionDeployment) *api.UpdateStrategy {
 	// Update Strategy
 	stagger := types.ParseIntOrDurationValueFromMap(fd.Labels, "com.openfaas.nomad.update.stagger", 5*time.Second)
@@ -116,7 +423,7 @@ func (f *jobFactory) createUpdateStrategy(fd ftypes.FunctionDeployment) *api.Upd
 	healthyDeadline := types.ParseIntOrDurationValueFromMap(fd.Labels, "com.openfaas.nomad.update.healthy_deadline", 2*time.Minute)
 	progressDeadline := types.ParseIntOrDurationValueFromMap(fd.Labels, "com.openfaas.nomad.update.progress_deadline", 5*time.Minute)
 	canary := types.ParseIntValueFromMap(fd.Labels, "com.openfaas.nomad.update.canary", 0)
-	autoRevert := types.ParseBoolValueFromMap(fd.Labels, "com.openfaas.nomad.update.auto_revert", true)
+	autoRevert := types.ParseBoolValueFromMap(fd.Labels, "com.openfaas.nomad.update.auto_revert", f.config.Scheduling.AutoRevert)
 	autoPromote := types.ParseBoolValueFromMap(fd.Labels, "com.openfaas.nomad.update.auto_promote", false)
 
 	return &api.UpdateStrategy{
@@ -132,20 +439,121 @@ func (f *jobFactory) createUpdateStrategy(fd ftypes.FunctionDeployment) *api.Upd
 	}
 }
 
-func (f *jobFactory) createTaskGroups(fd ftypes.FunctionDeployment) []*api.TaskGroup {
-	count := f.getInitialCount(fd)
+func (f *jobFactory) createTaskGroups(namespace string, fd ftypes.FunctionDeployment) ([]*api.TaskGroup, error) {
+	count := f.getInitialCount(fd) + types.ParseIntValueFromMap(fd.Labels, WarmLabel, 0)
 
-	network := &api.NetworkResource{
-		Mode:         f.config.Scheduling.NetworkingMode,
-		DynamicPorts: []api.Port{{Label: "http", To: 8080}},
+	network, err := f.createNetwork(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := createServiceCheck(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName, err := f.config.Scheduling.ServiceName.Render(f.config.Scheduling.JobPrefix, fd.Service, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &api.Service{
+		Name:      serviceName,
+		PortLabel: "http",
+		Tags:      []string{"http", "faas"},
+		Checks:    []api.ServiceCheck{check},
+	}
+
+	tags, meta, err := f.createServiceLabels(fd)
+	if err != nil {
+		return nil, err
+	}
+	service.Tags = append(service.Tags, tags...)
+	service.Meta = meta
+
+	task, err := f.createTask(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.createLogLevel(namespace, fd, task); err != nil {
+		return nil, err
 	}
 
+	services := []*api.Service{service}
+	for _, port := range auxiliaryPorts(network) {
+		// the named ports are also published on the primary service, so the resolver can select them
+		if service.Meta == nil {
+			service.Meta = map[string]string{}
+		}
+		service.Meta["port_"+port.Label] = fmt.Sprintf("${NOMAD_HOST_PORT_%s}", port.Label)
+
+		services = append(services, &api.Service{
+			Name:      fmt.Sprintf("%s-%s", service.Name, port.Label),
+			PortLabel: port.Label,
+			Tags:      []string{"faas", "aux"},
+		})
+		if ports, ok := task.Config["ports"].([]string); ok {
+			task.Config["ports"] = append(ports, port.Label)
+		}
+	}
+
+	if f.config.Scheduling.ConsulConnect {
+		if network.Mode != "bridge" {
+			return nil, fmt.Errorf("invalid network mode '%s', consul connect requires the bridge network mode", network.Mode)
+		}
+		upstreams, err := ParseEgress(fd)
+		if err != nil {
+			return nil, err
+		}
+		service.Connect = &api.ConsulConnect{
+			SidecarService: &api.ConsulSidecarService{
+				Proxy: &api.ConsulProxy{Upstreams: upstreams},
+			},
+		}
+	} else if _, ok := labelValue(fd, "com.openfaas.egress"); ok {
+		return nil, fmt.Errorf("invalid egress, consul connect is not enabled")
+	}
+
+	tasks := []*api.Task{task}
+	if value, ok := labelValue(fd, "com.openfaas.prestart"); ok {
+		prestart, err := f.createPrestartTask(fd, value)
+		if err != nil {
+			return nil, err
+		}
+		// the function task stays the first task of the group, as the function is read from it
+		tasks = append(tasks, prestart)
+	}
+
+	shutdownDelay, err := createShutdownDelay(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	group := api.TaskGroup{
+		Name:          &fd.Service,
+		Count:         &count,
+		Networks:      []*api.NetworkResource{network},
+		Services:      services,
+		Tasks:         tasks,
+		ShutdownDelay: shutdownDelay,
+	}
+
+	// instances with fixed host ports can't share a node
+	if network.Mode == "host" && len(network.ReservedPorts) != 0 {
+		group.Constraints = append(group.Constraints, api.NewConstraint("", api.ConstraintDistinctHosts, "true"))
+	}
+
+	return []*api.TaskGroup{&group}, nil
+}
+
+// createServiceCheck returns the Consul check of a function, of the type of the com.openfaas.health.type label.
+func createServiceCheck(fd ftypes.FunctionDeployment) (api.ServiceCheck, error) {
 	gracePeriod := 5 * time.Second
 
 	check := api.ServiceCheck{
-		Type:                   "http",
+		Type:                   healthTypeHTTP,
 		PortLabel:              "http",
-		Path:                   "/_/health",
 		InitialStatus:          "critical",
 		SuccessBeforePassing:   1,
 		FailuresBeforeCritical: 3,
@@ -158,58 +566,729 @@ func (f *jobFactory) createTaskGroups(fd ftypes.FunctionDeployment) []*api.TaskG
 		},
 	}
 
-	service := &api.Service{
-		Name:      fmt.Sprintf("%s%s", f.config.Scheduling.JobPrefix, fd.Service),
-		PortLabel: "http",
-		Tags:      []string{"http", "faas"},
-		Checks:    []api.ServiceCheck{check},
+	if value, ok := labelValue(fd, HealthTypeLabel); ok {
+		check.Type = strings.ToLower(strings.TrimSpace(value))
+		if !containsString(healthTypes, check.Type) {
+			return api.ServiceCheck{}, fmt.Errorf("invalid health check type '%s', must be one of %s", value, strings.Join(healthTypes, ", "))
+		}
 	}
 
-	group := api.TaskGroup{
-		Name:     &fd.Service,
-		Count:    &count,
-		Networks: []*api.NetworkResource{network},
-		Services: []*api.Service{service},
-		Tasks:    []*api.Task{f.createTask(fd)},
+	grpcService, hasGRPCService := labelValue(fd, HealthGRPCServiceLabel)
+	grpcTLS, hasGRPCTLS := labelValue(fd, HealthGRPCTLSLabel)
+	command, hasCommand := labelValue(fd, HealthCommandLabel)
+
+	if check.Type != healthTypeGRPC && (hasGRPCService || hasGRPCTLS) {
+		return api.ServiceCheck{}, fmt.Errorf("invalid health check, %s and %s only apply to grpc checks", HealthGRPCServiceLabel, HealthGRPCTLSLabel)
+	}
+	if check.Type != healthTypeScript && hasCommand {
+		return api.ServiceCheck{}, fmt.Errorf("invalid health check, %s only applies to script checks", HealthCommandLabel)
+	}
+
+	switch check.Type {
+	case healthTypeHTTP:
+		check.Path = "/_/health"
+	case healthTypeGRPC:
+		if hasGRPCService {
+			if !grpcServiceRe.MatchString(grpcService) {
+				return api.ServiceCheck{}, fmt.Errorf("invalid gRPC health check service '%s'", grpcService)
+			}
+			check.GRPCService = grpcService
+		}
+		if hasGRPCTLS {
+			useTLS, err := strconv.ParseBool(grpcTLS)
+			if err != nil {
+				return api.ServiceCheck{}, fmt.Errorf("invalid %s '%s', must be true or false", HealthGRPCTLSLabel, grpcTLS)
+			}
+			check.GRPCUseTLS = useTLS
+		}
+	case healthTypeScript:
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return api.ServiceCheck{}, fmt.Errorf("invalid health check, script checks require the %s label", HealthCommandLabel)
+		}
+		// the command runs inside the task of the function, so the check isn't bound to a port
+		check.PortLabel = ""
+		check.TaskName = fd.Service
+		check.Command = args[0]
+		check.Args = args[1:]
+	}
+
+	return check, nil
+}
+
+// createServiceLabels translates the labels of a function selected in the configuration into tags, in the form of
+// <label>=<value>, and meta of the Consul service of the function. Meta keys are the label names, with the
+// characters not allowed by Consul replaced by underscores.
+func (f *jobFactory) createServiceLabels(fd ftypes.FunctionDeployment) ([]string, map[string]string, error) {
+	var tags []string
+	for _, label := range f.config.Scheduling.ConsulTagLabels {
+		value, ok := labelValue(fd, label)
+		if !ok {
+			continue
+		}
+		tag := fmt.Sprintf("%s=%s", label, value)
+		if !serviceTagRe.MatchString(tag) {
+			return nil, nil, fmt.Errorf("invalid service tag '%s', must only contain alphanumeric characters or any of _.:=/-", tag)
+		}
+		tags = append(tags, tag)
+	}
+
+	var meta map[string]string
+	for _, label := range f.config.Scheduling.ConsulMetaLabels {
+		value, ok := labelValue(fd, label)
+		if !ok {
+			continue
+		}
+		key := serviceMetaKeyRe.ReplaceAllString(label, "_")
+		if len(key) > 128 || len(value) > 512 {
+			return nil, nil, fmt.Errorf("invalid service meta '%s', keys are limited to 128 and values to 512 characters", label)
+		}
+		// the port_ prefix is used to publish the named ports of a function to the resolver
+		if strings.HasPrefix(key, "consul-") || strings.HasPrefix(key, "port_") {
+			return nil, nil, fmt.Errorf("invalid service meta '%s', the consul- and port_ prefixes are reserved", label)
+		}
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		meta[key] = value
+	}
+
+	return tags, meta, nil
+}
+
+// createNetwork creates the network of a function, with the primary http port used to resolve the function,
+// and the auxiliary ports of the com.openfaas.ports label, e.g. metrics:9100,grpc:9000:9000, each mapping
+// a name to a port of the function, optionally on a fixed host port.
+func (f *jobFactory) createNetwork(fd ftypes.FunctionDeployment) (*api.NetworkResource, error) {
+	mode := f.config.Scheduling.NetworkingMode
+	if value, ok := labelValue(fd, "com.openfaas.network-mode"); ok {
+		if !containsString(networkModes, value) {
+			return nil, fmt.Errorf("invalid network mode '%s', must be one of %s", value, strings.Join(networkModes, ", "))
+		}
+		mode = value
 	}
 
-	return []*api.TaskGroup{&group}
+	network := &api.NetworkResource{
+		Mode:         mode,
+		DynamicPorts: []api.Port{{Label: "http", To: 8080}},
+	}
+
+	value, ok := labelValue(fd, "com.openfaas.ports")
+	if !ok {
+		return network, nil
+	}
+
+	labels := map[string]bool{"http": true}
+	static := map[int]bool{}
+
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || !portLabelRe.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid port '%s', must be in the form name:port or name:port:host-port", entry)
+		}
+
+		label := parts[0]
+		if labels[label] {
+			return nil, fmt.Errorf("invalid port '%s', port %s is already defined", entry, label)
+		}
+		labels[label] = true
+
+		to, err := parsePort(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s', %s", entry, err)
+		}
+
+		if len(parts) == 2 {
+			network.DynamicPorts = append(network.DynamicPorts, api.Port{Label: label, To: to})
+			continue
+		}
+
+		hostPort, err := parsePort(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s', %s", entry, err)
+		}
+		if static[hostPort] {
+			return nil, fmt.Errorf("invalid port '%s', host port %d is already used", entry, hostPort)
+		}
+		static[hostPort] = true
+
+		network.ReservedPorts = append(network.ReservedPorts, api.Port{Label: label, Value: hostPort, To: to})
+	}
+
+	return network, nil
+}
+
+// ParseEgress parses the com.openfaas.egress label of a function into Consul Connect upstreams, e.g.
+// payments:9001,users:9002, each mapping an upstream service to the local port the function reaches it on.
+func ParseEgress(fd ftypes.FunctionDeployment) ([]*api.ConsulUpstream, error) {
+	value, ok := labelValue(fd, "com.openfaas.egress")
+	if !ok {
+		return nil, nil
+	}
+
+	var upstreams []*api.ConsulUpstream
+	names := map[string]bool{}
+	ports := map[int]bool{}
+
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 || !upstreamRe.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid egress '%s', must be in the form service:local-port", entry)
+		}
+
+		if names[parts[0]] {
+			return nil, fmt.Errorf("invalid egress '%s', service %s is already defined", entry, parts[0])
+		}
+		names[parts[0]] = true
+
+		port, err := parsePort(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress '%s', %s", entry, err)
+		}
+		if port == 8080 || ports[port] {
+			return nil, fmt.Errorf("invalid egress '%s', local port %d is already used", entry, port)
+		}
+		ports[port] = true
+
+		upstreams = append(upstreams, &api.ConsulUpstream{DestinationName: parts[0], LocalBindPort: port})
+	}
+
+	return upstreams, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %s must be a number between 1 and 65535", value)
+	}
+	return port, nil
+}
+
+// auxiliaryPorts returns all ports of the network, except for the primary http port.
+func auxiliaryPorts(network *api.NetworkResource) []api.Port {
+	var ports []api.Port
+	for _, port := range append(append([]api.Port{}, network.DynamicPorts...), network.ReservedPorts...) {
+		if port.Label != "http" {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// createPrestartTask creates a task from a prestart template of the config, which has to complete successfully
+// before the function task is started.
+func (f *jobFactory) createPrestartTask(fd ftypes.FunctionDeployment, name string) (*api.Task, error) {
+	template, ok := f.config.Scheduling.Prestart[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid prestart '%s', no such prestart template", name)
+	}
+	if len(template.Image) == 0 {
+		return nil, fmt.Errorf("invalid prestart '%s', the prestart template has no image", name)
+	}
+
+	config := map[string]interface{}{
+		"image": template.Image,
+	}
+	if len(template.Command) != 0 {
+		config["command"] = template.Command
+	}
+	if len(template.Args) != 0 {
+		config["args"] = template.Args
+	}
+
+	return &api.Task{
+		Name:   fmt.Sprintf("%s-prestart", fd.Service),
+		Driver: driverDocker,
+		Config: config,
+		Lifecycle: &api.TaskLifecycle{
+			Hook:    api.TaskLifecycleHookPrestart,
+			Sidecar: false,
+		},
+		LogConfig: &api.LogConfig{
+			MaxFiles:      &logFiles,
+			MaxFileSizeMB: &logSize,
+		},
+	}, nil
 }
 
 func (f *jobFactory) getInitialCount(fd ftypes.FunctionDeployment) int {
-	return types.ParseIntValueFromMap(fd.Labels, "com.openfaas.scale.min", 1)
+	defaultReplicas := f.config.Scheduling.DefaultReplicas
+	if defaultReplicas < 1 {
+		defaultReplicas = 1
+	}
+	return types.ParseIntValueFromMap(fd.Labels, "com.openfaas.scale.min", defaultReplicas)
 }
 
-func (f *jobFactory) createTask(fd ftypes.FunctionDeployment) *api.Task {
+func (f *jobFactory) createTask(fd ftypes.FunctionDeployment) (*api.Task, error) {
+	resources, err := createTaskResources(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	env, consulEnv, err := createConsulEnv(fd, createEnvVars(fd))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.addProxyEnv(fd, env); err != nil {
+		return nil, err
+	}
+
+	driver, config, artifacts, err := createTaskConfig(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver != driverDocker {
+		if _, ok := env["port"]; !ok {
+			env["port"] = "${NOMAD_PORT_http}"
+		}
+	}
+
 	var task api.Task
 	task = api.Task{
-		Name:   fd.Service,
-		Driver: "docker",
-		Config: map[string]interface{}{
-			"image":  fd.Image,
-			"ports":  []string{"http"},
-			"labels": createLabels(fd),
-		},
+		Name:      fd.Service,
+		Driver:    driver,
+		Config:    config,
+		Artifacts: artifacts,
 		LogConfig: &api.LogConfig{
 			MaxFiles:      &logFiles,
 			MaxFileSizeMB: &logSize,
 		},
-		Env:       createEnvVars(fd),
-		Resources: createTaskResources(fd),
+		Env:       env,
+		Resources: resources,
+		Meta:      createTaskMeta(fd),
+	}
+
+	killTimeout, err := f.createKillTimeout(fd)
+	if err != nil {
+		return nil, err
+	}
+	task.KillTimeout = killTimeout
+
+	if consulEnv != nil {
+		task.Templates = append(task.Templates, consulEnv)
 	}
 
 	if len(fd.Secrets) > 0 {
-		task.Config["volumes"] = createSecretVolumes(fd.Secrets)
-		task.Templates = createSecrets(f.config.Vault.SecretPathPrefix, fd.Secrets)
-		task.Vault = &api.Vault{
-			Policies: []string{f.config.Vault.Policy},
+		changeMode, changeSignal, err := createSecretsChangeMode(fd)
+		if err != nil {
+			return nil, err
+		}
+		if driver == driverDocker {
+			task.Config["volumes"] = createSecretVolumes(fd.Secrets)
+		}
+		if f.config.Secrets.Backend == SecretsBackendNomad {
+			task.Templates = append(task.Templates, createSecrets(variableSecretTemplate, f.config.Nomad.SecretPathPrefix, fd.Secrets, changeMode, changeSignal)...)
+		} else {
+			task.Templates = append(task.Templates, createSecrets(vaultSecretTemplate, f.config.Vault.SecretPathPrefix, fd.Secrets, changeMode, changeSignal)...)
+			task.Vault = &api.Vault{
+				Policies: []string{f.config.Vault.Policy},
+			}
+		}
+	}
+
+	return &task, nil
+}
+
+// createKillTimeout returns the kill timeout of the task from the com.openfaas.kill-timeout label, given as
+// duration or in seconds. Nomad clients cap the kill timeout at their max_kill_timeout, so a longer kill timeout
+// is rejected instead of being cut short silently.
+func (f *jobFactory) createKillTimeout(fd ftypes.FunctionDeployment) (*time.Duration, error) {
+	value, ok := labelValue(fd, KillTimeoutLabel)
+	if !ok {
+		return nil, nil
+	}
+	timeout, ok := parseDuration(value)
+	if !ok || timeout <= 0 {
+		return nil, fmt.Errorf("invalid kill timeout '%s', must be a positive duration", value)
+	}
+	if max := f.config.Scheduling.MaxKillTimeout; max > 0 && timeout > max {
+		return nil, fmt.Errorf("invalid kill timeout '%s', exceeds the max kill timeout of %s", value, max)
+	}
+	return &timeout, nil
+}
+
+// createShutdownDelay returns the shutdown delay of the task group from the com.openfaas.shutdown-delay label,
+// given as duration or in seconds. The services of a function are registered on the group, so the delay is
+// applied after the services of the group are deregistered.
+func createShutdownDelay(fd ftypes.FunctionDeployment) (*time.Duration, error) {
+	value, ok := labelValue(fd, ShutdownDelayLabel)
+	if !ok {
+		return nil, nil
+	}
+	delay, ok := parseDuration(value)
+	if !ok || delay < 0 {
+		return nil, fmt.Errorf("invalid shutdown delay '%s', must be a duration", value)
+	}
+	return &delay, nil
+}
+
+func parseWarmReplicas(fd ftypes.FunctionDeployment) (int, error) {
+	value, ok := labelValue(fd, WarmLabel)
+	if !ok {
+		return 0, nil
+	}
+	warm, err := strconv.Atoi(value)
+	if err != nil || warm < 0 {
+		return 0, fmt.Errorf("invalid warm replicas '%s', must be a positive number", value)
+	}
+	return warm, nil
+}
+
+// WarmReplicas returns the number of warm replicas of a function, which are part of the count of its job.
+func WarmReplicas(labels map[string]string) int {
+	if warm := types.ParseIntValueFromMap(&labels, WarmLabel, 0); warm > 0 {
+		return warm
+	}
+	return 0
+}
+
+// parseDuration parses a duration, where a number is a duration in seconds.
+func parseDuration(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	d, err := time.ParseDuration(value)
+	return d, err == nil
+}
+
+// createTaskConfig creates the driver config of a task, with the artifact to fetch for the non-docker drivers.
+func createTaskConfig(fd ftypes.FunctionDeployment) (string, map[string]interface{}, []*api.TaskArtifact, error) {
+	driver := types.ParseStringValueFromMap(fd.Labels, DriverLabel, driverDocker)
+	if !containsString(drivers, driver) {
+		return "", nil, nil, fmt.Errorf("invalid driver '%s', must be one of %s", driver, strings.Join(drivers, ", "))
+	}
+
+	ulimit, sysctl, err := createKernelLimits(fd)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	dnsServers, extraHosts, err := createNameResolution(fd)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if driver == driverDocker {
+		image, _, err := createImage(fd)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		config := map[string]interface{}{
+			"image":  image,
+			"ports":  []string{"http"},
+			"labels": createLabels(fd),
 		}
+		if len(ulimit) != 0 {
+			config["ulimit"] = ulimit
+		}
+		if len(sysctl) != 0 {
+			config["sysctl"] = sysctl
+		}
+		if len(dnsServers) != 0 {
+			config["dns_servers"] = dnsServers
+		}
+		if len(extraHosts) != 0 {
+			config["extra_hosts"] = extraHosts
+		}
+		return driver, config, nil, nil
+	}
+
+	if len(ulimit) != 0 || len(sysctl) != 0 {
+		return "", nil, nil, fmt.Errorf("ulimits and sysctls are only supported with the docker driver")
+	}
+	if len(dnsServers) != 0 || len(extraHosts) != 0 {
+		return "", nil, nil, fmt.Errorf("dns servers and extra hosts are only supported with the docker driver")
+	}
+
+	artifacts, err := createArtifacts(fd)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	args := strings.Fields(types.ParseStringValueFromMap(fd.Labels, "com.openfaas.args", ""))
+	config := map[string]interface{}{}
+
+	if driver == driverJava {
+		jarPath, ok := labelValue(fd, "com.openfaas.jar-path")
+		if !ok || len(jarPath) == 0 {
+			return "", nil, nil, fmt.Errorf("the java driver requires the com.openfaas.jar-path label")
+		}
+		config["jar_path"] = jarPath
+		if len(args) != 0 {
+			config["args"] = args
+		}
+		if options := strings.Fields(types.ParseStringValueFromMap(fd.Labels, "com.openfaas.jvm-options", "")); len(options) != 0 {
+			config["jvm_options"] = options
+		}
+		return driver, config, artifacts, nil
+	}
+
+	command, ok := labelValue(fd, "com.openfaas.command")
+	if !ok || len(command) == 0 {
+		return "", nil, nil, fmt.Errorf("the %s driver requires the com.openfaas.command label", driver)
+	}
+	config["command"] = command
+	if len(args) != 0 {
+		config["args"] = args
+	}
+
+	return driver, config, artifacts, nil
+}
+
+// createKernelLimits creates the ulimit and sysctl config of the docker driver from the com.openfaas.ulimit.* and
+// com.openfaas.sysctl.* labels. Ulimits are limited to the resources known by docker, with soft and hard limits
+// up to 1048576 where the soft limit can't exceed the hard limit. Sysctls are limited to the parameters which are
+// namespaced per container, so a function can't change the kernel parameters of the node.
+func createKernelLimits(fd ftypes.FunctionDeployment) (map[string]string, map[string]string, error) {
+	if fd.Labels == nil {
+		return nil, nil, nil
 	}
 
-	return &task
+	ulimit := map[string]string{}
+	sysctl := map[string]string{}
+
+	for key, value := range *fd.Labels {
+		switch {
+		case strings.HasPrefix(key, UlimitLabelPrefix):
+			name := strings.TrimPrefix(key, UlimitLabelPrefix)
+			if !containsString(ulimits, name) {
+				return nil, nil, fmt.Errorf("invalid ulimit '%s', must be one of %s", name, strings.Join(ulimits, ", "))
+			}
+			limit, err := parseUlimit(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value '%s' for ulimit %s: %s", value, name, err)
+			}
+			ulimit[name] = limit
+		case strings.HasPrefix(key, SysctlLabelPrefix):
+			name := strings.TrimPrefix(key, SysctlLabelPrefix)
+			if !sysctlKeyRe.MatchString(name) || !hasPrefix(sysctlPrefixes, name) {
+				return nil, nil, fmt.Errorf("invalid sysctl '%s', only namespaced parameters starting with %s are allowed", name, strings.Join(sysctlPrefixes, ", "))
+			}
+			value = strings.TrimSpace(value)
+			if !sysctlValueRe.MatchString(value) {
+				return nil, nil, fmt.Errorf("invalid value '%s' for sysctl %s, must be numeric", value, name)
+			}
+			sysctl[name] = value
+		}
+	}
+
+	return ulimit, sysctl, nil
 }
 
-func createTaskResources(fd ftypes.FunctionDeployment) *api.Resources {
+// createNameResolution creates the dns_servers and extra_hosts config of the docker driver from the
+// com.openfaas.dns-servers and com.openfaas.extra-hosts labels, in the order given. The host of an extra host is
+// split from its address at the first colon, so the address can be an IPv6 address.
+func createNameResolution(fd ftypes.FunctionDeployment) ([]string, []string, error) {
+	var dnsServers []string
+	if value, ok := labelValue(fd, DNSServersLabel); ok {
+		for _, server := range strings.Split(value, ",") {
+			server = strings.TrimSpace(server)
+			if net.ParseIP(server) == nil {
+				return nil, nil, fmt.Errorf("invalid dns server '%s', must be an IP address", server)
+			}
+			dnsServers = append(dnsServers, server)
+		}
+	}
+
+	var extraHosts []string
+	if value, ok := labelValue(fd, ExtraHostsLabel); ok {
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+			if len(parts) != 2 || !hostnameRe.MatchString(parts[0]) || net.ParseIP(parts[1]) == nil {
+				return nil, nil, fmt.Errorf("invalid extra host '%s', expected format is <host>:<ip>", strings.TrimSpace(entry))
+			}
+			extraHosts = append(extraHosts, parts[0]+":"+parts[1])
+		}
+	}
+
+	return dnsServers, extraHosts, nil
+}
+
+// parseUlimit parses a ulimit in the form of soft or soft:hard, where the hard limit defaults to the soft limit.
+func parseUlimit(value string) (string, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), ":", 2)
+
+	var limits []int
+	for _, part := range parts {
+		limit, err := strconv.Atoi(part)
+		if err != nil {
+			return "", fmt.Errorf("must be numeric")
+		}
+		if limit < 0 || limit > maxUlimit {
+			return "", fmt.Errorf("must be between 0 and %d", maxUlimit)
+		}
+		limits = append(limits, limit)
+	}
+
+	if len(limits) == 1 {
+		return strconv.Itoa(limits[0]), nil
+	}
+	if limits[0] > limits[1] {
+		return "", fmt.Errorf("soft limit exceeds hard limit")
+	}
+	return fmt.Sprintf("%d:%d", limits[0], limits[1]), nil
+}
+
+func hasPrefix(prefixes []string, value string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// createImage returns the image of a function with the cpu architectures it is restricted to, if any. With the
+// com.openfaas.images label, the image is the common form of the images of the architectures, in which the
+// architecture is replaced by the ${attr.cpu.arch} attribute.
+func createImage(fd ftypes.FunctionDeployment) (string, []string, error) {
+	value, ok := labelValue(fd, ImagesLabel)
+	if !ok {
+		archs, err := parseArchs(types.ParseStringValueFromMap(fd.Labels, ArchsLabel, ""))
+		return fd.Image, archs, err
+	}
+
+	if _, ok := labelValue(fd, ArchsLabel); ok {
+		return "", nil, fmt.Errorf("invalid images, the %s and %s labels can't be combined", ImagesLabel, ArchsLabel)
+	}
+
+	images := map[string]string{}
+	var archs []string
+	for _, entry := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("invalid image '%s', expected format is <arch>=<image>", entry)
+		}
+		arch, image := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !archRe.MatchString(arch) || len(image) == 0 {
+			return "", nil, fmt.Errorf("invalid image '%s', expected format is <arch>=<image>", entry)
+		}
+		if _, ok := images[arch]; ok {
+			return "", nil, fmt.Errorf("invalid images, duplicate architecture '%s'", arch)
+		}
+		images[arch] = image
+		archs = append(archs, arch)
+	}
+
+	if len(archs) == 0 {
+		return "", nil, fmt.Errorf("invalid images '%s', at least one image is required", value)
+	}
+
+	sort.Strings(archs)
+
+	common := ""
+	for _, arch := range archs {
+		image := images[arch]
+		i := strings.LastIndex(image, arch)
+		if i < 0 {
+			return "", nil, fmt.Errorf("invalid image '%s', the image of an architecture must contain the name of the architecture", image)
+		}
+		interpolated := image[:i] + archAttribute + image[i+len(arch):]
+		if len(common) == 0 {
+			common = interpolated
+		} else if interpolated != common {
+			return "", nil, fmt.Errorf("invalid images '%s', the images may only differ in the name of the architecture, use a multi-arch image with the %s label instead", value, ArchsLabel)
+		}
+	}
+
+	return common, archs, nil
+}
+
+func parseArchs(value string) ([]string, error) {
+	var archs []string
+	for _, arch := range strings.Split(value, ",") {
+		arch = strings.TrimSpace(arch)
+		if len(arch) == 0 {
+			continue
+		}
+		if !archRe.MatchString(arch) {
+			return nil, fmt.Errorf("invalid architecture '%s'", arch)
+		}
+		if !containsString(archs, arch) {
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+	return archs, nil
+}
+
+// createArtifacts creates the artifacts fetched by Nomad before the task is started. Next to the source given
+// by the com.openfaas.artifact label (or the image), additional artifacts are configured with the
+// com.openfaas.artifact.<name>.source, com.openfaas.artifact.<name>.destination and
+// com.openfaas.artifact.<name>.checksum labels.
+func createArtifacts(fd ftypes.FunctionDeployment) ([]*api.TaskArtifact, error) {
+	var artifacts []*api.TaskArtifact
+
+	if source := types.ParseStringValueFromMap(fd.Labels, "com.openfaas.artifact", fd.Image); len(source) != 0 {
+		artifact, err := createArtifact(source, defaultArtifactDestination, "")
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	if fd.Labels == nil {
+		return artifacts, nil
+	}
+
+	var names []string
+	for key := range *fd.Labels {
+		if strings.HasPrefix(key, artifactLabelPrefix) && strings.HasSuffix(key, ".source") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, artifactLabelPrefix), ".source"))
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prefix := artifactLabelPrefix + name
+		source := types.ParseStringValueFromMap(fd.Labels, prefix+".source", "")
+		destination := types.ParseStringValueFromMap(fd.Labels, prefix+".destination", defaultArtifactDestination)
+		checksum := types.ParseStringValueFromMap(fd.Labels, prefix+".checksum", "")
+
+		artifact, err := createArtifact(source, destination, checksum)
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact '%s': %s", name, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, nil
+}
+
+func createArtifact(source string, destination string, checksum string) (*api.TaskArtifact, error) {
+	// go-getter allows to force a getter with a prefix, e.g. git::https://github.com/...
+	u, err := url.Parse(artifactGetterRe.ReplaceAllString(source, ""))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid artifact source '%s', must be a URL", source)
+	}
+
+	if path.IsAbs(destination) || strings.HasPrefix(path.Clean(destination), "..") {
+		return nil, fmt.Errorf("invalid artifact destination '%s', must be relative to the task directory", destination)
+	}
+
+	artifact := &api.TaskArtifact{
+		GetterSource: &source,
+		RelativeDest: &destination,
+	}
+
+	if len(checksum) != 0 {
+		parts := strings.SplitN(checksum, ":", 2)
+		length, ok := checksumLengths[parts[0]]
+		if len(parts) != 2 || !ok || len(parts[1]) != length || !hexRe.MatchString(parts[1]) {
+			return nil, fmt.Errorf("invalid artifact checksum '%s', must be in the form type:value with type one of md5, sha1, sha256 or sha512", checksum)
+		}
+		artifact.GetterOptions = map[string]string{"checksum": checksum}
+	}
+
+	return artifact, nil
+}
+
+func createTaskResources(fd ftypes.FunctionDeployment) (*api.Resources, error) {
 	taskMemory := 128
 	taskCPU := 100
 
@@ -225,10 +1304,25 @@ func createTaskResources(fd ftypes.FunctionDeployment) *api.Resources {
 		}
 	}
 
-	return &api.Resources{
+	resources := &api.Resources{
 		MemoryMB: &taskMemory,
 		CPU:      &taskCPU,
 	}
+
+	// allow functions to burst above their reserved memory when memory oversubscription is enabled
+	if value, ok := labelValue(fd, "com.openfaas.memory.max"); ok {
+		memoryMax, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory max '%s', must be a number", value)
+		}
+		if int(memoryMax) < taskMemory {
+			return nil, fmt.Errorf("invalid memory max '%s', must be greater than or equal to the reserved memory of %d", value, taskMemory)
+		}
+		taskMemoryMax := int(memoryMax)
+		resources.MemoryMaxMB = &taskMemoryMax
+	}
+
+	return resources, nil
 }
 
 func createLabels(r ftypes.FunctionDeployment) []map[string]interface{} {
@@ -241,6 +1335,19 @@ func createLabels(r ftypes.FunctionDeployment) []map[string]interface{} {
 	return []map[string]interface{}{labels}
 }
 
+// createTaskMeta stores the labels of a function in the meta of its task, where they are kept for every driver, as
+// only the docker driver has labels of its own.
+func createTaskMeta(r ftypes.FunctionDeployment) map[string]string {
+	if r.Labels == nil || len(*r.Labels) == 0 {
+		return nil
+	}
+	meta := make(map[string]string, len(*r.Labels))
+	for k, v := range *r.Labels {
+		meta[k] = v
+	}
+	return meta
+}
+
 func createEnvVars(r ftypes.FunctionDeployment) map[string]string {
 	envVars := map[string]string{}
 
@@ -255,6 +1362,136 @@ func createEnvVars(r ftypes.FunctionDeployment) map[string]string {
 	return envVars
 }
 
+// addProxyEnv sets the outbound proxy of a function as the HTTP_PROXY, HTTPS_PROXY and NO_PROXY env vars, in upper
+// and lower case as both are common. Env vars set by the function itself are kept.
+func (f *jobFactory) addProxyEnv(fd ftypes.FunctionDeployment, env map[string]string) error {
+	httpProxy, err := proxyURL(fd, HTTPProxyLabel, f.config.Scheduling.HTTPProxy)
+	if err != nil {
+		return err
+	}
+	httpsProxy, err := proxyURL(fd, HTTPSProxyLabel, f.config.Scheduling.HTTPSProxy)
+	if err != nil {
+		return err
+	}
+	if len(httpProxy) == 0 && len(httpsProxy) == 0 {
+		return nil
+	}
+
+	exclusions := f.config.Scheduling.NoProxy
+	if value, ok := labelValue(fd, NoProxyLabel); ok {
+		exclusions = strings.Split(value, ",")
+	}
+
+	seen := map[string]bool{}
+	var addresses []string
+	for _, address := range append(append([]string{}, internalAddresses...), exclusions...) {
+		address = strings.TrimSpace(address)
+		if len(address) != 0 && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	setProxyEnv(env, "HTTP_PROXY", httpProxy)
+	setProxyEnv(env, "HTTPS_PROXY", httpsProxy)
+	setProxyEnv(env, "NO_PROXY", strings.Join(addresses, ","))
+	return nil
+}
+
+// proxyURL returns the outbound proxy of a function from its label, or the configured proxy otherwise.
+func proxyURL(fd ftypes.FunctionDeployment, label, fallback string) (string, error) {
+	value, ok := labelValue(fd, label)
+	if !ok {
+		return fallback, nil
+	}
+	if value == noProxy {
+		return "", nil
+	}
+	if !types.ValidProxyURL(value) {
+		return "", fmt.Errorf("invalid %s '%s', must be an http, https or socks5 URL", label, value)
+	}
+	return value, nil
+}
+
+func setProxyEnv(env map[string]string, name, value string) {
+	if len(value) == 0 {
+		return
+	}
+	for _, key := range []string{name, strings.ToLower(name)} {
+		if _, ok := env[key]; !ok {
+			env[key] = value
+		}
+	}
+}
+
+// createConsulEnv moves the env values referencing a Consul KV key into a template rendering them as env vars.
+func createConsulEnv(fd ftypes.FunctionDeployment, envVars map[string]string) (map[string]string, *api.Template, error) {
+	env := map[string]string{}
+	var names []string
+
+	for name, value := range envVars {
+		if !strings.HasPrefix(value, ConsulEnvPrefix) {
+			env[name] = value
+			continue
+		}
+		key := strings.TrimPrefix(value, ConsulEnvPrefix)
+		if !consulKeyRe.MatchString(key) {
+			return nil, nil, fmt.Errorf("invalid Consul KV reference '%s' for env var %s, must be in the form consul:path/to/key", value, name)
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return env, nil, nil
+	}
+
+	changeMode := defaultConsulChangeMode
+	if value, ok := labelValue(fd, "com.openfaas.consul.change-mode"); ok {
+		if !containsString(templateChangeModes, value) {
+			return nil, nil, fmt.Errorf("invalid Consul change mode '%s', must be one of %s", value, strings.Join(templateChangeModes, ", "))
+		}
+		changeMode = value
+	}
+
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		key := strings.TrimPrefix(envVars[name], ConsulEnvPrefix)
+		lines = append(lines, fmt.Sprintf(`%s={{ key "%s" | toJSON }}`, name, key))
+	}
+
+	destPath := consulEnvFile
+	embeddedTemplate := strings.Join(lines, "\n") + "\n"
+	envvars := true
+
+	template := &api.Template{
+		DestPath:     &destPath,
+		EmbeddedTmpl: &embeddedTemplate,
+		Envvars:      &envvars,
+		ChangeMode:   &changeMode,
+	}
+
+	if changeMode == "signal" {
+		changeSignal := strings.ToUpper(types.ParseStringValueFromMap(fd.Labels, "com.openfaas.consul.change-signal", defaultConsulChangeSignal))
+		if !containsString(changeSignals, changeSignal) {
+			return nil, nil, fmt.Errorf("invalid Consul change signal '%s', must be one of %s", changeSignal, strings.Join(changeSignals, ", "))
+		}
+		template.ChangeSignal = &changeSignal
+	}
+
+	return env, template, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func createSecretVolumes(secrets []string) []string {
 	newVolumes := []string{}
 	for _, s := range secrets {
@@ -264,17 +1501,44 @@ func createSecretVolumes(secrets []string) []string {
 	return newVolumes
 }
 
-func createSecrets(vaultPrefix string, secrets []string) []*api.Template {
+// createSecretsChangeMode returns the change mode of the templates rendering the secrets of a function, from the
+// com.openfaas.secrets.change-mode label, and the signal sent to the function in signal mode, SIGHUP by default.
+func createSecretsChangeMode(fd ftypes.FunctionDeployment) (string, string, error) {
+	changeMode := defaultSecretsChangeMode
+	if value, ok := labelValue(fd, SecretsChangeModeLabel); ok {
+		if !containsString(templateChangeModes, value) {
+			return "", "", fmt.Errorf("invalid secrets change mode '%s', must be one of %s", value, strings.Join(templateChangeModes, ", "))
+		}
+		changeMode = value
+	}
+
+	if changeMode != "signal" {
+		return changeMode, "", nil
+	}
+	changeSignal := strings.ToUpper(types.ParseStringValueFromMap(fd.Labels, SecretsChangeSignalLabel, defaultConsulChangeSignal))
+	if !containsString(changeSignals, changeSignal) {
+		return "", "", fmt.Errorf("invalid secrets change signal '%s', must be one of %s", changeSignal, strings.Join(changeSignals, ", "))
+	}
+	return changeMode, changeSignal, nil
+}
+
+func createSecrets(secretTemplate string, prefix string, secrets []string, changeMode, changeSignal string) []*api.Template {
 	var templates []*api.Template
 
 	for _, s := range secrets {
-		path := fmt.Sprintf("%s/%s", vaultPrefix, s)
+		path := fmt.Sprintf("%s/%s", prefix, s)
 		destPath := "secrets/" + s
+		mode := changeMode
 
-		embeddedTemplate := fmt.Sprintf(`{{with secret "%s"}}{{base64Decode .Data.value}}{{end}}`, path)
+		embeddedTemplate := fmt.Sprintf(secretTemplate, path)
 		template := &api.Template{
 			DestPath:     &destPath,
 			EmbeddedTmpl: &embeddedTemplate,
+			ChangeMode:   &mode,
+		}
+		if len(changeSignal) != 0 {
+			signal := changeSignal
+			template.ChangeSignal = &signal
 		}
 
 		templates = append(templates, template)
@@ -9,6 +9,8 @@ type Jobs interface {
 	List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error)
 	Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error)
 	LatestDeployment(jobID string, q *api.QueryOptions) (*api.Deployment, *api.QueryMeta, error)
+	Versions(jobID string, diffs bool, q *api.QueryOptions) ([]*api.Job, []*api.JobDiff, *api.QueryMeta, error)
+	Revert(jobID string, version uint64, enforcePriorVersion *uint64, q *api.WriteOptions, consulToken, vaultToken string) (*api.JobRegisterResponse, *api.WriteMeta, error)
 	RegisterOpts(job *api.Job, opts *api.RegisterOptions, q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error)
 	Deregister(jobID string, purge bool, q *api.WriteOptions) (string, *api.WriteMeta, error)
 	Scale(jobID, group string, count *int, message string, error bool, meta map[string]interface{}, q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error)
@@ -16,6 +18,16 @@ type Jobs interface {
 }
 
 func NewNomadJobs(config types.NomadConfig) (Jobs, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.Jobs(), nil
+}
+
+func newNomadClient(config types.NomadConfig) (*api.Client, error) {
 	c := api.DefaultConfig()
 
 	c.Address = config.Addr
@@ -25,11 +37,5 @@ func NewNomadJobs(config types.NomadConfig) (Jobs, error) {
 	c.TLSConfig.ClientKey = config.ClientKey
 	c.TLSConfig.Insecure = config.TLSSkipVerify
 
-	nomadClient, err := api.NewClient(c)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return nomadClient.Jobs(), nil
+	return api.NewClient(c)
 }
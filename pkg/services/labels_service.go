@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	labelsCacheExpiry = 30 * time.Second
+)
+
+type FunctionLabels interface {
+	Labels(functionName string) (map[string]string, error)
+}
+
+func NewFunctionLabels(config *types.ProviderConfig, jobs Jobs) FunctionLabels {
+	return &CachedFunctionLabels{
+		jobs:      jobs,
+		prefix:    config.Scheduling.JobPrefix,
+		namespace: config.Scheduling.Namespace,
+	}
+}
+
+type CachedFunctionLabels struct {
+	jobs      Jobs
+	cache     sync.Map
+	prefix    string
+	namespace string
+}
+
+type labelsItem struct {
+	labels map[string]string
+	expiry time.Time
+}
+
+func (c *CachedFunctionLabels) Labels(functionName string) (map[string]string, error) {
+	name := strings.TrimSuffix(functionName, "."+c.namespace)
+
+	if val, ok := c.cache.Load(name); ok {
+		item := val.(*labelsItem)
+		if time.Now().Before(item.expiry) {
+			return item.labels, nil
+		}
+	}
+
+	job, err := ReadFunctionJob(c.jobs, c.prefix, &api.QueryOptions{Namespace: c.namespace}, name)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := JobLabels(job)
+	c.cache.Store(name, &labelsItem{labels: labels, expiry: time.Now().Add(labelsCacheExpiry)})
+
+	return labels, nil
+}
+
+// RemoveCacheItem forgets the labels of a deleted function.
+func (c *CachedFunctionLabels) RemoveCacheItem(functionName string) {
+	c.cache.Delete(strings.TrimSuffix(functionName, "."+c.namespace))
+}
+
+// JobLabels extracts the OpenFaaS function labels stored in the meta of the function task, for every driver, and in
+// the docker config of the function task, where the functions deployed before the labels were kept in the meta
+// only have them.
+func JobLabels(job *api.Job) map[string]string {
+	labels := map[string]string{}
+	if job == nil || len(job.TaskGroups) == 0 || len(job.TaskGroups[0].Tasks) == 0 {
+		return labels
+	}
+
+	task := job.TaskGroups[0].Tasks[0]
+	for k, v := range task.Meta {
+		labels[k] = v
+	}
+
+	switch l := task.Config["labels"].(type) {
+	case []interface{}:
+		for _, m := range l {
+			if values, ok := m.(map[string]interface{}); ok {
+				for k, v := range values {
+					labels[k] = fmt.Sprintf("%v", v)
+				}
+			}
+		}
+	case []map[string]interface{}:
+		for _, values := range l {
+			for k, v := range values {
+				labels[k] = fmt.Sprintf("%v", v)
+			}
+		}
+	}
+
+	return labels
+}
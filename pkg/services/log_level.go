@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// LogLevelLabel selects how the log level of a running function is changed, without a redeploy:
+	//  - signal sends the signal given for the level by the com.openfaas.log-level.signals label to the function
+	//  - template writes the level to a Consul KV key, which is rendered in the env var of the
+	//    com.openfaas.log-level.env label, restarting the task of the function in place
+	LogLevelLabel = "com.openfaas.log-level"
+
+	// LogLevelSignalsLabel gives the signal per log level, e.g. debug=SIGUSR1,info=SIGUSR2.
+	LogLevelSignalsLabel = "com.openfaas.log-level.signals"
+
+	// LogLevelEnvLabel is the env var holding the log level of a function changed with a template.
+	LogLevelEnvLabel = "com.openfaas.log-level.env"
+
+	LogLevelModeSignal   = "signal"
+	LogLevelModeTemplate = "template"
+
+	defaultLogLevelSignals = "debug=SIGUSR1,info=SIGUSR2"
+	defaultLogLevelEnv     = "LOG_LEVEL"
+	defaultLogLevel        = "info"
+	logLevelEnvFile        = "local/log-level.env"
+)
+
+var (
+	LogLevels     = []string{"trace", "debug", "info", "warn", "error"}
+	logLevelModes = []string{LogLevelModeSignal, LogLevelModeTemplate}
+	logLevelEnvRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// LogLevelMode returns the mechanism changing the log level of a function, or an empty mode when the log level of
+// the function can't be changed.
+func LogLevelMode(labels map[string]string) (string, error) {
+	mode, ok := labels[LogLevelLabel]
+	if !ok || len(mode) == 0 {
+		return "", nil
+	}
+	if !containsString(logLevelModes, mode) {
+		return "", fmt.Errorf("invalid %s '%s', must be one of %s", LogLevelLabel, mode, strings.Join(logLevelModes, ", "))
+	}
+	return mode, nil
+}
+
+// LogLevelSignals returns the signal per log level of a function changing its log level with a signal.
+func LogLevelSignals(labels map[string]string) (map[string]string, error) {
+	value, ok := labels[LogLevelSignalsLabel]
+	if !ok || len(value) == 0 {
+		value = defaultLogLevelSignals
+	}
+
+	signals := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s '%s', expected format is <level>=<signal>", LogLevelSignalsLabel, value)
+		}
+		level, signal := strings.ToLower(strings.TrimSpace(parts[0])), strings.ToUpper(strings.TrimSpace(parts[1]))
+		if !containsString(LogLevels, level) {
+			return nil, fmt.Errorf("invalid %s '%s', '%s' is not a log level, must be one of %s", LogLevelSignalsLabel, value, level, strings.Join(LogLevels, ", "))
+		}
+		if !containsString(changeSignals, signal) {
+			return nil, fmt.Errorf("invalid %s '%s', '%s' is not a supported signal, must be one of %s", LogLevelSignalsLabel, value, signal, strings.Join(changeSignals, ", "))
+		}
+		signals[level] = signal
+	}
+	return signals, nil
+}
+
+// LogLevelKey returns the Consul KV key holding the log level of a function changing its log level with a template.
+func LogLevelKey(prefix, namespace, function string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(prefix, "/"), namespace, function)
+}
+
+// createLogLevel validates the log level labels of a function, and adds the template rendering the log level of the
+// function into its env when the log level is changed with a template. Until a level is written to the key, the
+// env var keeps the value given in the env of the function, or info.
+func (f *jobFactory) createLogLevel(namespace string, fd ftypes.FunctionDeployment, task *api.Task) error {
+	var labels map[string]string
+	if fd.Labels != nil {
+		labels = *fd.Labels
+	}
+
+	mode, err := LogLevelMode(labels)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case LogLevelModeSignal:
+		_, err := LogLevelSignals(labels)
+		return err
+	case LogLevelModeTemplate:
+		name := types.ParseStringValueFromMap(fd.Labels, LogLevelEnvLabel, defaultLogLevelEnv)
+		if !logLevelEnvRe.MatchString(name) {
+			return fmt.Errorf("invalid %s '%s', must be a valid env var name", LogLevelEnvLabel, name)
+		}
+
+		level := defaultLogLevel
+		if value, ok := task.Env[name]; ok && len(value) != 0 {
+			level = value
+		}
+		delete(task.Env, name)
+
+		key := LogLevelKey(f.config.Consul.LogLevelKVPrefix, namespace, fd.Service)
+		destPath := logLevelEnvFile
+		embeddedTemplate := fmt.Sprintf("%s={{ keyOrDefault %q %q | toJSON }}\n", name, key, level)
+		envvars := true
+		// env vars are only read when the task starts, so the task is restarted in place
+		changeMode := "restart"
+
+		task.Templates = append(task.Templates, &api.Template{
+			DestPath:     &destPath,
+			EmbeddedTmpl: &embeddedTemplate,
+			Envvars:      &envvars,
+			ChangeMode:   &changeMode,
+		})
+	}
+	return nil
+}
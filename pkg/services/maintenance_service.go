@@ -0,0 +1,118 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+const (
+	// WarmReason is the reason of the maintenance mode of the instances drained as warm replica, which tells
+	// them apart from instances put into maintenance by an operator.
+	WarmReason = "faas-nomad warm replica"
+
+	maintenanceCheckPrefix = "_service_maintenance:"
+)
+
+// ServiceInstance is an instance of a service registered in Consul.
+type ServiceInstance struct {
+	ID          string
+	Node        string
+	NodeAddress string
+	Datacenter  string
+	// Healthy reports whether the checks of the instance are passing, regardless of its maintenance mode.
+	Healthy bool
+	// Drained reports whether the instance is in maintenance mode as warm replica.
+	Drained bool
+}
+
+// ServiceMaintenance puts instances of a service into maintenance mode, so they are no longer resolved.
+type ServiceMaintenance interface {
+	Instances(service string) ([]ServiceInstance, error)
+	SetMaintenance(instance ServiceInstance, enable bool) error
+}
+
+// NewConsulServiceMaintenance creates the maintenance of the services in Consul. The maintenance mode of
+// a service is managed by the agent the service is registered with, so the agent of the node of an
+// instance is reached on the node address, with the configured agent port.
+func NewConsulServiceMaintenance(config types.ConsulConfig) (ServiceMaintenance, error) {
+	client, err := newConsulClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &consulServiceMaintenance{config: config, health: client.Health()}, nil
+}
+
+type consulServiceMaintenance struct {
+	config types.ConsulConfig
+	health *consulapi.Health
+	agents sync.Map
+}
+
+func (m *consulServiceMaintenance) Instances(service string) ([]ServiceInstance, error) {
+	entries, _, err := m.health.Service(service, "", false, &consulapi.QueryOptions{Datacenter: m.config.Datacenter})
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		instance := ServiceInstance{
+			ID:          entry.Service.ID,
+			Node:        entry.Node.Node,
+			NodeAddress: entry.Node.Address,
+			Datacenter:  entry.Node.Datacenter,
+			Healthy:     true,
+		}
+		for _, check := range entry.Checks {
+			if check.CheckID == maintenanceCheckPrefix+entry.Service.ID {
+				instance.Drained = check.Notes == WarmReason
+				continue
+			}
+			if check.Status != consulapi.HealthPassing {
+				instance.Healthy = false
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (m *consulServiceMaintenance) SetMaintenance(instance ServiceInstance, enable bool) error {
+	agent, err := m.agent(instance.NodeAddress)
+	if err != nil {
+		return err
+	}
+	if enable {
+		return agent.EnableServiceMaintenance(instance.ID, WarmReason)
+	}
+	return agent.DisableServiceMaintenance(instance.ID)
+}
+
+// agent returns a client of the agent of a node, sharing the scheme, token and tls config of the Consul config.
+func (m *consulServiceMaintenance) agent(nodeAddress string) (*consulapi.Agent, error) {
+	if val, ok := m.agents.Load(nodeAddress); ok {
+		return val.(*consulapi.Agent), nil
+	}
+
+	scheme := "http"
+	if u, err := url.Parse(m.config.Addr); err == nil && len(u.Scheme) != 0 {
+		scheme = u.Scheme
+	}
+
+	config := m.config
+	config.Addr = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(nodeAddress, strconv.Itoa(m.config.AgentPort)))
+
+	client, err := newConsulClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	agent, _ := m.agents.LoadOrStore(nodeAddress, client.Agent())
+	return agent.(*consulapi.Agent), nil
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"net/url"
 
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/nomad/api"
 	ftypes "github.com/openfaas/faas-provider/types"
 	"github.com/stretchr/testify/mock"
@@ -12,8 +13,8 @@ type MockSecrets struct {
 	mock.Mock
 }
 
-func (ms *MockSecrets) List() ([]ftypes.Secret, error) {
-	args := ms.Called()
+func (ms *MockSecrets) List(namespace string) ([]ftypes.Secret, error) {
+	args := ms.Called(namespace)
 
 	var resp []ftypes.Secret
 	if r := args.Get(0); r != nil {
@@ -23,18 +24,23 @@ func (ms *MockSecrets) List() ([]ftypes.Secret, error) {
 	return resp, args.Error(1)
 }
 
-func (ms *MockSecrets) Set(key, value string) error {
-	args := ms.Called(key, value)
+func (ms *MockSecrets) Get(namespace, key string) (string, error) {
+	args := ms.Called(namespace, key)
+	return args.String(0), args.Error(1)
+}
+
+func (ms *MockSecrets) Set(namespace, key, value string) error {
+	args := ms.Called(namespace, key, value)
 	return args.Error(0)
 }
 
-func (ms *MockSecrets) Exists(key string) bool {
-	args := ms.Called(key)
+func (ms *MockSecrets) Exists(namespace, key string) bool {
+	args := ms.Called(namespace, key)
 	return args.Bool(0)
 }
 
-func (ms *MockSecrets) Delete(key string) error {
-	args := ms.Called(key)
+func (ms *MockSecrets) Delete(namespace, key string) error {
+	args := ms.Called(namespace, key)
 	return args.Error(0)
 }
 
@@ -90,6 +96,28 @@ func (m *MockJobs) LatestDeployment(jobID string, q *api.QueryOptions) (*api.Dep
 	return deployment, meta, args.Error(2)
 }
 
+func (m *MockJobs) Versions(jobID string, diffs bool, q *api.QueryOptions) ([]*api.Job, []*api.JobDiff, *api.QueryMeta, error) {
+	args := m.Called(jobID, diffs, q)
+
+	var versions []*api.Job
+	if r := args.Get(0); r != nil {
+		versions = r.([]*api.Job)
+	}
+
+	return versions, nil, nil, args.Error(1)
+}
+
+func (m *MockJobs) Revert(jobID string, version uint64, enforcePriorVersion *uint64, q *api.WriteOptions, consulToken, vaultToken string) (*api.JobRegisterResponse, *api.WriteMeta, error) {
+	args := m.Called(jobID, version, enforcePriorVersion, q)
+
+	var resp *api.JobRegisterResponse
+	if r := args.Get(0); r != nil {
+		resp = r.(*api.JobRegisterResponse)
+	}
+
+	return resp, nil, args.Error(1)
+}
+
 func (m *MockJobs) RegisterOpts(job *api.Job, opts *api.RegisterOptions, w *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error) {
 
 	args := m.Called(job, opts, w)
@@ -148,6 +176,92 @@ func (m *MockJobs) Allocations(jobID string, allAllocs bool, q *api.QueryOptions
 	return allocs, meta, args.Error(2)
 }
 
+type MockEvaluations struct {
+	mock.Mock
+}
+
+func (m *MockEvaluations) Info(evalID string, q *api.QueryOptions) (*api.Evaluation, *api.QueryMeta, error) {
+	args := m.Called(evalID, q)
+
+	var eval *api.Evaluation
+	if e := args.Get(0); e != nil {
+		eval = e.(*api.Evaluation)
+	}
+
+	return eval, nil, args.Error(1)
+}
+
+type MockAllocations struct {
+	mock.Mock
+}
+
+func (m *MockAllocations) List(q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error) {
+	args := m.Called(q)
+
+	var stubs []*api.AllocationListStub
+	if a := args.Get(0); a != nil {
+		stubs = a.([]*api.AllocationListStub)
+	}
+
+	return stubs, nil, args.Error(1)
+}
+
+func (m *MockAllocations) Info(allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error) {
+	args := m.Called(allocID, q)
+
+	var alloc *api.Allocation
+	if a := args.Get(0); a != nil {
+		alloc = a.(*api.Allocation)
+	}
+
+	var meta *api.QueryMeta
+	if r := args.Get(1); r != nil {
+		meta = r.(*api.QueryMeta)
+	}
+
+	return alloc, meta, args.Error(2)
+}
+
+func (m *MockAllocations) Signal(alloc *api.Allocation, q *api.QueryOptions, task, signal string) error {
+	args := m.Called(alloc, q, task, signal)
+	return args.Error(0)
+}
+
+type MockNodes struct {
+	mock.Mock
+}
+
+func (m *MockNodes) List(q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error) {
+	args := m.Called(q)
+
+	var stubs []*api.NodeListStub
+	if n := args.Get(0); n != nil {
+		stubs = n.([]*api.NodeListStub)
+	}
+
+	return stubs, nil, args.Error(1)
+}
+
+type MockAllocFS struct {
+	mock.Mock
+}
+
+func (m *MockAllocFS) Logs(alloc *api.Allocation, follow bool, task, logType, origin string, offset int64, cancel <-chan struct{}, q *api.QueryOptions) (<-chan *api.StreamFrame, <-chan error) {
+	args := m.Called(alloc, follow, task, logType, origin, offset, cancel, q)
+
+	var frames <-chan *api.StreamFrame
+	if f := args.Get(0); f != nil {
+		frames = f.(chan *api.StreamFrame)
+	}
+
+	var errs <-chan error
+	if e := args.Get(1); e != nil {
+		errs = e.(chan error)
+	}
+
+	return frames, errs
+}
+
 type MockResolver struct {
 	mock.Mock
 }
@@ -160,9 +274,139 @@ func (mr *MockResolver) Resolve(functionName string) (url.URL, error) {
 		resp = r.(url.URL)
 	}
 
-	return resp, args.Error(2)
+	return resp, args.Error(1)
+}
+
+func (mr *MockResolver) ResolveAll(functionName string) ([]url.URL, error) {
+	args := mr.Called(functionName)
+
+	var resp []url.URL
+	if r := args.Get(0); r != nil {
+		resp = r.([]url.URL)
+	}
+
+	return resp, args.Error(1)
+}
+
+func (mr *MockResolver) ResolveAllPort(functionName string, port string) ([]url.URL, error) {
+	args := mr.Called(functionName, port)
+
+	var resp []url.URL
+	if r := args.Get(0); r != nil {
+		resp = r.([]url.URL)
+	}
+
+	return resp, args.Error(1)
 }
 
 func (mr *MockResolver) RemoveCacheItem(functionName string) {
 	mr.Called(functionName)
 }
+
+type MockFunctionLabels struct {
+	mock.Mock
+}
+
+func (m *MockFunctionLabels) Labels(functionName string) (map[string]string, error) {
+	args := m.Called(functionName)
+
+	var labels map[string]string
+	if l := args.Get(0); l != nil {
+		labels = l.(map[string]string)
+	}
+
+	return labels, args.Error(1)
+}
+
+type MockServiceRegistry struct {
+	mock.Mock
+}
+
+func (m *MockServiceRegistry) ServiceRegister(service *consulapi.AgentServiceRegistration) error {
+	args := m.Called(service)
+	return args.Error(0)
+}
+
+func (m *MockServiceRegistry) ServiceDeregister(serviceID string) error {
+	args := m.Called(serviceID)
+	return args.Error(0)
+}
+
+type MockConsulConnect struct {
+	mock.Mock
+}
+
+func (m *MockConsulConnect) IntentionMatch(args *consulapi.IntentionMatch, q *consulapi.QueryOptions) (map[string][]*consulapi.Intention, *consulapi.QueryMeta, error) {
+	a := m.Called(args, q)
+
+	var resp map[string][]*consulapi.Intention
+	if r := a.Get(0); r != nil {
+		resp = r.(map[string][]*consulapi.Intention)
+	}
+
+	return resp, nil, a.Error(1)
+}
+
+func (m *MockConsulConnect) IntentionCreate(ixn *consulapi.Intention, q *consulapi.WriteOptions) (string, *consulapi.WriteMeta, error) {
+	args := m.Called(ixn, q)
+	return args.String(0), nil, args.Error(1)
+}
+
+func (m *MockConsulConnect) IntentionDelete(id string, q *consulapi.WriteOptions) (*consulapi.WriteMeta, error) {
+	args := m.Called(id, q)
+	return nil, args.Error(0)
+}
+
+type MockDeployments struct {
+	mock.Mock
+}
+
+func (m *MockDeployments) Fail(deploymentID string, q *api.WriteOptions) (*api.DeploymentUpdateResponse, *api.WriteMeta, error) {
+	args := m.Called(deploymentID, q)
+	return nil, nil, args.Error(0)
+}
+
+type MockServiceMaintenance struct {
+	mock.Mock
+}
+
+func (m *MockServiceMaintenance) Instances(service string) ([]ServiceInstance, error) {
+	args := m.Called(service)
+
+	var resp []ServiceInstance
+	if r := args.Get(0); r != nil {
+		resp = r.([]ServiceInstance)
+	}
+
+	return resp, args.Error(1)
+}
+
+func (m *MockServiceMaintenance) SetMaintenance(instance ServiceInstance, enable bool) error {
+	args := m.Called(instance, enable)
+	return args.Error(0)
+}
+
+type MockConsulKV struct {
+	mock.Mock
+}
+
+func (m *MockConsulKV) Get(key string, q *consulapi.QueryOptions) (*consulapi.KVPair, *consulapi.QueryMeta, error) {
+	args := m.Called(key, q)
+
+	var resp *consulapi.KVPair
+	if r := args.Get(0); r != nil {
+		resp = r.(*consulapi.KVPair)
+	}
+
+	return resp, nil, args.Error(1)
+}
+
+func (m *MockConsulKV) Put(p *consulapi.KVPair, q *consulapi.WriteOptions) (*consulapi.WriteMeta, error) {
+	args := m.Called(p, q)
+	return nil, args.Error(0)
+}
+
+func (m *MockConsulKV) Delete(key string, w *consulapi.WriteOptions) (*consulapi.WriteMeta, error) {
+	args := m.Called(key, w)
+	return nil, args.Error(0)
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type Nodes interface {
+	List(q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error)
+}
+
+func NewNomadNodes(config types.NomadConfig) (Nodes, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nomadClient.Nodes(), nil
+}
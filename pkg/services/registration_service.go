@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"os"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+type ServiceRegistry interface {
+	ServiceRegister(service *consulapi.AgentServiceRegistration) error
+	ServiceDeregister(serviceID string) error
+}
+
+func NewConsulServiceRegistry(config types.ConsulConfig) (ServiceRegistry, error) {
+	client, err := newConsulClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Agent(), nil
+}
+
+func newConsulClient(config types.ConsulConfig) (*consulapi.Client, error) {
+	c := consulapi.DefaultConfig()
+
+	c.Address = config.Addr
+	c.Token = config.ACLToken
+	c.TLSConfig.CAFile = config.CACert
+	c.TLSConfig.CertFile = config.ClientCert
+	c.TLSConfig.KeyFile = config.ClientKey
+	c.TLSConfig.InsecureSkipVerify = config.TLSSkipVerify
+
+	return consulapi.NewClient(c)
+}
+
+// SelfRegistration registers the provider itself as a Consul service, with an HTTP health check
+// pointing at its own health endpoint.
+type SelfRegistration struct {
+	registry ServiceRegistry
+	config   *types.ProviderConfig
+	hostname string
+}
+
+func NewSelfRegistration(config *types.ProviderConfig, registry ServiceRegistry) *SelfRegistration {
+	hostname, _ := os.Hostname()
+	return &SelfRegistration{
+		registry: registry,
+		config:   config,
+		hostname: hostname,
+	}
+}
+
+func (s *SelfRegistration) Register() error {
+	return s.registry.ServiceRegister(s.registration())
+}
+
+func (s *SelfRegistration) Deregister() error {
+	return s.registry.ServiceDeregister(s.serviceID())
+}
+
+func (s *SelfRegistration) serviceID() string {
+	if len(s.hostname) == 0 {
+		return s.config.Consul.ServiceName
+	}
+	return fmt.Sprintf("%s-%s", s.config.Consul.ServiceName, s.hostname)
+}
+
+func (s *SelfRegistration) registration() *consulapi.AgentServiceRegistration {
+	port := 8080
+	if s.config.FaaS.TCPPort != nil {
+		port = *s.config.FaaS.TCPPort
+	}
+
+	checkAddress := s.config.Consul.ServiceAddress
+	if len(checkAddress) == 0 {
+		checkAddress = "127.0.0.1"
+	}
+
+	return &consulapi.AgentServiceRegistration{
+		ID:      s.serviceID(),
+		Name:    s.config.Consul.ServiceName,
+		Tags:    s.config.Consul.ServiceTags,
+		Address: s.config.Consul.ServiceAddress,
+		Port:    port,
+		Check: &consulapi.AgentServiceCheck{
+			Name:                           "faas-nomad health check",
+			HTTP:                           fmt.Sprintf("http://%s:%d/healthz", checkAddress, port),
+			Interval:                       "10s",
+			Timeout:                        "2s",
+			DeregisterCriticalServiceAfter: "1m",
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func registrationConfig() *types.ProviderConfig {
+	config, _ := types.DefaultConfig()
+	port := 8081
+	config.FaaS.TCPPort = &port
+	config.Consul.ServiceName = "faas-nomad"
+	config.Consul.ServiceTags = []string{"openfaas", "provider"}
+	return config
+}
+
+func TestSelfRegistrationPayload(t *testing.T) {
+	config := registrationConfig()
+	config.Consul.ServiceAddress = "10.0.0.1"
+
+	registry := &MockServiceRegistry{}
+	registry.On("ServiceRegister", mock.Anything).Return(nil)
+
+	registration := NewSelfRegistration(config, registry)
+	registration.hostname = "node-1"
+
+	err := registration.Register()
+	assert.NoError(t, err)
+
+	payload := registry.Calls[0].Arguments.Get(0).(*consulapi.AgentServiceRegistration)
+	assert.Equal(t, "faas-nomad-node-1", payload.ID)
+	assert.Equal(t, "faas-nomad", payload.Name)
+	assert.Equal(t, []string{"openfaas", "provider"}, payload.Tags)
+	assert.Equal(t, "10.0.0.1", payload.Address)
+	assert.Equal(t, 8081, payload.Port)
+	assert.Equal(t, "http://10.0.0.1:8081/healthz", payload.Check.HTTP)
+	assert.Equal(t, "10s", payload.Check.Interval)
+	assert.Equal(t, "1m", payload.Check.DeregisterCriticalServiceAfter)
+}
+
+func TestSelfRegistrationPayloadWithoutAddress(t *testing.T) {
+	registration := NewSelfRegistration(registrationConfig(), &MockServiceRegistry{})
+	registration.hostname = ""
+
+	payload := registration.registration()
+
+	assert.Equal(t, "faas-nomad", payload.ID)
+	assert.Equal(t, "", payload.Address)
+	assert.Equal(t, "http://127.0.0.1:8081/healthz", payload.Check.HTTP)
+}
+
+func TestSelfRegistrationDeregister(t *testing.T) {
+	registry := &MockServiceRegistry{}
+	registry.On("ServiceDeregister", "faas-nomad-node-1").Return(nil)
+
+	registration := NewSelfRegistration(registrationConfig(), registry)
+	registration.hostname = "node-1"
+
+	err := registration.Deregister()
+
+	assert.NoError(t, err)
+	registry.AssertExpectations(t)
+}
@@ -1,10 +1,12 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/hashicorp/vault/api"
@@ -12,14 +14,35 @@ import (
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
-type Secrets interface {
-	List() ([]ftypes.Secret, error)
-	Set(key, value string) error
-	Exists(key string) bool
-	Delete(key string) error
+const (
+	SecretsBackendVault = "vault"
+	SecretsBackendNomad = "nomad"
+)
+
+// SecretStore stores the secrets of functions, scoped by namespace.
+type SecretStore interface {
+	List(namespace string) ([]ftypes.Secret, error)
+	Get(namespace, key string) (string, error)
+	Set(namespace, key, value string) error
+	Exists(namespace, key string) bool
+	Delete(namespace, key string) error
+}
+
+// NewSecretStore creates the secret store of the configured backend.
+func NewSecretStore(config *types.ProviderConfig) (SecretStore, error) {
+	switch config.Secrets.Backend {
+	case SecretsBackendVault:
+		return NewVaultSecrets(config.Vault)
+	case SecretsBackendNomad:
+		return NewNomadSecrets(config.Nomad)
+	default:
+		return nil, fmt.Errorf("invalid secrets backend '%s'", config.Secrets.Backend)
+	}
 }
 
-func NewVaultSecrets(config types.VaultConfig) (Secrets, error) {
+// NewVaultSecrets creates a secret store backed by Vault. Vault paths aren't namespaced,
+// so secrets are shared between namespaces, as the templates of the function jobs expect.
+func NewVaultSecrets(config types.VaultConfig) (SecretStore, error) {
 
 	clientConfig := api.DefaultConfig()
 	clientConfig.Address = config.Addr
@@ -42,9 +65,10 @@ func NewVaultSecrets(config types.VaultConfig) (Secrets, error) {
 	}
 
 	vs := &VaultSecrets{
-		client: vaultClient,
-		prefix: config.SecretPathPrefix,
-		token:  config.Token,
+		client:    vaultClient,
+		prefix:    config.SecretPathPrefix,
+		token:     config.Token,
+		tokenFile: config.TokenFile,
 	}
 
 	if err := vs.login(); err != nil {
@@ -55,12 +79,13 @@ func NewVaultSecrets(config types.VaultConfig) (Secrets, error) {
 }
 
 type VaultSecrets struct {
-	client *api.Client
-	prefix string
-	token  string
+	client    *api.Client
+	prefix    string
+	token     string
+	tokenFile string
 }
 
-func (vs *VaultSecrets) List() ([]ftypes.Secret, error) {
+func (vs *VaultSecrets) List(namespace string) ([]ftypes.Secret, error) {
 	secretList, err := vs.client.Logical().List(fmt.Sprintf("%s", vs.prefix))
 
 	if err != nil || secretList == nil {
@@ -69,23 +94,47 @@ func (vs *VaultSecrets) List() ([]ftypes.Secret, error) {
 
 	var secrets []ftypes.Secret
 	for _, k := range secretList.Data["keys"].([]interface{}) {
-		secrets = append(secrets, ftypes.Secret{Name: k.(string)})
+		secrets = append(secrets, ftypes.Secret{Name: k.(string), Namespace: namespace})
 	}
 
 	return secrets, nil
 }
 
-func (vs *VaultSecrets) Exists(key string) bool {
+func (vs *VaultSecrets) Get(namespace, key string) (string, error) {
+	s, err := vs.client.Logical().Read(fmt.Sprintf("%s/%s", vs.prefix, key))
+	if err != nil {
+		return "", err
+	}
+	if s == nil {
+		return "", fmt.Errorf("secret %s not found", key)
+	}
+	value, _ := s.Data["value"].(string)
+	return value, nil
+}
+
+func (vs *VaultSecrets) Exists(namespace, key string) bool {
 	s, err := vs.client.Logical().Read(fmt.Sprintf("%s/%s", vs.prefix, key))
 	return s != nil && err == nil
 }
 
-func (vs *VaultSecrets) Set(key, value string) error {
+func (vs *VaultSecrets) Set(namespace, key, value string) error {
 	_, err := vs.client.Logical().Write(fmt.Sprintf("%s/%s", vs.prefix, key), map[string]interface{}{"value": value})
 	return err
 }
 
-func (vs *VaultSecrets) Delete(key string) error {
+// Ping verifies that Vault can be reached and is unsealed.
+func (vs *VaultSecrets) Ping(ctx context.Context) error {
+	health, err := vs.client.Sys().Health()
+	if err != nil {
+		return err
+	}
+	if health.Sealed {
+		return fmt.Errorf("vault is sealed")
+	}
+	return nil
+}
+
+func (vs *VaultSecrets) Delete(namespace, key string) error {
 	_, err := vs.client.Logical().Delete(fmt.Sprintf("%s/%s", vs.prefix, key))
 	return err
 }
@@ -114,10 +163,17 @@ func (vs *VaultSecrets) renew() {
 	}
 }
 
+// readToken reads the token from the configured token file, or otherwise from the secrets of the provider task,
+// so that a rotated token is applied on SIGUSR1.
 func (vs *VaultSecrets) readToken() string {
-	file, err := ioutil.ReadFile("/secrets/vault_token")
+	filename := vs.tokenFile
+	if len(filename) == 0 {
+		filename = "/secrets/vault_token"
+	}
+	file, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return vs.token
 	}
-	return string(file)
+	// the token file is often written with a trailing newline, which isn't part of the token
+	return strings.TrimSpace(string(file))
 }
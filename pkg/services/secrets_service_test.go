@@ -0,0 +1,180 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault implements the subset of the Vault KV API used by the Vault secret store.
+func fakeVault() *httptest.Server {
+	var mu sync.Mutex
+	data := map[string]string{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+			var keys []string
+			for k := range data {
+				if strings.HasPrefix(k, path+"/") {
+					keys = append(keys, strings.TrimPrefix(k, path+"/"))
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sort.Strings(keys)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+		case r.Method == http.MethodGet:
+			value, ok := data[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"value": value}})
+		case r.Method == http.MethodPut:
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			data[path] = body["value"]
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete:
+			delete(data, path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+// fakeNomadVariables implements the subset of the Nomad variables API used by the Nomad secret store.
+func fakeNomadVariables() *httptest.Server {
+	var mu sync.Mutex
+	data := map[string]*variable{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		namespace := r.URL.Query().Get("namespace")
+
+		if r.URL.Path == "/v1/vars" {
+			variables := []*variable{}
+			for _, v := range data {
+				if v.Namespace == namespace && strings.HasPrefix(v.Path, r.URL.Query().Get("prefix")) {
+					variables = append(variables, &variable{Namespace: v.Namespace, Path: v.Path})
+				}
+			}
+			sort.Slice(variables, func(i, j int) bool { return variables[i].Path < variables[j].Path })
+			json.NewEncoder(w).Encode(variables)
+			return
+		}
+
+		key := namespace + "@" + strings.TrimPrefix(r.URL.Path, "/v1/var/")
+
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := data[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(v)
+		case http.MethodPut:
+			v := &variable{}
+			json.NewDecoder(r.Body).Decode(v)
+			data[key] = v
+			json.NewEncoder(w).Encode(v)
+		case http.MethodDelete:
+			delete(data, key)
+		}
+	}))
+}
+
+func testSecretStore(t *testing.T, store SecretStore) {
+	assert.False(t, store.Exists("default", "secret-a"))
+
+	assert.NoError(t, store.Set("default", "secret-a", "value-a"))
+	assert.NoError(t, store.Set("default", "secret-b", "value-b"))
+
+	assert.True(t, store.Exists("default", "secret-a"))
+
+	value, err := store.Get("default", "secret-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-a", value)
+
+	secrets, err := store.List("default")
+	assert.NoError(t, err)
+	assert.Equal(t, []ftypes.Secret{{Name: "secret-a", Namespace: "default"}, {Name: "secret-b", Namespace: "default"}}, secrets)
+
+	assert.NoError(t, store.Delete("default", "secret-a"))
+	assert.False(t, store.Exists("default", "secret-a"))
+
+	_, err = store.Get("default", "secret-a")
+	assert.Error(t, err)
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	server := fakeVault()
+	defer server.Close()
+
+	store, err := NewVaultSecrets(types.VaultConfig{Addr: server.URL, Token: "token", SecretPathPrefix: "openfaas-fn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSecretStore(t, store)
+}
+
+func TestVaultSecretsTrimsTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "vault_token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("s.file-token\n"), 0600))
+
+	vs := &VaultSecrets{token: "token", tokenFile: tokenFile}
+
+	assert.Equal(t, "s.file-token", vs.readToken())
+}
+
+func TestNomadSecretStore(t *testing.T) {
+	server := fakeNomadVariables()
+	defer server.Close()
+
+	store, err := NewNomadSecrets(types.NomadConfig{Addr: server.URL, SecretPathPrefix: "openfaas-fn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSecretStore(t, store)
+}
+
+func TestNomadSecretStoreIsNamespaced(t *testing.T) {
+	server := fakeNomadVariables()
+	defer server.Close()
+
+	store, err := NewNomadSecrets(types.NomadConfig{Addr: server.URL, SecretPathPrefix: "openfaas-fn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, store.Set("default", "secret-a", "value-a"))
+
+	assert.True(t, store.Exists("default", "secret-a"))
+	assert.False(t, store.Exists("other", "secret-a"))
+
+	secrets, err := store.List("other")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(secrets))
+}
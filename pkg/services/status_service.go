@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+)
+
+// NomadStatus verifies the connectivity of the provider with the Nomad cluster.
+type NomadStatus struct {
+	status *api.Status
+}
+
+func NewNomadStatus(config types.NomadConfig) (*NomadStatus, error) {
+	nomadClient, err := newNomadClient(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &NomadStatus{status: nomadClient.Status()}, nil
+}
+
+// Ping verifies that Nomad can be reached and has elected a leader.
+func (s *NomadStatus) Ping(ctx context.Context) error {
+	leader, err := s.status.Leader()
+	if err != nil {
+		return err
+	}
+	if len(leader) == 0 {
+		return fmt.Errorf("nomad has no leader")
+	}
+	return nil
+}
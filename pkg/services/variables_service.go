@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/types"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+// variable is the subset of a Nomad variable used to store a secret.
+type variable struct {
+	Namespace string            `json:"Namespace"`
+	Path      string            `json:"Path"`
+	Items     map[string]string `json:"Items,omitempty"`
+}
+
+// NewNomadSecrets creates a secret store backed by Nomad variables, stored in the namespace of the function.
+//
+// The function jobs read the variables with a nomadVar template, so the variables must be readable by
+// the workload identity of the jobs, e.g. with an ACL policy on the secret path prefix.
+func NewNomadSecrets(config types.NomadConfig) (SecretStore, error) {
+	nomadClient, err := newNomadClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NomadSecrets{
+		raw:    nomadClient.Raw(),
+		prefix: config.SecretPathPrefix,
+	}, nil
+}
+
+type NomadSecrets struct {
+	raw    *api.Raw
+	prefix string
+}
+
+func (ns *NomadSecrets) List(namespace string) ([]ftypes.Secret, error) {
+	var variables []*variable
+	query := url.Values{"prefix": []string{ns.prefix + "/"}}
+	_, err := ns.raw.Query("/v1/vars?"+query.Encode(), &variables, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return []ftypes.Secret{}, err
+	}
+
+	var secrets []ftypes.Secret
+	for _, v := range variables {
+		secrets = append(secrets, ftypes.Secret{Name: strings.TrimPrefix(v.Path, ns.prefix+"/"), Namespace: namespace})
+	}
+
+	return secrets, nil
+}
+
+func (ns *NomadSecrets) Get(namespace, key string) (string, error) {
+	var v variable
+	_, err := ns.raw.Query(ns.endpoint(key), &v, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return "", err
+	}
+	return v.Items["value"], nil
+}
+
+func (ns *NomadSecrets) Exists(namespace, key string) bool {
+	_, err := ns.Get(namespace, key)
+	return err == nil
+}
+
+func (ns *NomadSecrets) Set(namespace, key, value string) error {
+	v := &variable{
+		Namespace: namespace,
+		Path:      ns.path(key),
+		Items:     map[string]string{"value": value},
+	}
+	_, err := ns.raw.Write(ns.endpoint(key), v, nil, &api.WriteOptions{Namespace: namespace})
+	return err
+}
+
+func (ns *NomadSecrets) Delete(namespace, key string) error {
+	_, err := ns.raw.Delete(ns.endpoint(key), nil, &api.WriteOptions{Namespace: namespace})
+	return err
+}
+
+func (ns *NomadSecrets) path(key string) string {
+	return fmt.Sprintf("%s/%s", ns.prefix, key)
+}
+
+func (ns *NomadSecrets) endpoint(key string) string {
+	return fmt.Sprintf("/v1/var/%s", ns.path(key))
+}
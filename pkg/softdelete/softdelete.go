@@ -0,0 +1,189 @@
+package softdelete
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+)
+
+// PendingDelete is a function that was stopped and will be purged once the grace period has passed.
+type PendingDelete struct {
+	FunctionName string    `json:"functionName"`
+	Namespace    string    `json:"namespace"`
+	DeletedAt    time.Time `json:"deletedAt"`
+	PurgeAt      time.Time `json:"purgeAt"`
+}
+
+type pendingItem struct {
+	PendingDelete
+	jobID string
+	timer *time.Timer
+}
+
+// Tracker soft-deletes functions by stopping their job, and purges the job after a grace period.
+//
+// During the grace period a function can be restored, or re-deployed. Pending deletes are kept in memory,
+// so they don't survive a restart of the provider; stopped jobs are then left to the Nomad garbage collector.
+type Tracker struct {
+	jobs        services.Jobs
+	gracePeriod time.Duration
+	logger      hclog.Logger
+	mu          sync.Mutex
+	pending     map[string]*pendingItem
+	onPurge     func(functionName string)
+}
+
+func NewTracker(jobs services.Jobs, gracePeriod time.Duration, logger hclog.Logger) *Tracker {
+	return &Tracker{
+		jobs:        jobs,
+		gracePeriod: gracePeriod,
+		logger:      logger.Named("soft_delete"),
+		pending:     map[string]*pendingItem{},
+	}
+}
+
+// OnPurge registers a func which is called once a function is purged, to remove the state which is kept while the
+// function can still be restored, e.g. its intentions.
+func (t *Tracker) OnPurge(f func(functionName string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onPurge = f
+}
+
+// Delete stops the job of a function without purging it, and schedules the purge after the grace period.
+func (t *Tracker) Delete(namespace, jobID, functionName string) error {
+	_, _, err := t.jobs.Deregister(jobID, false, &api.WriteOptions{Namespace: namespace})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	item := &pendingItem{
+		PendingDelete: PendingDelete{
+			FunctionName: functionName,
+			Namespace:    namespace,
+			DeletedAt:    now,
+			PurgeAt:      now.Add(t.gracePeriod),
+		},
+		jobID: jobID,
+	}
+	item.timer = time.AfterFunc(t.gracePeriod, func() { t.purge(item) })
+
+	t.mu.Lock()
+	if previous, ok := t.pending[functionName]; ok {
+		previous.timer.Stop()
+	}
+	t.pending[functionName] = item
+	t.mu.Unlock()
+
+	t.logger.Info("Function stopped, purge scheduled", "function", functionName, "namespace", namespace, "purge_at", item.PurgeAt)
+
+	return nil
+}
+
+// Restore cancels the pending purge of a function and starts its job again.
+func (t *Tracker) Restore(functionName string) error {
+	t.mu.Lock()
+	item, ok := t.pending[functionName]
+	t.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending delete found for function %s", functionName)
+	}
+
+	job, _, err := t.jobs.Info(item.jobID, &api.QueryOptions{Namespace: item.Namespace})
+	if err != nil {
+		return err
+	}
+
+	stop := false
+	job.Stop = &stop
+
+	if _, _, err := t.jobs.RegisterOpts(job, &api.RegisterOptions{}, &api.WriteOptions{Namespace: item.Namespace}); err != nil {
+		return err
+	}
+
+	t.Forget(functionName)
+
+	t.logger.Info("Function restored", "function", functionName, "namespace", item.Namespace)
+
+	return nil
+}
+
+// Forget removes a function from the pending deletes without purging it, e.g. after a hard delete.
+func (t *Tracker) Forget(functionName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if item, ok := t.pending[functionName]; ok {
+		item.timer.Stop()
+		delete(t.pending, functionName)
+	}
+}
+
+// IsPending returns true when the function was soft-deleted and isn't purged yet.
+func (t *Tracker) IsPending(functionName string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.pending[functionName]
+	return ok
+}
+
+// Pending returns the pending deletes, ordered by purge time.
+func (t *Tracker) Pending() []PendingDelete {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := make([]PendingDelete, 0, len(t.pending))
+	for _, item := range t.pending {
+		pending = append(pending, item.PendingDelete)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].PurgeAt.Before(pending[j].PurgeAt)
+	})
+
+	return pending
+}
+
+func (t *Tracker) purge(item *pendingItem) {
+	t.mu.Lock()
+	if t.pending[item.FunctionName] != item {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, item.FunctionName)
+	t.mu.Unlock()
+
+	// the function could have been re-deployed during the grace period, only purge jobs that are still stopped
+	job, _, err := t.jobs.Info(item.jobID, &api.QueryOptions{Namespace: item.Namespace})
+	if err != nil {
+		t.logger.Error("Error reading function before purge", "function", item.FunctionName, "namespace", item.Namespace, "error", err.Error())
+		return
+	}
+
+	if job == nil || job.Stop == nil || !*job.Stop {
+		t.logger.Debug("Function was re-deployed, skipping purge", "function", item.FunctionName, "namespace", item.Namespace)
+		return
+	}
+
+	if _, _, err := t.jobs.Deregister(item.jobID, true, &api.WriteOptions{Namespace: item.Namespace}); err != nil {
+		t.logger.Error("Error purging function", "function", item.FunctionName, "namespace", item.Namespace, "error", err.Error())
+		return
+	}
+
+	t.logger.Info("Function purged", "function", item.FunctionName, "namespace", item.Namespace)
+
+	t.mu.Lock()
+	onPurge := t.onPurge
+	t.mu.Unlock()
+	if onPurge != nil {
+		onPurge(item.FunctionName)
+	}
+}
@@ -0,0 +1,109 @@
+package softdelete
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jsiebens/faas-nomad/pkg/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func stoppedJob(stop bool) *api.Job {
+	job := api.NewServiceJob("faas-fn-echo", "faas-fn-echo", "global", 50)
+	job.Stop = &stop
+	return job
+}
+
+func TestTrackerStopsJobAndPurgesAfterGracePeriod(t *testing.T) {
+	purged := make(chan struct{})
+
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", false, mock.Anything).Return("", nil, nil)
+	jobs.On("Deregister", "faas-fn-echo", true, mock.Anything).Return("", nil, nil).Run(func(args mock.Arguments) { close(purged) })
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(stoppedJob(true), nil, nil)
+
+	tracker := NewTracker(jobs, 10*time.Millisecond, hclog.Default())
+
+	err := tracker.Delete("default", "faas-fn-echo", "echo")
+
+	assert.NoError(t, err)
+	assert.True(t, tracker.IsPending("echo"))
+	assert.Equal(t, "echo", tracker.Pending()[0].FunctionName)
+
+	select {
+	case <-purged:
+	case <-time.After(time.Second):
+		t.Fatal("function was not purged after the grace period")
+	}
+
+	assert.False(t, tracker.IsPending("echo"))
+}
+
+func TestTrackerSkipsPurgeWhenFunctionWasRedeployed(t *testing.T) {
+	checked := make(chan struct{})
+
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", false, mock.Anything).Return("", nil, nil)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(stoppedJob(false), nil, nil).Run(func(args mock.Arguments) { close(checked) })
+
+	tracker := NewTracker(jobs, 10*time.Millisecond, hclog.Default())
+	_ = tracker.Delete("default", "faas-fn-echo", "echo")
+
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("function was not checked after the grace period")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	jobs.AssertNotCalled(t, "Deregister", "faas-fn-echo", true, mock.Anything)
+}
+
+func TestTrackerRestoresFunction(t *testing.T) {
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", false, mock.Anything).Return("", nil, nil)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(stoppedJob(true), nil, nil)
+	jobs.On("RegisterOpts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	tracker := NewTracker(jobs, time.Hour, hclog.Default())
+	_ = tracker.Delete("default", "faas-fn-echo", "echo")
+
+	err := tracker.Restore("echo")
+
+	assert.NoError(t, err)
+	assert.False(t, tracker.IsPending("echo"))
+
+	job := jobs.Calls[2].Arguments.Get(0).(*api.Job)
+	assert.False(t, *job.Stop)
+}
+
+func TestTrackerRestoreFailsWithoutPendingDelete(t *testing.T) {
+	tracker := NewTracker(&services.MockJobs{}, time.Hour, hclog.Default())
+
+	err := tracker.Restore("echo")
+
+	assert.Error(t, err)
+}
+
+func TestTrackerNotifiesPurge(t *testing.T) {
+	purged := make(chan string, 1)
+
+	jobs := &services.MockJobs{}
+	jobs.On("Deregister", "faas-fn-echo", mock.Anything, mock.Anything).Return("", nil, nil)
+	jobs.On("Info", "faas-fn-echo", mock.Anything).Return(stoppedJob(true), nil, nil)
+
+	tracker := NewTracker(jobs, 10*time.Millisecond, hclog.Default())
+	tracker.OnPurge(func(functionName string) { purged <- functionName })
+
+	assert.NoError(t, tracker.Delete("default", "faas-fn-echo", "echo"))
+
+	select {
+	case name := <-purged:
+		assert.Equal(t, "echo", name)
+	case <-time.After(time.Second):
+		t.Fatal("purge of function was not notified")
+	}
+}
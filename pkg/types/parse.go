@@ -36,3 +36,11 @@ func ParseIntOrDurationValueFromMap(values *map[string]string, key string, fallb
 	m := *values
 	return types.ParseIntOrDurationValue(m[key], fallback)
 }
+
+func ParseFloatValueFromMap(values *map[string]string, key string, fallback float64) float64 {
+	if values == nil {
+		return fallback
+	}
+	m := *values
+	return parseFloat(m[key], fallback)
+}
@@ -1,35 +1,82 @@
 package types
 
 import (
+	"fmt"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	ftypes "github.com/openfaas/faas-provider/types"
 )
 
 type ConsulConfig struct {
-	Addr          string
-	ACLToken      string
-	CACert        string
-	ClientCert    string
-	ClientKey     string
-	TLSSkipVerify bool
+	Addr                string
+	ACLToken            string
+	CACert              string
+	ClientCert          string
+	ClientKey           string
+	TLSSkipVerify       bool
+	ResolveHostnames    bool
+	DNSCacheTTL         time.Duration
+	ConsistencyMode     string
+	MaxStale            time.Duration
+	Register            bool
+	ServiceName         string
+	ServiceTags         []string
+	ServiceAddress      string
+	IncludeWarning      bool
+	Datacenter          string
+	PortName            string
+	CoordinatesRefresh  time.Duration
+	WatchErrorThreshold int
+	// WatchWaitTime is the wait time of the blocking queries of the watcher, where zero is the Consul default.
+	WatchWaitTime time.Duration
+	// WatchRetries is the number of times the watcher retries a failed query, with an exponential backoff,
+	// before the error is reported and the watcher restarted after the restart delay.
+	WatchRetries         int
+	WatchRetryBackoff    time.Duration
+	WatchRetryMaxBackoff time.Duration
+	WatchRestartDelay    time.Duration
+	MaxInstances         int
+	AgentPort            int
+	BlueGreenKVPrefix    string
+	Environments         []EnvironmentConfig
+	// LogLevelKVPrefix prefixes the Consul KV keys holding the log level of the functions changing their log level
+	// with a template, as <prefix>/<namespace>/<function>.
+	LogLevelKVPrefix string
+}
+
+// EnvironmentConfig describes an additional set of functions, e.g. staging functions sharing the same Consul,
+// resolved by the proxy when a request selects the environment.
+type EnvironmentConfig struct {
+	Name       string
+	JobPrefix  string
+	Namespace  string
+	Datacenter string
 }
 
 type NomadConfig struct {
-	Addr          string
-	ACLToken      string
-	CACert        string
-	ClientCert    string
-	ClientKey     string
-	TLSSkipVerify bool
+	Addr             string
+	ACLToken         string
+	CACert           string
+	ClientCert       string
+	ClientKey        string
+	TLSSkipVerify    bool
+	SecretPathPrefix string
+}
+
+type SecretsConfig struct {
+	Backend string
 }
 
 type VaultConfig struct {
 	Addr             string
 	Token            string
+	TokenFile        string
 	CACert           string
 	ClientCert       string
 	ClientKey        string
@@ -39,33 +86,164 @@ type VaultConfig struct {
 }
 
 type SchedulingConfig struct {
-	Region         string
-	Datacenters    []string
-	Namespace      string
-	JobPrefix      string
-	NetworkingMode string
-	HttpCheck      bool
+	Region           string
+	Datacenters      []string
+	Namespace        string
+	JobPrefix        string
+	NetworkingMode   string
+	HttpCheck        bool
+	DefaultReplicas  int
+	DefaultPriority  int
+	ScaleBatchLimit  int
+	SoftDelete       bool
+	SoftDeleteGrace  time.Duration
+	ConsulConnect    bool
+	ConsulTagLabels  []string
+	ConsulMetaLabels []string
+	DeployTimeout    time.Duration
+	AutoRevert       bool
+	MaxKillTimeout   time.Duration
+	CostLabels       []string
+	Prestart         map[string]PrestartTemplate
+	Quotas           map[string]QuotaConfig
+	ServiceName      *ServiceNameTemplate
+	BlueGreen        bool
+	BlueGreenGrace   time.Duration
+	// FunctionNames is the policy applied to the names of deployed functions, one of strict, lowercase or replace.
+	FunctionNames         string
+	FunctionNameMaxLength int
+	// DependencyTimeout is how long a batch deployment waits for the dependencies of a function to become healthy.
+	DependencyTimeout time.Duration
+	// HTTPProxy and HTTPSProxy are the outbound proxies of the functions, set as env vars of their tasks together
+	// with the addresses in NoProxy, which are reached directly.
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    []string
+	// HealthProbeCount is the number of consecutive health probes a deployed function has to pass, within the
+	// health probe timeout, before its deployment succeeds, where zero doesn't probe the function.
+	HealthProbeCount    int
+	HealthProbePath     string
+	HealthProbeInterval time.Duration
+	HealthProbeTimeout  time.Duration
+	// IdempotencyWindow is how long the result of a deployment with an idempotency key is returned for a retry of
+	// the deployment, where zero disables idempotency keys.
+	IdempotencyWindow time.Duration
+	// EvalRetries is the number of times the blocked evaluation of a deployed function is awaited when its placement
+	// failed for lack of resources, backing off from EvalRetryBackoff, where zero doesn't await the evaluation.
+	EvalRetries      int
+	EvalRetryBackoff time.Duration
+}
+
+// QuotaConfig limits the total resources reserved by the functions of a namespace, where a zero limit is unlimited.
+type QuotaConfig struct {
+	CPU    int
+	Memory int
+}
+
+// PrestartTemplate is a task to run before the task of a function, selected with the com.openfaas.prestart label.
+type PrestartTemplate struct {
+	Image   string
+	Command string
+	Args    []string
+}
+
+type GatewayConfig struct {
+	PublicURL string
+}
+
+// InfoConfig configures the provider info reported on /system/info and /system/config.
+type InfoConfig struct {
+	IncludeCounts bool
+	// ExposeConfig serves the effective config of the provider, with its secrets redacted, on /system/config.
+	ExposeConfig bool
+}
+
+// AdmissionConfig configures the webhook reviewing deployments before they are registered.
+type AdmissionConfig struct {
+	WebhookURL string
+	Timeout    time.Duration
+	FailOpen   bool
+}
+
+// AutoscalerConfig configures the push of the load of the functions to an external autoscaler.
+type AutoscalerConfig struct {
+	WebhookURL string
+	Interval   time.Duration
 }
 
 type LogConfig struct {
-	Level  string
-	Format string
-	File   string
+	Level         string
+	Format        string
+	File          string
+	BufferEnabled bool
+	BufferSize    int
+}
+
+// AccessLogConfig configures the access log records of the function invocations, written to the sink when it is
+// set to stdout or file. The sample rate is the fraction of the invocations which are recorded.
+type AccessLogConfig struct {
+	Sink       string
+	File       string
+	SampleRate float64
+}
+
+// ReadinessConfig configures the gates the provider passes at startup before reporting ready, one per dependency.
+type ReadinessConfig struct {
+	CheckTimeout  time.Duration
+	CheckInterval time.Duration
 }
 
 type ProviderConfig struct {
 	FaaS ftypes.FaaSConfig
 
 	Vault      VaultConfig
+	Secrets    SecretsConfig
 	Consul     ConsulConfig
 	Nomad      NomadConfig
 	Scheduling SchedulingConfig
 	Proxy      ProxyConfig
+	Gateway    GatewayConfig
+	Autoscaler AutoscalerConfig
+	Admission  AdmissionConfig
+	Info       InfoConfig
 	Log        LogConfig
+	AccessLog  AccessLogConfig
+	Readiness  ReadinessConfig
 }
 
 type ProxyConfig struct {
-	Strategy string
+	Strategy        string
+	Gzip            bool
+	GzipMinSize     int
+	InstancePinning bool
+	CacheSize       int
+	CacheDefaultTTL time.Duration
+	RetryAfter      time.Duration
+	WaitTimeout     time.Duration
+	DebugHeaders    bool
+	DebugVerbose    bool
+	Hosts           map[string]string
+	ErrorPages      map[int]ErrorPageConfig
+	// Passthrough maps the routes under /service/ to plain Consul services, which aren't functions, e.g.
+	// legacy=legacy-api proxies /service/legacy/ to the instances of the legacy-api service.
+	Passthrough map[string]string
+	// Retries is the number of times a failed invocation is retried on another instance, within the retry budget.
+	Retries           int
+	RetryBudget       float64
+	RetryBudgetMin    int
+	RetryBudgetWindow time.Duration
+	// Variants routes requests with the X-Faas-Variant-Weights header among the variants of a function.
+	Variants bool
+	// Middlewares is the ordered chain of middlewares wrapping the proxy, outermost first, by default all built-in
+	// middlewares in their default order. A middleware left out of the chain is disabled.
+	Middlewares []string
+}
+
+// ErrorPageConfig is the template of the body of an error returned by the proxy itself, e.g. a 503 when a function
+// has no instances, rather than by the function.
+type ErrorPageConfig struct {
+	Template    string
+	ContentType string
 }
 
 func DefaultConfig() (*ProviderConfig, error) {
@@ -82,6 +260,11 @@ func LoadConfig(filename string) (*ProviderConfig, error) {
 }
 
 func doLoadConfig(env ftypes.HasEnv) (*ProviderConfig, error) {
+	env, err := readSecretFiles(env)
+	if err != nil {
+		return nil, err
+	}
+
 	faasConfig, err := ftypes.ReadConfig{}.Read(env)
 
 	if err != nil {
@@ -94,6 +277,7 @@ func doLoadConfig(env ftypes.HasEnv) (*ProviderConfig, error) {
 		Vault: VaultConfig{
 			Addr:             ftypes.ParseString(env.Getenv("vault_addr"), "http://localhost:8200"),
 			Token:            ftypes.ParseString(env.Getenv("vault_token"), ""),
+			TokenFile:        ftypes.ParseString(env.Getenv("vault_token_file"), ""),
 			SecretPathPrefix: ftypes.ParseString(env.Getenv("vault_secret_path_prefix"), "openfaas-fn"),
 			CACert:           ftypes.ParseString(env.Getenv("vault_tls_ca"), ""),
 			ClientCert:       ftypes.ParseString(env.Getenv("vault_tls_cert"), ""),
@@ -103,46 +287,340 @@ func doLoadConfig(env ftypes.HasEnv) (*ProviderConfig, error) {
 		},
 
 		Consul: ConsulConfig{
-			Addr:          ftypes.ParseString(env.Getenv("consul_addr"), "http://localhost:8500"),
-			ACLToken:      ftypes.ParseString(env.Getenv("consul_token"), ""),
-			CACert:        ftypes.ParseString(env.Getenv("consul_tls_ca"), ""),
-			ClientCert:    ftypes.ParseString(env.Getenv("consul_tls_cert"), ""),
-			ClientKey:     ftypes.ParseString(env.Getenv("consul_tls_key"), ""),
-			TLSSkipVerify: ftypes.ParseBoolValue(env.Getenv("consul_tls_skip_verify"), false),
+			Addr:                ftypes.ParseString(env.Getenv("consul_addr"), "http://localhost:8500"),
+			ACLToken:            ftypes.ParseString(env.Getenv("consul_token"), ""),
+			CACert:              ftypes.ParseString(env.Getenv("consul_tls_ca"), ""),
+			ClientCert:          ftypes.ParseString(env.Getenv("consul_tls_cert"), ""),
+			ClientKey:           ftypes.ParseString(env.Getenv("consul_tls_key"), ""),
+			TLSSkipVerify:       ftypes.ParseBoolValue(env.Getenv("consul_tls_skip_verify"), false),
+			ResolveHostnames:    ftypes.ParseBoolValue(env.Getenv("consul_resolve_hostnames"), false),
+			DNSCacheTTL:         ftypes.ParseIntOrDurationValue(env.Getenv("consul_dns_cache_ttl"), 5*time.Minute),
+			ConsistencyMode:     ftypes.ParseString(env.Getenv("consul_consistency_mode"), "stale"),
+			MaxStale:            ftypes.ParseIntOrDurationValue(env.Getenv("consul_max_stale"), 10*time.Second),
+			Register:            ftypes.ParseBoolValue(env.Getenv("consul_register"), false),
+			ServiceName:         ftypes.ParseString(env.Getenv("consul_service_name"), "faas-nomad"),
+			ServiceTags:         parseList(env.Getenv("consul_service_tags")),
+			ServiceAddress:      ftypes.ParseString(env.Getenv("consul_service_address"), ""),
+			IncludeWarning:      ftypes.ParseBoolValue(env.Getenv("consul_include_warning"), false),
+			Datacenter:          ftypes.ParseString(env.Getenv("consul_datacenter"), ""),
+			PortName:            ftypes.ParseString(env.Getenv("consul_port_name"), "http"),
+			CoordinatesRefresh:  ftypes.ParseIntOrDurationValue(env.Getenv("consul_coordinates_refresh_interval"), time.Minute),
+			WatchErrorThreshold: ftypes.ParseIntValue(env.Getenv("consul_watch_error_threshold"), 5),
+
+			WatchWaitTime:        ftypes.ParseIntOrDurationValue(env.Getenv("consul_watch_wait_time"), 0),
+			WatchRetries:         ftypes.ParseIntValue(env.Getenv("consul_watch_retries"), 0),
+			WatchRetryBackoff:    ftypes.ParseIntOrDurationValue(env.Getenv("consul_watch_retry_backoff"), 250*time.Millisecond),
+			WatchRetryMaxBackoff: ftypes.ParseIntOrDurationValue(env.Getenv("consul_watch_retry_max_backoff"), time.Minute),
+			WatchRestartDelay:    ftypes.ParseIntOrDurationValue(env.Getenv("consul_watch_restart_delay"), 5*time.Second),
+
+			MaxInstances:      ftypes.ParseIntValue(env.Getenv("consul_max_instances"), 0),
+			AgentPort:         ftypes.ParseIntValue(env.Getenv("consul_agent_port"), 8500),
+			BlueGreenKVPrefix: ftypes.ParseString(env.Getenv("consul_blue_green_kv_prefix"), "faas-nomad/blue-green"),
+			LogLevelKVPrefix:  ftypes.ParseString(env.Getenv("consul_log_level_kv_prefix"), "faas-nomad/log-level"),
 		},
 
 		Nomad: NomadConfig{
-			Addr:          ftypes.ParseString(env.Getenv("nomad_addr"), "http://localhost:4646"),
-			ACLToken:      ftypes.ParseString(env.Getenv("nomad_token"), ""),
-			CACert:        ftypes.ParseString(env.Getenv("nomad_tls_ca"), ""),
-			ClientCert:    ftypes.ParseString(env.Getenv("nomad_tls_cert"), ""),
-			ClientKey:     ftypes.ParseString(env.Getenv("nomad_tls_key"), ""),
-			TLSSkipVerify: ftypes.ParseBoolValue(env.Getenv("nomad_tls_skip_verify"), false),
+			Addr:             ftypes.ParseString(env.Getenv("nomad_addr"), "http://localhost:4646"),
+			ACLToken:         ftypes.ParseString(env.Getenv("nomad_token"), ""),
+			CACert:           ftypes.ParseString(env.Getenv("nomad_tls_ca"), ""),
+			ClientCert:       ftypes.ParseString(env.Getenv("nomad_tls_cert"), ""),
+			ClientKey:        ftypes.ParseString(env.Getenv("nomad_tls_key"), ""),
+			TLSSkipVerify:    ftypes.ParseBoolValue(env.Getenv("nomad_tls_skip_verify"), false),
+			SecretPathPrefix: ftypes.ParseString(env.Getenv("nomad_secret_path_prefix"), "openfaas-fn"),
+		},
+
+		Secrets: SecretsConfig{
+			Backend: ftypes.ParseString(env.Getenv("secrets_backend"), "vault"),
 		},
 
 		Scheduling: SchedulingConfig{
-			Region:         ftypes.ParseString(env.Getenv("job_region"), "global"),
-			Datacenters:    strings.Split(ftypes.ParseString(env.Getenv("job_datacenters"), "dc1"), ","),
-			Namespace:      ftypes.ParseString(env.Getenv("job_namespace"), "default"),
-			JobPrefix:      ftypes.ParseString(env.Getenv("job_name_prefix"), "faas-fn-"),
-			NetworkingMode: ftypes.ParseString(env.Getenv("job_network_mode"), "host"),
-			HttpCheck:      ftypes.ParseBoolValue(env.Getenv("job_http_check"), true),
+			Region:           ftypes.ParseString(env.Getenv("job_region"), "global"),
+			Datacenters:      strings.Split(ftypes.ParseString(env.Getenv("job_datacenters"), "dc1"), ","),
+			Namespace:        ftypes.ParseString(env.Getenv("job_namespace"), "default"),
+			JobPrefix:        ftypes.ParseString(env.Getenv("job_name_prefix"), "faas-fn-"),
+			NetworkingMode:   ftypes.ParseString(env.Getenv("job_network_mode"), "host"),
+			HttpCheck:        ftypes.ParseBoolValue(env.Getenv("job_http_check"), true),
+			DefaultReplicas:  ftypes.ParseIntValue(env.Getenv("job_default_replicas"), 1),
+			DefaultPriority:  ftypes.ParseIntValue(env.Getenv("job_default_priority"), 50),
+			ScaleBatchLimit:  ftypes.ParseIntValue(env.Getenv("job_scale_batch_limit"), 50),
+			SoftDelete:       ftypes.ParseBoolValue(env.Getenv("job_soft_delete"), false),
+			SoftDeleteGrace:  ftypes.ParseIntOrDurationValue(env.Getenv("job_soft_delete_grace_period"), 24*time.Hour),
+			ConsulConnect:    ftypes.ParseBoolValue(env.Getenv("job_consul_connect"), false),
+			ConsulTagLabels:  parseList(env.Getenv("job_consul_tag_labels")),
+			ConsulMetaLabels: parseList(env.Getenv("job_consul_meta_labels")),
+			DeployTimeout:    ftypes.ParseIntOrDurationValue(env.Getenv("job_deploy_timeout"), 0),
+			AutoRevert:       ftypes.ParseBoolValue(env.Getenv("job_auto_revert"), true),
+			MaxKillTimeout:   ftypes.ParseIntOrDurationValue(env.Getenv("job_max_kill_timeout"), 30*time.Second),
+			CostLabels:       parseList(ftypes.ParseString(env.Getenv("job_cost_labels"), "team,project,cost-center")),
+
+			BlueGreen:      ftypes.ParseBoolValue(env.Getenv("job_blue_green"), false),
+			BlueGreenGrace: ftypes.ParseIntOrDurationValue(env.Getenv("job_blue_green_grace_period"), 15*time.Minute),
+
+			FunctionNames:         ftypes.ParseString(env.Getenv("job_function_names"), FunctionNamesStrict),
+			FunctionNameMaxLength: ftypes.ParseIntValue(env.Getenv("job_function_name_max_length"), 63),
+
+			DependencyTimeout: ftypes.ParseIntOrDurationValue(env.Getenv("job_dependency_timeout"), 5*time.Minute),
+
+			HTTPProxy:  ftypes.ParseString(env.Getenv("job_http_proxy"), ""),
+			HTTPSProxy: ftypes.ParseString(env.Getenv("job_https_proxy"), ""),
+			NoProxy:    parseList(env.Getenv("job_no_proxy")),
+
+			HealthProbeCount:    ftypes.ParseIntValue(env.Getenv("job_health_probe_count"), 0),
+			HealthProbePath:     ftypes.ParseString(env.Getenv("job_health_probe_path"), "/_/health"),
+			HealthProbeInterval: ftypes.ParseIntOrDurationValue(env.Getenv("job_health_probe_interval"), 2*time.Second),
+			HealthProbeTimeout:  ftypes.ParseIntOrDurationValue(env.Getenv("job_health_probe_timeout"), time.Minute),
+
+			IdempotencyWindow: ftypes.ParseIntOrDurationValue(env.Getenv("job_idempotency_window"), 10*time.Minute),
+
+			EvalRetries:      ftypes.ParseIntValue(env.Getenv("job_eval_retries"), 0),
+			EvalRetryBackoff: ftypes.ParseIntOrDurationValue(env.Getenv("job_eval_retry_backoff"), 5*time.Second),
 		},
 
 		Proxy: ProxyConfig{
-			Strategy: ftypes.ParseString(env.Getenv("proxy_strategy"), "roundrobin"),
+			Strategy:        ftypes.ParseString(env.Getenv("proxy_strategy"), "roundrobin"),
+			Gzip:            ftypes.ParseBoolValue(env.Getenv("proxy_gzip"), false),
+			GzipMinSize:     ftypes.ParseIntValue(env.Getenv("proxy_gzip_min_size"), 1024),
+			InstancePinning: ftypes.ParseBoolValue(env.Getenv("proxy_instance_pinning"), false),
+			CacheSize:       ftypes.ParseIntValue(env.Getenv("proxy_cache_size"), 1000),
+			CacheDefaultTTL: ftypes.ParseIntOrDurationValue(env.Getenv("proxy_cache_default_ttl"), 0),
+			RetryAfter:      ftypes.ParseIntOrDurationValue(env.Getenv("proxy_retry_after"), 5*time.Second),
+			WaitTimeout:     ftypes.ParseIntOrDurationValue(env.Getenv("proxy_wait_timeout"), 0),
+			DebugHeaders:    ftypes.ParseBoolValue(env.Getenv("proxy_debug_headers"), false),
+			DebugVerbose:    ftypes.ParseBoolValue(env.Getenv("proxy_debug_verbose"), false),
+
+			Retries:           ftypes.ParseIntValue(env.Getenv("proxy_retries"), 0),
+			RetryBudget:       parseFloat(env.Getenv("proxy_retry_budget"), 0.1),
+			RetryBudgetMin:    ftypes.ParseIntValue(env.Getenv("proxy_retry_budget_min"), 10),
+			RetryBudgetWindow: ftypes.ParseIntOrDurationValue(env.Getenv("proxy_retry_budget_window"), 10*time.Second),
+
+			Variants:    ftypes.ParseBoolValue(env.Getenv("proxy_variants"), false),
+			Middlewares: parseList(env.Getenv("proxy_middlewares")),
+		},
+
+		Gateway: GatewayConfig{
+			PublicURL: ftypes.ParseString(env.Getenv("gateway_public_url"), "http://localhost:8080"),
+		},
+
+		Autoscaler: AutoscalerConfig{
+			WebhookURL: ftypes.ParseString(env.Getenv("autoscaler_webhook_url"), ""),
+			Interval:   ftypes.ParseIntOrDurationValue(env.Getenv("autoscaler_interval"), 10*time.Second),
+		},
+
+		Admission: AdmissionConfig{
+			WebhookURL: ftypes.ParseString(env.Getenv("admission_webhook_url"), ""),
+			Timeout:    ftypes.ParseIntOrDurationValue(env.Getenv("admission_webhook_timeout"), 5*time.Second),
+			FailOpen:   ftypes.ParseBoolValue(env.Getenv("admission_fail_open"), false),
+		},
+
+		Info: InfoConfig{
+			IncludeCounts: ftypes.ParseBoolValue(env.Getenv("info_include_counts"), false),
+			ExposeConfig:  ftypes.ParseBoolValue(env.Getenv("info_expose_config"), false),
+		},
+
+		AccessLog: AccessLogConfig{
+			Sink:       ftypes.ParseString(env.Getenv("access_log_sink"), ""),
+			File:       ftypes.ParseString(env.Getenv("access_log_file"), ""),
+			SampleRate: parseFloat(env.Getenv("access_log_sample_rate"), 1),
+		},
+
+		Readiness: ReadinessConfig{
+			CheckTimeout:  ftypes.ParseIntOrDurationValue(env.Getenv("readiness_check_timeout"), 5*time.Second),
+			CheckInterval: ftypes.ParseIntOrDurationValue(env.Getenv("readiness_check_interval"), time.Second),
 		},
 
 		Log: LogConfig{
 			Level:  ftypes.ParseString(env.Getenv("log_level"), "info"),
 			Format: ftypes.ParseString(env.Getenv("log_format"), "text"),
 			File:   ftypes.ParseString(env.Getenv("log_file"), ""),
+
+			BufferEnabled: ftypes.ParseBoolValue(env.Getenv("log_buffer_enabled"), false),
+			BufferSize:    ftypes.ParseIntValue(env.Getenv("log_buffer_size"), 1000),
 		},
 	}
 
+	providerConfig.Consul.Environments = parseEnvironments(env, providerConfig)
+	providerConfig.Scheduling.Prestart = parsePrestartTemplates(env)
+	providerConfig.Scheduling.Quotas = parseQuotas(env)
+	providerConfig.Proxy.Hosts = parseHosts(env.Getenv("proxy_hosts"))
+	providerConfig.Proxy.Passthrough, err = parsePassthrough(env.Getenv("proxy_passthrough"))
+	if err != nil {
+		return nil, err
+	}
+	providerConfig.Proxy.ErrorPages, err = parseErrorPages(env)
+	if err != nil {
+		return nil, err
+	}
+
+	providerConfig.Scheduling.ServiceName, err = ParseServiceNameTemplate(env.Getenv("job_service_name_template"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch providerConfig.Scheduling.FunctionNames {
+	case FunctionNamesStrict, FunctionNamesLowercase, FunctionNamesReplace:
+	default:
+		return nil, fmt.Errorf("invalid job_function_names '%s', must be one of %s, %s or %s", providerConfig.Scheduling.FunctionNames, FunctionNamesStrict, FunctionNamesLowercase, FunctionNamesReplace)
+	}
+
+	if value := providerConfig.Scheduling.HTTPProxy; len(value) != 0 && !ValidProxyURL(value) {
+		return nil, fmt.Errorf("invalid job_http_proxy '%s', must be an http, https or socks5 URL", value)
+	}
+	if value := providerConfig.Scheduling.HTTPSProxy; len(value) != 0 && !ValidProxyURL(value) {
+		return nil, fmt.Errorf("invalid job_https_proxy '%s', must be an http, https or socks5 URL", value)
+	}
+
 	return providerConfig, err
 }
 
+func parseEnvironments(env ftypes.HasEnv, config *ProviderConfig) []EnvironmentConfig {
+	var environments []EnvironmentConfig
+	for _, name := range parseList(env.Getenv("consul_environments")) {
+		key := fmt.Sprintf("consul_environment_%s_", name)
+		environments = append(environments, EnvironmentConfig{
+			Name:       name,
+			JobPrefix:  ftypes.ParseString(env.Getenv(key+"job_prefix"), config.Scheduling.JobPrefix),
+			Namespace:  ftypes.ParseString(env.Getenv(key+"namespace"), config.Scheduling.Namespace),
+			Datacenter: ftypes.ParseString(env.Getenv(key+"datacenter"), config.Consul.Datacenter),
+		})
+	}
+	return environments
+}
+
+func parsePrestartTemplates(env ftypes.HasEnv) map[string]PrestartTemplate {
+	templates := map[string]PrestartTemplate{}
+	for _, name := range parseList(env.Getenv("job_prestart_templates")) {
+		key := fmt.Sprintf("job_prestart_%s_", name)
+		templates[name] = PrestartTemplate{
+			Image:   ftypes.ParseString(env.Getenv(key+"image"), ""),
+			Command: ftypes.ParseString(env.Getenv(key+"command"), ""),
+			Args:    strings.Fields(env.Getenv(key + "args")),
+		}
+	}
+	return templates
+}
+
+func parseQuotas(env ftypes.HasEnv) map[string]QuotaConfig {
+	quotas := map[string]QuotaConfig{}
+	for _, namespace := range parseList(env.Getenv("job_quota_namespaces")) {
+		key := fmt.Sprintf("job_quota_%s_", namespace)
+		quotas[namespace] = QuotaConfig{
+			CPU:    ftypes.ParseIntValue(env.Getenv(key+"cpu"), 0),
+			Memory: ftypes.ParseIntValue(env.Getenv(key+"memory"), 0),
+		}
+	}
+	return quotas
+}
+
+// parseErrorPages parses the error pages of the statuses listed in proxy_error_pages, e.g. 503,504, where the
+// template of a status is read from proxy_error_page_<status>, or the file of proxy_error_page_<status>_file.
+func parseErrorPages(env ftypes.HasEnv) (map[int]ErrorPageConfig, error) {
+	pages := map[int]ErrorPageConfig{}
+	for _, value := range parseList(env.Getenv("proxy_error_pages")) {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("invalid proxy_error_pages status '%s'", value)
+		}
+
+		key := fmt.Sprintf("proxy_error_page_%d", status)
+		template := env.Getenv(key)
+		if filename := env.Getenv(key + "_file"); len(filename) != 0 {
+			content, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read %s_file: %s", key, err)
+			}
+			template = string(content)
+		}
+		if len(template) == 0 {
+			return nil, fmt.Errorf("missing %s", key)
+		}
+
+		pages[status] = ErrorPageConfig{
+			Template:    template,
+			ContentType: ftypes.ParseString(env.Getenv(key+"_content_type"), "application/json"),
+		}
+	}
+	return pages, nil
+}
+
+// parseHosts parses a list of hostname=function mappings, e.g. api.example.com=echo,www.example.com=site.
+func parseHosts(value string) map[string]string {
+	hosts := map[string]string{}
+	for _, entry := range parseList(value) {
+		if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 && len(parts[0]) != 0 && len(parts[1]) != 0 {
+			hosts[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return hosts
+}
+
+// parsePassthrough parses a list of route=service mappings, e.g. legacy=legacy-api,billing=billing, where a route
+// is a single path segment and the service is the name of a Consul service.
+func parsePassthrough(value string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, entry := range parseList(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid proxy_passthrough '%s', expected format is <route>=<service>", entry)
+		}
+		route, service := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !serviceNameRe.MatchString(route) || !serviceNameRe.MatchString(service) {
+			return nil, fmt.Errorf("invalid proxy_passthrough '%s', route and service may only contain alphanumerics, dashes and underscores", entry)
+		}
+		routes[route] = service
+	}
+	return routes, nil
+}
+
+func parseFloat(value string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		return f
+	}
+	return fallback
+}
+
+func parseList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// secretFileKeys are the credentials of the provider which can be read from a file, given by the key with
+// a _file suffix, e.g. vault_token_file. The content of the file takes precedence over the value of the key.
+var secretFileKeys = []string{"vault_token", "consul_token", "nomad_token"}
+
+// secretFileEnv overrides the credentials of an env with the content of their files.
+type secretFileEnv struct {
+	ftypes.HasEnv
+	secrets map[string]string
+}
+
+func (e secretFileEnv) Getenv(key string) string {
+	if value, ok := e.secrets[key]; ok {
+		return value
+	}
+	return e.HasEnv.Getenv(key)
+}
+
+func readSecretFiles(env ftypes.HasEnv) (ftypes.HasEnv, error) {
+	secrets := map[string]string{}
+	for _, key := range secretFileKeys {
+		filename := env.Getenv(key + "_file")
+		if len(filename) == 0 {
+			continue
+		}
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s_file: %w", key, err)
+		}
+		secrets[key] = strings.TrimSpace(string(content))
+	}
+	return secretFileEnv{HasEnv: env, secrets: secrets}, nil
+}
+
 type emptyEnv struct {
 }
 
@@ -0,0 +1,179 @@
+package types
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapEnv map[string]string
+
+func (e mapEnv) Getenv(key string) string {
+	return e[key]
+}
+
+func writeSecretFile(t *testing.T, name, content string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
+
+func TestLoadConfigReadsVaultTokenFromFile(t *testing.T) {
+	filename := writeSecretFile(t, "vault_token", "s.file-token\n")
+
+	config, err := doLoadConfig(mapEnv{"vault_token": "s.inline-token", "vault_token_file": filename})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "s.file-token", config.Vault.Token)
+	assert.Equal(t, filename, config.Vault.TokenFile)
+}
+
+func TestLoadConfigReadsConsulTokenFromFile(t *testing.T) {
+	filename := writeSecretFile(t, "consul_token", "consul-file-token\n")
+
+	config, err := doLoadConfig(mapEnv{"consul_token": "consul-inline-token", "consul_token_file": filename})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "consul-file-token", config.Consul.ACLToken)
+}
+
+func TestLoadConfigReadsNomadTokenFromFile(t *testing.T) {
+	filename := writeSecretFile(t, "nomad_token", "nomad-file-token\n")
+
+	config, err := doLoadConfig(mapEnv{"nomad_token": "nomad-inline-token", "nomad_token_file": filename})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "nomad-file-token", config.Nomad.ACLToken)
+}
+
+func TestLoadConfigUsesInlineTokensWithoutFiles(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{"vault_token": "s.inline-token", "consul_token": "consul-inline-token", "nomad_token": "nomad-inline-token"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "s.inline-token", config.Vault.Token)
+	assert.Equal(t, "consul-inline-token", config.Consul.ACLToken)
+	assert.Equal(t, "nomad-inline-token", config.Nomad.ACLToken)
+}
+
+func TestLoadConfigReportsMissingTokenFile(t *testing.T) {
+	_, err := doLoadConfig(mapEnv{"nomad_token_file": filepath.Join(t.TempDir(), "missing")})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nomad_token_file")
+}
+
+func TestLoadConfigReportsInvalidFunctionNamePolicy(t *testing.T) {
+	_, err := doLoadConfig(mapEnv{"job_function_names": "truncate"})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "job_function_names")
+
+	config, err := doLoadConfig(mapEnv{"job_function_names": FunctionNamesReplace, "job_function_name_max_length": "40"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, FunctionNamesReplace, config.Scheduling.FunctionNames)
+	assert.Equal(t, 40, config.Scheduling.FunctionNameMaxLength)
+}
+
+func TestLoadConfigIgnoresNegativeLogBufferSize(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{"log_buffer_size": "-1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, config.Log.BufferSize)
+}
+
+func TestLoadConfigReadsErrorPages(t *testing.T) {
+	filename := writeSecretFile(t, "504.html", "<h1>{{.Function}} timed out</h1>")
+
+	config, err := doLoadConfig(mapEnv{
+		"proxy_error_pages":                 "503,504",
+		"proxy_error_page_503":              `{"detail": "{{.Message}}"}`,
+		"proxy_error_page_504_file":         filename,
+		"proxy_error_page_504_content_type": "text/html",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]ErrorPageConfig{
+		503: {Template: `{"detail": "{{.Message}}"}`, ContentType: "application/json"},
+		504: {Template: "<h1>{{.Function}} timed out</h1>", ContentType: "text/html"},
+	}, config.Proxy.ErrorPages)
+
+	_, err = doLoadConfig(mapEnv{"proxy_error_pages": "200"})
+	assert.Error(t, err)
+
+	_, err = doLoadConfig(mapEnv{"proxy_error_pages": "503"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "proxy_error_page_503")
+}
+
+func TestLoadConfigReadsRetryBudget(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, config.Proxy.Retries)
+	assert.Equal(t, 0.1, config.Proxy.RetryBudget)
+	assert.Equal(t, 10, config.Proxy.RetryBudgetMin)
+	assert.Equal(t, 10*time.Second, config.Proxy.RetryBudgetWindow)
+
+	config, err = doLoadConfig(mapEnv{
+		"proxy_retries":             "2",
+		"proxy_retry_budget":        "0.2",
+		"proxy_retry_budget_min":    "5",
+		"proxy_retry_budget_window": "1m",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.Proxy.Retries)
+	assert.Equal(t, 0.2, config.Proxy.RetryBudget)
+	assert.Equal(t, 5, config.Proxy.RetryBudgetMin)
+	assert.Equal(t, time.Minute, config.Proxy.RetryBudgetWindow)
+}
+
+func TestLoadConfigReadsProxyMiddlewares(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{})
+	assert.NoError(t, err)
+	assert.Empty(t, config.Proxy.Middlewares)
+
+	config, err = doLoadConfig(mapEnv{"proxy_middlewares": "errors, auth,accesslog, timeout"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"errors", "auth", "accesslog", "timeout"}, config.Proxy.Middlewares)
+}
+
+func TestLoadConfigReadsPassthroughRoutes(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{"proxy_passthrough": "legacy=legacy-api, billing=billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"legacy": "legacy-api", "billing": "billing"}, config.Proxy.Passthrough)
+
+	for _, value := range []string{"legacy", "legacy=", "le/gacy=legacy-api", "legacy=legacy api"} {
+		_, err = doLoadConfig(mapEnv{"proxy_passthrough": value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadConfigReadsEvalRetries(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, config.Scheduling.EvalRetries)
+	assert.Equal(t, 5*time.Second, config.Scheduling.EvalRetryBackoff)
+
+	config, err = doLoadConfig(mapEnv{"job_eval_retries": "3", "job_eval_retry_backoff": "2s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.Scheduling.EvalRetries)
+	assert.Equal(t, 2*time.Second, config.Scheduling.EvalRetryBackoff)
+}
+
+func TestLoadConfigReadsOutboundProxy(t *testing.T) {
+	config, err := doLoadConfig(mapEnv{
+		"job_http_proxy":  "http://proxy.example.com:3128",
+		"job_https_proxy": "https://proxy.example.com:3129",
+		"job_no_proxy":    "internal.example.com, 10.0.0.0/8",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128", config.Scheduling.HTTPProxy)
+	assert.Equal(t, "https://proxy.example.com:3129", config.Scheduling.HTTPSProxy)
+	assert.Equal(t, []string{"internal.example.com", "10.0.0.0/8"}, config.Scheduling.NoProxy)
+
+	_, err = doLoadConfig(mapEnv{"job_http_proxy": "ftp://proxy.example.com"})
+	assert.Error(t, err)
+}
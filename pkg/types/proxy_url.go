@@ -0,0 +1,20 @@
+package types
+
+import (
+	"net/url"
+)
+
+// ValidProxyURL reports whether a value is a valid outbound proxy of the functions, an http, https or socks5 URL
+// with a host, e.g. http://proxy.example.com:3128.
+func ValidProxyURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return false
+	}
+	return len(u.Host) != 0
+}
@@ -0,0 +1,84 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+var (
+	serviceNameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_\-]*[a-zA-Z0-9])?$`)
+	jobIDRe       = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._\-]*[a-zA-Z0-9])?$`)
+)
+
+// maxJobIDLength is the longest job ID accepted by Nomad.
+const maxJobIDLength = 128
+
+// The policies applied to the names of deployed functions: strict rejects names which aren't valid service names,
+// lowercase lowercases names before validating them, and replace also replaces the invalid characters with dashes.
+const (
+	FunctionNamesStrict    = "strict"
+	FunctionNamesLowercase = "lowercase"
+	FunctionNamesReplace   = "replace"
+)
+
+// ValidServiceName reports whether a name only contains alphanumeric characters, dashes or underscores, and
+// starts and ends with an alphanumeric character.
+func ValidServiceName(name string) bool {
+	return serviceNameRe.MatchString(name)
+}
+
+// ValidJobID reports whether an ID is a legal Nomad job ID, which is at most 128 characters, only contains
+// alphanumeric characters, dots, dashes or underscores, and starts and ends with an alphanumeric character.
+func ValidJobID(id string) bool {
+	return len(id) <= maxJobIDLength && jobIDRe.MatchString(id)
+}
+
+// ServiceNameTemplate renders the Consul service name of a function, so the service registered by the
+// job of a function and the service looked up by the resolver always agree.
+//
+// The template is executed with the Prefix, Name and Namespace of a function, e.g.
+// `{{.Namespace}}-{{.Name}}`. A nil template renders the job prefix followed by the function name.
+type ServiceNameTemplate struct {
+	tmpl *template.Template
+}
+
+type serviceNameData struct {
+	Prefix    string
+	Name      string
+	Namespace string
+}
+
+func ParseServiceNameTemplate(text string) (*ServiceNameTemplate, error) {
+	if len(text) == 0 {
+		return nil, nil
+	}
+	tmpl, err := template.New("service_name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service name template: %s", err)
+	}
+	return &ServiceNameTemplate{tmpl: tmpl}, nil
+}
+
+// String returns the text of the template.
+func (t *ServiceNameTemplate) String() string {
+	return t.tmpl.Tree.Root.String()
+}
+
+func (t *ServiceNameTemplate) Render(prefix, name, namespace string) (string, error) {
+	serviceName := prefix + name
+
+	if t != nil {
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, serviceNameData{Prefix: prefix, Name: name, Namespace: namespace}); err != nil {
+			return "", fmt.Errorf("invalid service name template: %s", err)
+		}
+		serviceName = buf.String()
+	}
+
+	if !serviceNameRe.MatchString(serviceName) {
+		return "", fmt.Errorf("invalid service name '%s', must only contain alphanumeric characters, dashes or underscores", serviceName)
+	}
+	return serviceName, nil
+}